@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/rag"
+)
+
+var indexer *rag.Indexer
+
+// indexMessage adds a message to the /ask index if its channel is opted
+// in. Failures are logged; they only cost /ask that message.
+func indexMessage(guildID string, m *discordgo.Message) {
+	if guildID == "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+	n, err := indexer.IndexMessage(commandCtx, guildID, m)
+	if err != nil {
+		slog.Error("Failed to index message", "guild_id", guildID, "channel_id", m.ChannelID, "message_id", m.ID, "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Debug("Indexed message", "channel_id", m.ChannelID, "message_id", m.ID, "chunks", n)
+	}
+}
+
+// messageUpdate re-indexes edited messages. Updates without an edit
+// timestamp are Discord adding link previews and don't change the text.
+func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	if m.Message == nil || m.EditedTimestamp == nil {
+		return
+	}
+	indexMessage(m.GuildID, m.Message)
+}
+
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	if err := indexer.DeleteMessage(m.ID); err != nil {
+		slog.Error("Failed to remove deleted message from index", "message_id", m.ID, "error", err)
+	}
+}
+
+func messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	for _, id := range m.Messages {
+		if err := indexer.DeleteMessage(id); err != nil {
+			slog.Error("Failed to remove deleted message from index", "message_id", id, "error", err)
+		}
+	}
+}
@@ -1,21 +1,71 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/conversation"
 	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/jobs"
+	"github.com/josh/discord-bot/internal/lifecycle"
 	"github.com/josh/discord-bot/internal/llm"
+	"github.com/josh/discord-bot/internal/moderation"
+	"github.com/josh/discord-bot/internal/officegen"
+	"github.com/josh/discord-bot/internal/rag"
+	"github.com/josh/discord-bot/internal/ratelimit"
+	"github.com/josh/discord-bot/internal/safety"
 	"github.com/josh/discord-bot/internal/sentiment"
 	"github.com/josh/discord-bot/internal/stocknews"
+	"github.com/josh/discord-bot/internal/tools"
+	"github.com/josh/discord-bot/internal/voice"
 	"github.com/josh/discord-bot/pkg/commands"
 )
 
 var commandMap = make(map[string]commands.Command)
 
-func registerCommands() {
+var cfgManager *config.Manager
+
+var inflight = lifecycle.NewTracker()
+
+var jobManager *jobs.Manager
+
+var aiCommand *commands.AICommand
+
+// moderator screens prompts and LLM output for every command.
+var moderator *moderation.Filter
+
+// commandCtx is the parent of every command's context. Shutdown cancels it
+// once the grace period is over so outbound requests are aborted.
+var commandCtx, cancelCommands = context.WithCancel(context.Background())
+
+func registerCommands(cfg *config.Config) error {
+	llmClient := newLLMRouter(cfg.LLM)
+	if len(cfg.LLM.Backends) > 0 {
+		llmClient.StartHealthChecks(commandCtx, cfg.LLM.HealthInterval)
+	}
+	sdClient, err := newImageBackend(cfg.ImageGen)
+	if err != nil {
+		return err
+	}
+
+	jobManager = jobs.NewManager(jobs.Limits{
+		Workers: map[jobs.Backend]int{
+			jobs.BackendLLM:      cfg.Jobs.LLMWorkers,
+			jobs.BackendChromium: cfg.Jobs.ChromiumWorkers,
+		},
+		PerUser:          cfg.Jobs.PerUserLimit,
+		ProgressInterval: cfg.Jobs.ProgressInterval,
+	})
+
 	ping := &commands.PingCommand{}
 	commandMap[ping.Name()] = ping
 	play := &commands.PlayCommand{}
@@ -32,86 +82,190 @@ func registerCommands() {
 	commandMap[search.Name()] = search
 	playlist := &commands.PlaylistCommand{}
 	commandMap[playlist.Name()] = playlist
-	ai := &commands.AICommand{}
-	commandMap[ai.Name()] = ai
+	marketaux := stocknews.NewMarketAuxClient(cfg.StockNews.MarketauxAPIKey)
+	alphavantage := stocknews.NewAlphaVantageClient(cfg.StockNews.AlphaVantageAPIKey)
+	newsClient := stocknews.NewFallbackClient(marketaux, alphavantage)
+	sentimentClient := sentiment.NewAggregator()
+
+	conv := conversation.NewManager(conversation.DBStore(), llmClient, func() config.AIConfig {
+		return cfgManager.Current().AI
+	}, tools.All(newsClient, sentimentClient, sdClient, voice.AddToQueue)...)
+	moderator = moderation.NewFilter(func(guildID string) config.ModerationConfig {
+		return cfgManager.ForGuild(guildID).Moderation
+	}, llmClient)
+	var safetyChecker safety.Classifier
+	if cfg.ImageSafety.ClassifierURL != "" {
+		safetyChecker = safety.NewHTTPClassifier(cfg.ImageSafety.ClassifierURL, cfg.ImageSafety.ClassifierTimeout)
+	}
+	gate := safety.NewGate(cfgManager.ForGuild, safetyChecker)
+	aiCommand = commands.NewAICommand(conv, llmClient, moderator, gate, cfgManager, limiter)
+	commandMap[aiCommand.Name()] = aiCommand
 	help := &commands.HelpCommand{}
 	commandMap[help.Name()] = help
+	configCmd := commands.NewConfigCommand(cfgManager)
+	commandMap[configCmd.Name()] = configCmd
+	jobsCmd := commands.NewJobsCommand(jobManager)
+	commandMap[jobsCmd.Name()] = jobsCmd
+	quota := commands.NewQuotaCommand(limiter, cfgManager)
+	commandMap[quota.Name()] = quota
+	persona := commands.NewPersonaCommand()
+	commandMap[persona.Name()] = persona
 
-	imageGenURL := os.Getenv("IMAGE_GEN_URL")
-	if imageGenURL == "" {
-		imageGenURL = "http://localhost:7860"
-	}
-	imagine := commands.NewImagineCommand(imageGenURL)
+	embedClient := llm.NewClient(cfg.RAG.EmbeddingURL, cfg.RAG.EmbeddingModel, cfg.RAG.EmbeddingTimeout)
+	indexer = rag.NewIndexer(rag.DBStore(), embedClient, func() config.RAGConfig {
+		return cfgManager.Current().RAG
+	})
+	ask := commands.NewAskCommand(indexer, llmClient, moderator)
+	commandMap[ask.Name()] = ask
+	index := commands.NewIndexCommand(indexer, cfgManager, jobManager)
+	commandMap[index.Name()] = index
+
+	imagine := commands.NewImagineCommand(sdClient, llmClient, cfgManager, jobManager, moderator, gate)
 	commandMap[imagine.Name()] = imagine
 
-	llmURL := os.Getenv("LLM_URL")
-	if llmURL == "" {
-		llmURL = "http://localhost:8081"
-	}
-	pdf := commands.NewPDFCommand(llmURL, imageGenURL)
+	gallery := commands.NewGalleryCommand(cfgManager)
+	commandMap[gallery.Name()] = gallery
+
+	pdf := commands.NewPDFCommand(officegen.NewClient(llmClient), sdClient, jobManager)
 	commandMap[pdf.Name()] = pdf
 
-	marketauxAPIKey := os.Getenv("MARKETAUX_API_KEY")
-	alphaVantageAPIKey := os.Getenv("ALPHA_VANTAGE_API_KEY")
-	marketaux := stocknews.NewMarketAuxClient(marketauxAPIKey)
-	alphavantage := stocknews.NewAlphaVantageClient(alphaVantageAPIKey)
-	newsClient := stocknews.NewFallbackClient(marketaux, alphavantage)
-	llmClient := llm.NewClient(llmURL)
-	sentimentClient := sentiment.NewAggregator()
-	stock := commands.NewStockCommand(newsClient, llmClient, sentimentClient)
+	stock := commands.NewStockCommand(newsClient, llmClient, sentimentClient, cfgManager, jobManager)
 	commandMap[stock.Name()] = stock
+
+	summarizeCmd := commands.NewSummarizeCommand(llmClient, cfgManager, jobManager, moderator)
+	commandMap[summarizeCmd.Name()] = summarizeCmd
+	return nil
+}
+
+// newImageBackend connects to the configured kind of image server.
+func newImageBackend(cfg config.ImageGenConfig) (imagegen.ImageBackend, error) {
+	switch cfg.Backend {
+	case config.ImageBackendComfyUI:
+		var workflows [2][]byte
+		for n, path := range []string{cfg.Workflow, cfg.Img2ImgWorkflow} {
+			if path == "" {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ComfyUI workflow: %w", err)
+			}
+			workflows[n] = data
+		}
+		return imagegen.NewComfyClient(cfg.URL, cfg.Timeout, cfg.Model, workflows[0], workflows[1])
+	case config.ImageBackendOpenAI:
+		return imagegen.NewOpenAIImageClient(cfg.URL, cfg.APIKey, cfg.Model, cfg.Timeout), nil
+	}
+	return imagegen.NewClient(cfg.URL, cfg.Timeout), nil
+}
+
+// newLLMRouter builds the pool of LLM servers every command shares.
+func newLLMRouter(cfg config.LLMConfig) *llm.Router {
+	var backends []llm.Backend
+	for _, b := range cfg.Pool() {
+		backends = append(backends, llm.Backend{
+			Name:   b.Name,
+			Client: llm.NewClient(b.URL, b.Model, cfg.Timeout, llm.WithRetries(cfg.MaxRetries, cfg.RetryBackoff)),
+			Weight: b.Weight,
+			Capabilities: llm.Capabilities{
+				ContextLength: b.ContextLength,
+				Vision:        b.Vision,
+				Tools:         b.Tools,
+			},
+		})
+	}
+	return llm.NewRouter(backends, llm.WithCircuitBreaker(cfg.FailureThreshold, cfg.Cooldown))
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the bot and blocks until it is asked to stop. The returned
+// value is the process exit status so systemd can tell a clean stop from
+// a failure.
+func run() int {
 	err := godotenv.Load()
 	if err != nil {
 		slog.Error("Error loading .env file", "error", err)
 	}
 
-	registerCommands()
-
-	err = db.InitDB()
+	cfgManager, err = config.NewManager(os.Getenv("CONFIG_PATH"), db.GetGuildSettings)
 	if err != nil {
-		slog.Error("Error initializing DB", "error", err)
-		return
+		slog.Error("Error loading config", "error", err)
+		return 1
 	}
+	cfg := cfgManager.Current()
 
-	token := os.Getenv("TOKEN")
-	if token == "" {
-		slog.Error("TOKEN not found")
-		return
+	limiter = ratelimit.NewLimiter(currentRateLimits, ratelimit.DBStore(), nil)
+	if err := registerCommands(cfg); err != nil {
+		slog.Error("Error registering commands", "error", err)
+		return 1
+	}
+
+	err = db.InitDB(cfg.DatabasePath)
+	if err != nil {
+		slog.Error("Error initializing DB", "error", err)
+		return 1
 	}
 
-	dg, err := discordgo.New("Bot " + token)
+	dg, err := discordgo.New("Bot " + cfg.Token)
 	if err != nil {
 		slog.Error("Error creating Discord session", "error", err)
-		return
+		db.Close()
+		return 1
 	}
 
 	dg.AddHandler(ready)
 	dg.AddHandler(interactionCreate)
+	dg.AddHandler(messageCreate)
+	dg.AddHandler(messageUpdate)
+	dg.AddHandler(messageDelete)
+	dg.AddHandler(messageDeleteBulk)
 
-	// Add intents for guilds and voice states
-	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildVoiceStates
+	// Add intents for guilds and voice states. Message content is a
+	// privileged intent and must be enabled in the developer portal; it
+	// lets replies to /ai answers continue the conversation and channels
+	// opted in with /index be indexed for /ask.
+	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildVoiceStates |
+		discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
 
 	err = dg.Open()
 	if err != nil {
 		slog.Error("Error opening connection", "error", err)
-		return
+		db.Close()
+		return 1
 	}
-	defer dg.Close()
+
+	go reloadOnSIGHUP()
+
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	slog.Info("Bot is now running. Press CTRL-C to exit.")
-	<-make(chan struct{})
+	sig := <-stop
+	slog.Info("Received signal, shutting down", "signal", sig.String())
+
+	return shutdown(dg, stop)
+}
+
+// reloadOnSIGHUP re-reads the config file whenever the process receives
+// SIGHUP (e.g. `systemctl reload discord-bot`).
+func reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		slog.Info("Received SIGHUP, reloading config")
+		if err := cfgManager.Reload(); err != nil {
+			slog.Error("Config reload failed, keeping previous config", "error", err)
+		}
+	}
 }
 
 func ready(s *discordgo.Session, event *discordgo.Ready) {
 	slog.Info("Bot is ready", "user", s.State.User.Username)
 
-	guildID := os.Getenv("GUILD_ID")
-	if guildID == "" {
-		guildID = "414275056265330689"
-	}
+	guildID := cfgManager.Current().GuildID
 
 	// // Delete all existing commands
 	// commands, err := s.ApplicationCommands(s.State.User.ID, guildID)
@@ -140,20 +294,72 @@ func ready(s *discordgo.Session, event *discordgo.Ready) {
 }
 
 func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		autocomplete(s, i)
+		return
+	}
+
+	var name string
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		name = i.ApplicationCommandData().Name
+	case discordgo.InteractionMessageComponent:
+		name, _, _ = strings.Cut(i.MessageComponentData().CustomID, ":")
+	case discordgo.InteractionModalSubmit:
+		name, _, _ = strings.Cut(i.ModalSubmitData().CustomID, ":")
+	default:
+		return
+	}
+
+	cmd, ok := commandMap[name]
+	if !ok {
+		slog.Error("Unknown command", "name", name)
+		return
+	}
+	execute := cmd.Execute
 	if i.Type != discordgo.InteractionApplicationCommand {
+		handler, ok := cmd.(commands.ComponentHandler)
+		if !ok {
+			slog.Error("Command has no components", "name", name)
+			return
+		}
+		execute = handler.HandleComponent
+	}
+
+	quota, refund, ok := checkRateLimit(s, i, cmd)
+	if !ok {
+		return
+	}
+	// Rejected requests give back the use checkRateLimit recorded.
+	if !commands.ScreenPrompt(commandCtx, s, i, moderator) {
+		refund()
 		return
 	}
 
-	data := i.ApplicationCommandData()
-	cmd, ok := commandMap[data.Name]
+	done, ok := inflight.Begin(cmd.Name(), i.Interaction)
 	if !ok {
-		slog.Error("Unknown command", "name", data.Name)
+		refund()
+		slog.Info("Rejecting command during shutdown", "name", cmd.Name())
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "🔄 The bot is restarting, please try again in a minute.",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
 		return
 	}
+	defer done()
 
 	slog.Info("Executing command", "name", cmd.Name(), "user", i.Member.User.Username)
 
-	err := cmd.Execute(s, i)
+	// Commands only refund before responding, so this needs no lock.
+	refunded := false
+	ctx := commands.WithRefund(withPersona(commandCtx, i.GuildID, i.ChannelID), func() {
+		refunded = true
+		refund()
+	})
+	err := execute(ctx, s, i)
 	if err != nil {
 		slog.Error("Error executing command", "name", cmd.Name(), "error", err)
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -163,7 +369,34 @@ func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
 				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
+	} else if refunded {
+		slog.Info("Command rejected the request", "name", cmd.Name())
 	} else {
 		slog.Info("Command executed successfully", "name", cmd.Name())
+		reportQuota(s, i, cmd.Name(), quota)
+	}
+}
+
+// autocomplete answers suggestions for an option being typed. It isn't
+// rate limited or screened: nothing runs until the command is sent.
+func autocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	name := i.ApplicationCommandData().Name
+	cmd, ok := commandMap[name].(commands.Autocompleter)
+	if !ok {
+		slog.Error("Command has no autocomplete", "name", name)
+		return
+	}
+	if err := cmd.Autocomplete(commandCtx, s, i); err != nil {
+		slog.Warn("Autocomplete failed", "name", name, "error", err)
+	}
+}
+
+func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author == nil || (s.State.User != nil && m.Author.ID == s.State.User.ID) {
+		return
 	}
+	aiCommand.HandleReply(withPersona(commandCtx, m.GuildID, m.ChannelID), s, m, func() (func(), bool) {
+		return admitReply(s, m)
+	})
+	indexMessage(m.GuildID, m.Message)
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+// withPersona attaches the persona assigned to the channel, or failing
+// that the guild, so every LLM request the command makes uses it. A lookup
+// failure only costs the persona, not the command.
+func withPersona(ctx context.Context, guildID, channelID string) context.Context {
+	if guildID == "" {
+		return ctx
+	}
+	persona, err := db.ResolvePersona(guildID, channelID)
+	if err != nil {
+		slog.Error("Failed to resolve persona", "guild_id", guildID, "channel_id", channelID, "error", err)
+		return ctx
+	}
+	if persona == nil {
+		return ctx
+	}
+	return llm.WithPersona(ctx, persona.Prompt)
+}
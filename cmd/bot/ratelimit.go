@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/ratelimit"
+	"github.com/josh/discord-bot/pkg/commands"
+)
+
+var limiter *ratelimit.Limiter
+
+// currentRateLimits reads limits from the active config so SIGHUP reloads
+// take effect without a restart.
+func currentRateLimits(command string) (config.RateLimitConfig, bool) {
+	rule, ok := cfgManager.Current().RateLimits[command]
+	return rule, ok
+}
+
+// checkRateLimit records a use of cmd and reports whether it may run. If
+// it may not, the user has already been told why. The returned refund
+// gives the use back if the command rejects the request. Limiter errors
+// fail open so a database problem doesn't take every command down with it.
+func checkRateLimit(s *discordgo.Session, i *discordgo.InteractionCreate, cmd commands.Command) (decision ratelimit.Decision, refund func(), ok bool) {
+	noRefund := func() {}
+	cost := 1
+	if coster, ok := cmd.(commands.Coster); ok {
+		cost = coster.Cost(i)
+	}
+	if cost == 0 {
+		return ratelimit.Decision{Allowed: true}, noRefund, true
+	}
+
+	req := commands.RateLimitRequest(cmd.Name(), i, cost)
+	decision, err := limiter.Allow(req)
+	if err != nil {
+		slog.Error("Rate limit check failed, allowing command", "name", cmd.Name(), "error", err)
+		return ratelimit.Decision{Allowed: true}, noRefund, true
+	}
+	if decision.Allowed {
+		return decision, func() {
+			if err := limiter.Refund(req, decision.Day); err != nil {
+				slog.Error("Failed to refund rejected command", "name", cmd.Name(), "error", err)
+			}
+		}, true
+	}
+
+	slog.Info("Rate limited command", "name", cmd.Name(), "user_id", req.UserID, "reason", decision.Reason)
+
+	content := fmt.Sprintf("⏱️ %s. Try again in %s.", decision.Reason, decision.RetryAfter)
+	if cost > 1 {
+		content += fmt.Sprintf("\nThis request counts as %d uses.", cost)
+	}
+	if decision.Remaining() >= 0 {
+		content += fmt.Sprintf("\nDaily quota: %s.", commands.QuotaText(decision))
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	return decision, noRefund, false
+}
+
+// admitReply lets a reply continuing an /ai conversation run as a use of
+// /ai: it's rate limited and charged like the command, and registered so
+// shutdown waits for it. If it may not run, the user has already been told
+// why; otherwise done must be called when it finishes.
+func admitReply(s *discordgo.Session, m *discordgo.MessageCreate) (done func(), ok bool) {
+	name := aiCommand.Name()
+	done, ok = inflight.BeginMessage(name, m.Message)
+	if !ok {
+		slog.Info("Rejecting AI reply during shutdown")
+		s.ChannelMessageSendReply(m.ChannelID, "🔄 The bot is restarting, please try again in a minute.", m.Reference())
+		return nil, false
+	}
+
+	req := ratelimit.Request{Command: name, GuildID: m.GuildID, UserID: m.Author.ID, Cost: 1}
+	if m.Member != nil {
+		req.RoleIDs = m.Member.Roles
+	}
+	decision, err := limiter.Allow(req)
+	if err != nil {
+		slog.Error("Rate limit check failed, allowing AI reply", "error", err)
+		return done, true
+	}
+	if decision.Allowed {
+		return done, true
+	}
+	done()
+
+	slog.Info("Rate limited AI reply", "user_id", req.UserID, "reason", decision.Reason)
+
+	content := fmt.Sprintf("⏱️ %s. Try again in %s.", decision.Reason, decision.RetryAfter)
+	if decision.Remaining() >= 0 {
+		content += fmt.Sprintf("\nDaily quota: %s.", commands.QuotaText(decision))
+	}
+	s.ChannelMessageSendReply(m.ChannelID, content, m.Reference())
+	return nil, false
+}
+
+// reportQuota tells the user privately how much of their daily quota is
+// left after a limited command has responded.
+func reportQuota(s *discordgo.Session, i *discordgo.InteractionCreate, name string, decision ratelimit.Decision) {
+	if decision.Exempt || decision.Remaining() < 0 {
+		return
+	}
+	content := fmt.Sprintf("📊 `/%s`: %s (resets at midnight UTC).", name, commands.QuotaText(decision))
+	if _, err := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: content,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	}); err != nil {
+		slog.Warn("Failed to send quota notice", "name", name, "error", err)
+	}
+}
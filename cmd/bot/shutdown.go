@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/voice"
+)
+
+// shutdown stops accepting commands and jobs, gives running ones the configured
+// grace period, cancels and tells users about those that didn't finish,
+// and releases voice, gateway and database resources. A second signal
+// skips the grace period.
+// It returns 0 if everything drained cleanly and 1 otherwise.
+func shutdown(s *discordgo.Session, signals <-chan os.Signal) int {
+	grace := cfgManager.Current().ShutdownGracePeriod
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("Received second signal, skipping grace period", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	slog.Info("Waiting for running commands to finish",
+		"active", inflight.Active(),
+		"jobs", jobManager.Active(),
+		"grace_period", grace,
+	)
+	remaining := inflight.Drain(ctx)
+
+	// Jobs post their own interruption notice once cancelled.
+	interruptedJobs := jobManager.Shutdown(ctx, 5*time.Second)
+
+	if len(remaining) > 0 {
+		// Abort outbound requests and let the commands unwind before
+		// posting the notice, so their own error edits don't replace it.
+		cancelCommands()
+		unwindCtx, cancelUnwind := context.WithTimeout(context.Background(), 5*time.Second)
+		inflight.Drain(unwindCtx)
+		cancelUnwind()
+	}
+
+	status := 0
+	if interruptedJobs > 0 {
+		status = 1
+	}
+	for _, entry := range remaining {
+		status = 1
+		slog.Warn("Interrupting command",
+			"name", entry.Command,
+			"running_for", time.Since(entry.Started).Round(time.Second),
+		)
+		content := fmt.Sprintf("⚠️ The bot is restarting and your `/%s` request was interrupted. Please try again in a minute.", entry.Command)
+		var err error
+		if entry.Message != nil {
+			_, err = s.ChannelMessageSendReply(entry.Message.ChannelID, content, entry.Message.Reference())
+		} else {
+			_, err = s.InteractionResponseEdit(entry.Interaction, &discordgo.WebhookEdit{
+				Content: &content,
+			})
+		}
+		if err != nil {
+			slog.Warn("Failed to notify user of interrupted command", "name", entry.Command, "error", err)
+		}
+	}
+
+	voice.LeaveAllVoiceChannels()
+
+	if err := s.Close(); err != nil {
+		slog.Error("Error closing Discord session", "error", err)
+		status = 1
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Error closing DB", "error", err)
+		status = 1
+	}
+
+	slog.Info("Shutdown complete", "interrupted", len(remaining), "interrupted_jobs", interruptedJobs)
+	return status
+}
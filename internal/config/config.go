@@ -0,0 +1,763 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is read when CONFIG_PATH is not set. A missing file at the
+// default path is not an error; the bot runs on defaults and env vars.
+const DefaultPath = "config.yaml"
+
+type Config struct {
+	Token               string            `yaml:"token"`
+	GuildID             string            `yaml:"guild_id"`
+	DatabasePath        string            `yaml:"database_path"`
+	ShutdownGracePeriod time.Duration     `yaml:"shutdown_grace_period"`
+	LLM                 LLMConfig         `yaml:"llm"`
+	ImageGen            ImageGenConfig    `yaml:"image_gen"`
+	AI                  AIConfig          `yaml:"ai"`
+	StockNews           StockNewsConfig   `yaml:"stock_news"`
+	Imagine             ImagineConfig     `yaml:"imagine"`
+	Jobs                JobsConfig        `yaml:"jobs"`
+	RAG                 RAGConfig         `yaml:"rag"`
+	Moderation          ModerationConfig  `yaml:"moderation"`
+	ImageSafety         ImageSafetyConfig `yaml:"image_safety"`
+	Gallery             GalleryConfig     `yaml:"gallery"`
+
+	// RateLimits is keyed by command name. Commands without an entry are
+	// not limited. rate_limits in the config file replaces the defaults
+	// as a whole, so leaving a command out removes its limits.
+	RateLimits map[string]RateLimitConfig `yaml:"rate_limits"`
+}
+
+// LLMConfig points at an OpenAI-compatible chat completions server.
+// Requests that fail with a network error, 429 or 5xx are retried up to
+// MaxRetries times, waiting RetryBackoff and doubling it each attempt.
+//
+// With Backends set, URL and Model are ignored and requests are spread
+// over the listed servers instead, skipping any that fail
+// FailureThreshold times in a row for Cooldown, or fail the health check
+// run every HealthInterval.
+type LLMConfig struct {
+	URL          string        `yaml:"url"`
+	Model        string        `yaml:"model"`
+	Timeout      time.Duration `yaml:"timeout"`
+	MaxRetries   int           `yaml:"max_retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+
+	Backends         []LLMBackendConfig `yaml:"backends"`
+	HealthInterval   time.Duration      `yaml:"health_interval"`
+	FailureThreshold int                `yaml:"failure_threshold"`
+	Cooldown         time.Duration      `yaml:"cooldown"`
+}
+
+// LLMBackendConfig is one server in the LLM pool. Weight is its share of
+// requests; ContextLength (0 for unknown), Vision and Tools say which
+// requests it can take. Name is what users pick with /ai ask model.
+type LLMBackendConfig struct {
+	Name          string `yaml:"name"`
+	URL           string `yaml:"url"`
+	Model         string `yaml:"model"`
+	Weight        int    `yaml:"weight"`
+	ContextLength int    `yaml:"context_length"`
+	Vision        bool   `yaml:"vision"`
+	Tools         bool   `yaml:"tools"`
+}
+
+// Pool returns the configured backends, or the single server given by URL
+// and Model, assumed capable of anything, if there are none.
+func (c LLMConfig) Pool() []LLMBackendConfig {
+	if len(c.Backends) > 0 {
+		return c.Backends
+	}
+	return []LLMBackendConfig{{
+		Name:   c.Model,
+		URL:    c.URL,
+		Model:  c.Model,
+		Weight: 1,
+		Vision: true,
+		Tools:  true,
+	}}
+}
+
+// AIConfig controls /ai conversations. History older than the token budget
+// is summarized (or dropped if summarizing is off) before each request.
+// With Tools on, the model may look up stock news and sentiment, generate
+// an image or queue a song, for up to MaxToolSteps rounds per answer.
+type AIConfig struct {
+	SystemPrompt       string `yaml:"system_prompt"`
+	HistoryTokenBudget int    `yaml:"history_token_budget"`
+	SummarizeHistory   bool   `yaml:"summarize_history"`
+	Tools              bool   `yaml:"tools"`
+	MaxToolSteps       int    `yaml:"max_tool_steps"`
+
+	// SummaryMaxMessages caps how many messages /summarize reads, and
+	// SummaryChunkTokens how much of them goes into each LLM request.
+	SummaryMaxMessages int `yaml:"summary_max_messages"`
+	SummaryChunkTokens int `yaml:"summary_chunk_tokens"`
+}
+
+// ImageGenConfig points at the image server. Backend is its kind: the
+// Automatic1111 web UI (automatic1111), ComfyUI (comfyui) or an
+// OpenAI-compatible images API (openai). Requests to it run one at a time;
+// Timeout bounds quick calls, and a generation is stopped once its
+// progress hasn't moved for that long.
+//
+// Model is the model used when a request doesn't pick one, for ComfyUI
+// and OpenAI-compatible servers; APIKey is sent to the latter. Workflow
+// and Img2ImgWorkflow are ComfyUI workflow files to use instead of the
+// built-in ones.
+type ImageGenConfig struct {
+	Backend string        `yaml:"backend"`
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	Model           string `yaml:"model"`
+	APIKey          string `yaml:"api_key"`
+	Workflow        string `yaml:"workflow"`
+	Img2ImgWorkflow string `yaml:"img2img_workflow"`
+}
+
+const (
+	ImageBackendAutomatic1111 = "automatic1111"
+	ImageBackendComfyUI       = "comfyui"
+	ImageBackendOpenAI        = "openai"
+)
+
+type StockNewsConfig struct {
+	MarketauxAPIKey    string `yaml:"marketaux_api_key"`
+	AlphaVantageAPIKey string `yaml:"alpha_vantage_api_key"`
+	DefaultDays        int    `yaml:"default_days"`
+}
+
+type ImagineConfig struct {
+	MaxWidth  int `yaml:"max_width"`
+	MaxHeight int `yaml:"max_height"`
+	MaxSteps  int `yaml:"max_steps"`
+
+	// Sampler and NegativePrompt are used when neither the preset nor the
+	// user picks one.
+	Sampler        string `yaml:"sampler"`
+	NegativePrompt string `yaml:"negative_prompt"`
+
+	// Presets are keyed by the name users pick them by. Presets in the
+	// config file are added to the built-in ones, or replace them.
+	Presets map[string]ImaginePreset `yaml:"presets"`
+}
+
+// ImaginePreset is a named style for /imagine. Prompt is a template in
+// which {prompt} is replaced by what the user typed; empty fields and a
+// zero CfgScale or Steps leave the usual defaults alone. Checkpoint is the
+// model to switch to, as listed by the web UI.
+type ImaginePreset struct {
+	Description    string  `yaml:"description"`
+	Prompt         string  `yaml:"prompt"`
+	NegativePrompt string  `yaml:"negative_prompt"`
+	Sampler        string  `yaml:"sampler"`
+	CfgScale       float64 `yaml:"cfg_scale"`
+	Steps          int     `yaml:"steps"`
+	Checkpoint     string  `yaml:"checkpoint"`
+}
+
+// GalleryConfig controls /gallery, which lists every /imagine result.
+// With StoreImages on, the images are also kept under Dir, with thumbnails
+// ThumbnailSize pixels on their longer side for the gallery to show.
+// PageSize is how many images a gallery page lists.
+type GalleryConfig struct {
+	StoreImages   bool   `yaml:"store_images"`
+	Dir           string `yaml:"dir"`
+	ThumbnailSize int    `yaml:"thumbnail_size"`
+	PageSize      int    `yaml:"page_size"`
+}
+
+// ModerationConfig screens prompts to /ai, /ask, /imagine and /pdf, and
+// what the bot posts from the LLM. Prompts matching the Blocklist (whole
+// words, any case), a Patterns regex, an invite link or a known prompt
+// injection phrase are refused; in replies the matches are masked. With
+// Classifier on, the LLM is also asked to judge text, and replies it
+// flags are withheld; it gets ClassifierTimeout before the text is let
+// through. Everything flagged is posted to LogChannel, which servers set
+// with /config set moderation.log_channel.
+type ModerationConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	Blocklist         []string      `yaml:"blocklist"`
+	Patterns          []string      `yaml:"patterns"`
+	BlockInvites      bool          `yaml:"block_invites"`
+	BlockInjection    bool          `yaml:"block_injection"`
+	Classifier        bool          `yaml:"classifier"`
+	ClassifierTimeout time.Duration `yaml:"classifier_timeout"`
+	LogChannel        string        `yaml:"log_channel"`
+}
+
+// ImageSafetyConfig gates what /imagine posts. Prompts containing one of
+// the BlockedTerms (whole words, any case) are refused, and RewriteTerms
+// are replaced by their values before generation. With ClassifierURL set,
+// every image is scored by the safety checker there; images scoring
+// Threshold or more are posted as spoilers in age-restricted channels and
+// withheld everywhere else. Images the checker can't score are posted as
+// spoilers. Refusals and withheld images are posted to the moderation
+// log channel.
+type ImageSafetyConfig struct {
+	Enabled           bool              `yaml:"enabled"`
+	BlockedTerms      []string          `yaml:"blocked_terms"`
+	RewriteTerms      map[string]string `yaml:"rewrite_terms"`
+	ClassifierURL     string            `yaml:"classifier_url"`
+	ClassifierTimeout time.Duration     `yaml:"classifier_timeout"`
+	Threshold         float64           `yaml:"threshold"`
+}
+
+// JobsConfig bounds the background job queue used by /pdf, /imagine and
+// /stock. Worker counts are per backend and are read once at startup.
+// Image requests aren't limited here: the image server's own queue runs
+// them one at a time, interactive ones first.
+type JobsConfig struct {
+	LLMWorkers       int           `yaml:"llm_workers"`
+	ChromiumWorkers  int           `yaml:"chromium_workers"`
+	PerUserLimit     int           `yaml:"per_user_limit"`
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+}
+
+// RAGConfig controls the message index behind /ask. Channels are only
+// indexed once an admin opts them in with /index enable. Sizes are in
+// characters.
+type RAGConfig struct {
+	EmbeddingURL       string        `yaml:"embedding_url"`
+	EmbeddingModel     string        `yaml:"embedding_model"`
+	EmbeddingTimeout   time.Duration `yaml:"embedding_timeout"`
+	ChunkSize          int           `yaml:"chunk_size"`
+	ChunkOverlap       int           `yaml:"chunk_overlap"`
+	MinMessageLength   int           `yaml:"min_message_length"`
+	MaxAttachmentBytes int64         `yaml:"max_attachment_bytes"`
+	BackfillMessages   int           `yaml:"backfill_messages"`
+	TopK               int           `yaml:"top_k"`
+	MinScore           float64       `yaml:"min_score"`
+}
+
+// RateLimitConfig combines a token bucket, which stops bursts, with daily
+// quotas. Zero disables the corresponding limit.
+type RateLimitConfig struct {
+	Burst      int     `yaml:"burst"`
+	PerMinute  float64 `yaml:"per_minute"`
+	UserDaily  int     `yaml:"user_daily"`
+	GuildDaily int     `yaml:"guild_daily"`
+
+	// RoleDaily maps role IDs to a daily quota that replaces user_daily
+	// for members with that role. The highest applicable quota wins.
+	RoleDaily map[string]int `yaml:"role_daily"`
+}
+
+func Default() *Config {
+	return &Config{
+		GuildID:             "414275056265330689",
+		DatabasePath:        "./playlists.db",
+		ShutdownGracePeriod: 30 * time.Second,
+		LLM: LLMConfig{
+			URL:              "http://localhost:8081",
+			Model:            "llama",
+			Timeout:          60 * time.Second,
+			MaxRetries:       2,
+			RetryBackoff:     time.Second,
+			HealthInterval:   30 * time.Second,
+			FailureThreshold: 3,
+			Cooldown:         30 * time.Second,
+		},
+		ImageGen: ImageGenConfig{
+			Backend: ImageBackendAutomatic1111,
+			URL:     "http://localhost:7860",
+			Timeout: 60 * time.Second,
+		},
+		AI: AIConfig{
+			SystemPrompt:       "You are a helpful assistant in a Discord server. User messages are prefixed with the sender's name. Keep answers concise.",
+			HistoryTokenBudget: 3000,
+			SummarizeHistory:   true,
+			MaxToolSteps:       4,
+			SummaryMaxMessages: 1000,
+			SummaryChunkTokens: 3000,
+		},
+		StockNews: StockNewsConfig{
+			DefaultDays: 7,
+		},
+		Imagine: ImagineConfig{
+			MaxWidth:       2048,
+			MaxHeight:      2048,
+			MaxSteps:       50,
+			Sampler:        "DPM++ 2M",
+			NegativePrompt: "ugly, blurry, low quality, distorted, deformed, bad anatomy",
+			Presets: map[string]ImaginePreset{
+				"photo": {
+					Description:    "Realistic photograph",
+					Prompt:         "{prompt}, photograph, natural lighting, sharp focus, 35mm, highly detailed",
+					NegativePrompt: "drawing, painting, illustration, cartoon, anime, 3d render, ugly, blurry, low quality, deformed",
+					Sampler:        "DPM++ 2M",
+					CfgScale:       6,
+					Steps:          30,
+				},
+				"anime": {
+					Description:    "Anime illustration",
+					Prompt:         "{prompt}, anime style, cel shading, vibrant colors, detailed line art",
+					NegativePrompt: "photo, realistic, 3d render, ugly, blurry, low quality, bad anatomy, extra fingers",
+					Sampler:        "Euler a",
+					CfgScale:       7,
+					Steps:          28,
+				},
+				"pixel-art": {
+					Description:    "Retro 16-bit pixel art",
+					Prompt:         "pixel art of {prompt}, 16-bit, limited palette, crisp pixels, retro game sprite",
+					NegativePrompt: "blurry, smooth shading, gradient, photo, realistic, anti-aliasing, low quality",
+					Sampler:        "Euler a",
+					CfgScale:       7,
+					Steps:          25,
+				},
+				"digital-art": {
+					Description:    "Polished digital painting",
+					Prompt:         "{prompt}, digital painting, concept art, dramatic lighting, trending on artstation, highly detailed",
+					NegativePrompt: "photo, ugly, blurry, low quality, distorted, deformed, watermark, text",
+					Sampler:        "DPM++ 2M",
+					CfgScale:       7,
+					Steps:          30,
+				},
+				"watercolor": {
+					Description:    "Soft watercolor painting",
+					Prompt:         "watercolor painting of {prompt}, soft washes, paper texture, loose brushstrokes",
+					NegativePrompt: "photo, 3d render, sharp edges, ugly, blurry, low quality, deformed",
+					Sampler:        "Euler a",
+					CfgScale:       6.5,
+					Steps:          28,
+				},
+				"cinematic": {
+					Description:    "Film still with dramatic lighting",
+					Prompt:         "cinematic film still of {prompt}, shallow depth of field, anamorphic, film grain, moody lighting",
+					NegativePrompt: "cartoon, drawing, anime, ugly, blurry, low quality, deformed, oversaturated",
+					Sampler:        "DPM++ 2M",
+					CfgScale:       6,
+					Steps:          30,
+				},
+			},
+		},
+		Jobs: JobsConfig{
+			LLMWorkers:       1,
+			ChromiumWorkers:  2,
+			PerUserLimit:     2,
+			ProgressInterval: 5 * time.Second,
+		},
+		RAG: RAGConfig{
+			EmbeddingURL:       "http://localhost:8082",
+			EmbeddingModel:     "nomic-embed-text",
+			EmbeddingTimeout:   30 * time.Second,
+			ChunkSize:          1000,
+			ChunkOverlap:       150,
+			MinMessageLength:   20,
+			MaxAttachmentBytes: 5 << 20,
+			BackfillMessages:   500,
+			TopK:               6,
+			MinScore:           0.3,
+		},
+		Moderation: ModerationConfig{
+			Enabled:           true,
+			BlockInvites:      true,
+			BlockInjection:    true,
+			ClassifierTimeout: 2 * time.Second,
+		},
+		ImageSafety: ImageSafetyConfig{
+			Enabled:           true,
+			ClassifierTimeout: 15 * time.Second,
+			Threshold:         0.5,
+		},
+		Gallery: GalleryConfig{
+			Dir:           "gallery",
+			ThumbnailSize: 256,
+			PageSize:      5,
+		},
+		RateLimits: map[string]RateLimitConfig{
+			"imagine":   {Burst: 3, PerMinute: 1, UserDaily: 50, GuildDaily: 500},
+			"pdf":       {Burst: 1, PerMinute: 0.2, UserDaily: 10, GuildDaily: 100},
+			"stock":     {Burst: 2, PerMinute: 1, UserDaily: 30},
+			"summarize": {Burst: 2, PerMinute: 0.5, UserDaily: 20},
+		},
+	}
+}
+
+// Load builds a Config from defaults, the YAML file at path and environment
+// overrides, in that order, and validates the result.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		// Maps are decoded into the defaults' entries, so rate_limits is
+		// decoded on its own to replace them.
+		defaultLimits := cfg.RateLimits
+		cfg.RateLimits = nil
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if cfg.RateLimits == nil {
+			cfg.RateLimits = defaultLimits
+		}
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+	default:
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func applyEnv(cfg *Config) error {
+	stringVars := map[string]*string{
+		"TOKEN":                 &cfg.Token,
+		"GUILD_ID":              &cfg.GuildID,
+		"DATABASE_PATH":         &cfg.DatabasePath,
+		"LLM_URL":               &cfg.LLM.URL,
+		"LLM_MODEL":             &cfg.LLM.Model,
+		"IMAGE_GEN_BACKEND":     &cfg.ImageGen.Backend,
+		"IMAGE_GEN_URL":         &cfg.ImageGen.URL,
+		"IMAGE_GEN_API_KEY":     &cfg.ImageGen.APIKey,
+		"MARKETAUX_API_KEY":     &cfg.StockNews.MarketauxAPIKey,
+		"ALPHA_VANTAGE_API_KEY": &cfg.StockNews.AlphaVantageAPIKey,
+	}
+	for key, field := range stringVars {
+		if v := os.Getenv(key); v != "" {
+			*field = v
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"LLM_TIMEOUT":           &cfg.LLM.Timeout,
+		"IMAGE_GEN_TIMEOUT":     &cfg.ImageGen.Timeout,
+		"SHUTDOWN_GRACE_PERIOD": &cfg.ShutdownGracePeriod,
+	}
+	for key, field := range durations {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q: %w", key, v, err)
+		}
+		*field = d
+	}
+
+	return nil
+}
+
+// Validate reports every problem with the config at once so a broken
+// deployment can be fixed in one pass.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Token == "" {
+		errs = append(errs, errors.New("token: required (set TOKEN or token in the config file)"))
+	}
+	if c.GuildID == "" {
+		errs = append(errs, errors.New("guild_id: required"))
+	}
+	if c.DatabasePath == "" {
+		errs = append(errs, errors.New("database_path: required"))
+	}
+
+	if c.ShutdownGracePeriod <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_grace_period: must be positive, got %s", c.ShutdownGracePeriod))
+	}
+
+	errs = append(errs, validateURL("llm.url", c.LLM.URL))
+	errs = append(errs, validateURL("image_gen.url", c.ImageGen.URL))
+
+	if c.LLM.Model == "" {
+		errs = append(errs, errors.New("llm.model: required"))
+	}
+	if c.LLM.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("llm.timeout: must be positive, got %s", c.LLM.Timeout))
+	}
+	if c.LLM.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("llm.max_retries: must not be negative, got %d", c.LLM.MaxRetries))
+	}
+	if c.LLM.MaxRetries > 0 && c.LLM.RetryBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("llm.retry_backoff: must be positive when retries are enabled, got %s", c.LLM.RetryBackoff))
+	}
+	if c.LLM.HealthInterval <= 0 {
+		errs = append(errs, fmt.Errorf("llm.health_interval: must be positive, got %s", c.LLM.HealthInterval))
+	}
+	if c.LLM.FailureThreshold < 1 {
+		errs = append(errs, fmt.Errorf("llm.failure_threshold: must be at least 1, got %d", c.LLM.FailureThreshold))
+	}
+	if c.LLM.Cooldown <= 0 {
+		errs = append(errs, fmt.Errorf("llm.cooldown: must be positive, got %s", c.LLM.Cooldown))
+	}
+	names := make(map[string]bool)
+	for n, b := range c.LLM.Backends {
+		field := fmt.Sprintf("llm.backends[%d]", n)
+		if b.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: required", field))
+		} else if names[b.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: %q is used twice", field, b.Name))
+		}
+		names[b.Name] = true
+		errs = append(errs, validateURL(field+".url", b.URL))
+		if b.Model == "" {
+			errs = append(errs, fmt.Errorf("%s.model: required", field))
+		}
+		if b.Weight < 0 {
+			errs = append(errs, fmt.Errorf("%s.weight: must not be negative, got %d", field, b.Weight))
+		}
+		if b.ContextLength < 0 {
+			errs = append(errs, fmt.Errorf("%s.context_length: must not be negative, got %d", field, b.ContextLength))
+		}
+	}
+	switch c.ImageGen.Backend {
+	case ImageBackendAutomatic1111, ImageBackendComfyUI, ImageBackendOpenAI:
+	default:
+		errs = append(errs, fmt.Errorf("image_gen.backend: must be %s, %s or %s, got %q", ImageBackendAutomatic1111, ImageBackendComfyUI, ImageBackendOpenAI, c.ImageGen.Backend))
+	}
+	if c.ImageGen.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("image_gen.timeout: must be positive, got %s", c.ImageGen.Timeout))
+	}
+	if c.AI.HistoryTokenBudget < 256 {
+		errs = append(errs, fmt.Errorf("ai.history_token_budget: must be at least 256, got %d", c.AI.HistoryTokenBudget))
+	}
+	if c.AI.MaxToolSteps < 1 {
+		errs = append(errs, fmt.Errorf("ai.max_tool_steps: must be at least 1, got %d", c.AI.MaxToolSteps))
+	}
+	if c.AI.SummaryMaxMessages < 1 {
+		errs = append(errs, fmt.Errorf("ai.summary_max_messages: must be at least 1, got %d", c.AI.SummaryMaxMessages))
+	}
+	if c.AI.SummaryChunkTokens < 500 {
+		errs = append(errs, fmt.Errorf("ai.summary_chunk_tokens: must be at least 500, got %d", c.AI.SummaryChunkTokens))
+	}
+	if c.StockNews.DefaultDays <= 0 {
+		errs = append(errs, fmt.Errorf("stock_news.default_days: must be positive, got %d", c.StockNews.DefaultDays))
+	}
+
+	errs = append(errs, c.Imagine.validate())
+	errs = append(errs, c.Jobs.validate())
+	errs = append(errs, c.RAG.validate())
+	errs = append(errs, c.Moderation.validate())
+	errs = append(errs, c.ImageSafety.validate())
+	errs = append(errs, c.Gallery.validate())
+	for command, limits := range c.RateLimits {
+		errs = append(errs, limits.validate("rate_limits."+command))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c ImagineConfig) validate() error {
+	var errs []error
+	if c.MaxWidth <= 0 || c.MaxWidth%8 != 0 {
+		errs = append(errs, fmt.Errorf("imagine.max_width: must be a positive multiple of 8, got %d", c.MaxWidth))
+	}
+	if c.MaxHeight <= 0 || c.MaxHeight%8 != 0 {
+		errs = append(errs, fmt.Errorf("imagine.max_height: must be a positive multiple of 8, got %d", c.MaxHeight))
+	}
+	if c.MaxSteps <= 0 {
+		errs = append(errs, fmt.Errorf("imagine.max_steps: must be positive, got %d", c.MaxSteps))
+	}
+	for name, preset := range c.Presets {
+		field := "imagine.presets." + name
+		if !presetName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s: names must be lowercase letters, digits and dashes, up to 32 long", field))
+		}
+		if preset.Prompt != "" && !strings.Contains(preset.Prompt, "{prompt}") {
+			errs = append(errs, fmt.Errorf("%s.prompt: must contain {prompt}", field))
+		}
+		if preset.CfgScale < 0 || preset.CfgScale > 30 {
+			errs = append(errs, fmt.Errorf("%s.cfg_scale: must be from 0 to 30, got %g", field, preset.CfgScale))
+		}
+		if preset.Steps < 0 {
+			errs = append(errs, fmt.Errorf("%s.steps: must not be negative, got %d", field, preset.Steps))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var presetName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+func (c JobsConfig) validate() error {
+	var errs []error
+	workers := []struct {
+		field string
+		value int
+	}{
+		{"jobs.llm_workers", c.LLMWorkers},
+		{"jobs.chromium_workers", c.ChromiumWorkers},
+		{"jobs.per_user_limit", c.PerUserLimit},
+	}
+	for _, w := range workers {
+		if w.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive, got %d", w.field, w.value))
+		}
+	}
+	if c.ProgressInterval < time.Second {
+		errs = append(errs, fmt.Errorf("jobs.progress_interval: must be at least 1s, got %s", c.ProgressInterval))
+	}
+	return errors.Join(errs...)
+}
+
+func (c RAGConfig) validate() error {
+	errs := []error{validateURL("rag.embedding_url", c.EmbeddingURL)}
+	if c.EmbeddingModel == "" {
+		errs = append(errs, errors.New("rag.embedding_model: required"))
+	}
+	if c.EmbeddingTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("rag.embedding_timeout: must be positive, got %s", c.EmbeddingTimeout))
+	}
+	if c.ChunkSize < 100 {
+		errs = append(errs, fmt.Errorf("rag.chunk_size: must be at least 100, got %d", c.ChunkSize))
+	}
+	if c.ChunkOverlap < 0 || c.ChunkOverlap >= c.ChunkSize/2 {
+		errs = append(errs, fmt.Errorf("rag.chunk_overlap: must be between 0 and half of chunk_size, got %d", c.ChunkOverlap))
+	}
+	if c.MaxAttachmentBytes < 0 {
+		errs = append(errs, fmt.Errorf("rag.max_attachment_bytes: must not be negative, got %d", c.MaxAttachmentBytes))
+	}
+	if c.BackfillMessages < 0 {
+		errs = append(errs, fmt.Errorf("rag.backfill_messages: must not be negative, got %d", c.BackfillMessages))
+	}
+	if c.TopK < 1 || c.TopK > 20 {
+		errs = append(errs, fmt.Errorf("rag.top_k: must be between 1 and 20, got %d", c.TopK))
+	}
+	if c.MinScore < -1 || c.MinScore > 1 {
+		errs = append(errs, fmt.Errorf("rag.min_score: must be between -1 and 1, got %g", c.MinScore))
+	}
+	return errors.Join(errs...)
+}
+
+func (c ModerationConfig) validate() error {
+	var errs []error
+	for n, pattern := range c.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("moderation.patterns[%d]: %w", n, err))
+		}
+	}
+	for n, word := range c.Blocklist {
+		if strings.TrimSpace(word) == "" {
+			errs = append(errs, fmt.Errorf("moderation.blocklist[%d]: must not be empty", n))
+		}
+	}
+	// Prompts are screened before the command answers Discord, which
+	// allows three seconds.
+	if c.Classifier && (c.ClassifierTimeout <= 0 || c.ClassifierTimeout > 2500*time.Millisecond) {
+		errs = append(errs, fmt.Errorf("moderation.classifier_timeout: must be between 0 and 2.5s when the classifier is on, got %s", c.ClassifierTimeout))
+	}
+	if c.LogChannel != "" && !isSnowflake(c.LogChannel) {
+		errs = append(errs, fmt.Errorf("moderation.log_channel: %q is not a channel ID", c.LogChannel))
+	}
+	return errors.Join(errs...)
+}
+
+func (c ImageSafetyConfig) validate() error {
+	var errs []error
+	for n, term := range c.BlockedTerms {
+		if strings.TrimSpace(term) == "" {
+			errs = append(errs, fmt.Errorf("image_safety.blocked_terms[%d]: must not be empty", n))
+		}
+	}
+	for term := range c.RewriteTerms {
+		if strings.TrimSpace(term) == "" {
+			errs = append(errs, errors.New("image_safety.rewrite_terms: terms must not be empty"))
+		}
+	}
+	if c.ClassifierURL != "" {
+		errs = append(errs, validateURL("image_safety.classifier_url", c.ClassifierURL))
+		if c.ClassifierTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("image_safety.classifier_timeout: must be positive, got %s", c.ClassifierTimeout))
+		}
+	}
+	if c.Threshold <= 0 || c.Threshold > 1 {
+		errs = append(errs, fmt.Errorf("image_safety.threshold: must be above 0 and at most 1, got %g", c.Threshold))
+	}
+	return errors.Join(errs...)
+}
+
+func (c GalleryConfig) validate() error {
+	var errs []error
+	if c.StoreImages && c.Dir == "" {
+		errs = append(errs, errors.New("gallery.dir: required when store_images is on"))
+	}
+	if c.ThumbnailSize < 64 || c.ThumbnailSize > 1024 {
+		errs = append(errs, fmt.Errorf("gallery.thumbnail_size: must be between 64 and 1024, got %d", c.ThumbnailSize))
+	}
+	// A page is a message of one embed per image, and Discord allows ten.
+	if c.PageSize < 1 || c.PageSize > 10 {
+		errs = append(errs, fmt.Errorf("gallery.page_size: must be between 1 and 10, got %d", c.PageSize))
+	}
+	return errors.Join(errs...)
+}
+
+func isSnowflake(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 64)
+	return err == nil
+}
+
+func (c RateLimitConfig) validate(field string) error {
+	var errs []error
+	if c.Burst < 0 {
+		errs = append(errs, fmt.Errorf("%s.burst: must not be negative, got %d", field, c.Burst))
+	}
+	if c.Burst > 0 && c.PerMinute <= 0 {
+		errs = append(errs, fmt.Errorf("%s.per_minute: must be positive when burst is set, got %g", field, c.PerMinute))
+	}
+	if c.UserDaily < 0 {
+		errs = append(errs, fmt.Errorf("%s.user_daily: must not be negative, got %d", field, c.UserDaily))
+	}
+	if c.GuildDaily < 0 {
+		errs = append(errs, fmt.Errorf("%s.guild_daily: must not be negative, got %d", field, c.GuildDaily))
+	}
+	for role, quota := range c.RoleDaily {
+		if quota <= 0 {
+			errs = append(errs, fmt.Errorf("%s.role_daily.%s: must be positive, got %d", field, role, quota))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateURL(field, raw string) error {
+	if raw == "" {
+		return fmt.Errorf("%s: required", field)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%s: invalid URL %q: %w", field, raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s: URL %q must use http or https", field, raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s: URL %q has no host", field, raw)
+	}
+	return nil
+}
+
+func parsePositiveInt(key, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", key, value)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s: must be positive, got %d", key, n)
+	}
+	return n, nil
+}
@@ -0,0 +1,369 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoad_FileAndEnvOverrides(t *testing.T) {
+	path := writeConfig(t, `
+token: file-token
+guild_id: "123"
+llm:
+  url: http://llm.local:9000
+  timeout: 90s
+imagine:
+  max_steps: 40
+`)
+	t.Setenv("TOKEN", "")
+	t.Setenv("LLM_URL", "")
+	t.Setenv("IMAGE_GEN_URL", "http://sd.local:7860")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Token != "file-token" {
+		t.Errorf("Expected token from file, got %q", cfg.Token)
+	}
+	if cfg.LLM.URL != "http://llm.local:9000" {
+		t.Errorf("Expected LLM URL from file, got %q", cfg.LLM.URL)
+	}
+	if cfg.LLM.Timeout != 90*time.Second {
+		t.Errorf("Expected 90s LLM timeout, got %s", cfg.LLM.Timeout)
+	}
+	if cfg.ImageGen.URL != "http://sd.local:7860" {
+		t.Errorf("Expected image gen URL from env, got %q", cfg.ImageGen.URL)
+	}
+	if cfg.Imagine.MaxSteps != 40 {
+		t.Errorf("Expected max steps 40, got %d", cfg.Imagine.MaxSteps)
+	}
+	if cfg.Imagine.MaxWidth != 2048 {
+		t.Errorf("Expected default max width to survive partial file, got %d", cfg.Imagine.MaxWidth)
+	}
+}
+
+func TestLoad_MissingDefaultFileUsesEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("TOKEN", "env-token")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Token != "env-token" {
+		t.Errorf("Expected token from env, got %q", cfg.Token)
+	}
+	if cfg.LLM.URL != "http://localhost:8081" {
+		t.Errorf("Expected default LLM URL, got %q", cfg.LLM.URL)
+	}
+}
+
+func TestLoad_MissingExplicitFileFails(t *testing.T) {
+	t.Setenv("TOKEN", "env-token")
+
+	_, err := Load(filepath.Join(t.TempDir(), "nope.yaml"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestValidate_ReportsAllErrors(t *testing.T) {
+	cfg := Default()
+	cfg.LLM.URL = "localhost:8081"
+	cfg.ImageGen.Timeout = 0
+	cfg.Imagine.MaxWidth = 1001
+	cfg.Jobs.ChromiumWorkers = 0
+	cfg.LLM.MaxRetries = -1
+	cfg.RateLimits["pdf"] = RateLimitConfig{Burst: 2}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+
+	for _, want := range []string{"token: required", "llm.url", "image_gen.timeout", "imagine.max_width", "jobs.chromium_workers", "rate_limits.pdf.per_minute", "llm.max_retries"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestValidate_LLMBackends(t *testing.T) {
+	cfg := Default()
+	cfg.Token = "token"
+	cfg.LLM.Backends = []LLMBackendConfig{
+		{Name: "small", URL: "http://localhost:8081", Model: "qwen"},
+		{Name: "small", URL: "localhost:8082", Model: ""},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, want := range []string{`llm.backends[1].name: "small" is used twice`, "llm.backends[1].url", "llm.backends[1].model: required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "llm.backends[0]") {
+		t.Errorf("Expected first backend to be valid, got: %v", err)
+	}
+}
+
+func TestValidate_Moderation(t *testing.T) {
+	cfg := Default()
+	cfg.Token = "token"
+	cfg.Moderation.Patterns = []string{`ok`, `(unclosed`}
+	cfg.Moderation.Classifier = true
+	cfg.Moderation.ClassifierTimeout = 5 * time.Second
+	cfg.Moderation.LogChannel = "#mod-log"
+
+	err := cfg.Validate()
+	for _, want := range []string{"moderation.patterns[1]", "moderation.classifier_timeout", "moderation.log_channel"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+
+	if err := ValidateGuildSetting("moderation.log_channel", "<#1234567890>"); err != nil {
+		t.Errorf("Expected a channel mention to be accepted, got %v", err)
+	}
+	if err := ValidateGuildSetting("moderation.log_channel", "mod-log"); err == nil {
+		t.Error("Expected a channel name to be rejected")
+	}
+}
+
+func TestValidate_ImageSafety(t *testing.T) {
+	cfg := Default()
+	cfg.Token = "token"
+	cfg.ImageSafety.BlockedTerms = []string{"gore", " "}
+	cfg.ImageSafety.ClassifierURL = "localhost:7861"
+	cfg.ImageSafety.Threshold = 1.5
+
+	err := cfg.Validate()
+	for _, want := range []string{"image_safety.blocked_terms[1]", "image_safety.classifier_url", "image_safety.threshold"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestValidate_ImageGenBackend(t *testing.T) {
+	cfg := Default()
+	cfg.Token = "token"
+	for _, backend := range []string{ImageBackendAutomatic1111, ImageBackendComfyUI, ImageBackendOpenAI} {
+		cfg.ImageGen.Backend = backend
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected %s to be valid, got: %v", backend, err)
+		}
+	}
+
+	cfg.ImageGen.Backend = "invokeai"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "image_gen.backend") {
+		t.Errorf("Expected an unknown backend to be rejected, got: %v", err)
+	}
+}
+
+func TestValidate_ImaginePresets(t *testing.T) {
+	cfg := Default()
+	cfg.Token = "token"
+	cfg.Imagine.Presets["Bad Name"] = ImaginePreset{}
+	cfg.Imagine.Presets["no-slot"] = ImaginePreset{Prompt: "a cat, oil painting"}
+	cfg.Imagine.Presets["wild"] = ImaginePreset{CfgScale: 50, Steps: -1}
+
+	err := cfg.Validate()
+	for _, want := range []string{"imagine.presets.Bad Name", "imagine.presets.no-slot.prompt", "imagine.presets.wild.cfg_scale", "imagine.presets.wild.steps"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoad_PresetsAddToBuiltIns(t *testing.T) {
+	path := writeConfig(t, `
+token: file-token
+imagine:
+  presets:
+    photo:
+      prompt: "{prompt}, studio portrait"
+      checkpoint: realisticVision.safetensors
+    logo:
+      description: Flat vector logo
+      prompt: "flat vector logo of {prompt}"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	presets := cfg.Imagine.Presets
+	if presets["logo"].Prompt != "flat vector logo of {prompt}" {
+		t.Errorf("Expected the new preset, got %+v", presets["logo"])
+	}
+	if p := presets["photo"]; p.Prompt != "{prompt}, studio portrait" || p.Checkpoint != "realisticVision.safetensors" {
+		t.Errorf("Expected photo replaced, got %+v", p)
+	}
+	if _, ok := presets["anime"]; !ok {
+		t.Error("Expected built-in presets to survive")
+	}
+	if _, ok := Default().Imagine.Presets["logo"]; ok {
+		t.Error("Expected loading not to change the defaults")
+	}
+}
+
+func TestLoad_RateLimitsReplaceDefaults(t *testing.T) {
+	path := writeConfig(t, `
+token: file-token
+rate_limits:
+  imagine:
+    burst: 1
+    per_minute: 1
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.RateLimits) != 1 || cfg.RateLimits["imagine"].UserDaily != 0 {
+		t.Errorf("Expected only the file's limits, got %+v", cfg.RateLimits)
+	}
+
+	path = writeConfig(t, "token: file-token\nrate_limits: {}\n")
+	if cfg, err = Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.RateLimits) != 0 {
+		t.Errorf("Expected an empty rate_limits to remove every limit, got %+v", cfg.RateLimits)
+	}
+
+	path = writeConfig(t, "token: file-token\n")
+	if cfg, err = Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.RateLimits) != len(Default().RateLimits) {
+		t.Errorf("Expected the default limits without rate_limits, got %+v", cfg.RateLimits)
+	}
+}
+
+func TestLLMConfig_PoolFallsBackToURL(t *testing.T) {
+	cfg := Default().LLM
+	pool := cfg.Pool()
+	if len(pool) != 1 || pool[0].URL != cfg.URL || pool[0].Model != cfg.Model || !pool[0].Vision || !pool[0].Tools {
+		t.Errorf("Expected single backend from llm.url, got %+v", pool)
+	}
+
+	cfg.Backends = []LLMBackendConfig{{Name: "a"}, {Name: "b"}}
+	if pool := cfg.Pool(); len(pool) != 2 {
+		t.Errorf("Expected configured backends, got %+v", pool)
+	}
+}
+
+func TestLoad_InvalidDurationEnv(t *testing.T) {
+	t.Setenv("TOKEN", "env-token")
+	t.Setenv("LLM_TIMEOUT", "soon")
+
+	_, err := Load(writeConfig(t, ""))
+	if err == nil || !strings.Contains(err.Error(), "LLM_TIMEOUT") {
+		t.Errorf("Expected LLM_TIMEOUT error, got %v", err)
+	}
+}
+
+func TestManager_ForGuild(t *testing.T) {
+	t.Setenv("TOKEN", "env-token")
+	path := writeConfig(t, "")
+
+	overrides := map[string]map[string]string{
+		"guild-a": {"imagine.max_steps": "20", "stock_news.default_days": "3"},
+		"guild-b": {"imagine.max_width": "1001", "imagine.max_steps": "nope"},
+	}
+	m, err := NewManager(path, func(guildID string) (map[string]string, error) {
+		return overrides[guildID], nil
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	a := m.ForGuild("guild-a")
+	if a.Imagine.MaxSteps != 20 || a.StockNews.DefaultDays != 3 {
+		t.Errorf("Expected guild-a overrides applied, got steps=%d days=%d", a.Imagine.MaxSteps, a.StockNews.DefaultDays)
+	}
+	if m.Current().Imagine.MaxSteps != 50 {
+		t.Errorf("Guild overrides must not leak into global config")
+	}
+
+	b := m.ForGuild("guild-b")
+	if b.Imagine.MaxWidth != 2048 || b.Imagine.MaxSteps != 50 {
+		t.Errorf("Expected invalid guild-b overrides to be ignored, got width=%d steps=%d", b.Imagine.MaxWidth, b.Imagine.MaxSteps)
+	}
+}
+
+func TestManager_ReloadKeepsOldConfigOnError(t *testing.T) {
+	t.Setenv("TOKEN", "env-token")
+	path := writeConfig(t, "imagine:\n  max_steps: 25\n")
+
+	m, err := NewManager(path, nil)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("imagine:\n  max_steps: -1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Fatal("Expected reload to fail validation")
+	}
+	if m.Current().Imagine.MaxSteps != 25 {
+		t.Errorf("Expected previous config to stay active, got max steps %d", m.Current().Imagine.MaxSteps)
+	}
+
+	if err := os.WriteFile(path, []byte("imagine:\n  max_steps: 35\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if m.Current().Imagine.MaxSteps != 35 {
+		t.Errorf("Expected reloaded max steps 35, got %d", m.Current().Imagine.MaxSteps)
+	}
+}
+
+func TestValidateGuildSetting(t *testing.T) {
+	if err := ValidateGuildSetting("imagine.max_steps", "30"); err != nil {
+		t.Errorf("Expected valid setting, got %v", err)
+	}
+	if err := ValidateGuildSetting("llm.url", "http://evil"); err == nil {
+		t.Error("Expected connection settings to be rejected")
+	}
+	if err := ValidateGuildSetting("imagine.max_width", "1001"); err == nil {
+		t.Error("Expected non-multiple-of-8 width to be rejected")
+	}
+}
+
+func TestValidate_Gallery(t *testing.T) {
+	cfg := Default()
+	cfg.Token = "token"
+	cfg.Gallery.StoreImages = true
+	cfg.Gallery.Dir = ""
+	cfg.Gallery.PageSize = 11
+
+	err := cfg.Validate()
+	for _, want := range []string{"gallery.dir", "gallery.page_size"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
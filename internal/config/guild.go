@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GuildLoader returns the per-guild overrides stored for guildID as
+// key/value pairs, e.g. {"imagine.max_steps": "30"}.
+type GuildLoader func(guildID string) (map[string]string, error)
+
+type guildSetter func(c *Config, value string) error
+
+// guildKeys lists the settings a guild may override. Connection settings
+// (token, URLs, database) are deliberately global.
+var guildKeys = map[string]guildSetter{
+	"imagine.max_width": func(c *Config, v string) error {
+		n, err := parsePositiveInt("imagine.max_width", v)
+		if err != nil {
+			return err
+		}
+		c.Imagine.MaxWidth = n
+		return nil
+	},
+	"imagine.max_height": func(c *Config, v string) error {
+		n, err := parsePositiveInt("imagine.max_height", v)
+		if err != nil {
+			return err
+		}
+		c.Imagine.MaxHeight = n
+		return nil
+	},
+	"imagine.max_steps": func(c *Config, v string) error {
+		n, err := parsePositiveInt("imagine.max_steps", v)
+		if err != nil {
+			return err
+		}
+		c.Imagine.MaxSteps = n
+		return nil
+	},
+	"moderation.log_channel": func(c *Config, v string) error {
+		id := strings.TrimSuffix(strings.TrimPrefix(v, "<#"), ">")
+		if !isSnowflake(id) {
+			return fmt.Errorf("moderation.log_channel: %q is not a channel", v)
+		}
+		c.Moderation.LogChannel = id
+		return nil
+	},
+	"stock_news.default_days": func(c *Config, v string) error {
+		n, err := parsePositiveInt("stock_news.default_days", v)
+		if err != nil {
+			return err
+		}
+		c.StockNews.DefaultDays = n
+		return nil
+	},
+}
+
+// GuildKeys returns the names of all settings that can be overridden per
+// guild, sorted for display.
+func GuildKeys() []string {
+	keys := make([]string, 0, len(guildKeys))
+	for key := range guildKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateGuildSetting checks that key is overridable and value is valid
+// for it without modifying any config.
+func ValidateGuildSetting(key, value string) error {
+	probe := Default()
+	if err := applyGuildSetting(probe, key, value); err != nil {
+		return err
+	}
+	return probe.Imagine.validate()
+}
+
+func applyGuildSetting(c *Config, key, value string) error {
+	set, ok := guildKeys[key]
+	if !ok {
+		return fmt.Errorf("unknown guild setting %q", key)
+	}
+	return set(c, value)
+}
+
+// withGuildOverrides returns a copy of c with overrides applied. Invalid
+// overrides are skipped and reported so one bad row can't break a guild.
+func (c *Config) withGuildOverrides(overrides map[string]string) (*Config, []error) {
+	merged := *c
+	var errs []error
+	for key, value := range overrides {
+		candidate := merged
+		if err := applyGuildSetting(&candidate, key, value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := candidate.Imagine.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		merged = candidate
+	}
+	return &merged, errs
+}
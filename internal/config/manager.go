@@ -0,0 +1,118 @@
+package config
+
+import (
+	"log/slog"
+	"reflect"
+	"sync"
+)
+
+// Manager holds the active config and swaps it atomically on Reload so
+// commands always see a consistent snapshot.
+type Manager struct {
+	path   string
+	loader GuildLoader
+
+	mu  sync.RWMutex
+	cfg *Config
+}
+
+func NewManager(path string, loader GuildLoader) (*Manager, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		path:   path,
+		loader: loader,
+		cfg:    cfg,
+	}, nil
+}
+
+// Current returns the global config. Callers must treat it as read-only.
+func (m *Manager) Current() *Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cfg
+}
+
+// ForGuild returns the global config with the guild's stored overrides
+// applied. It falls back to the global config if overrides can't be read.
+func (m *Manager) ForGuild(guildID string) *Config {
+	cfg := m.Current()
+	if guildID == "" || m.loader == nil {
+		return cfg
+	}
+
+	overrides, err := m.loader(guildID)
+	if err != nil {
+		slog.Warn("Failed to load guild config overrides", "guild_id", guildID, "error", err)
+		return cfg
+	}
+	if len(overrides) == 0 {
+		return cfg
+	}
+
+	merged, errs := cfg.withGuildOverrides(overrides)
+	for _, err := range errs {
+		slog.Warn("Ignoring invalid guild config override", "guild_id", guildID, "error", err)
+	}
+	return merged
+}
+
+// Reload re-reads the config file and environment. On error the previous
+// config stays active.
+func (m *Manager) Reload() error {
+	cfg, err := Load(m.path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	old := m.cfg
+	m.cfg = cfg
+	m.mu.Unlock()
+
+	for _, field := range restartOnlyChanges(old, cfg) {
+		slog.Warn("Config change requires a restart to take effect", "field", field)
+	}
+	slog.Info("Config reloaded", "path", m.path)
+	return nil
+}
+
+// restartOnlyChanges lists fields that were read once at startup to build
+// sessions and clients, so changing them at runtime has no effect.
+func restartOnlyChanges(old, updated *Config) []string {
+	var fields []string
+	if old.Token != updated.Token {
+		fields = append(fields, "token")
+	}
+	if old.GuildID != updated.GuildID {
+		fields = append(fields, "guild_id")
+	}
+	if old.DatabasePath != updated.DatabasePath {
+		fields = append(fields, "database_path")
+	}
+	if !reflect.DeepEqual(old.LLM, updated.LLM) {
+		fields = append(fields, "llm")
+	}
+	if old.ImageGen != updated.ImageGen {
+		fields = append(fields, "image_gen")
+	}
+	if old.Jobs != updated.Jobs {
+		fields = append(fields, "jobs")
+	}
+	if old.RAG.EmbeddingURL != updated.RAG.EmbeddingURL ||
+		old.RAG.EmbeddingModel != updated.RAG.EmbeddingModel ||
+		old.RAG.EmbeddingTimeout != updated.RAG.EmbeddingTimeout {
+		fields = append(fields, "rag embedding server")
+	}
+	if old.ImageSafety.ClassifierURL != updated.ImageSafety.ClassifierURL ||
+		old.ImageSafety.ClassifierTimeout != updated.ImageSafety.ClassifierTimeout {
+		fields = append(fields, "image_safety classifier")
+	}
+	if old.StockNews.MarketauxAPIKey != updated.StockNews.MarketauxAPIKey ||
+		old.StockNews.AlphaVantageAPIKey != updated.StockNews.AlphaVantageAPIKey {
+		fields = append(fields, "stock_news api keys")
+	}
+	return fields
+}
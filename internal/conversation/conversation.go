@@ -0,0 +1,344 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+const summaryPrefix = "Summary of the earlier conversation: "
+
+// ChatClient sends a conversation to the LLM. The Completer methods are
+// used when tools are enabled.
+type ChatClient interface {
+	llm.Completer
+	ChatMessages(ctx context.Context, messages []llm.ChatMessage) (string, error)
+	ChatStream(ctx context.Context, messages []llm.ChatMessage, onDelta func(delta string) error) (string, error)
+}
+
+// Store persists conversation history per session.
+type Store interface {
+	Messages(session string) ([]llm.ChatMessage, error)
+	Append(session string, messages ...llm.ChatMessage) error
+	Replace(session string, messages []llm.ChatMessage) error
+	Clear(session string) error
+
+	// Link and SessionForMessage map the bot's Discord messages to the
+	// session they answered, so replies can continue it.
+	Link(messageID, session string) error
+	SessionForMessage(messageID string) (string, error)
+}
+
+type dbStore struct{}
+
+// DBStore returns a Store backed by the bot's SQLite database.
+func DBStore() Store {
+	return dbStore{}
+}
+
+func (dbStore) Messages(session string) ([]llm.ChatMessage, error) {
+	rows, err := db.GetConversation(session)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]llm.ChatMessage, len(rows))
+	for i, row := range rows {
+		messages[i] = llm.ChatMessage{Role: row.Role, Content: row.Content}
+	}
+	return messages, nil
+}
+
+func (dbStore) Append(session string, messages ...llm.ChatMessage) error {
+	return db.AppendConversation(session, toRows(messages))
+}
+
+func (dbStore) Replace(session string, messages []llm.ChatMessage) error {
+	return db.ReplaceConversation(session, toRows(messages))
+}
+
+func (dbStore) Clear(session string) error {
+	return db.ClearConversation(session)
+}
+
+func (dbStore) Link(messageID, session string) error {
+	return db.LinkConversationMessage(messageID, session)
+}
+
+func (dbStore) SessionForMessage(messageID string) (string, error) {
+	return db.ConversationForMessage(messageID)
+}
+
+func toRows(messages []llm.ChatMessage) []db.ConversationMessage {
+	rows := make([]db.ConversationMessage, len(messages))
+	for i, m := range messages {
+		rows[i] = db.ConversationMessage{Role: m.Role, Content: m.Content}
+	}
+	return rows
+}
+
+// SessionForChannel returns the session key for a channel. Discord threads
+// have their own channel ID, so each thread gets its own conversation.
+func SessionForChannel(channelID string) string {
+	return "channel:" + channelID
+}
+
+type Manager struct {
+	store    Store
+	client   ChatClient
+	settings func() config.AIConfig
+	tools    []llm.AgentTool
+}
+
+// NewManager returns a Manager whose answers may use tools while the
+// settings have them enabled.
+func NewManager(store Store, client ChatClient, settings func() config.AIConfig, tools ...llm.AgentTool) *Manager {
+	return &Manager{
+		store:    store,
+		client:   client,
+		settings: settings,
+		tools:    tools,
+	}
+}
+
+// Turn is one user message and the assistant's reply, ready to be saved
+// once the reply has been delivered.
+type Turn struct {
+	Session   string
+	User      llm.ChatMessage
+	Assistant llm.ChatMessage
+}
+
+// Ask sends prompt with the session's history and returns the reply. The
+// turn is not saved; call Save once the reply has been posted so a failed
+// delivery doesn't leave half a conversation behind.
+//
+// images are sent with this prompt only. History keeps just the text, so
+// the prompt should mention them for later turns to make sense.
+func (m *Manager) Ask(ctx context.Context, session, author, prompt string, images ...llm.ContentPart) (*Turn, error) {
+	messages, user, err := m.prepare(ctx, session, author, prompt, images)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := m.reply(ctx, messages, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Turn{
+		Session:   session,
+		User:      user,
+		Assistant: llm.ChatMessage{Role: "assistant", Content: reply},
+	}, nil
+}
+
+// AskStream is Ask with the reply streamed to onDelta as it is generated.
+func (m *Manager) AskStream(ctx context.Context, session, author, prompt string, onDelta func(delta string) error, images ...llm.ContentPart) (*Turn, error) {
+	messages, user, err := m.prepare(ctx, session, author, prompt, images)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := m.reply(ctx, messages, onDelta)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Turn{
+		Session:   session,
+		User:      user,
+		Assistant: llm.ChatMessage{Role: "assistant", Content: reply},
+	}, nil
+}
+
+// reply gets the assistant's answer to messages, streaming it to onDelta
+// if that is not nil. With tools enabled the agent loop runs instead, and
+// the reply is everything that was streamed, so what is saved matches what
+// was shown.
+func (m *Manager) reply(ctx context.Context, messages []llm.ChatMessage, onDelta func(delta string) error) (string, error) {
+	settings := m.settings()
+	if !settings.Tools || len(m.tools) == 0 {
+		if onDelta == nil {
+			return m.client.ChatMessages(ctx, messages)
+		}
+		return m.client.ChatStream(ctx, messages, onDelta)
+	}
+
+	agent := llm.NewAgent(m.client, settings.MaxToolSteps, m.tools...)
+
+	var shown strings.Builder
+	var stream func(delta string) error
+	if onDelta != nil {
+		stream = func(delta string) error {
+			shown.WriteString(delta)
+			return onDelta(delta)
+		}
+	}
+
+	result, err := agent.Run(ctx, messages, stream)
+	for _, step := range result.Steps {
+		slog.Info("AI used tool", "tool", step.Tool, "failed", step.Err != nil)
+	}
+	if err != nil {
+		return "", err
+	}
+	if onDelta != nil {
+		return shown.String(), nil
+	}
+	return result.Content, nil
+}
+
+// prepare builds the request for prompt: the system prompt, the session's
+// history fitted to the token budget, and the new user message with any
+// images. The returned user message, which is what gets saved, has none.
+//
+// A summary of older history is stored as a system message, but many chat
+// templates accept only one, leading system message, so it's folded into
+// the system prompt rather than sent in the conversation.
+func (m *Manager) prepare(ctx context.Context, session, author, prompt string, images []llm.ContentPart) ([]llm.ChatMessage, llm.ChatMessage, error) {
+	settings := m.settings()
+
+	history, err := m.store.Messages(session)
+	if err != nil {
+		return nil, llm.ChatMessage{}, fmt.Errorf("failed to load conversation: %w", err)
+	}
+
+	user := llm.ChatMessage{Role: "user", Content: fmt.Sprintf("%s: %s", author, prompt)}
+
+	history = m.fit(ctx, session, history, settings.HistoryTokenBudget-EstimateTokens(user.Content), settings.SummarizeHistory)
+
+	var system []string
+	if settings.SystemPrompt != "" {
+		system = append(system, settings.SystemPrompt)
+	}
+	turns := make([]llm.ChatMessage, 0, len(history))
+	for _, msg := range history {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+		} else {
+			turns = append(turns, msg)
+		}
+	}
+
+	messages := make([]llm.ChatMessage, 0, len(turns)+2)
+	if len(system) > 0 {
+		messages = append(messages, llm.ChatMessage{Role: "system", Content: strings.Join(system, "\n\n")})
+	}
+	messages = append(messages, turns...)
+	request := user
+	request.Parts = images
+	messages = append(messages, request)
+
+	return messages, user, nil
+}
+
+// Save stores turn and links the Discord messages that carried the reply
+// to its session.
+func (m *Manager) Save(turn *Turn, messageIDs ...string) error {
+	if err := m.store.Append(turn.Session, turn.User, turn.Assistant); err != nil {
+		return fmt.Errorf("failed to save conversation: %w", err)
+	}
+	for _, id := range messageIDs {
+		if err := m.store.Link(id, turn.Session); err != nil {
+			return fmt.Errorf("failed to link message: %w", err)
+		}
+	}
+	return nil
+}
+
+// SessionForMessage returns the session a bot message belongs to, or ""
+// if it isn't part of a conversation.
+func (m *Manager) SessionForMessage(messageID string) (string, error) {
+	return m.store.SessionForMessage(messageID)
+}
+
+func (m *Manager) History(session string) ([]llm.ChatMessage, error) {
+	return m.store.Messages(session)
+}
+
+func (m *Manager) Reset(session string) error {
+	return m.store.Clear(session)
+}
+
+// fit shrinks history to budget tokens. The newest messages are kept
+// verbatim up to half the budget; anything older is replaced by a summary
+// that is saved back to the store, or dropped if summarizing is disabled
+// or fails.
+func (m *Manager) fit(ctx context.Context, session string, history []llm.ChatMessage, budget int, summarize bool) []llm.ChatMessage {
+	if totalTokens(history) <= budget {
+		return history
+	}
+
+	keepFrom := len(history)
+	kept := 0
+	for keepFrom > 0 {
+		cost := EstimateTokens(history[keepFrom-1].Content)
+		if kept+cost > budget/2 {
+			break
+		}
+		kept += cost
+		keepFrom--
+	}
+	older, recent := history[:keepFrom], history[keepFrom:]
+
+	if !summarize {
+		slog.Info("Truncating conversation history", "session", session, "dropped", len(older))
+		return recent
+	}
+
+	summary, err := m.summarize(ctx, older, budget/2)
+	if err != nil {
+		slog.Warn("Failed to summarize conversation, truncating instead", "session", session, "error", err)
+		return recent
+	}
+
+	compacted := append([]llm.ChatMessage{{Role: "system", Content: summaryPrefix + summary}}, recent...)
+	if err := m.store.Replace(session, compacted); err != nil {
+		slog.Warn("Failed to save summarized conversation", "session", session, "error", err)
+	}
+	slog.Info("Summarized conversation history", "session", session, "summarized", len(older), "kept", len(recent))
+	return compacted
+}
+
+func (m *Manager) summarize(ctx context.Context, messages []llm.ChatMessage, maxTokens int) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "system" && strings.HasPrefix(msg.Content, summaryPrefix):
+			transcript.WriteString(msg.Content + "\n")
+		case msg.Role == "assistant":
+			transcript.WriteString("Assistant: " + msg.Content + "\n")
+		default:
+			transcript.WriteString(msg.Content + "\n")
+		}
+	}
+
+	prompt := fmt.Sprintf(`Summarize this conversation so it can be continued later. Keep names, facts, decisions and open questions. Use at most %d words and reply with the summary only.
+
+%s`, maxTokens*3/4, transcript.String())
+
+	summary, err := m.client.ChatMessages(llm.WithoutPersona(ctx), []llm.ChatMessage{{Role: "user", Content: prompt}})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// EstimateTokens approximates the token count of s. Llama-family tokenizers
+// average about four characters per token for English text.
+func EstimateTokens(s string) int {
+	return len(s)/4 + 4
+}
+
+func totalTokens(messages []llm.ChatMessage) int {
+	total := 0
+	for _, m := range messages {
+		total += EstimateTokens(m.Content)
+	}
+	return total
+}
@@ -0,0 +1,344 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+type memoryStore struct {
+	sessions map[string][]llm.ChatMessage
+	links    map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		sessions: make(map[string][]llm.ChatMessage),
+		links:    make(map[string]string),
+	}
+}
+
+func (s *memoryStore) Messages(session string) ([]llm.ChatMessage, error) {
+	return append([]llm.ChatMessage(nil), s.sessions[session]...), nil
+}
+
+func (s *memoryStore) Append(session string, messages ...llm.ChatMessage) error {
+	s.sessions[session] = append(s.sessions[session], messages...)
+	return nil
+}
+
+func (s *memoryStore) Replace(session string, messages []llm.ChatMessage) error {
+	s.sessions[session] = append([]llm.ChatMessage(nil), messages...)
+	return nil
+}
+
+func (s *memoryStore) Clear(session string) error {
+	delete(s.sessions, session)
+	return nil
+}
+
+func (s *memoryStore) Link(messageID, session string) error {
+	s.links[messageID] = session
+	return nil
+}
+
+func (s *memoryStore) SessionForMessage(messageID string) (string, error) {
+	return s.links[messageID], nil
+}
+
+// fakeClient records every request and answers summary requests
+// differently from chat requests.
+type fakeClient struct {
+	requests   [][]llm.ChatMessage
+	summaryErr error
+
+	// toolCall is returned once to the first request that offers tools.
+	toolCall *llm.ToolCall
+}
+
+func (c *fakeClient) ChatMessages(ctx context.Context, messages []llm.ChatMessage) (string, error) {
+	c.requests = append(c.requests, messages)
+	if strings.HasPrefix(messages[0].Content, "Summarize this conversation") {
+		if c.summaryErr != nil {
+			return "", c.summaryErr
+		}
+		return "alice likes blue", nil
+	}
+	return "reply", nil
+}
+
+func (c *fakeClient) ChatStream(ctx context.Context, messages []llm.ChatMessage, onDelta func(string) error) (string, error) {
+	reply, err := c.ChatMessages(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range reply {
+		if err := onDelta(string(r)); err != nil {
+			return "", err
+		}
+	}
+	return reply, nil
+}
+
+func (c *fakeClient) Complete(ctx context.Context, messages []llm.ChatMessage, opts llm.Options) (*llm.Completion, error) {
+	return c.CompleteStream(ctx, messages, opts, func(string) error { return nil })
+}
+
+func (c *fakeClient) CompleteStream(ctx context.Context, messages []llm.ChatMessage, opts llm.Options, onDelta func(string) error) (*llm.Completion, error) {
+	if len(opts.Tools) > 0 && c.toolCall != nil {
+		c.requests = append(c.requests, messages)
+		call := *c.toolCall
+		c.toolCall = nil
+		return &llm.Completion{ToolCalls: []llm.ToolCall{call}}, nil
+	}
+	reply, err := c.ChatStream(ctx, messages, onDelta)
+	return &llm.Completion{Content: reply}, err
+}
+
+func settings(budget int, summarize bool) func() config.AIConfig {
+	return func() config.AIConfig {
+		return config.AIConfig{SystemPrompt: "be nice", HistoryTokenBudget: budget, SummarizeHistory: summarize}
+	}
+}
+
+func TestManager_SendsHistoryAndSavesTurn(t *testing.T) {
+	store := newMemoryStore()
+	client := &fakeClient{}
+	m := NewManager(store, client, settings(3000, true))
+	session := SessionForChannel("c1")
+
+	turn, err := m.Ask(context.Background(), session, "alice", "my favourite colour is blue")
+	if err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+	if err := m.Save(turn, "msg-1"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if _, err := m.Ask(context.Background(), session, "alice", "what is my favourite colour?"); err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+
+	last := client.requests[len(client.requests)-1]
+	want := []llm.ChatMessage{
+		{Role: "system", Content: "be nice"},
+		{Role: "user", Content: "alice: my favourite colour is blue"},
+		{Role: "assistant", Content: "reply"},
+		{Role: "user", Content: "alice: what is my favourite colour?"},
+	}
+	if len(last) != len(want) {
+		t.Fatalf("Expected %d messages, got %d: %+v", len(want), len(last), last)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(last[i], want[i]) {
+			t.Errorf("Message %d: expected %+v, got %+v", i, want[i], last[i])
+		}
+	}
+
+	if got, _ := m.SessionForMessage("msg-1"); got != session {
+		t.Errorf("Expected msg-1 linked to %s, got %q", session, got)
+	}
+}
+
+func TestManager_AskStreamUsesHistory(t *testing.T) {
+	store := newMemoryStore()
+	client := &fakeClient{}
+	m := NewManager(store, client, settings(3000, true))
+	session := SessionForChannel("c1")
+	fillHistory(store, session, 1)
+
+	var streamed strings.Builder
+	turn, err := m.AskStream(context.Background(), session, "alice", "go on", func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AskStream failed: %v", err)
+	}
+	if streamed.String() != "reply" || turn.Assistant.Content != "reply" {
+		t.Errorf("Expected streamed and final reply to match, got %q and %q", streamed.String(), turn.Assistant.Content)
+	}
+	if got := len(client.requests[0]); got != 4 {
+		t.Errorf("Expected system prompt, 2 history messages and the prompt, got %d messages", got)
+	}
+}
+
+func TestManager_AskSendsImagesOnce(t *testing.T) {
+	store := newMemoryStore()
+	client := &fakeClient{}
+	m := NewManager(store, client, settings(3000, true))
+	session := SessionForChannel("c1")
+
+	image := llm.ImagePart("image/png", []byte("png"))
+	turn, err := m.Ask(context.Background(), session, "alice", "what's in this? [image: cat.png]", image)
+	if err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+
+	sent := client.requests[0][len(client.requests[0])-1]
+	if len(sent.Parts) != 1 || !reflect.DeepEqual(sent.Parts[0], image) {
+		t.Errorf("Expected the image to be sent with the prompt, got %+v", sent.Parts)
+	}
+	if turn.User.Parts != nil || turn.User.Content != "alice: what's in this? [image: cat.png]" {
+		t.Errorf("Expected the saved turn to keep only the text, got %+v", turn.User)
+	}
+
+	if err := m.Save(turn); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := m.Ask(context.Background(), session, "alice", "and now?"); err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+	for _, msg := range client.requests[1] {
+		if len(msg.Parts) > 0 {
+			t.Errorf("Expected the image not to be resent, got %+v", msg)
+		}
+	}
+}
+
+func TestManager_SessionsAreIsolated(t *testing.T) {
+	store := newMemoryStore()
+	client := &fakeClient{}
+	m := NewManager(store, client, settings(3000, true))
+
+	turn, _ := m.Ask(context.Background(), SessionForChannel("c1"), "alice", "hello")
+	m.Save(turn)
+
+	m.Ask(context.Background(), SessionForChannel("thread-9"), "bob", "hi")
+	last := client.requests[len(client.requests)-1]
+	if len(last) != 2 {
+		t.Errorf("Expected a new thread to start without history, got %+v", last)
+	}
+}
+
+func fillHistory(store *memoryStore, session string, turns int) {
+	for i := 0; i < turns; i++ {
+		store.Append(session,
+			llm.ChatMessage{Role: "user", Content: "alice: " + strings.Repeat("x", 400)},
+			llm.ChatMessage{Role: "assistant", Content: strings.Repeat("y", 400)},
+		)
+	}
+}
+
+func TestManager_SummarizesOverBudget(t *testing.T) {
+	store := newMemoryStore()
+	client := &fakeClient{}
+	m := NewManager(store, client, settings(1000, true))
+	session := SessionForChannel("c1")
+	fillHistory(store, session, 10)
+
+	if _, err := m.Ask(context.Background(), session, "alice", "and now?"); err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+
+	if len(client.requests) != 2 {
+		t.Fatalf("Expected a summary request and a chat request, got %d requests", len(client.requests))
+	}
+
+	saved := store.sessions[session]
+	if saved[0].Role != "system" || !strings.Contains(saved[0].Content, "alice likes blue") {
+		t.Errorf("Expected summary saved as the first message, got %+v", saved[0])
+	}
+	if totalTokens(saved) > 1000 {
+		t.Errorf("Expected compacted history within budget, got %d tokens", totalTokens(saved))
+	}
+
+	chat := client.requests[1]
+	if chat[0].Role != "system" || !strings.Contains(chat[0].Content, saved[0].Content) {
+		t.Errorf("Expected the summary folded into the system prompt, got %+v", chat[0])
+	}
+	for _, msg := range chat[1:] {
+		if msg.Role == "system" {
+			t.Errorf("Expected a single leading system message, got another: %+v", msg)
+		}
+	}
+}
+
+func TestManager_TruncatesWhenSummaryFails(t *testing.T) {
+	store := newMemoryStore()
+	client := &fakeClient{summaryErr: errors.New("llm down")}
+	m := NewManager(store, client, settings(1000, true))
+	session := SessionForChannel("c1")
+	fillHistory(store, session, 10)
+
+	if _, err := m.Ask(context.Background(), session, "alice", "and now?"); err != nil {
+		t.Fatalf("Ask failed: %v", err)
+	}
+
+	chat := client.requests[len(client.requests)-1]
+	if totalTokens(chat) > 1000+EstimateTokens("be nice") {
+		t.Errorf("Expected truncated request within budget, got %d tokens", totalTokens(chat))
+	}
+	if len(store.sessions[session]) != 20 {
+		t.Errorf("Expected stored history to be kept when only truncating, got %d messages", len(store.sessions[session]))
+	}
+}
+
+func TestManager_Reset(t *testing.T) {
+	store := newMemoryStore()
+	m := NewManager(store, &fakeClient{}, settings(3000, true))
+	session := SessionForChannel("c1")
+	fillHistory(store, session, 1)
+
+	if err := m.Reset(session); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	history, _ := m.History(session)
+	if len(history) != 0 {
+		t.Errorf("Expected empty history after reset, got %d messages", len(history))
+	}
+}
+
+func TestManager_AskStreamRunsTools(t *testing.T) {
+	store := newMemoryStore()
+	client := &fakeClient{toolCall: &llm.ToolCall{
+		ID:       "call_1",
+		Type:     "function",
+		Function: llm.FunctionCall{Name: "lookup", Arguments: `{"q":"colour"}`},
+	}}
+
+	var gotArgs string
+	lookup := llm.AgentTool{
+		Definition: llm.FunctionDefinition{Name: "lookup"},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			gotArgs = string(args)
+			return "blue", nil
+		},
+	}
+
+	withTools := func() config.AIConfig {
+		cfg := settings(3000, true)()
+		cfg.Tools = true
+		cfg.MaxToolSteps = 2
+		return cfg
+	}
+	m := NewManager(store, client, withTools, lookup)
+
+	var streamed strings.Builder
+	turn, err := m.AskStream(context.Background(), SessionForChannel("c1"), "alice", "what colour?", func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AskStream failed: %v", err)
+	}
+
+	if gotArgs != `{"q":"colour"}` {
+		t.Errorf("Expected tool to get the model's arguments, got %q", gotArgs)
+	}
+	if turn.Assistant.Content != "reply" || streamed.String() != "reply" {
+		t.Errorf("Expected final answer to be streamed and kept, got %q / %q", turn.Assistant.Content, streamed.String())
+	}
+
+	last := client.requests[len(client.requests)-1]
+	result := last[len(last)-1]
+	if result.Role != "tool" || result.Content != "blue" || result.ToolCallID != "call_1" {
+		t.Errorf("Expected tool result to be sent back, got %+v", result)
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import "database/sql"
+
+type ConversationMessage struct {
+	Role    string
+	Content string
+}
+
+// GetConversation returns a session's messages, oldest first.
+func GetConversation(session string) ([]ConversationMessage, error) {
+	rows, err := DB.Query("SELECT role, content FROM ai_messages WHERE session = ? ORDER BY id", session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var messages []ConversationMessage
+	for rows.Next() {
+		var m ConversationMessage
+		if err := rows.Scan(&m.Role, &m.Content); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func AppendConversation(session string, messages []ConversationMessage) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertConversation(tx, session, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceConversation swaps a session's history in one transaction, used
+// when older turns are summarized.
+func ReplaceConversation(session string, messages []ConversationMessage) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM ai_messages WHERE session = ?", session); err != nil {
+		return err
+	}
+	if err := insertConversation(tx, session, messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertConversation(tx *sql.Tx, session string, messages []ConversationMessage) error {
+	for _, m := range messages {
+		if _, err := tx.Exec("INSERT INTO ai_messages (session, role, content) VALUES (?, ?, ?)", session, m.Role, m.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ClearConversation(session string) error {
+	if _, err := DB.Exec("DELETE FROM ai_messages WHERE session = ?", session); err != nil {
+		return err
+	}
+	_, err := DB.Exec("DELETE FROM ai_message_links WHERE session = ?", session)
+	return err
+}
+
+// LinkConversationMessage remembers that a Discord message posted by the
+// bot belongs to session, so replies to it can continue the conversation.
+func LinkConversationMessage(messageID, session string) error {
+	_, err := DB.Exec(`INSERT INTO ai_message_links (message_id, session) VALUES (?, ?)
+		ON CONFLICT (message_id) DO UPDATE SET session = excluded.session`, messageID, session)
+	return err
+}
+
+// ConversationForMessage returns the session a bot message belongs to, or
+// "" if it isn't part of one.
+func ConversationForMessage(messageID string) (string, error) {
+	var session string
+	err := DB.QueryRow("SELECT session FROM ai_message_links WHERE message_id = ?", messageID).Scan(&session)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return session, err
+}
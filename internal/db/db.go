@@ -9,19 +9,127 @@ import (
 
 var DB *sql.DB
 
-func InitDB() error {
-	var err error
-	DB, err = sql.Open("sqlite3", "./playlists.db")
-	if err != nil {
-		return err
-	}
-	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS playlists (
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS playlists (
 		user_id TEXT,
 		name TEXT,
 		songs TEXT,
 		PRIMARY KEY (user_id, name)
-	)`)
-	return err
+	)`,
+	`CREATE TABLE IF NOT EXISTS guild_settings (
+		guild_id TEXT,
+		key TEXT,
+		value TEXT,
+		PRIMARY KEY (guild_id, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS quota_usage (
+		scope TEXT,
+		scope_id TEXT,
+		command TEXT,
+		day TEXT,
+		count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (scope, scope_id, command, day)
+	)`,
+	`CREATE TABLE IF NOT EXISTS rate_limit_exemptions (
+		guild_id TEXT,
+		subject_type TEXT,
+		subject_id TEXT,
+		PRIMARY KEY (guild_id, subject_type, subject_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ai_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session TEXT NOT NULL,
+		role TEXT NOT NULL,
+		content TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS ai_messages_session ON ai_messages (session, id)`,
+	`CREATE TABLE IF NOT EXISTS ai_message_links (
+		message_id TEXT PRIMARY KEY,
+		session TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS personas (
+		guild_id TEXT,
+		name TEXT,
+		prompt TEXT NOT NULL,
+		created_by TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (guild_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS persona_assignments (
+		scope TEXT,
+		scope_id TEXT,
+		guild_id TEXT NOT NULL,
+		persona TEXT NOT NULL,
+		PRIMARY KEY (scope, scope_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS rag_channels (
+		channel_id TEXT PRIMARY KEY,
+		guild_id TEXT NOT NULL,
+		enabled_by TEXT,
+		enabled_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS rag_chunks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		guild_id TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		author TEXT,
+		source TEXT NOT NULL,
+		content TEXT NOT NULL,
+		created_at DATETIME,
+		embedding BLOB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS rag_chunks_guild ON rag_chunks (guild_id)`,
+	`CREATE INDEX IF NOT EXISTS rag_chunks_message ON rag_chunks (message_id)`,
+	`CREATE TABLE IF NOT EXISTS image_generations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		guild_id TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		params TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS gallery_images (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		guild_id TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		prompt TEXT NOT NULL,
+		params TEXT NOT NULL,
+		seed INTEGER NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		steps INTEGER NOT NULL,
+		nsfw INTEGER NOT NULL DEFAULT 0,
+		file TEXT NOT NULL DEFAULT '',
+		thumbnail TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS gallery_images_guild ON gallery_images (guild_id, id)`,
+	`CREATE INDEX IF NOT EXISTS gallery_images_user ON gallery_images (guild_id, user_id, id)`,
+}
+
+func InitDB(path string) error {
+	var err error
+	DB, err = sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range schema {
+		if _, err := DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	return DB.Close()
 }
 
 func CreatePlaylist(userID, name string) error {
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// GalleryImage is one image of a posted /imagine result. Params holds the
+// JSON request that reproduces it. File and Thumbnail are paths under the
+// gallery directory, or "" if the image isn't stored. NSFW images were
+// posted as spoilers, and are only listed in age-restricted channels.
+type GalleryImage struct {
+	ID        int64
+	GuildID   string
+	ChannelID string
+	MessageID string
+	UserID    string
+	Prompt    string
+	Params    string
+	Seed      int64
+	Width     int
+	Height    int
+	Steps     int
+	NSFW      bool
+	File      string
+	Thumbnail string
+	CreatedAt time.Time
+}
+
+// GalleryFilter picks the images a gallery lists. An empty UserID lists
+// the whole guild, and every word of Search must appear in the prompt.
+type GalleryFilter struct {
+	GuildID string
+	UserID  string
+	Search  string
+	NSFW    bool
+}
+
+// SaveGalleryImage stores g and returns its new ID.
+func SaveGalleryImage(g GalleryImage) (int64, error) {
+	res, err := DB.Exec(`INSERT INTO gallery_images
+		(guild_id, channel_id, message_id, user_id, prompt, params, seed, width, height, steps, nsfw, file, thumbnail)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		g.GuildID, g.ChannelID, g.MessageID, g.UserID, g.Prompt, g.Params, g.Seed, g.Width, g.Height, g.Steps, g.NSFW, g.File, g.Thumbnail)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// SetGalleryFiles records where an image and its thumbnail were stored.
+func SetGalleryFiles(id int64, file, thumbnail string) error {
+	_, err := DB.Exec("UPDATE gallery_images SET file = ?, thumbnail = ? WHERE id = ?", file, thumbnail, id)
+	return err
+}
+
+const galleryColumns = `id, guild_id, channel_id, message_id, user_id, prompt, params, seed, width, height, steps, nsfw, file, thumbnail, created_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanGalleryImage(row scanner) (GalleryImage, error) {
+	var g GalleryImage
+	err := row.Scan(&g.ID, &g.GuildID, &g.ChannelID, &g.MessageID, &g.UserID, &g.Prompt, &g.Params,
+		&g.Seed, &g.Width, &g.Height, &g.Steps, &g.NSFW, &g.File, &g.Thumbnail, &g.CreatedAt)
+	return g, err
+}
+
+// GetGalleryImage returns nil if there is no image with that ID.
+func GetGalleryImage(id int64) (*GalleryImage, error) {
+	g, err := scanGalleryImage(DB.QueryRow("SELECT "+galleryColumns+" FROM gallery_images WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ListGalleryImages returns up to limit of the images f picks, newest
+// first, skipping offset of them, and how many it picks in all.
+func ListGalleryImages(f GalleryFilter, offset, limit int) ([]GalleryImage, int, error) {
+	where := []string{"guild_id = ?"}
+	args := []any{f.GuildID}
+	if f.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if !f.NSFW {
+		where = append(where, "nsfw = 0")
+	}
+	for _, word := range strings.Fields(f.Search) {
+		where = append(where, `prompt LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(word)+"%")
+	}
+	clause := " FROM gallery_images WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*)"+clause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query("SELECT "+galleryColumns+clause+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var images []GalleryImage
+	for rows.Next() {
+		g, err := scanGalleryImage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		images = append(images, g)
+	}
+	return images, total, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
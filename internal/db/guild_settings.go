@@ -0,0 +1,29 @@
+package db
+
+func GetGuildSettings(guildID string) (map[string]string, error) {
+	rows, err := DB.Query("SELECT key, value FROM guild_settings WHERE guild_id = ?", guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
+
+func SetGuildSetting(guildID, key, value string) error {
+	_, err := DB.Exec(`INSERT INTO guild_settings (guild_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (guild_id, key) DO UPDATE SET value = excluded.value`, guildID, key, value)
+	return err
+}
+
+func DeleteGuildSetting(guildID, key string) error {
+	_, err := DB.Exec("DELETE FROM guild_settings WHERE guild_id = ? AND key = ?", guildID, key)
+	return err
+}
@@ -0,0 +1,37 @@
+package db
+
+import "database/sql"
+
+// ImageGeneration is a posted /imagine result. Params holds the JSON
+// requests that reproduce each of its images, for its buttons.
+type ImageGeneration struct {
+	ID        int64
+	GuildID   string
+	ChannelID string
+	UserID    string
+	Params    string
+}
+
+// SaveImageGeneration stores g and returns its new ID.
+func SaveImageGeneration(g ImageGeneration) (int64, error) {
+	res, err := DB.Exec("INSERT INTO image_generations (guild_id, channel_id, user_id, params) VALUES (?, ?, ?, ?)",
+		g.GuildID, g.ChannelID, g.UserID, g.Params)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetImageGeneration returns nil if there is no generation with that ID.
+func GetImageGeneration(id int64) (*ImageGeneration, error) {
+	g := ImageGeneration{ID: id}
+	err := DB.QueryRow("SELECT guild_id, channel_id, user_id, params FROM image_generations WHERE id = ?", id).
+		Scan(&g.GuildID, &g.ChannelID, &g.UserID, &g.Params)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import "database/sql"
+
+type Persona struct {
+	Name      string
+	Prompt    string
+	CreatedBy string
+}
+
+// SavePersona creates a guild persona or replaces the prompt of an
+// existing one. It reports whether the persona already existed.
+func SavePersona(guildID string, p Persona) (bool, error) {
+	existing, err := GetPersona(guildID, p.Name)
+	if err != nil {
+		return false, err
+	}
+	_, err = DB.Exec(`INSERT INTO personas (guild_id, name, prompt, created_by) VALUES (?, ?, ?, ?)
+		ON CONFLICT (guild_id, name) DO UPDATE SET prompt = excluded.prompt`,
+		guildID, p.Name, p.Prompt, p.CreatedBy)
+	return existing != nil, err
+}
+
+// GetPersona returns nil if the guild has no persona with that name.
+func GetPersona(guildID, name string) (*Persona, error) {
+	p := Persona{Name: name}
+	err := DB.QueryRow("SELECT prompt, created_by FROM personas WHERE guild_id = ? AND name = ?", guildID, name).
+		Scan(&p.Prompt, &p.CreatedBy)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ListPersonas(guildID string) ([]Persona, error) {
+	rows, err := DB.Query("SELECT name, prompt, created_by FROM personas WHERE guild_id = ? ORDER BY name", guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var personas []Persona
+	for rows.Next() {
+		var p Persona
+		if err := rows.Scan(&p.Name, &p.Prompt, &p.CreatedBy); err != nil {
+			return nil, err
+		}
+		personas = append(personas, p)
+	}
+	return personas, rows.Err()
+}
+
+// AssignPersona makes name the persona for a guild or channel. scope is
+// "guild" or "channel" and scopeID the matching Discord ID.
+func AssignPersona(guildID, scope, scopeID, name string) error {
+	_, err := DB.Exec(`INSERT INTO persona_assignments (scope, scope_id, guild_id, persona) VALUES (?, ?, ?, ?)
+		ON CONFLICT (scope, scope_id) DO UPDATE SET persona = excluded.persona`,
+		scope, scopeID, guildID, name)
+	return err
+}
+
+func UnassignPersona(scope, scopeID string) error {
+	_, err := DB.Exec("DELETE FROM persona_assignments WHERE scope = ? AND scope_id = ?", scope, scopeID)
+	return err
+}
+
+// AssignedPersona returns the persona name assigned to a guild or channel,
+// or "" if there is none.
+func AssignedPersona(scope, scopeID string) (string, error) {
+	var name string
+	err := DB.QueryRow("SELECT persona FROM persona_assignments WHERE scope = ? AND scope_id = ?", scope, scopeID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return name, err
+}
+
+// ResolvePersona returns the persona that applies in a channel: the
+// channel's own assignment if it has one, otherwise the guild's. It
+// returns nil if neither is set.
+func ResolvePersona(guildID, channelID string) (*Persona, error) {
+	p := &Persona{}
+	err := DB.QueryRow(`SELECT p.name, p.prompt, p.created_by FROM persona_assignments a
+		JOIN personas p ON p.guild_id = a.guild_id AND p.name = a.persona
+		WHERE a.guild_id = ? AND ((a.scope = 'channel' AND a.scope_id = ?) OR (a.scope = 'guild' AND a.scope_id = ?))
+		ORDER BY a.scope = 'channel' DESC
+		LIMIT 1`, guildID, channelID, guildID).Scan(&p.Name, &p.Prompt, &p.CreatedBy)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+type RAGChunk struct {
+	GuildID   string
+	ChannelID string
+	MessageID string
+	Author    string
+
+	// Source is "message" for the message text, or the attachment's
+	// file name.
+	Source    string
+	Content   string
+	CreatedAt time.Time
+	Embedding []float32
+}
+
+func EnableRAGChannel(guildID, channelID, userID string) error {
+	_, err := DB.Exec(`INSERT INTO rag_channels (channel_id, guild_id, enabled_by) VALUES (?, ?, ?)
+		ON CONFLICT (channel_id) DO NOTHING`, channelID, guildID, userID)
+	return err
+}
+
+// DisableRAGChannel stops indexing a channel and deletes what was indexed.
+func DisableRAGChannel(channelID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM rag_channels WHERE channel_id = ?", channelID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM rag_chunks WHERE channel_id = ?", channelID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func RAGChannelEnabled(channelID string) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT 1 FROM rag_channels WHERE channel_id = ?", channelID).Scan(&n)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListRAGChannels returns the indexed channels of a guild with how many
+// chunks each holds.
+func ListRAGChannels(guildID string) (map[string]int, error) {
+	rows, err := DB.Query(`SELECT c.channel_id, COUNT(k.id) FROM rag_channels c
+		LEFT JOIN rag_chunks k ON k.channel_id = c.channel_id
+		WHERE c.guild_id = ? GROUP BY c.channel_id`, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	channels := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		channels[id] = count
+	}
+	return channels, rows.Err()
+}
+
+// ReplaceRAGChunks swaps the chunks indexed for a message, so edits don't
+// leave stale text behind.
+func ReplaceRAGChunks(messageID string, chunks []RAGChunk) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM rag_chunks WHERE message_id = ?", messageID); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if _, err := tx.Exec(`INSERT INTO rag_chunks
+			(guild_id, channel_id, message_id, author, source, content, created_at, embedding)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.GuildID, c.ChannelID, messageID, c.Author, c.Source, c.Content, c.CreatedAt, encodeVector(c.Embedding)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func DeleteRAGChunks(messageID string) error {
+	_, err := DB.Exec("DELETE FROM rag_chunks WHERE message_id = ?", messageID)
+	return err
+}
+
+// GuildRAGChunks loads every chunk indexed in a guild, embeddings included.
+func GuildRAGChunks(guildID string) ([]RAGChunk, error) {
+	rows, err := DB.Query(`SELECT guild_id, channel_id, message_id, author, source, content, created_at, embedding
+		FROM rag_chunks WHERE guild_id = ?`, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []RAGChunk
+	for rows.Next() {
+		var c RAGChunk
+		var blob []byte
+		if err := rows.Scan(&c.GuildID, &c.ChannelID, &c.MessageID, &c.Author, &c.Source, &c.Content, &c.CreatedAt, &blob); err != nil {
+			return nil, err
+		}
+		if c.Embedding, err = decodeVector(blob); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// Vectors are stored as little-endian float32s.
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) ([]float32, error) {
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("embedding has %d bytes, not a multiple of 4", len(buf))
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v, nil
+}
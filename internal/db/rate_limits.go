@@ -0,0 +1,67 @@
+package db
+
+import "database/sql"
+
+// GetQuotaUsage returns how much of command's daily quota scopeID has used.
+// Usage is counted per scope ("user" or "guild") and UTC day, so old rows
+// can be pruned without affecting today's counts.
+func GetQuotaUsage(scope, scopeID, command, day string) (int, error) {
+	var count int
+	err := DB.QueryRow(`SELECT count FROM quota_usage
+		WHERE scope = ? AND scope_id = ? AND command = ? AND day = ?`,
+		scope, scopeID, command, day).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return count, err
+}
+
+func AddQuotaUsage(scope, scopeID, command, day string, amount int) error {
+	_, err := DB.Exec(`INSERT INTO quota_usage (scope, scope_id, command, day, count) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (scope, scope_id, command, day) DO UPDATE SET count = count + excluded.count`,
+		scope, scopeID, command, day, amount)
+	return err
+}
+
+func PruneQuotaUsage(beforeDay string) error {
+	_, err := DB.Exec("DELETE FROM quota_usage WHERE day < ?", beforeDay)
+	return err
+}
+
+type RateLimitExemption struct {
+	SubjectType string // "user" or "role"
+	SubjectID   string
+}
+
+func AddRateLimitExemption(guildID, subjectType, subjectID string) error {
+	_, err := DB.Exec(`INSERT INTO rate_limit_exemptions (guild_id, subject_type, subject_id) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`, guildID, subjectType, subjectID)
+	return err
+}
+
+func RemoveRateLimitExemption(guildID, subjectType, subjectID string) (bool, error) {
+	res, err := DB.Exec("DELETE FROM rate_limit_exemptions WHERE guild_id = ? AND subject_type = ? AND subject_id = ?",
+		guildID, subjectType, subjectID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func ListRateLimitExemptions(guildID string) ([]RateLimitExemption, error) {
+	rows, err := DB.Query("SELECT subject_type, subject_id FROM rate_limit_exemptions WHERE guild_id = ?", guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var exemptions []RateLimitExemption
+	for rows.Next() {
+		var e RateLimitExemption
+		if err := rows.Scan(&e.SubjectType, &e.SubjectID); err != nil {
+			return nil, err
+		}
+		exemptions = append(exemptions, e)
+	}
+	return exemptions, rows.Err()
+}
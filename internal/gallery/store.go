@@ -0,0 +1,77 @@
+// Package gallery keeps /imagine results on disk, with thumbnails for
+// /gallery to show.
+package gallery
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/josh/discord-bot/internal/imagegen"
+)
+
+// Store writes images under a directory per guild. Paths it hands out
+// are relative to its directory, so the directory can be moved.
+type Store struct {
+	dir           string
+	thumbnailSize int
+}
+
+func NewStore(dir string, thumbnailSize int) *Store {
+	return &Store{dir: dir, thumbnailSize: thumbnailSize}
+}
+
+// Save writes image id of a guild and a JPEG thumbnail of it, and returns
+// the paths of both.
+func (s *Store) Save(guildID string, id int64, data []byte) (file, thumbnail string, err error) {
+	ext := "png"
+	if format, ok := strings.CutPrefix(http.DetectContentType(data), "image/"); ok {
+		ext = format
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, imagegen.Thumbnail(img, s.thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return "", "", fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+
+	guildDir := guildID
+	if guildDir == "" {
+		guildDir = "dm"
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, guildDir), 0o755); err != nil {
+		return "", "", fmt.Errorf("failed to create gallery directory: %w", err)
+	}
+	file = filepath.Join(guildDir, fmt.Sprintf("%d.%s", id, ext))
+	thumbnail = filepath.Join(guildDir, fmt.Sprintf("%d_thumb.jpg", id))
+	if err := os.WriteFile(filepath.Join(s.dir, file), data, 0o644); err != nil {
+		return "", "", fmt.Errorf("failed to write image: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, thumbnail), thumb.Bytes(), 0o644); err != nil {
+		return "", "", fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	return file, thumbnail, nil
+}
+
+// Read returns a file Save wrote.
+func (s *Store) Read(name string) ([]byte, error) {
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("invalid gallery path %q", name)
+	}
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+// MessageLink is the URL that jumps to a message.
+func MessageLink(guildID, channelID, messageID string) string {
+	if guildID == "" {
+		guildID = "@me"
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
@@ -0,0 +1,70 @@
+package gallery
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStore_SaveAndRead(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, 256)
+	data := testPNG(t, 1024, 768)
+
+	file, thumbnail, err := store.Save("123", 42, data)
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if file != filepath.Join("123", "42.png") || thumbnail != filepath.Join("123", "42_thumb.jpg") {
+		t.Errorf("Unexpected paths %s, %s", file, thumbnail)
+	}
+
+	got, err := store.Read(file)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Expected the image back, got %d bytes, %v", len(got), err)
+	}
+	thumb, err := os.ReadFile(filepath.Join(dir, thumbnail))
+	if err != nil {
+		t.Fatalf("Failed to read thumbnail: %v", err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil || format != "jpeg" || cfg.Width != 256 || cfg.Height != 192 {
+		t.Errorf("Expected a 256x192 JPEG thumbnail, got %s %dx%d, %v", format, cfg.Width, cfg.Height, err)
+	}
+}
+
+func TestStore_SaveRejectsNonImages(t *testing.T) {
+	if _, _, err := NewStore(t.TempDir(), 256).Save("123", 1, []byte("not an image")); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestStore_ReadStaysInDir(t *testing.T) {
+	store := NewStore(t.TempDir(), 256)
+	for _, name := range []string{"../secret", "/etc/passwd", ""} {
+		if _, err := store.Read(name); err == nil {
+			t.Errorf("Expected Read(%q) to fail", name)
+		}
+	}
+}
+
+func TestMessageLink(t *testing.T) {
+	if got := MessageLink("1", "2", "3"); got != "https://discord.com/channels/1/2/3" {
+		t.Errorf("Unexpected link %s", got)
+	}
+	if got := MessageLink("", "2", "3"); got != "https://discord.com/channels/@me/2/3" {
+		t.Errorf("Unexpected DM link %s", got)
+	}
+}
@@ -0,0 +1,125 @@
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ImageBackend is a server that generates images: the Automatic1111 web
+// UI (Client), ComfyUI (ComfyClient) or an OpenAI-compatible images API
+// (OpenAIImageClient). Requests and responses use the web UI's shapes;
+// the others translate them. Each backend runs one request at a time,
+// in the order set by WithPriority.
+type ImageBackend interface {
+	GenerateImage(ctx context.Context, req *GenerationRequest) (*GenerationResponse, error)
+	ImageToImage(ctx context.Context, req *Img2ImgRequest) (*GenerationResponse, error)
+	Upscale(ctx context.Context, req *UpscaleRequest) (*UpscaleResponse, error)
+
+	Models(ctx context.Context) ([]Model, error)
+	Samplers(ctx context.Context) ([]Sampler, error)
+	ResolveModel(ctx context.Context, name string) (string, error)
+	ResolveSampler(ctx context.Context, name string) (string, error)
+
+	HealthCheck(ctx context.Context) error
+}
+
+// ErrUnsupported is returned for requests a backend can't do, like
+// upscaling on an OpenAI-compatible server.
+var ErrUnsupported = errors.New("not supported by this image server")
+
+func DecodeImage(base64Str string) ([]byte, error) {
+	imageData, err := base64.StdEncoding.DecodeString(base64Str)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+	return imageData, nil
+}
+
+// newResponse builds the web UI's response for a backend that doesn't
+// give one: req's parameters, with the seed each image was made from.
+func newResponse(req *GenerationRequest, images [][]byte, seeds []int64) *GenerationResponse {
+	resp := &GenerationResponse{Images: make([]string, len(images))}
+	for n, data := range images {
+		resp.Images[n] = EncodeImage(data)
+	}
+	p := &resp.Parameters
+	p.Prompt, p.NegativePrompt = req.Prompt, req.NegativePrompt
+	p.Steps, p.Width, p.Height = req.Steps, req.Width, req.Height
+	p.CfgScale, p.SamplerName = req.CfgScale, req.SamplerName
+	p.BatchSize = len(images)
+	if len(seeds) > 0 {
+		p.Seed = seeds[0]
+		info, _ := json.Marshal(generationInfo{Seed: seeds[0], AllSeeds: seeds})
+		resp.Info = string(info)
+	}
+	return resp
+}
+
+// doJSON sends in as JSON, unless it's nil, and decodes the reply into
+// out, unless that's nil.
+func doJSON(ctx context.Context, client *http.Client, method, url string, header http.Header, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		jsonData, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(jsonData)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		httpReq.Header[key] = values
+	}
+	if in != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return decodeResponse(resp, out)
+}
+
+// fetch downloads url, for servers that answer with where an image is
+// rather than the image.
+func fetch(ctx context.Context, client *http.Client, url string, header http.Header) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		httpReq.Header[key] = values
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image download returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return data, nil
+}
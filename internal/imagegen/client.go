@@ -1,7 +1,7 @@
 package imagegen
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -11,9 +11,22 @@ import (
 	"time"
 )
 
+// Client talks to the Automatic1111 Stable Diffusion web UI's sdapi.
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+
+	// genClient has no timeout: generations can take minutes, and run
+	// stops them when they stall instead.
+	genClient    *http.Client
+	timeout      time.Duration
+	pollInterval time.Duration
+
+	queue  queue
+	loaded string
+
+	models   cached[[]Model]
+	samplers cached[[]Sampler]
 }
 
 type GenerationRequest struct {
@@ -24,31 +37,98 @@ type GenerationRequest struct {
 	Height         int     `json:"height,omitempty"`
 	CfgScale       float64 `json:"cfg_scale,omitempty"`
 	SamplerName    string  `json:"sampler_name,omitempty"`
+
+	// Seed fixes the noise the image starts from; 0 picks a random one.
+	Seed int64 `json:"seed,omitempty"`
+
+	// Subseed and SubseedStrength blend in a little of a second seed's
+	// noise, which gives variations that keep the original composition.
+	Subseed         int64   `json:"subseed,omitempty"`
+	SubseedStrength float64 `json:"subseed_strength,omitempty"`
+
+	BatchSize int `json:"batch_size,omitempty"`
+
+	// Checkpoint is the model to load before generating, by its title in
+	// Models. Empty uses whichever model is loaded.
+	Checkpoint string `json:"-"`
 }
 
 type GenerationResponse struct {
 	Images     []string `json:"images"`
 	Parameters struct {
-		Prompt         string  `json:"prompt"`
-		NegativePrompt string  `json:"negative_prompt"`
-		Steps          int     `json:"steps"`
-		Width          int     `json:"width"`
-		Height         int     `json:"height"`
-		CfgScale       float64 `json:"cfg_scale"`
-		Seed           int64   `json:"seed"`
+		Prompt          string  `json:"prompt"`
+		NegativePrompt  string  `json:"negative_prompt"`
+		Steps           int     `json:"steps"`
+		Width           int     `json:"width"`
+		Height          int     `json:"height"`
+		CfgScale        float64 `json:"cfg_scale"`
+		SamplerName     string  `json:"sampler_name"`
+		Seed            int64   `json:"seed"`
+		Subseed         int64   `json:"subseed"`
+		SubseedStrength float64 `json:"subseed_strength"`
+		BatchSize       int     `json:"batch_size"`
 	} `json:"parameters"`
+
+	// Info is a JSON string with the seeds the server actually used;
+	// Parameters only echoes the request, where -1 means random.
+	Info string `json:"info"`
+}
+
+// generationInfo is the part of GenerationResponse.Info we use.
+type generationInfo struct {
+	Seed        int64   `json:"seed"`
+	AllSeeds    []int64 `json:"all_seeds"`
+	Subseed     int64   `json:"subseed"`
+	AllSubseeds []int64 `json:"all_subseeds"`
 }
 
-func NewClient(baseURL string) *Client {
+// Replay returns the request that reproduces image n of the response:
+// its parameters with the seeds that were actually used.
+func (r *GenerationResponse) Replay(n int) GenerationRequest {
+	p := r.Parameters
+	req := GenerationRequest{
+		Prompt:          p.Prompt,
+		NegativePrompt:  p.NegativePrompt,
+		Steps:           p.Steps,
+		Width:           p.Width,
+		Height:          p.Height,
+		CfgScale:        p.CfgScale,
+		SamplerName:     p.SamplerName,
+		Seed:            p.Seed,
+		Subseed:         p.Subseed,
+		SubseedStrength: p.SubseedStrength,
+	}
+
+	var info generationInfo
+	if r.Info != "" && json.Unmarshal([]byte(r.Info), &info) == nil {
+		req.Seed, req.Subseed = info.Seed, info.Subseed
+		if n < len(info.AllSeeds) {
+			req.Seed = info.AllSeeds[n]
+		}
+		if n < len(info.AllSubseeds) {
+			req.Subseed = info.AllSubseeds[n]
+		}
+	}
+	if req.SubseedStrength == 0 {
+		req.Subseed = 0
+	}
+	return req
+}
+
+func NewClient(baseURL string, timeout time.Duration) *Client {
 	return &Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+			Timeout: timeout,
 		},
+		genClient:    &http.Client{},
+		timeout:      timeout,
+		pollInterval: pollInterval,
 	}
 }
 
-func (c *Client) GenerateImage(req *GenerationRequest) (*GenerationResponse, error) {
+// applyDefaults fills in the settings shared by txt2img and img2img.
+func (req *GenerationRequest) applyDefaults() {
 	if req.Steps == 0 {
 		req.Steps = 30
 	}
@@ -67,60 +147,181 @@ func (c *Client) GenerateImage(req *GenerationRequest) (*GenerationResponse, err
 	if req.NegativePrompt == "" {
 		req.NegativePrompt = "ugly, blurry, low quality, distorted, deformed, bad anatomy"
 	}
+}
 
-	slog.Info("Generating image",
-		"prompt", req.Prompt,
-		"steps", req.Steps,
-		"width", req.Width,
-		"height", req.Height,
-	)
+func (c *Client) GenerateImage(ctx context.Context, req *GenerationRequest) (*GenerationResponse, error) {
+	req.applyDefaults()
 
-	jsonData, err := json.Marshal(req)
+	var genResp GenerationResponse
+	err := c.run(ctx, req.Checkpoint, func(ctx context.Context) error {
+		slog.Info("Generating image",
+			"prompt", req.Prompt,
+			"steps", req.Steps,
+			"width", req.Width,
+			"height", req.Height,
+		)
+		return c.send(ctx, c.genClient, "/sdapi/v1/txt2img", req, &genResp)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, err
 	}
-
-	url := fmt.Sprintf("%s/sdapi/v1/txt2img", c.baseURL)
-	resp, err := c.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	// The web UI puts a grid of the batch first when it's set to return
+	// one.
+	if batch := max(req.BatchSize, 1); len(genResp.Images) > batch {
+		genResp.Images = genResp.Images[len(genResp.Images)-batch:]
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
-	}
+	slog.Info("Image generated successfully",
+		"num_images", len(genResp.Images),
+		"seed", genResp.Replay(0).Seed,
+	)
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	return &genResp, nil
+}
+
+// Img2ImgRequest redraws InitImages guided by the prompt. Denoising
+// strength is how far the result may stray from the original: 0 keeps it,
+// 1 ignores it. With a Mask only its white areas are redrawn (inpainting).
+type Img2ImgRequest struct {
+	GenerationRequest
+	InitImages        []string `json:"init_images"`
+	DenoisingStrength float64  `json:"denoising_strength"`
+	Mask              string   `json:"mask,omitempty"`
+	MaskBlur          int      `json:"mask_blur,omitempty"`
+
+	// InpaintingFill seeds the masked area before redrawing; 1 starts
+	// from the original pixels.
+	InpaintingFill int `json:"inpainting_fill"`
+
+	// InpaintFullRes redraws just the masked region at full resolution
+	// and pastes it back, which keeps small edits sharp.
+	InpaintFullRes bool `json:"inpaint_full_res"`
+}
+
+// ImageToImage runs img2img, or inpainting if req has a mask.
+func (c *Client) ImageToImage(ctx context.Context, req *Img2ImgRequest) (*GenerationResponse, error) {
+	if len(req.InitImages) == 0 {
+		return nil, fmt.Errorf("img2img needs an init image")
+	}
+	req.applyDefaults()
+	if req.DenoisingStrength == 0 {
+		req.DenoisingStrength = 0.6
+	}
+	if req.Mask != "" {
+		if req.MaskBlur == 0 {
+			req.MaskBlur = 4
+		}
+		req.InpaintingFill = 1
+		req.InpaintFullRes = true
 	}
 
 	var genResp GenerationResponse
-	if err := json.Unmarshal(body, &genResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	err := c.run(ctx, req.Checkpoint, func(ctx context.Context) error {
+		slog.Info("Editing image",
+			"prompt", req.Prompt,
+			"steps", req.Steps,
+			"width", req.Width,
+			"height", req.Height,
+			"denoising_strength", req.DenoisingStrength,
+			"inpaint", req.Mask != "",
+		)
+		return c.send(ctx, c.genClient, "/sdapi/v1/img2img", req, &genResp)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	slog.Info("Image generated successfully",
+	slog.Info("Image edited successfully",
 		"num_images", len(genResp.Images),
-		"seed", genResp.Parameters.Seed,
+		"seed", genResp.Replay(0).Seed,
 	)
 
 	return &genResp, nil
 }
 
-func (c *Client) DecodeImage(base64Str string) ([]byte, error) {
-	imageData, err := base64.StdEncoding.DecodeString(base64Str)
+// UpscaleRequest enlarges Image by UpscalingResize with the named
+// upscaler model.
+type UpscaleRequest struct {
+	Image           string  `json:"image"`
+	UpscalingResize float64 `json:"upscaling_resize"`
+	Upscaler1       string  `json:"upscaler_1"`
+}
+
+type UpscaleResponse struct {
+	Image    string `json:"image"`
+	HTMLInfo string `json:"html_info"`
+}
+
+// Upscale runs the extras tab's single image upscaler.
+func (c *Client) Upscale(ctx context.Context, req *UpscaleRequest) (*UpscaleResponse, error) {
+	if req.UpscalingResize == 0 {
+		req.UpscalingResize = 2
+	}
+	if req.Upscaler1 == "" {
+		req.Upscaler1 = "R-ESRGAN 4x+"
+	}
+
+	var upResp UpscaleResponse
+	err := c.run(ctx, "", func(ctx context.Context) error {
+		slog.Info("Upscaling image", "factor", req.UpscalingResize, "upscaler", req.Upscaler1)
+		return c.send(ctx, c.genClient, "/sdapi/v1/extra-single-image", req, &upResp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if upResp.Image == "" {
+		return nil, fmt.Errorf("upscaler returned no image")
+	}
+	return &upResp, nil
+}
+
+// post sends in as JSON to path and decodes the reply into out, unless out
+// is nil.
+func (c *Client) post(ctx context.Context, path string, in, out any) error {
+	return c.send(ctx, c.httpClient, path, in, out)
+}
+
+func (c *Client) send(ctx context.Context, client *http.Client, path string, in, out any) error {
+	return doJSON(ctx, client, http.MethodPost, c.baseURL+path, nil, in, out)
+}
+
+func (c *Client) get(ctx context.Context, path string, out any) error {
+	return doJSON(ctx, c.httpClient, http.MethodGet, c.baseURL+path, nil, nil, out)
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64 image: %w", err)
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
 	}
-	return imageData, nil
+	return nil
+}
+
+// EncodeImage is the inverse of DecodeImage, for sending images to the
+// API.
+func EncodeImage(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
 }
 
-func (c *Client) HealthCheck() error {
+func (c *Client) DecodeImage(base64Str string) ([]byte, error) {
+	return DecodeImage(base64Str)
+}
+
+func (c *Client) HealthCheck(ctx context.Context) error {
 	url := fmt.Sprintf("%s/sdapi/v1/sd-models", c.baseURL)
-	resp, err := c.httpClient.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
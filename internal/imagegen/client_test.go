@@ -0,0 +1,239 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_GenerateImageCancellationAbortsSlowServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Consume the body so the server notices the client going away.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "a cat"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GenerateImage took %s after cancellation, expected it to abort promptly", elapsed)
+	}
+}
+
+// stubServer records the JSON body posted to each path and answers with
+// the given response.
+func stubServer(t *testing.T, path string, response any) (*httptest.Server, *map[string]any) {
+	t.Helper()
+	got := map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != path {
+			t.Errorf("Expected POST %s, got %s %s", path, r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &got
+}
+
+func TestClient_GenerateImageRecordedResponse(t *testing.T) {
+	var response map[string]any
+	json.Unmarshal(fixture(t, "automatic1111/txt2img.json"), &response)
+	server, _ := stubServer(t, "/sdapi/v1/txt2img", response)
+
+	client := NewClient(server.URL, time.Minute)
+	resp, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "a lighthouse on a cliff at dusk", BatchSize: 2})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	// The web UI puts a grid of the batch first.
+	if data, _ := DecodeImage(resp.Images[0]); len(resp.Images) != 2 || string(data) != "first" {
+		t.Errorf("Expected the batch without its grid, got %d images starting %q", len(resp.Images), data)
+	}
+	if r := resp.Replay(1); r.Seed != 3528142211 || r.Subseed != 0 || r.Steps != 30 {
+		t.Errorf("Expected the second image's seed from info, got %+v", r)
+	}
+}
+
+func TestClient_ImageToImage(t *testing.T) {
+	response := map[string]any{
+		"images":     []string{EncodeImage([]byte("edited"))},
+		"parameters": map[string]any{"prompt": "a dog", "width": 512, "height": 768, "steps": 30, "seed": 42},
+	}
+	server, got := stubServer(t, "/sdapi/v1/img2img", response)
+
+	client := NewClient(server.URL, time.Minute)
+	resp, err := client.ImageToImage(context.Background(), &Img2ImgRequest{
+		GenerationRequest: GenerationRequest{Prompt: "a dog", Width: 512, Height: 768},
+		InitImages:        []string{EncodeImage([]byte("original"))},
+		DenoisingStrength: 0.4,
+	})
+	if err != nil {
+		t.Fatalf("ImageToImage failed: %v", err)
+	}
+
+	req := *got
+	if req["prompt"] != "a dog" || req["width"] != float64(512) || req["denoising_strength"] != 0.4 {
+		t.Errorf("Unexpected request: %v", req)
+	}
+	if images, _ := req["init_images"].([]any); len(images) != 1 || images[0] != EncodeImage([]byte("original")) {
+		t.Errorf("Expected the init image to be sent, got %v", req["init_images"])
+	}
+	if _, ok := req["mask"]; ok {
+		t.Errorf("Expected no mask without inpainting, got %v", req["mask"])
+	}
+	if req["sampler_name"] != "DPM++ 2M" || req["steps"] != float64(30) {
+		t.Errorf("Expected txt2img defaults to apply, got %v", req)
+	}
+
+	data, _ := client.DecodeImage(resp.Images[0])
+	if string(data) != "edited" || resp.Parameters.Seed != 42 || resp.Parameters.Height != 768 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestClient_Inpaint(t *testing.T) {
+	server, got := stubServer(t, "/sdapi/v1/img2img", map[string]any{"images": []string{"eA=="}})
+
+	client := NewClient(server.URL, time.Minute)
+	_, err := client.ImageToImage(context.Background(), &Img2ImgRequest{
+		GenerationRequest: GenerationRequest{Prompt: "a hat"},
+		InitImages:        []string{"aW1n"},
+		Mask:              "bWFzaw==",
+	})
+	if err != nil {
+		t.Fatalf("ImageToImage failed: %v", err)
+	}
+
+	req := *got
+	if req["mask"] != "bWFzaw==" || req["mask_blur"] != float64(4) || req["inpainting_fill"] != float64(1) || req["inpaint_full_res"] != true {
+		t.Errorf("Expected inpainting settings, got %v", req)
+	}
+	if req["denoising_strength"] != 0.6 {
+		t.Errorf("Expected default denoising strength, got %v", req["denoising_strength"])
+	}
+}
+
+func TestClient_ImageToImageNeedsImage(t *testing.T) {
+	client := NewClient("http://127.0.0.1:0", time.Minute)
+	if _, err := client.ImageToImage(context.Background(), &Img2ImgRequest{}); err == nil {
+		t.Error("Expected an error without an init image")
+	}
+}
+
+func TestClient_Upscale(t *testing.T) {
+	server, got := stubServer(t, "/sdapi/v1/extra-single-image", map[string]any{
+		"image":     EncodeImage([]byte("big")),
+		"html_info": "<p>done</p>",
+	})
+
+	client := NewClient(server.URL, time.Minute)
+	resp, err := client.Upscale(context.Background(), &UpscaleRequest{Image: EncodeImage([]byte("small"))})
+	if err != nil {
+		t.Fatalf("Upscale failed: %v", err)
+	}
+
+	req := *got
+	if req["image"] != EncodeImage([]byte("small")) || req["upscaling_resize"] != float64(2) || req["upscaler_1"] != "R-ESRGAN 4x+" {
+		t.Errorf("Unexpected request: %v", req)
+	}
+	if data, _ := client.DecodeImage(resp.Image); string(data) != "big" {
+		t.Errorf("Expected the upscaled image, got %q", data)
+	}
+}
+
+func TestClient_UpscaleReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"detail":"Upscaler not found"}`, http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, time.Minute).Upscale(context.Background(), &UpscaleRequest{Image: "eA==", Upscaler1: "Nope"})
+	if err == nil || !strings.Contains(err.Error(), "422") || !strings.Contains(err.Error(), "Upscaler not found") {
+		t.Errorf("Expected the API error, got %v", err)
+	}
+}
+
+func TestClient_GenerateVariations(t *testing.T) {
+	info, _ := json.Marshal(map[string]any{
+		"seed":         1234,
+		"all_seeds":    []int64{1234, 1234, 1234},
+		"subseed":      77,
+		"all_subseeds": []int64{77, 78, 79},
+	})
+	response := map[string]any{
+		"images": []string{EncodeImage([]byte("grid")), EncodeImage([]byte("a")), EncodeImage([]byte("b")), EncodeImage([]byte("c"))},
+		"parameters": map[string]any{
+			"prompt": "a fox", "width": 768, "height": 512, "steps": 20, "cfg_scale": 7,
+			"sampler_name": "Euler a", "seed": 1234, "subseed": -1, "subseed_strength": 0.15, "batch_size": 3,
+		},
+		"info": string(info),
+	}
+	server, got := stubServer(t, "/sdapi/v1/txt2img", response)
+
+	client := NewClient(server.URL, time.Minute)
+	resp, err := client.GenerateImage(context.Background(), &GenerationRequest{
+		Prompt: "a fox", Width: 768, Height: 512, Steps: 20, SamplerName: "Euler a",
+		Seed: 1234, Subseed: -1, SubseedStrength: 0.15, BatchSize: 3,
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+
+	body := *got
+	if body["seed"] != float64(1234) || body["subseed"] != float64(-1) || body["subseed_strength"] != 0.15 || body["batch_size"] != float64(3) {
+		t.Errorf("Unexpected request body: %v", body)
+	}
+	if len(resp.Images) != 3 {
+		t.Fatalf("Expected the grid dropped, got %d images", len(resp.Images))
+	}
+	if data, _ := client.DecodeImage(resp.Images[0]); string(data) != "a" {
+		t.Errorf("Expected the first image after the grid, got %q", data)
+	}
+
+	replay := resp.Replay(2)
+	want := GenerationRequest{
+		Prompt: "a fox", NegativePrompt: replay.NegativePrompt, Steps: 20, Width: 768, Height: 512, CfgScale: 7,
+		SamplerName: "Euler a", Seed: 1234, Subseed: 79, SubseedStrength: 0.15,
+	}
+	if replay != want {
+		t.Errorf("Expected %+v, got %+v", want, replay)
+	}
+}
+
+func TestGenerationResponse_ReplayWithoutInfo(t *testing.T) {
+	var resp GenerationResponse
+	resp.Parameters.Prompt = "a cat"
+	resp.Parameters.Seed = 42
+	resp.Parameters.Subseed = -1
+
+	replay := resp.Replay(0)
+	if replay.Prompt != "a cat" || replay.Seed != 42 || replay.Subseed != 0 {
+		t.Errorf("Expected the echoed parameters without a subseed, got %+v", replay)
+	}
+}
@@ -0,0 +1,570 @@
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed workflows/*.json
+var workflows embed.FS
+
+// placeholder matches a value to fill in a workflow template.
+var placeholder = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
+// comfySamplers maps ComfyUI's sampler names to the web UI's, so presets
+// written for either work on both.
+var comfySamplers = map[string]string{
+	"euler":              "Euler",
+	"euler_ancestral":    "Euler a",
+	"heun":               "Heun",
+	"dpm_2":              "DPM2",
+	"dpm_2_ancestral":    "DPM2 a",
+	"lms":                "LMS",
+	"dpmpp_2s_ancestral": "DPM++ 2S a",
+	"dpmpp_sde":          "DPM++ SDE",
+	"dpmpp_2m":           "DPM++ 2M",
+	"dpmpp_2m_sde":       "DPM++ 2M SDE",
+	"dpmpp_3m_sde":       "DPM++ 3M SDE",
+	"ddim":               "DDIM",
+	"uni_pc":             "UniPC",
+	"lcm":                "LCM",
+}
+
+// ComfyClient runs generations as ComfyUI workflows. A workflow is a
+// graph in ComfyUI's API format ("Save (API Format)") with placeholders:
+// a string that is exactly "{{seed}}" becomes the seed's value, and
+// placeholders inside longer strings are replaced by their text. The
+// first image the workflow saves is the result.
+//
+// Placeholders are prompt, negative_prompt, seed, steps, width, height,
+// cfg_scale, sampler, scheduler and checkpoint, plus image (the uploaded
+// source) and denoise for img2img.
+type ComfyClient struct {
+	baseURL      string
+	httpClient   *http.Client
+	timeout      time.Duration
+	pollInterval time.Duration
+	clientID     string
+
+	checkpoint       string
+	txt2img, img2img []byte
+
+	queue queue
+	// running is the prompt ComfyUI is working on for us. Like
+	// Client.loaded, only the request holding the queue sees it.
+	running string
+
+	models   cached[[]Model]
+	samplers cached[[]Sampler]
+}
+
+// NewComfyClient returns a client for the ComfyUI server at baseURL.
+// checkpoint is the model used when a request doesn't pick one, or the
+// first ComfyUI lists if it's empty. Nil workflows use the built-in ones.
+func NewComfyClient(baseURL string, timeout time.Duration, checkpoint string, txt2img, img2img []byte) (*ComfyClient, error) {
+	c := &ComfyClient{
+		baseURL:      baseURL,
+		httpClient:   &http.Client{Timeout: timeout},
+		timeout:      timeout,
+		pollInterval: pollInterval,
+		clientID:     fmt.Sprintf("discord-bot-%x", rand.Uint64()),
+		checkpoint:   checkpoint,
+		txt2img:      txt2img,
+		img2img:      img2img,
+	}
+	for name, workflow := range map[string]*[]byte{"txt2img": &c.txt2img, "img2img": &c.img2img} {
+		if *workflow == nil {
+			data, err := workflows.ReadFile("workflows/" + name + ".json")
+			if err != nil {
+				return nil, fmt.Errorf("failed to read built-in %s workflow: %w", name, err)
+			}
+			*workflow = data
+		}
+		var graph map[string]any
+		if err := json.Unmarshal(*workflow, &graph); err != nil {
+			return nil, fmt.Errorf("invalid %s workflow: %w", name, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *ComfyClient) GenerateImage(ctx context.Context, req *GenerationRequest) (*GenerationResponse, error) {
+	req.applyDefaults()
+	// ComfyUI has no subseeds, so a variation is a new seed.
+	if req.SubseedStrength > 0 && req.Subseed > 0 {
+		req.Seed, req.Subseed, req.SubseedStrength = req.Subseed, 0, 0
+	}
+	if req.Seed <= 0 {
+		req.Seed = rand.Int64N(1 << 32)
+	}
+
+	var images [][]byte
+	var seeds []int64
+	err := c.run(ctx, func(ctx context.Context) error {
+		values, err := c.values(ctx, req)
+		if err != nil {
+			return err
+		}
+		slog.Info("Generating image",
+			"prompt", req.Prompt,
+			"steps", req.Steps,
+			"width", req.Width,
+			"height", req.Height,
+			"backend", "comfyui",
+		)
+		// One prompt per image, seed after seed as the web UI numbers a
+		// batch, so each image can be made again from its seed.
+		batch := max(req.BatchSize, 1)
+		for n := range batch {
+			seed := req.Seed + int64(n)
+			values["seed"] = seed
+			image, err := c.execute(ctx, c.txt2img, values)
+			if err != nil {
+				return err
+			}
+			images, seeds = append(images, image), append(seeds, seed)
+			progressFrom(ctx)(Progress{Fraction: float64(n+1) / float64(batch)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Image generated successfully", "num_images", len(images), "seed", seeds[0])
+	return newResponse(req, images, seeds), nil
+}
+
+// ImageToImage uploads the source image and runs the img2img workflow on
+// it. ComfyUI inpainting needs a workflow of its own, so masks aren't
+// supported.
+func (c *ComfyClient) ImageToImage(ctx context.Context, req *Img2ImgRequest) (*GenerationResponse, error) {
+	if len(req.InitImages) == 0 {
+		return nil, fmt.Errorf("img2img needs an init image")
+	}
+	if req.Mask != "" {
+		return nil, fmt.Errorf("inpainting is %w", ErrUnsupported)
+	}
+	source, err := DecodeImage(req.InitImages[0])
+	if err != nil {
+		return nil, err
+	}
+	req.applyDefaults()
+	if req.DenoisingStrength == 0 {
+		req.DenoisingStrength = 0.6
+	}
+	if req.Seed <= 0 {
+		req.Seed = rand.Int64N(1 << 32)
+	}
+
+	var image []byte
+	err = c.run(ctx, func(ctx context.Context) error {
+		name, err := c.upload(ctx, source)
+		if err != nil {
+			return err
+		}
+		values, err := c.values(ctx, &req.GenerationRequest)
+		if err != nil {
+			return err
+		}
+		values["image"], values["denoise"] = name, req.DenoisingStrength
+		slog.Info("Editing image",
+			"prompt", req.Prompt,
+			"steps", req.Steps,
+			"denoising_strength", req.DenoisingStrength,
+			"backend", "comfyui",
+		)
+		image, err = c.execute(ctx, c.img2img, values)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(&req.GenerationRequest, [][]byte{image}, []int64{req.Seed}), nil
+}
+
+func (c *ComfyClient) Upscale(ctx context.Context, req *UpscaleRequest) (*UpscaleResponse, error) {
+	return nil, fmt.Errorf("upscaling is %w", ErrUnsupported)
+}
+
+// values are the placeholders for req, but for the seed.
+func (c *ComfyClient) values(ctx context.Context, req *GenerationRequest) (map[string]any, error) {
+	checkpoint := req.Checkpoint
+	if checkpoint == "" {
+		checkpoint = c.checkpoint
+	}
+	if checkpoint == "" {
+		models, err := c.Models(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list models: %w", err)
+		}
+		if len(models) == 0 {
+			return nil, errors.New("ComfyUI has no checkpoints")
+		}
+		checkpoint = models[0].Title
+	}
+	sampler, scheduler := comfySampler(req.SamplerName)
+	return map[string]any{
+		"prompt":          req.Prompt,
+		"negative_prompt": req.NegativePrompt,
+		"seed":            req.Seed,
+		"steps":           req.Steps,
+		"width":           req.Width,
+		"height":          req.Height,
+		"cfg_scale":       req.CfgScale,
+		"sampler":         sampler,
+		"scheduler":       scheduler,
+		"checkpoint":      checkpoint,
+	}, nil
+}
+
+// comfySampler translates a web UI sampler name, like "DPM++ 2M Karras",
+// to ComfyUI's sampler and scheduler. ComfyUI's own names pass through.
+func comfySampler(name string) (sampler, scheduler string) {
+	scheduler = "normal"
+	if base, ok := strings.CutSuffix(name, " Karras"); ok {
+		name, scheduler = base, "karras"
+	}
+	for comfy, webui := range comfySamplers {
+		if strings.EqualFold(name, webui) {
+			return comfy, scheduler
+		}
+	}
+	return name, scheduler
+}
+
+// fillWorkflow replaces the placeholders in v, a decoded workflow.
+func fillWorkflow(v any, values map[string]any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, e := range v {
+			v[key] = fillWorkflow(e, values)
+		}
+	case []any:
+		for n, e := range v {
+			v[n] = fillWorkflow(e, values)
+		}
+	case string:
+		if m := placeholder.FindStringSubmatch(v); m != nil && m[0] == v {
+			if value, ok := values[m[1]]; ok {
+				return value
+			}
+		}
+		return placeholder.ReplaceAllStringFunc(v, func(m string) string {
+			if value, ok := values[m[2:len(m)-2]]; ok {
+				return fmt.Sprint(value)
+			}
+			return m
+		})
+	}
+	return v
+}
+
+// run waits its turn at ComfyUI and calls fn. A prompt that's given up on
+// is interrupted and taken off ComfyUI's queue before the next one starts.
+func (c *ComfyClient) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	release, err := c.queue.acquire(ctx, priorityFrom(ctx), progressFrom(ctx))
+	if err != nil {
+		return err
+	}
+	c.running = ""
+	err = fn(ctx)
+	if err == nil || ctx.Err() == nil || c.running == "" {
+		release()
+		return err
+	}
+
+	id := c.running
+	go func() {
+		defer release()
+		ctx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+		defer cancel()
+		if err := c.post(ctx, "/queue", map[string]any{"delete": []string{id}}, nil); err != nil {
+			slog.Warn("Failed to remove prompt from ComfyUI queue", "prompt_id", id, "error", err)
+		}
+		if err := c.post(ctx, "/interrupt", struct{}{}, nil); err != nil {
+			slog.Warn("Failed to interrupt ComfyUI", "error", err)
+		}
+	}()
+	return err
+}
+
+type comfyPromptResponse struct {
+	PromptID string `json:"prompt_id"`
+}
+
+type comfyImage struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+// comfyHistory is the part of a /history entry we use.
+type comfyHistory struct {
+	Status struct {
+		StatusStr string `json:"status_str"`
+		Completed bool   `json:"completed"`
+		// Messages are [type, details] pairs.
+		Messages [][]json.RawMessage `json:"messages"`
+	} `json:"status"`
+	Outputs map[string]struct {
+		Images []comfyImage `json:"images"`
+	} `json:"outputs"`
+}
+
+// execute queues the workflow filled in with values, waits for it and
+// returns the first image it saved.
+func (c *ComfyClient) execute(ctx context.Context, template []byte, values map[string]any) ([]byte, error) {
+	var graph any
+	if err := json.Unmarshal(template, &graph); err != nil {
+		return nil, fmt.Errorf("invalid workflow: %w", err)
+	}
+	var queued comfyPromptResponse
+	err := c.post(ctx, "/prompt", map[string]any{
+		"prompt":    fillWorkflow(graph, values),
+		"client_id": c.clientID,
+	}, &queued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue workflow: %w", err)
+	}
+	if queued.PromptID == "" {
+		return nil, errors.New("ComfyUI didn't return a prompt ID")
+	}
+	c.running = queued.PromptID
+
+	entry, err := c.wait(ctx, queued.PromptID)
+	if err != nil {
+		return nil, err
+	}
+	c.running = ""
+
+	// Outputs are keyed by node ID; take the lowest with a saved image.
+	nodes := make([]string, 0, len(entry.Outputs))
+	for node := range entry.Outputs {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		for _, image := range entry.Outputs[node].Images {
+			if image.Type != "output" {
+				continue
+			}
+			query := url.Values{"filename": {image.Filename}, "subfolder": {image.Subfolder}, "type": {image.Type}}
+			return fetch(ctx, c.httpClient, c.baseURL+"/view?"+query.Encode(), nil)
+		}
+	}
+	return nil, errors.New("the workflow saved no image")
+}
+
+// wait polls the history until the prompt is done. A prompt that's
+// neither done nor in ComfyUI's queue for the client's timeout is given
+// up on.
+func (c *ComfyClient) wait(ctx context.Context, id string) (*comfyHistory, error) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	lastSeen := time.Now()
+	for {
+		var history map[string]comfyHistory
+		if err := c.get(ctx, "/history/"+id, &history); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			slog.Debug("Failed to read ComfyUI history", "error", err)
+		} else if entry, ok := history[id]; ok {
+			if entry.Status.StatusStr == "error" {
+				return nil, fmt.Errorf("workflow failed: %s", entry.failure())
+			}
+			if entry.Status.Completed {
+				return &entry, nil
+			}
+		}
+
+		if queued, err := c.queued(ctx, id); err == nil && queued {
+			lastSeen = time.Now()
+		}
+		if time.Since(lastSeen) > c.timeout {
+			return nil, fmt.Errorf("%w for %s", errStalled, c.timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// failure is ComfyUI's reason for a failed prompt.
+func (h *comfyHistory) failure() string {
+	for _, m := range h.Status.Messages {
+		var kind string
+		if len(m) < 2 || json.Unmarshal(m[0], &kind) != nil || kind != "execution_error" {
+			continue
+		}
+		var details struct {
+			NodeType         string `json:"node_type"`
+			ExceptionMessage string `json:"exception_message"`
+		}
+		if json.Unmarshal(m[1], &details) == nil && details.ExceptionMessage != "" {
+			return fmt.Sprintf("%s: %s", details.NodeType, strings.TrimSpace(details.ExceptionMessage))
+		}
+	}
+	return "unknown error"
+}
+
+// queued reports whether the prompt is running or waiting in ComfyUI.
+func (c *ComfyClient) queued(ctx context.Context, id string) (bool, error) {
+	var q struct {
+		Running [][]json.RawMessage `json:"queue_running"`
+		Pending [][]json.RawMessage `json:"queue_pending"`
+	}
+	if err := c.get(ctx, "/queue", &q); err != nil {
+		return false, err
+	}
+	for _, item := range append(q.Running, q.Pending...) {
+		var itemID string
+		if len(item) > 1 && json.Unmarshal(item[1], &itemID) == nil && itemID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// upload puts an image in ComfyUI's input folder and returns the name
+// LoadImage takes.
+func (c *ComfyClient) upload(ctx context.Context, data []byte) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("image", fmt.Sprintf("discord-bot-%x.png", rand.Uint64()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload: %w", err)
+	}
+	part.Write(data)
+	w.WriteField("overwrite", "true")
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/upload/image", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("image upload returned status %d", resp.StatusCode)
+	}
+
+	var uploaded struct {
+		Name      string `json:"name"`
+		Subfolder string `json:"subfolder"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+		return "", fmt.Errorf("failed to decode upload response: %w", err)
+	}
+	return path.Join(uploaded.Subfolder, uploaded.Name), nil
+}
+
+// Models lists the checkpoints CheckpointLoaderSimple can load.
+func (c *ComfyClient) Models(ctx context.Context) ([]Model, error) {
+	return c.models.get(ctx, func(ctx context.Context) ([]Model, error) {
+		names, err := c.options(ctx, "CheckpointLoaderSimple", "ckpt_name")
+		if err != nil {
+			return nil, err
+		}
+		models := make([]Model, len(names))
+		for n, name := range names {
+			models[n] = Model{Title: name, ModelName: strings.TrimSuffix(path.Base(name), path.Ext(name))}
+		}
+		return models, nil
+	})
+}
+
+// Samplers lists KSampler's samplers, with the web UI's names as aliases.
+func (c *ComfyClient) Samplers(ctx context.Context) ([]Sampler, error) {
+	return c.samplers.get(ctx, func(ctx context.Context) ([]Sampler, error) {
+		names, err := c.options(ctx, "KSampler", "sampler_name")
+		if err != nil {
+			return nil, err
+		}
+		samplers := make([]Sampler, len(names))
+		for n, name := range names {
+			samplers[n] = Sampler{Name: name}
+			if webui, ok := comfySamplers[name]; ok {
+				samplers[n].Aliases = []string{webui}
+			}
+		}
+		return samplers, nil
+	})
+}
+
+// options reads the choices for a node's input from /object_info. Older
+// servers list them as the input's first element, newer ones as
+// ["COMBO", {"options": [...]}].
+func (c *ComfyClient) options(ctx context.Context, node, input string) ([]string, error) {
+	var info map[string]struct {
+		Input struct {
+			Required map[string][]json.RawMessage `json:"required"`
+		} `json:"input"`
+	}
+	if err := c.get(ctx, "/object_info/"+node, &info); err != nil {
+		return nil, err
+	}
+	spec := info[node].Input.Required[input]
+	if len(spec) == 0 {
+		return nil, fmt.Errorf("ComfyUI has no %s.%s", node, input)
+	}
+	var names []string
+	if json.Unmarshal(spec[0], &names) == nil {
+		return names, nil
+	}
+	var combo struct {
+		Options []string `json:"options"`
+	}
+	if len(spec) > 1 && json.Unmarshal(spec[1], &combo) == nil && combo.Options != nil {
+		return combo.Options, nil
+	}
+	return nil, fmt.Errorf("unexpected choices for %s.%s", node, input)
+}
+
+func (c *ComfyClient) ResolveModel(ctx context.Context, name string) (string, error) {
+	return resolveModel(ctx, c, name)
+}
+
+func (c *ComfyClient) ResolveSampler(ctx context.Context, name string) (string, error) {
+	return resolveSampler(ctx, c, name)
+}
+
+func (c *ComfyClient) HealthCheck(ctx context.Context) error {
+	if err := c.get(ctx, "/system_stats", nil); err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	return nil
+}
+
+func (c *ComfyClient) post(ctx context.Context, path string, in, out any) error {
+	return doJSON(ctx, c.httpClient, http.MethodPost, c.baseURL+path, nil, in, out)
+}
+
+func (c *ComfyClient) get(ctx context.Context, path string, out any) error {
+	return doJSON(ctx, c.httpClient, http.MethodGet, c.baseURL+path, nil, nil, out)
+}
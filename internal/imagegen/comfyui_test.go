@@ -0,0 +1,289 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	return data
+}
+
+const comfyPromptID = "6f1d7e8a-3c2b-4a59-9e1f-0b7c2d4e5a61"
+
+// fakeComfy serves recorded ComfyUI responses. Each queued prompt shows
+// as running for one history poll, then history is served; with history
+// empty it runs until it's cancelled.
+type fakeComfy struct {
+	t       *testing.T
+	history string
+	queue   string
+
+	mu      sync.Mutex
+	polls   int
+	prompts []map[string]any
+	uploads []string
+	events  []string
+}
+
+func newFakeComfy(t *testing.T, history string) (*fakeComfy, *ComfyClient) {
+	t.Helper()
+	f := &fakeComfy{t: t, history: history, queue: "comfyui/queue_running.json"}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	client, err := NewComfyClient(server.URL, 5*time.Second, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewComfyClient failed: %v", err)
+	}
+	client.pollInterval = 5 * time.Millisecond
+	return f, client
+}
+
+func (f *fakeComfy) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /object_info/CheckpointLoaderSimple":
+		w.Write(fixture(f.t, "comfyui/object_info_checkpoint.json"))
+	case "GET /object_info/KSampler":
+		w.Write(fixture(f.t, "comfyui/object_info_ksampler.json"))
+	case "POST /prompt":
+		var body struct {
+			Prompt   map[string]any `json:"prompt"`
+			ClientID string         `json:"client_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.ClientID == "" {
+			f.t.Error("Expected a client ID with the prompt")
+		}
+		f.prompts = append(f.prompts, body.Prompt)
+		f.polls = 0
+		w.Write(fixture(f.t, "comfyui/prompt.json"))
+	case "GET /history/" + comfyPromptID:
+		f.polls++
+		if f.polls == 1 || f.history == "" {
+			w.Write([]byte("{}"))
+			return
+		}
+		w.Write(fixture(f.t, f.history))
+	case "GET /queue":
+		w.Write(fixture(f.t, f.queue))
+	case "GET /view":
+		q := r.URL.Query()
+		w.Write([]byte(q.Get("type") + "/" + q.Get("filename")))
+	case "POST /upload/image":
+		file, header, err := r.FormFile("image")
+		if err != nil {
+			f.t.Errorf("Expected an image in the upload: %v", err)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		f.uploads = append(f.uploads, filepath.Ext(header.Filename)+" "+string(data)+" overwrite="+r.FormValue("overwrite"))
+		w.Write(fixture(f.t, "comfyui/upload.json"))
+	case "POST /queue":
+		var body struct {
+			Delete []string `json:"delete"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.events = append(f.events, "delete "+strings.Join(body.Delete, ","))
+		w.Write([]byte("{}"))
+	case "POST /interrupt":
+		f.events = append(f.events, "interrupt")
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// node returns the inputs of a node in the n'th queued workflow.
+func (f *fakeComfy) node(n int, id string) map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.prompts[n][id].(map[string]any)["inputs"].(map[string]any)
+}
+
+func TestComfyClient_GenerateImage(t *testing.T) {
+	f, client := newFakeComfy(t, "comfyui/history_success.json")
+
+	var progress []float64
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		if p.QueuePosition == 0 {
+			progress = append(progress, p.Fraction)
+		}
+	})
+	resp, err := client.GenerateImage(ctx, &GenerationRequest{
+		Prompt:      "a lighthouse, {{unknown}}",
+		Width:       832,
+		Height:      1216,
+		Seed:        1234,
+		SamplerName: "DPM++ 2M Karras",
+		BatchSize:   2,
+	})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+
+	if len(f.prompts) != 2 {
+		t.Fatalf("Expected a prompt per image, got %d", len(f.prompts))
+	}
+	sampler := f.node(0, "3")
+	if sampler["seed"] != 1234.0 || sampler["steps"] != 30.0 || sampler["cfg"] != 7.0 || sampler["sampler_name"] != "dpmpp_2m" || sampler["scheduler"] != "karras" {
+		t.Errorf("Unexpected KSampler inputs %v", sampler)
+	}
+	if seed := f.node(1, "3")["seed"]; seed != 1235.0 {
+		t.Errorf("Expected the second image from the next seed, got %v", seed)
+	}
+	if latent := f.node(0, "5"); latent["width"] != 832.0 || latent["height"] != 1216.0 {
+		t.Errorf("Unexpected latent size %v", latent)
+	}
+	if text := f.node(0, "6")["text"]; text != "a lighthouse, {{unknown}}" {
+		t.Errorf("Expected the prompt as written, got %v", text)
+	}
+	if ckpt := f.node(0, "4")["ckpt_name"]; ckpt != "sd_xl_base_1.0.safetensors" {
+		t.Errorf("Expected the first checkpoint by default, got %v", ckpt)
+	}
+
+	if len(resp.Images) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(resp.Images))
+	}
+	if data, _ := DecodeImage(resp.Images[0]); string(data) != "output/discord-bot_00042_.png" {
+		t.Errorf("Expected the saved image rather than the preview, got %q", data)
+	}
+	if r := resp.Replay(1); r.Seed != 1235 || r.Width != 832 || r.Prompt != "a lighthouse, {{unknown}}" {
+		t.Errorf("Unexpected replay %+v", r)
+	}
+	if len(progress) != 2 || progress[1] != 1 {
+		t.Errorf("Expected progress after each image, got %v", progress)
+	}
+}
+
+func TestComfyClient_WorkflowError(t *testing.T) {
+	_, client := newFakeComfy(t, "comfyui/history_error.json")
+
+	_, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "a cat", Checkpoint: "anime/animagine-xl-3.1.safetensors"})
+	if err == nil || !strings.Contains(err.Error(), "KSampler: Allocation on device") {
+		t.Errorf("Expected ComfyUI's error, got %v", err)
+	}
+}
+
+func TestComfyClient_Catalog(t *testing.T) {
+	_, client := newFakeComfy(t, "")
+	ctx := context.Background()
+
+	if title, err := client.ResolveModel(ctx, "animagine-xl-3.1"); err != nil || title != "anime/animagine-xl-3.1.safetensors" {
+		t.Errorf("ResolveModel = %q, %v", title, err)
+	}
+	// Newer servers list choices as a COMBO; web UI names are aliases.
+	for name, want := range map[string]string{"euler_ancestral": "euler_ancestral", "DPM++ 2M SDE": "dpmpp_2m_sde"} {
+		if got, err := client.ResolveSampler(ctx, name); err != nil || got != want {
+			t.Errorf("ResolveSampler(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestComfyClient_ImageToImage(t *testing.T) {
+	f, client := newFakeComfy(t, "comfyui/history_success.json")
+
+	resp, err := client.ImageToImage(context.Background(), &Img2ImgRequest{
+		GenerationRequest: GenerationRequest{Prompt: "as a watercolor", Width: 512, Height: 512},
+		InitImages:        []string{EncodeImage([]byte("source"))},
+		DenoisingStrength: 0.45,
+	})
+	if err != nil {
+		t.Fatalf("ImageToImage failed: %v", err)
+	}
+	if len(f.uploads) != 1 || f.uploads[0] != ".png source overwrite=true" {
+		t.Errorf("Unexpected uploads %v", f.uploads)
+	}
+	if image := f.node(0, "10")["image"]; image != "bot/discord-bot-source.png" {
+		t.Errorf("Expected the uploaded image loaded, got %v", image)
+	}
+	if denoise := f.node(0, "3")["denoise"]; denoise != 0.45 {
+		t.Errorf("Expected denoise 0.45, got %v", denoise)
+	}
+	if len(resp.Images) != 1 || resp.Replay(0).Seed == 0 {
+		t.Errorf("Expected one image with its seed, got %+v", resp.Parameters)
+	}
+
+	_, err = client.ImageToImage(context.Background(), &Img2ImgRequest{InitImages: []string{"x"}, Mask: "y"})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected inpainting to be unsupported, got %v", err)
+	}
+	if _, err := client.Upscale(context.Background(), &UpscaleRequest{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected upscaling to be unsupported, got %v", err)
+	}
+}
+
+func TestComfyClient_CancelRemovesPrompt(t *testing.T) {
+	f, client := newFakeComfy(t, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "slow"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the generation to end with the context, got %v", err)
+	}
+
+	// The next request waits for the clean-up.
+	f.mu.Lock()
+	f.history = "comfyui/history_success.json"
+	f.mu.Unlock()
+	if _, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "next"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := strings.Join(f.events, "\n"); got != "delete "+comfyPromptID+"\ninterrupt" {
+		t.Errorf("Expected the prompt removed and interrupted, got:\n%s", got)
+	}
+}
+
+func TestComfyClient_LostPromptStalls(t *testing.T) {
+	f, client := newFakeComfy(t, "")
+	f.queue = "comfyui/queue_empty.json"
+	client.timeout = 30 * time.Millisecond
+
+	_, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "lost"})
+	if !errors.Is(err, errStalled) {
+		t.Errorf("Expected a prompt missing from the queue to stall, got %v", err)
+	}
+}
+
+func TestFillWorkflow(t *testing.T) {
+	var graph any
+	json.Unmarshal([]byte(`{"a": {"inputs": {"seed": "{{seed}}", "text": "{{prompt}}, by {{artist}}", "list": ["{{steps}}", 2]}}}`), &graph)
+	got, _ := json.Marshal(fillWorkflow(graph, map[string]any{"seed": int64(7), "prompt": "a fox", "steps": 20}))
+	want := `{"a":{"inputs":{"list":[20,2],"seed":7,"text":"a fox, by {{artist}}"}}}`
+	if string(got) != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestComfySampler(t *testing.T) {
+	tests := map[string][2]string{
+		"Euler a":         {"euler_ancestral", "normal"},
+		"DPM++ 2M Karras": {"dpmpp_2m", "karras"},
+		"dpmpp_2m_sde":    {"dpmpp_2m_sde", "normal"},
+	}
+	for name, want := range tests {
+		if sampler, scheduler := comfySampler(name); sampler != want[0] || scheduler != want[1] {
+			t.Errorf("comfySampler(%q) = %s, %s, want %v", name, sampler, scheduler, want)
+		}
+	}
+}
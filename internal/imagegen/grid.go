@@ -0,0 +1,126 @@
+package imagegen
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+// gridGap is the space around and between grid cells.
+const gridGap = 8
+
+var (
+	gridBackground = color.RGBA{0x2b, 0x2d, 0x31, 0xff}
+	gridBadge      = color.RGBA{0x11, 0x12, 0x14, 0xff}
+	gridLabel      = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// digitGlyphs are 3x5 bitmaps of 0-9, one row per string.
+var digitGlyphs = [10][5]string{
+	{"###", "#.#", "#.#", "#.#", "###"},
+	{".#.", "##.", ".#.", ".#.", "###"},
+	{"###", "..#", "###", "#..", "###"},
+	{"###", "..#", "###", "..#", "###"},
+	{"#.#", "#.#", "###", "..#", "..#"},
+	{"###", "#..", "###", "..#", "###"},
+	{"###", "#..", "###", "#.#", "###"},
+	{"###", "..#", "..#", "..#", "..#"},
+	{"###", "#.#", "###", "#.#", "###"},
+	{"###", "#.#", "###", "..#", "###"},
+}
+
+// Grid lays images out in a contact sheet, as square as it can be, with
+// each one numbered from 1 in its top-left corner. Images bigger than
+// maxCell on a side are shrunk by a whole factor to fit. It draws into a
+// new image and leaves its inputs alone.
+func Grid(images []image.Image, maxCell int) *image.RGBA {
+	if len(images) == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
+
+	cells := make([]image.Image, len(images))
+	cellW, cellH := 0, 0
+	for n, img := range images {
+		cells[n] = shrink(img, maxCell)
+		b := cells[n].Bounds()
+		cellW, cellH = max(cellW, b.Dx()), max(cellH, b.Dy())
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(cells)))))
+	rows := (len(cells) + cols - 1) / cols
+	grid := image.NewRGBA(image.Rect(0, 0, cols*cellW+(cols+1)*gridGap, rows*cellH+(rows+1)*gridGap))
+	draw.Draw(grid, grid.Bounds(), image.NewUniform(gridBackground), image.Point{}, draw.Src)
+
+	scale := max(2, cellH/128)
+	for n, cell := range cells {
+		origin := image.Pt(gridGap+(n%cols)*(cellW+gridGap), gridGap+(n/cols)*(cellH+gridGap))
+		draw.Draw(grid, cell.Bounds().Sub(cell.Bounds().Min).Add(origin), cell, cell.Bounds().Min, draw.Src)
+		drawLabel(grid, origin, strconv.Itoa(n+1), scale)
+	}
+	return grid
+}
+
+// Thumbnail shrinks img to fit in maxSide the way Grid shrinks its cells.
+// Images that already fit are returned as they are.
+func Thumbnail(img image.Image, maxSide int) image.Image {
+	return shrink(img, maxSide)
+}
+
+// shrink scales img down by the smallest whole factor that fits it in
+// maxSide, averaging each block of pixels.
+func shrink(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	factor := 1
+	if maxSide > 0 {
+		factor = max(1, (max(b.Dx(), b.Dy())+maxSide-1)/maxSide)
+	}
+	if factor == 1 {
+		return img
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()/factor, b.Dy()/factor))
+	area := uint32(factor * factor)
+	for y := range out.Bounds().Dy() {
+		for x := range out.Bounds().Dx() {
+			var r, g, bl, a uint32
+			for dy := range factor {
+				for dx := range factor {
+					pr, pg, pb, pa := img.At(b.Min.X+x*factor+dx, b.Min.Y+y*factor+dy).RGBA()
+					r, g, bl, a = r+pr, g+pg, bl+pb, a+pa
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / area >> 8),
+				G: uint8(g / area >> 8),
+				B: uint8(bl / area >> 8),
+				A: uint8(a / area >> 8),
+			})
+		}
+	}
+	return out
+}
+
+// drawLabel draws text, which must be digits, on a dark badge at origin,
+// with each glyph pixel scale pixels wide.
+func drawLabel(dst *image.RGBA, origin image.Point, text string, scale int) {
+	width := (len(text)*4 + 1) * scale
+	height := 7 * scale
+	badge := image.Rect(0, 0, width, height).Add(origin)
+	draw.Draw(dst, badge, image.NewUniform(gridBadge), image.Point{}, draw.Src)
+
+	for i, ch := range text {
+		glyph := digitGlyphs[ch-'0']
+		left := origin.X + (1+i*4)*scale
+		for row, bits := range glyph {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				px := image.Rect(0, 0, scale, scale).Add(image.Pt(left+col*scale, origin.Y+(1+row)*scale))
+				draw.Draw(dst, px, image.NewUniform(gridLabel), image.Point{}, draw.Src)
+			}
+		}
+	}
+}
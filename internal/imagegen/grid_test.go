@@ -0,0 +1,105 @@
+package imagegen
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func solid(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func assertPixel(t *testing.T, img *image.RGBA, x, y int, want color.RGBA) {
+	t.Helper()
+	if got := img.RGBAAt(x, y); got != want {
+		t.Errorf("Pixel (%d, %d): expected %v, got %v", x, y, want, got)
+	}
+}
+
+var (
+	red    = color.RGBA{0xff, 0, 0, 0xff}
+	green  = color.RGBA{0, 0xff, 0, 0xff}
+	blue   = color.RGBA{0, 0, 0xff, 0xff}
+	yellow = color.RGBA{0xff, 0xff, 0, 0xff}
+)
+
+func TestGrid_TwoByTwo(t *testing.T) {
+	grid := Grid([]image.Image{solid(64, 64, red), solid(64, 64, green), solid(64, 64, blue), solid(64, 64, yellow)}, 64)
+
+	if b := grid.Bounds(); b.Dx() != 2*64+3*gridGap || b.Dy() != 2*64+3*gridGap {
+		t.Fatalf("Unexpected grid size %v", b)
+	}
+
+	// Cell centres, left to right then top to bottom.
+	step := 64 + gridGap
+	for n, want := range []color.RGBA{red, green, blue, yellow} {
+		x := gridGap + (n%2)*step + 48
+		y := gridGap + (n/2)*step + 48
+		assertPixel(t, grid, x, y, want)
+	}
+
+	// The border and the gap between cells.
+	assertPixel(t, grid, 0, 0, gridBackground)
+	assertPixel(t, grid, gridGap+64+gridGap/2, 40, gridBackground)
+	assertPixel(t, grid, 40, gridGap+64+gridGap/2, gridBackground)
+
+	// The "1" badge on the first cell, drawn at scale 2: its margin is the
+	// badge colour and the top of the 1's stem is white.
+	assertPixel(t, grid, gridGap, gridGap, gridBadge)
+	assertPixel(t, grid, gridGap+2, gridGap+2, gridBadge)
+	assertPixel(t, grid, gridGap+4, gridGap+2, gridLabel)
+	// The badge ends after one glyph and its margin.
+	assertPixel(t, grid, gridGap+10, gridGap+2, red)
+
+	// The "4" badge: its top-left and top-right glyph pixels are lit, the
+	// top middle isn't.
+	x4, y4 := gridGap+step, gridGap+step
+	assertPixel(t, grid, x4+2, y4+2, gridLabel)
+	assertPixel(t, grid, x4+4, y4+2, gridBadge)
+	assertPixel(t, grid, x4+6, y4+2, gridLabel)
+}
+
+func TestGrid_LeavesEmptyCellsBlank(t *testing.T) {
+	grid := Grid([]image.Image{solid(32, 32, red), solid(32, 32, green), solid(32, 32, blue)}, 0)
+
+	if b := grid.Bounds(); b.Dx() != 2*32+3*gridGap || b.Dy() != 2*32+3*gridGap {
+		t.Fatalf("Unexpected grid size %v", b)
+	}
+	assertPixel(t, grid, gridGap+32+gridGap+20, gridGap+32+gridGap+20, gridBackground)
+	assertPixel(t, grid, gridGap+20, gridGap+32+gridGap+20, blue)
+}
+
+func TestGrid_ShrinksLargeImages(t *testing.T) {
+	// A checkerboard averages to grey when halved.
+	checker := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := range 100 {
+		for x := range 200 {
+			if (x+y)%2 == 0 {
+				checker.SetRGBA(x, y, color.RGBA{0xff, 0xff, 0xff, 0xff})
+			} else {
+				checker.SetRGBA(x, y, color.RGBA{0, 0, 0, 0xff})
+			}
+		}
+	}
+
+	grid := Grid([]image.Image{checker, solid(200, 100, red)}, 100)
+	if b := grid.Bounds(); b.Dx() != 2*100+3*gridGap || b.Dy() != 50+2*gridGap {
+		t.Fatalf("Unexpected grid size %v", b)
+	}
+	assertPixel(t, grid, gridGap+60, gridGap+30, color.RGBA{0x7f, 0x7f, 0x7f, 0xff})
+	assertPixel(t, grid, gridGap+100+gridGap+60, gridGap+30, red)
+
+	if checker.RGBAAt(0, 0) != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Error("Expected the input left unchanged")
+	}
+}
+
+func TestGrid_Empty(t *testing.T) {
+	if b := Grid(nil, 64).Bounds(); !b.Empty() {
+		t.Errorf("Expected an empty grid, got %v", b)
+	}
+}
@@ -0,0 +1,156 @@
+package imagegen
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catalogTTL is how long the lists of models and samplers are cached.
+// They only change when files are added to the web UI, and autocomplete
+// asks for them on every keystroke.
+const catalogTTL = 5 * time.Minute
+
+// Model is a checkpoint the web UI can load. Title, e.g.
+// "sd_xl_base_1.0.safetensors [31e35c80fc]", is what /options takes.
+type Model struct {
+	Title     string `json:"title"`
+	ModelName string `json:"model_name"`
+	Hash      string `json:"hash"`
+}
+
+type Sampler struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+type cached[T any] struct {
+	mu      sync.Mutex
+	value   T
+	fetched time.Time
+}
+
+// get returns the cached value, or fetches it if it's older than
+// catalogTTL.
+func (c *cached[T]) get(ctx context.Context, fetch func(ctx context.Context) (T, error)) (T, error) {
+	c.mu.Lock()
+	if !c.fetched.IsZero() && time.Since(c.fetched) < catalogTTL {
+		value := c.value
+		c.mu.Unlock()
+		return value, nil
+	}
+	c.mu.Unlock()
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return value, err
+	}
+	c.mu.Lock()
+	c.value, c.fetched = value, time.Now()
+	c.mu.Unlock()
+	return value, nil
+}
+
+// Models lists the checkpoints the web UI has.
+func (c *Client) Models(ctx context.Context) ([]Model, error) {
+	return c.models.get(ctx, func(ctx context.Context) ([]Model, error) {
+		var models []Model
+		err := c.get(ctx, "/sdapi/v1/sd-models", &models)
+		return models, err
+	})
+}
+
+// Samplers lists the samplers the web UI has.
+func (c *Client) Samplers(ctx context.Context) ([]Sampler, error) {
+	return c.samplers.get(ctx, func(ctx context.Context) ([]Sampler, error) {
+		var samplers []Sampler
+		err := c.get(ctx, "/sdapi/v1/samplers", &samplers)
+		return samplers, err
+	})
+}
+
+func (c *Client) ResolveModel(ctx context.Context, name string) (string, error) {
+	return resolveModel(ctx, c, name)
+}
+
+func (c *Client) ResolveSampler(ctx context.Context, name string) (string, error) {
+	return resolveSampler(ctx, c, name)
+}
+
+// resolveModel finds the model called name, by its title, its file name
+// or its title without the hash, ignoring case, and returns its title.
+func resolveModel(ctx context.Context, b ImageBackend, name string) (string, error) {
+	models, err := b.Models(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list models: %w", err)
+	}
+	for _, m := range models {
+		bare, _, _ := strings.Cut(m.Title, " [")
+		if strings.EqualFold(name, m.Title) || strings.EqualFold(name, m.ModelName) || strings.EqualFold(name, bare) {
+			return m.Title, nil
+		}
+	}
+	return "", fmt.Errorf("there's no model called %q", name)
+}
+
+// resolveSampler finds the sampler called name, or with name as an alias,
+// ignoring case, and returns its name.
+func resolveSampler(ctx context.Context, b ImageBackend, name string) (string, error) {
+	samplers, err := b.Samplers(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list samplers: %w", err)
+	}
+	for _, s := range samplers {
+		if strings.EqualFold(name, s.Name) {
+			return s.Name, nil
+		}
+		for _, alias := range s.Aliases {
+			if strings.EqualFold(name, alias) {
+				return s.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("there's no sampler called %q", name)
+}
+
+// useCheckpoint loads want, unless it's what we last loaded. An empty want
+// takes whatever is loaded. It's only called from run, so one request at a
+// time sees c.loaded; a model changed in the web UI by hand is noticed at
+// the next switch.
+func (c *Client) useCheckpoint(ctx context.Context, want string) error {
+	if want == "" || want == c.loaded {
+		return nil
+	}
+	if err := c.switchCheckpoint(ctx, want); err != nil {
+		return err
+	}
+	c.loaded = want
+	return nil
+}
+
+type checkpointOptions struct {
+	SDModelCheckpoint string `json:"sd_model_checkpoint"`
+}
+
+// switchCheckpoint loads title through /options, unless it's already
+// loaded. The web UI answers once the model is in memory.
+func (c *Client) switchCheckpoint(ctx context.Context, title string) error {
+	var current checkpointOptions
+	if err := c.get(ctx, "/sdapi/v1/options", &current); err != nil {
+		return fmt.Errorf("failed to read current model: %w", err)
+	}
+	if current.SDModelCheckpoint == title {
+		return nil
+	}
+
+	slog.Info("Switching checkpoint", "from", current.SDModelCheckpoint, "to", title)
+	start := time.Now()
+	if err := c.post(ctx, "/sdapi/v1/options", checkpointOptions{SDModelCheckpoint: title}, nil); err != nil {
+		return fmt.Errorf("failed to switch model: %w", err)
+	}
+	slog.Info("Checkpoint loaded", "model", title, "duration", time.Since(start))
+	return nil
+}
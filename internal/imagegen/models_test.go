@@ -0,0 +1,256 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebUI is a stateful stand-in for the web UI's model endpoints. Each
+// txt2img call records the model that was loaded, and waits on hold if it
+// is set. With steps set, each progress poll reports one more step and a
+// new preview every other step.
+type fakeWebUI struct {
+	mu       sync.Mutex
+	model    string
+	requests map[string]int
+	events   []string
+	hold     chan struct{}
+	steps    int
+	polls    int
+}
+
+func newFakeWebUI(t *testing.T) (*fakeWebUI, *Client) {
+	t.Helper()
+	f := &fakeWebUI{model: "base.safetensors [aaa]", requests: map[string]int{}}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, NewClient(server.URL, 5*time.Second)
+}
+
+func (f *fakeWebUI) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[r.Method+" "+r.URL.Path]++
+	f.mu.Unlock()
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /sdapi/v1/sd-models":
+		json.NewEncoder(w).Encode([]Model{
+			{Title: "base.safetensors [aaa]", ModelName: "base", Hash: "aaa"},
+			{Title: "anime-v3.safetensors [bbb]", ModelName: "anime-v3", Hash: "bbb"},
+		})
+	case "GET /sdapi/v1/samplers":
+		json.NewEncoder(w).Encode([]Sampler{
+			{Name: "DPM++ 2M", Aliases: []string{"k_dpmpp_2m"}},
+			{Name: "Euler a", Aliases: []string{"k_euler_a"}},
+		})
+	case "GET /sdapi/v1/options":
+		f.mu.Lock()
+		json.NewEncoder(w).Encode(map[string]any{"sd_model_checkpoint": f.model, "samples_format": "png"})
+		f.mu.Unlock()
+	case "POST /sdapi/v1/options":
+		var opts checkpointOptions
+		json.NewDecoder(r.Body).Decode(&opts)
+		f.mu.Lock()
+		f.model = opts.SDModelCheckpoint
+		f.events = append(f.events, "switch "+opts.SDModelCheckpoint)
+		f.mu.Unlock()
+		w.Write([]byte("null"))
+	case "GET /sdapi/v1/progress":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.steps == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		f.polls++
+		step := min(f.polls, f.steps)
+		json.NewEncoder(w).Encode(map[string]any{
+			"progress":      float64(step) / float64(f.steps),
+			"eta_relative":  float64(f.steps - step),
+			"state":         map[string]any{"job_no": 0, "sampling_step": step, "sampling_steps": f.steps},
+			"current_image": EncodeImage([]byte(fmt.Sprintf("preview %d", step/2))),
+		})
+	case "POST /sdapi/v1/interrupt":
+		f.mu.Lock()
+		f.events = append(f.events, "interrupt")
+		f.mu.Unlock()
+		w.Write([]byte("{}"))
+	case "POST /sdapi/v1/txt2img":
+		var req GenerationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		f.events = append(f.events, "start "+req.Prompt+" on "+f.model)
+		hold := f.hold
+		f.mu.Unlock()
+		if hold != nil {
+			select {
+			case <-hold:
+			case <-r.Context().Done():
+				f.mu.Lock()
+				f.events = append(f.events, "abort "+req.Prompt)
+				f.mu.Unlock()
+				return
+			}
+		}
+		f.mu.Lock()
+		f.events = append(f.events, "end "+req.Prompt)
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"images": []string{EncodeImage([]byte("png"))}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeWebUI) count(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[key]
+}
+
+func (f *fakeWebUI) eventLog() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.events, "\n")
+}
+
+// waitFor polls until n txt2img requests have arrived.
+func (f *fakeWebUI) waitFor(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for f.count("POST /sdapi/v1/txt2img") < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d generations to start", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClient_CatalogIsCached(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"anime-v3", "ANIME-V3.safetensors", "anime-v3.safetensors [bbb]"} {
+		title, err := client.ResolveModel(ctx, name)
+		if err != nil || title != "anime-v3.safetensors [bbb]" {
+			t.Errorf("ResolveModel(%q) = %q, %v", name, title, err)
+		}
+	}
+	if _, err := client.ResolveModel(ctx, "missing"); err == nil || !strings.Contains(err.Error(), "no model") {
+		t.Errorf("Expected an unknown model error, got %v", err)
+	}
+
+	if name, err := client.ResolveSampler(ctx, "k_euler_a"); err != nil || name != "Euler a" {
+		t.Errorf("Expected the alias to resolve to Euler a, got %q, %v", name, err)
+	}
+	client.Samplers(ctx)
+
+	if n := f.count("GET /sdapi/v1/sd-models"); n != 1 {
+		t.Errorf("Expected models fetched once, got %d", n)
+	}
+	if n := f.count("GET /sdapi/v1/samplers"); n != 1 {
+		t.Errorf("Expected samplers fetched once, got %d", n)
+	}
+}
+
+func TestClient_GenerateSwitchesCheckpoint(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	ctx := context.Background()
+
+	// Already loaded: no switch.
+	if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "one", Checkpoint: "base.safetensors [aaa]"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "two", Checkpoint: "anime-v3.safetensors [bbb]"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	// The gate remembers the switch, so this doesn't ask again.
+	if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "three", Checkpoint: "anime-v3.safetensors [bbb]"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+
+	want := []string{
+		"start one on base.safetensors [aaa]", "end one",
+		"switch anime-v3.safetensors [bbb]",
+		"start two on anime-v3.safetensors [bbb]", "end two",
+		"start three on anime-v3.safetensors [bbb]", "end three",
+	}
+	if strings.Join(f.events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected events:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(f.events, "\n"))
+	}
+	if n := f.count("GET /sdapi/v1/options"); n != 2 {
+		t.Errorf("Expected the current model read twice, got %d", n)
+	}
+}
+
+func TestClient_CheckpointSwitchWaitsForGenerations(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	ctx := context.Background()
+	f.hold = make(chan struct{})
+
+	var wg sync.WaitGroup
+	generate := func(prompt, checkpoint string) {
+		defer wg.Done()
+		if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: prompt, Checkpoint: checkpoint}); err != nil {
+			t.Errorf("GenerateImage(%s) failed: %v", prompt, err)
+		}
+	}
+
+	wg.Add(1)
+	go generate("first", "base.safetensors [aaa]")
+	f.waitFor(t, 1)
+
+	wg.Add(1)
+	go generate("other", "anime-v3.safetensors [bbb]")
+	time.Sleep(50 * time.Millisecond)
+	wg.Add(1)
+	go generate("same", "")
+	time.Sleep(50 * time.Millisecond)
+
+	// The web UI does one thing at a time: even a generation on the same
+	// model waits, and so does the switch.
+	if n := f.count("POST /sdapi/v1/txt2img"); n != 1 {
+		t.Errorf("Expected the others to wait for the first, got %d running", n)
+	}
+	f.mu.Lock()
+	switched := f.model != "base.safetensors [aaa]"
+	f.mu.Unlock()
+	if switched {
+		t.Fatal("Expected no switch while a generation is running")
+	}
+
+	close(f.hold)
+	wg.Wait()
+
+	want := []string{
+		"start first on base.safetensors [aaa]", "end first",
+		"switch anime-v3.safetensors [bbb]",
+		"start other on anime-v3.safetensors [bbb]", "end other",
+		"start same on anime-v3.safetensors [bbb]", "end same",
+	}
+	if got := f.eventLog(); got != strings.Join(want, "\n") {
+		t.Errorf("Expected events:\n%s\ngot:\n%s", strings.Join(want, "\n"), got)
+	}
+}
+
+func TestClient_CheckpointWaitHonoursContext(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	f.hold = make(chan struct{})
+	defer close(f.hold)
+
+	go client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "slow"})
+	f.waitFor(t, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "other", Checkpoint: "anime-v3.safetensors [bbb]"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAIImageClient generates images with an OpenAI-compatible
+// /v1/images/generations endpoint, which LocalAI and several other local
+// servers expose. The API only takes a prompt, a size and a count, so
+// negative prompts, steps, samplers and seeds are ignored, and editing
+// and upscaling aren't supported.
+type OpenAIImageClient struct {
+	baseURL string
+	apiKey  string
+	model   string
+
+	httpClient *http.Client
+	// genClient has no timeout of its own; a generation gets timeout per
+	// image, since the API can't say how it's getting on.
+	genClient *http.Client
+	timeout   time.Duration
+
+	queue  queue
+	models cached[[]Model]
+}
+
+// NewOpenAIImageClient returns a client for the server at baseURL, the
+// part before /v1. model is used when a request doesn't pick one, and
+// apiKey, if set, is sent as a bearer token.
+func NewOpenAIImageClient(baseURL, apiKey, model string, timeout time.Duration) *OpenAIImageClient {
+	return &OpenAIImageClient{
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		model:      model,
+		httpClient: &http.Client{Timeout: timeout},
+		genClient:  &http.Client{},
+		timeout:    timeout,
+	}
+}
+
+type openAIImageRequest struct {
+	Model          string `json:"model,omitempty"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n"`
+	Size           string `json:"size"`
+	ResponseFormat string `json:"response_format"`
+}
+
+type openAIImageResponse struct {
+	Data []struct {
+		B64JSON string `json:"b64_json"`
+		URL     string `json:"url"`
+	} `json:"data"`
+}
+
+func (c *OpenAIImageClient) GenerateImage(ctx context.Context, req *GenerationRequest) (*GenerationResponse, error) {
+	req.applyDefaults()
+	model := req.Checkpoint
+	if model == "" {
+		model = c.model
+	}
+	batch := max(req.BatchSize, 1)
+
+	release, err := c.queue.acquire(ctx, priorityFrom(ctx), progressFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	slog.Info("Generating image",
+		"prompt", req.Prompt,
+		"width", req.Width,
+		"height", req.Height,
+		"backend", "openai",
+	)
+	genCtx, cancel := context.WithTimeout(ctx, time.Duration(batch)*c.timeout)
+	defer cancel()
+	var resp openAIImageResponse
+	err = doJSON(genCtx, c.genClient, http.MethodPost, c.baseURL+"/v1/images/generations", c.header(), openAIImageRequest{
+		Model:          model,
+		Prompt:         req.Prompt,
+		N:              batch,
+		Size:           fmt.Sprintf("%dx%d", req.Width, req.Height),
+		ResponseFormat: "b64_json",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some servers answer with a link whatever the format asked for.
+	var images [][]byte
+	for _, d := range resp.Data {
+		var data []byte
+		switch {
+		case d.B64JSON != "":
+			data, err = DecodeImage(d.B64JSON)
+		case strings.HasPrefix(d.URL, "/"):
+			data, err = fetch(genCtx, c.httpClient, c.baseURL+d.URL, c.header())
+		case d.URL != "":
+			// The key is only for our server, not wherever it stores images.
+			header := c.header()
+			if !strings.HasPrefix(d.URL, c.baseURL+"/") {
+				header = nil
+			}
+			data, err = fetch(genCtx, c.httpClient, d.URL, header)
+		default:
+			err = errors.New("image server returned an empty image")
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, data)
+	}
+	if len(images) == 0 {
+		return nil, errors.New("image server returned no images")
+	}
+
+	slog.Info("Image generated successfully", "num_images", len(images))
+	return newResponse(req, images, nil), nil
+}
+
+func (c *OpenAIImageClient) ImageToImage(ctx context.Context, req *Img2ImgRequest) (*GenerationResponse, error) {
+	return nil, fmt.Errorf("editing images is %w", ErrUnsupported)
+}
+
+func (c *OpenAIImageClient) Upscale(ctx context.Context, req *UpscaleRequest) (*UpscaleResponse, error) {
+	return nil, fmt.Errorf("upscaling is %w", ErrUnsupported)
+}
+
+// Models lists the server's models. They aren't all image models, but
+// the API doesn't say which are.
+func (c *OpenAIImageClient) Models(ctx context.Context) ([]Model, error) {
+	return c.models.get(ctx, func(ctx context.Context) ([]Model, error) {
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := doJSON(ctx, c.httpClient, http.MethodGet, c.baseURL+"/v1/models", c.header(), nil, &list); err != nil {
+			return nil, err
+		}
+		models := make([]Model, len(list.Data))
+		for n, m := range list.Data {
+			models[n] = Model{Title: m.ID, ModelName: m.ID}
+		}
+		return models, nil
+	})
+}
+
+// Samplers is empty: the API has no choice of sampler.
+func (c *OpenAIImageClient) Samplers(ctx context.Context) ([]Sampler, error) {
+	return nil, nil
+}
+
+func (c *OpenAIImageClient) ResolveModel(ctx context.Context, name string) (string, error) {
+	return resolveModel(ctx, c, name)
+}
+
+func (c *OpenAIImageClient) ResolveSampler(ctx context.Context, name string) (string, error) {
+	return resolveSampler(ctx, c, name)
+}
+
+func (c *OpenAIImageClient) HealthCheck(ctx context.Context) error {
+	if err := doJSON(ctx, c.httpClient, http.MethodGet, c.baseURL+"/v1/models", c.header(), nil, nil); err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	return nil
+}
+
+func (c *OpenAIImageClient) header() http.Header {
+	if c.apiKey == "" {
+		return nil
+	}
+	return http.Header{"Authorization": {"Bearer " + c.apiKey}}
+}
@@ -0,0 +1,99 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeOpenAIImages serves recorded responses from an OpenAI-compatible
+// server, with the recorded image links pointed at itself.
+func fakeOpenAIImages(t *testing.T, generations string) (*httptest.Server, *openAIImageRequest, *[]string) {
+	t.Helper()
+	var got openAIImageRequest
+	var auth []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.URL.Path+" "+r.Header.Get("Authorization"))
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/images/generations":
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(strings.ReplaceAll(string(fixture(t, generations)), "http://localhost:8080", server.URL)))
+		case "GET /generated-images/b641ae1e.png":
+			w.Write([]byte("linked"))
+		case "GET /v1/models":
+			w.Write(fixture(t, "openai/models.json"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &got, &auth
+}
+
+func TestOpenAIImageClient_GenerateImage(t *testing.T) {
+	server, got, auth := fakeOpenAIImages(t, "openai/generations_b64.json")
+	client := NewOpenAIImageClient(server.URL, "secret", "stablediffusion", 5*time.Second)
+
+	resp, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "a lighthouse", Width: 768, Height: 512, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	want := openAIImageRequest{Model: "stablediffusion", Prompt: "a lighthouse", N: 2, Size: "768x512", ResponseFormat: "b64_json"}
+	if *got != want {
+		t.Errorf("Expected request %+v, got %+v", want, *got)
+	}
+	if (*auth)[0] != "/v1/images/generations Bearer secret" {
+		t.Errorf("Expected the API key sent, got %v", *auth)
+	}
+
+	if len(resp.Images) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(resp.Images))
+	}
+	if data, _ := DecodeImage(resp.Images[1]); string(data) != "second" {
+		t.Errorf("Expected the second image, got %q", data)
+	}
+	if r := resp.Replay(0); r.Prompt != "a lighthouse" || r.Width != 768 || r.Seed != 0 {
+		t.Errorf("Expected the request back with no seed, got %+v", r)
+	}
+}
+
+func TestOpenAIImageClient_DownloadsLinks(t *testing.T) {
+	server, got, _ := fakeOpenAIImages(t, "openai/generations_url.json")
+	client := NewOpenAIImageClient(server.URL, "", "", 5*time.Second)
+
+	resp, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "a fox", Checkpoint: "flux.1-schnell"})
+	if err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	if got.Model != "flux.1-schnell" || got.Size != "1024x1024" {
+		t.Errorf("Expected the picked model at the default size, got %+v", *got)
+	}
+	if data, _ := DecodeImage(resp.Images[0]); string(data) != "linked" {
+		t.Errorf("Expected the linked image, got %q", data)
+	}
+}
+
+func TestOpenAIImageClient_Catalog(t *testing.T) {
+	server, _, _ := fakeOpenAIImages(t, "")
+	client := NewOpenAIImageClient(server.URL, "", "", 5*time.Second)
+	ctx := context.Background()
+
+	if name, err := client.ResolveModel(ctx, "FLUX.1-schnell"); err != nil || name != "flux.1-schnell" {
+		t.Errorf("ResolveModel = %q, %v", name, err)
+	}
+	if _, err := client.ResolveSampler(ctx, "Euler a"); err == nil {
+		t.Error("Expected no samplers to pick from")
+	}
+	if err := client.HealthCheck(ctx); err != nil {
+		t.Errorf("HealthCheck failed: %v", err)
+	}
+	if _, err := client.ImageToImage(ctx, &Img2ImgRequest{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected editing to be unsupported, got %v", err)
+	}
+}
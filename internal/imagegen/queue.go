@@ -0,0 +1,290 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priority orders requests waiting for the web UI. Interactive requests,
+// like /imagine, go ahead of background ones, like /pdf illustrations;
+// requests of the same priority run in the order they came.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota
+	PriorityBackground
+)
+
+// Progress is where a request is: its place in line while it waits, then
+// how far the web UI has got.
+type Progress struct {
+	// QueuePosition is 1 for the next request to run, or 0 once running.
+	QueuePosition int
+
+	Fraction    float64
+	Step, Steps int
+	ETA         time.Duration
+
+	// Preview is the web UI's live preview of the image so far, set only
+	// when it has changed since the last report.
+	Preview []byte
+}
+
+// Describe says where the request is, for a job's stage: what it's doing
+// then the place in line or how far it's got.
+func (p Progress) Describe(what string) string {
+	switch {
+	case p.QueuePosition > 0:
+		return fmt.Sprintf("%s: waiting for Stable Diffusion, position %d in line", what, p.QueuePosition)
+	case p.Steps > 0 && p.ETA > 0:
+		return fmt.Sprintf("%s: %d%%, step %d/%d, about %s left", what, int(p.Fraction*100), p.Step, p.Steps, p.ETA.Round(time.Second))
+	case p.Steps > 0:
+		return fmt.Sprintf("%s: %d%%, step %d/%d", what, int(p.Fraction*100), p.Step, p.Steps)
+	case p.Fraction > 0:
+		return fmt.Sprintf("%s: %d%%", what, int(p.Fraction*100))
+	}
+	return what
+}
+
+// pollInterval is how often a running request's progress is read.
+const pollInterval = time.Second
+
+// interruptTimeout bounds stopping an abandoned request, so a web UI that
+// hangs doesn't hold the queue forever.
+const interruptTimeout = 10 * time.Second
+
+var errStalled = errors.New("the image server stopped making progress")
+
+type priorityKey struct{}
+type progressKey struct{}
+
+// WithPriority returns a context whose requests wait in line at priority
+// p. Requests default to PriorityInteractive.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// WithProgress returns a context whose requests call report with their
+// place in line and, once running, their progress. Reports of the place
+// in line may come from other goroutines and must not block.
+func WithProgress(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+func progressFrom(ctx context.Context) func(Progress) {
+	report, _ := ctx.Value(progressKey{}).(func(Progress))
+	if report == nil {
+		return func(Progress) {}
+	}
+	return report
+}
+
+// queue lets one request at a time use the web UI, which only works on
+// one image at a time anyway; concurrent requests just slow each other
+// down until they time out.
+type queue struct {
+	mu      sync.Mutex
+	busy    bool
+	waiting []*ticket
+	nextSeq uint64
+}
+
+type ticket struct {
+	priority Priority
+	seq      uint64
+	report   func(Progress)
+	ready    chan struct{}
+}
+
+// acquire waits for the web UI, reporting the place in line as it
+// changes. The returned release must be called when the request is done.
+func (q *queue) acquire(ctx context.Context, priority Priority, report func(Progress)) (func(), error) {
+	q.mu.Lock()
+	if !q.busy && len(q.waiting) == 0 {
+		q.busy = true
+		q.mu.Unlock()
+		return sync.OnceFunc(q.release), nil
+	}
+
+	q.nextSeq++
+	t := &ticket{priority: priority, seq: q.nextSeq, report: report, ready: make(chan struct{})}
+	q.waiting = append(q.waiting, t)
+	sort.SliceStable(q.waiting, func(i, j int) bool {
+		a, b := q.waiting[i], q.waiting[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		return a.seq < b.seq
+	})
+	q.reportPositionsLocked()
+	q.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return sync.OnceFunc(q.release), nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-t.ready:
+			// The web UI was handed over just as we gave up; pass it on.
+			q.releaseLocked()
+		default:
+			for i, other := range q.waiting {
+				if other == t {
+					q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+					break
+				}
+			}
+			q.reportPositionsLocked()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (q *queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+// releaseLocked hands the web UI to the first in line, or frees it.
+func (q *queue) releaseLocked() {
+	if len(q.waiting) == 0 {
+		q.busy = false
+		return
+	}
+	next := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(next.ready)
+	q.reportPositionsLocked()
+}
+
+func (q *queue) reportPositionsLocked() {
+	for i, t := range q.waiting {
+		t.report(Progress{QueuePosition: i + 1})
+	}
+}
+
+// run waits its turn at the web UI, loads checkpoint and calls fn. While
+// fn runs its progress is polled and reported, and if it stops moving for
+// the client's timeout the request is stopped. A request that's given up
+// on, by its caller or for stalling, is interrupted in the web UI before
+// the next one starts.
+func (c *Client) run(ctx context.Context, checkpoint string, fn func(ctx context.Context) error) error {
+	report := progressFrom(ctx)
+	release, err := c.queue.acquire(ctx, priorityFrom(ctx), report)
+	if err != nil {
+		return err
+	}
+
+	if err := c.useCheckpoint(ctx, checkpoint); err != nil {
+		release()
+		return err
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		c.watch(runCtx, cancel, report)
+	}()
+
+	err = fn(runCtx)
+	cancel(nil)
+	<-watched
+
+	stalled := errors.Is(context.Cause(runCtx), errStalled)
+	if err == nil || (!stalled && ctx.Err() == nil) {
+		release()
+		return err
+	}
+
+	// The web UI carries on with a request whose connection is gone, so
+	// stop it; the caller needn't wait for that.
+	go func() {
+		defer release()
+		ctx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+		defer cancel()
+		if err := c.post(ctx, "/sdapi/v1/interrupt", struct{}{}, nil); err != nil {
+			slog.Warn("Failed to interrupt image generation", "error", err)
+		}
+	}()
+	if stalled {
+		return fmt.Errorf("%w for %s", errStalled, c.timeout)
+	}
+	return err
+}
+
+// progressResponse is the part of /sdapi/v1/progress we use.
+type progressResponse struct {
+	Progress    float64 `json:"progress"`
+	ETARelative float64 `json:"eta_relative"`
+	State       struct {
+		JobNo         int `json:"job_no"`
+		SamplingStep  int `json:"sampling_step"`
+		SamplingSteps int `json:"sampling_steps"`
+	} `json:"state"`
+	CurrentImage string `json:"current_image"`
+}
+
+func (p progressResponse) moved(since progressResponse) bool {
+	return p.Progress != since.Progress || p.State.JobNo != since.State.JobNo || p.State.SamplingStep != since.State.SamplingStep
+}
+
+// watch polls the running request's progress until ctx is done, and
+// cancels it if nothing has moved for the client's timeout. If the web UI
+// can't report progress the timeout runs from the start.
+func (c *Client) watch(ctx context.Context, cancel context.CancelCauseFunc, report func(Progress)) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	var last progressResponse
+	lastMoved := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var p progressResponse
+		if err := c.get(ctx, "/sdapi/v1/progress", &p); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Debug("Failed to read image progress", "error", err)
+		} else if p.moved(last) {
+			lastMoved = time.Now()
+			progress := Progress{
+				Fraction: p.Progress,
+				Step:     p.State.SamplingStep,
+				Steps:    p.State.SamplingSteps,
+				ETA:      time.Duration(p.ETARelative * float64(time.Second)),
+			}
+			if p.CurrentImage != "" && p.CurrentImage != last.CurrentImage {
+				if preview, err := base64.StdEncoding.DecodeString(p.CurrentImage); err == nil {
+					progress.Preview = preview
+				}
+			}
+			last = p
+			report(progress)
+		}
+
+		if time.Since(lastMoved) > c.timeout {
+			cancel(errStalled)
+			return
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package imagegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_InteractiveRequestsGoFirst(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	f.hold = make(chan struct{})
+
+	var (
+		mu        sync.Mutex
+		positions = map[string][]int{}
+		wg        sync.WaitGroup
+	)
+	generate := func(prompt string, priority Priority) {
+		defer wg.Done()
+		ctx := WithPriority(context.Background(), priority)
+		ctx = WithProgress(ctx, func(p Progress) {
+			if p.QueuePosition > 0 {
+				mu.Lock()
+				positions[prompt] = append(positions[prompt], p.QueuePosition)
+				mu.Unlock()
+			}
+		})
+		if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: prompt}); err != nil {
+			t.Errorf("GenerateImage(%s) failed: %v", prompt, err)
+		}
+	}
+	queued := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			client.queue.mu.Lock()
+			waiting := len(client.queue.waiting)
+			client.queue.mu.Unlock()
+			if waiting == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d requests in line, got %d", n, waiting)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	wg.Add(1)
+	go generate("running", PriorityInteractive)
+	f.waitFor(t, 1)
+
+	wg.Add(3)
+	go generate("pdf-1", PriorityBackground)
+	queued(1)
+	go generate("pdf-2", PriorityBackground)
+	queued(2)
+	go generate("imagine", PriorityInteractive)
+	queued(3)
+
+	close(f.hold)
+	wg.Wait()
+
+	var order []string
+	for _, event := range strings.Split(f.eventLog(), "\n") {
+		if prompt, ok := strings.CutPrefix(event, "start "); ok {
+			order = append(order, strings.Fields(prompt)[0])
+		}
+	}
+	if got := strings.Join(order, ","); got != "running,imagine,pdf-1,pdf-2" {
+		t.Errorf("Expected the interactive request to jump the background ones, got %s", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]string{
+		"pdf-1":   "[1 1 2 1]",
+		"pdf-2":   "[2 3 2 1]",
+		"imagine": "[1]",
+	}
+	for prompt, w := range want {
+		if got := fmt.Sprint(positions[prompt]); got != w {
+			t.Errorf("Expected %s to be told positions %s, got %s", prompt, w, got)
+		}
+	}
+}
+
+func TestClient_ReportsProgressAndPreviews(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	client.pollInterval = 5 * time.Millisecond
+	f.steps = 6
+	f.hold = make(chan struct{})
+
+	var (
+		reports  []Progress
+		previews []string
+		finished sync.Once
+	)
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+		if p.Preview != nil {
+			previews = append(previews, string(p.Preview))
+		}
+		if p.Step == 6 {
+			finished.Do(func() { close(f.hold) })
+		}
+	})
+	if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "slow"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+
+	if len(reports) != 6 {
+		t.Fatalf("Expected a report per step, got %+v", reports)
+	}
+	last := reports[len(reports)-1]
+	if last.Step != 6 || last.Steps != 6 || last.Fraction != 1 || last.ETA != 0 {
+		t.Errorf("Unexpected last report %+v", last)
+	}
+	if reports[0].ETA != 5*time.Second || reports[0].QueuePosition != 0 {
+		t.Errorf("Unexpected first report %+v", reports[0])
+	}
+	// The preview changes every other step, and is only sent when it does.
+	if got := strings.Join(previews, ","); got != "preview 0,preview 1,preview 2,preview 3" {
+		t.Errorf("Unexpected previews %s", got)
+	}
+}
+
+func TestClient_StalledGenerationIsInterrupted(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	client.timeout = 50 * time.Millisecond
+	client.pollInterval = 5 * time.Millisecond
+	f.hold = make(chan struct{})
+
+	_, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "stuck"})
+	if !errors.Is(err, errStalled) {
+		t.Fatalf("Expected a stall, got %v", err)
+	}
+
+	// The next request runs once the stuck one has been interrupted.
+	f.mu.Lock()
+	f.hold = nil
+	f.mu.Unlock()
+	if _, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "next"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	got := f.eventLog()
+	if interrupt := strings.Index(got, "interrupt"); interrupt < 0 || interrupt > strings.Index(got, "start next") {
+		t.Errorf("Expected an interrupt before the next generation, got:\n%s", got)
+	}
+}
+
+func TestClient_CancelledGenerationIsInterrupted(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	f.hold = make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for f.count("POST /sdapi/v1/txt2img") < 1 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "unwanted"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+
+	// Waiting in line behind the interrupt, then running.
+	f.mu.Lock()
+	f.hold = nil
+	f.mu.Unlock()
+	if _, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "next"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	if n := f.count("POST /sdapi/v1/interrupt"); n != 1 {
+		t.Errorf("Expected one interrupt, got %d", n)
+	}
+}
+
+func TestClient_CancelWhileQueued(t *testing.T) {
+	f, client := newFakeWebUI(t)
+	f.hold = make(chan struct{})
+
+	go client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "running"})
+	f.waitFor(t, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GenerateImage(ctx, &GenerationRequest{Prompt: "impatient"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the wait to end with the context, got %v", err)
+	}
+	close(f.hold)
+
+	if _, err := client.GenerateImage(context.Background(), &GenerationRequest{Prompt: "next"}); err != nil {
+		t.Fatalf("GenerateImage failed: %v", err)
+	}
+	if strings.Contains(f.eventLog(), "impatient") || f.count("POST /sdapi/v1/interrupt") != 0 {
+		t.Errorf("Expected the cancelled request to leave no trace, got:\n%s", f.eventLog())
+	}
+}
+
+func TestProgress_Describe(t *testing.T) {
+	tests := []struct {
+		progress Progress
+		want     string
+	}{
+		{Progress{QueuePosition: 2}, "Generating image: waiting for Stable Diffusion, position 2 in line"},
+		{Progress{Fraction: 0.456, Step: 14, Steps: 30, ETA: 12400 * time.Millisecond}, "Generating image: 45%, step 14/30, about 12s left"},
+		{Progress{Fraction: 1, Step: 30, Steps: 30}, "Generating image: 100%, step 30/30"},
+		{Progress{Fraction: 0.5}, "Generating image: 50%"},
+		{Progress{}, "Generating image"},
+	}
+	for _, tt := range tests {
+		if got := tt.progress.Describe("Generating image"); got != tt.want {
+			t.Errorf("Describe(%+v) = %q, want %q", tt.progress, got, tt.want)
+		}
+	}
+}
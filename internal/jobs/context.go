@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"context"
+)
+
+type jobKey struct{}
+
+// FromContext returns the job running ctx, or nil outside of a job.
+func FromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobKey{}).(*Job)
+	return job
+}
+
+// SetStage records a human-readable description of what the job in ctx is
+// doing. It is a no-op outside of a job.
+func SetStage(ctx context.Context, stage string) {
+	job := FromContext(ctx)
+	if job == nil {
+		return
+	}
+	job.manager.mu.Lock()
+	job.stage = stage
+	job.manager.mu.Unlock()
+}
+
+// Acquire waits for a worker slot on backend and sets the job's stage.
+// The returned release func must be called when the work is done. Outside
+// of a job, or for a backend without a pool, it returns immediately.
+func Acquire(ctx context.Context, backend Backend, stage string) (release func(), err error) {
+	job := FromContext(ctx)
+	if job == nil {
+		return func() {}, nil
+	}
+	release, err = job.manager.acquire(ctx, job, backend)
+	if err != nil {
+		return nil, err
+	}
+	SetStage(ctx, stage)
+	return release, nil
+}
+
+func (m *Manager) acquire(ctx context.Context, job *Job, backend Backend) (func(), error) {
+	m.mu.Lock()
+	p, ok := m.pools[backend]
+	if !ok {
+		m.mu.Unlock()
+		return func() {}, nil
+	}
+
+	if p.active < p.slots && len(p.waiters) == 0 {
+		p.active++
+		m.mu.Unlock()
+		return m.releaseFunc(p), nil
+	}
+
+	w := &waiter{job: job, ready: make(chan struct{})}
+	p.waiters = append(p.waiters, w)
+	job.state = StateQueued
+	job.waitingOn = p
+	job.waiter = w
+	m.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return m.releaseFunc(p), nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		select {
+		case <-w.ready:
+			// The slot was handed over just as we gave up; pass it on.
+			m.releaseLocked(p)
+		default:
+			for i, other := range p.waiters {
+				if other == w {
+					p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		job.waitingOn = nil
+		job.waiter = nil
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Manager) releaseFunc(p *pool) func() {
+	var released bool
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if released {
+			return
+		}
+		released = true
+		m.releaseLocked(p)
+	}
+}
+
+// releaseLocked hands the slot to the next waiter, or frees it.
+func (m *Manager) releaseLocked(p *pool) {
+	if len(p.waiters) == 0 {
+		p.active--
+		return
+	}
+	next := p.waiters[0]
+	p.waiters = p.waiters[1:]
+	next.job.state = StateRunning
+	next.job.waitingOn = nil
+	next.job.waiter = nil
+	close(next.ready)
+}
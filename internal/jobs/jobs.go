@@ -0,0 +1,358 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backend identifies a shared resource that long-running work competes
+// for. Each backend has its own bounded worker pool.
+type Backend string
+
+const (
+	BackendLLM      Backend = "llm"
+	BackendChromium Backend = "chromium"
+)
+
+var (
+	ErrTooManyJobs  = errors.New("too many jobs running")
+	ErrShuttingDown = errors.New("bot is shutting down")
+	ErrNotFound     = errors.New("job not found")
+
+	// errCancelled and errShutdown are the cancellation causes recorded on
+	// a job's context so the final state can tell them apart.
+	errCancelled = errors.New("job cancelled by user")
+	errShutdown  = errors.New("job interrupted by shutdown")
+)
+
+type State string
+
+const (
+	StateQueued      State = "queued"
+	StateRunning     State = "running"
+	StateDone        State = "done"
+	StateFailed      State = "failed"
+	StateCancelled   State = "cancelled"
+	StateInterrupted State = "interrupted"
+)
+
+// Status is a point-in-time snapshot of a job, passed to progress
+// callbacks and returned by List.
+type Status struct {
+	ID            string
+	UserID        string
+	Command       string
+	Description   string
+	State         State
+	Stage         string
+	WaitingFor    Backend
+	QueuePosition int
+	Elapsed       time.Duration
+	Err           error
+}
+
+// Spec describes a job being submitted.
+type Spec struct {
+	UserID      string
+	GuildID     string
+	Command     string
+	Description string
+
+	// Progress is called periodically while the job runs, and once more
+	// if the job ends with an error, cancellation or interruption.
+	// Successful jobs are expected to post their own result.
+	Progress func(Status)
+}
+
+type Limits struct {
+	Workers          map[Backend]int
+	PerUser          int
+	ProgressInterval time.Duration
+}
+
+type Manager struct {
+	limits Limits
+
+	mu       sync.Mutex
+	pools    map[Backend]*pool
+	jobs     map[string]*Job
+	nextID   int
+	draining bool
+	wg       sync.WaitGroup
+}
+
+func NewManager(limits Limits) *Manager {
+	pools := make(map[Backend]*pool)
+	for backend, workers := range limits.Workers {
+		pools[backend] = &pool{backend: backend, slots: max(workers, 1)}
+	}
+	return &Manager{
+		limits: limits,
+		pools:  pools,
+		jobs:   make(map[string]*Job),
+	}
+}
+
+type Job struct {
+	manager *Manager
+	spec    Spec
+	id      string
+	created time.Time
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+
+	// Guarded by manager.mu.
+	state     State
+	stage     string
+	waitingOn *pool
+	waiter    *waiter
+	err       error
+}
+
+func (j *Job) ID() string {
+	return j.id
+}
+
+type pool struct {
+	backend Backend
+	slots   int
+	active  int
+	waiters []*waiter
+}
+
+type waiter struct {
+	job   *Job
+	ready chan struct{}
+}
+
+// Submit starts fn in the background with a context that carries the job,
+// so Acquire and SetStage calls further down the stack report against it.
+// The job is cancelled when parent is cancelled.
+func (m *Manager) Submit(parent context.Context, spec Spec, fn func(ctx context.Context) error) (*Job, error) {
+	m.mu.Lock()
+	if m.draining {
+		m.mu.Unlock()
+		return nil, ErrShuttingDown
+	}
+	if m.limits.PerUser > 0 && m.activeForUserLocked(spec.UserID) >= m.limits.PerUser {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: you can run %d at a time", ErrTooManyJobs, m.limits.PerUser)
+	}
+
+	m.nextID++
+	ctx, cancel := context.WithCancelCause(parent)
+	job := &Job{
+		manager: m,
+		spec:    spec,
+		id:      strconv.Itoa(m.nextID),
+		created: time.Now(),
+		cancel:  cancel,
+		state:   StateRunning,
+	}
+	job.ctx = context.WithValue(ctx, jobKey{}, job)
+	m.jobs[job.id] = job
+	m.wg.Add(1)
+	m.mu.Unlock()
+
+	slog.Info("Job submitted", "id", job.id, "command", spec.Command, "user_id", spec.UserID)
+
+	go m.run(job, fn)
+	return job, nil
+}
+
+func (m *Manager) activeForUserLocked(userID string) int {
+	count := 0
+	for _, job := range m.jobs {
+		if job.spec.UserID == userID {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *Manager) run(job *Job, fn func(ctx context.Context) error) {
+	defer m.wg.Done()
+
+	stopReporting := m.startReporting(job)
+	err := fn(job.ctx)
+	stopReporting()
+
+	m.mu.Lock()
+	switch cause := context.Cause(job.ctx); {
+	case err == nil:
+		job.state = StateDone
+	case errors.Is(cause, errCancelled):
+		job.state = StateCancelled
+	case errors.Is(cause, errShutdown):
+		job.state = StateInterrupted
+	default:
+		job.state = StateFailed
+	}
+	job.err = err
+	job.stage = ""
+	delete(m.jobs, job.id)
+	final := m.statusLocked(job)
+	m.mu.Unlock()
+
+	job.cancel(nil)
+
+	slog.Info("Job finished", "id", job.id, "command", job.spec.Command, "state", final.State, "elapsed", final.Elapsed.Round(time.Millisecond), "error", err)
+
+	if err != nil && job.spec.Progress != nil {
+		job.spec.Progress(final)
+	}
+}
+
+// startReporting calls the job's Progress callback once immediately and
+// then on every interval tick until the job returns.
+func (m *Manager) startReporting(job *Job) func() {
+	if job.spec.Progress == nil {
+		return func() {}
+	}
+
+	interval := m.limits.ProgressInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		report := func() {
+			job.spec.Progress(m.Status(job))
+		}
+
+		report()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Status returns a snapshot of job.
+func (m *Manager) Status(job *Job) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.statusLocked(job)
+}
+
+func (m *Manager) statusLocked(job *Job) Status {
+	status := Status{
+		ID:          job.id,
+		UserID:      job.spec.UserID,
+		Command:     job.spec.Command,
+		Description: job.spec.Description,
+		State:       job.state,
+		Stage:       job.stage,
+		Elapsed:     time.Since(job.created),
+		Err:         job.err,
+	}
+	if job.waitingOn != nil {
+		status.WaitingFor = job.waitingOn.backend
+		for i, w := range job.waitingOn.waiters {
+			if w == job.waiter {
+				status.QueuePosition = i + 1
+				break
+			}
+		}
+	}
+	return status
+}
+
+// List returns the active jobs for userID, oldest first.
+func (m *Manager) List(userID string) []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var statuses []Status
+	for _, job := range m.jobs {
+		if job.spec.UserID == userID {
+			statuses = append(statuses, m.statusLocked(job))
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Elapsed > statuses[j].Elapsed
+	})
+	return statuses
+}
+
+// Cancel cancels an active job owned by userID.
+func (m *Manager) Cancel(userID, jobID string) error {
+	m.mu.Lock()
+	job, ok := m.jobs[jobID]
+	m.mu.Unlock()
+
+	if !ok || job.spec.UserID != userID {
+		return ErrNotFound
+	}
+	job.cancel(errCancelled)
+	slog.Info("Job cancelled", "id", jobID, "user_id", userID)
+	return nil
+}
+
+// Active returns the number of jobs that haven't finished.
+func (m *Manager) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.jobs)
+}
+
+// Shutdown stops accepting jobs and waits for running ones until ctx is
+// done. Jobs still running then are cancelled as interrupted and given
+// unwind time to report it. It returns how many jobs were interrupted.
+func (m *Manager) Shutdown(ctx context.Context, unwind time.Duration) int {
+	m.mu.Lock()
+	m.draining = true
+	m.mu.Unlock()
+
+	if waitTimeout(&m.wg, ctx) {
+		return 0
+	}
+
+	m.mu.Lock()
+	interrupted := len(m.jobs)
+	for _, job := range m.jobs {
+		job.cancel(errShutdown)
+	}
+	m.mu.Unlock()
+
+	unwindCtx, cancel := context.WithTimeout(context.Background(), unwind)
+	defer cancel()
+	waitTimeout(&m.wg, unwindCtx)
+
+	return interrupted
+}
+
+func waitTimeout(wg *sync.WaitGroup, ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
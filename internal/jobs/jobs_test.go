@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	statuses []Status
+}
+
+func (r *recorder) progress(s Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, s)
+}
+
+func (r *recorder) last() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.statuses) == 0 {
+		return Status{}
+	}
+	return r.statuses[len(r.statuses)-1]
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManager_PoolQueuesBeyondWorkerLimit(t *testing.T) {
+	m := NewManager(Limits{Workers: map[Backend]int{BackendChromium: 1}})
+
+	release := make(chan struct{})
+	work := func(ctx context.Context) error {
+		done, err := Acquire(ctx, BackendChromium, "Generating image")
+		if err != nil {
+			return err
+		}
+		defer done()
+		<-release
+		return nil
+	}
+
+	first, err := m.Submit(context.Background(), Spec{UserID: "a", Command: "imagine"}, work)
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	waitFor(t, func() bool { return m.Status(first).Stage == "Generating image" })
+
+	second, err := m.Submit(context.Background(), Spec{UserID: "b", Command: "imagine"}, work)
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	waitFor(t, func() bool { return m.Status(second).State == StateQueued })
+
+	status := m.Status(second)
+	if status.WaitingFor != BackendChromium || status.QueuePosition != 1 {
+		t.Errorf("Expected second job queued at position 1 for sd, got %s position %d", status.WaitingFor, status.QueuePosition)
+	}
+
+	close(release)
+	waitFor(t, func() bool { return m.Active() == 0 })
+}
+
+func TestManager_PerUserLimit(t *testing.T) {
+	m := NewManager(Limits{PerUser: 1})
+
+	block := make(chan struct{})
+	defer close(block)
+
+	_, err := m.Submit(context.Background(), Spec{UserID: "a"}, func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	_, err = m.Submit(context.Background(), Spec{UserID: "a"}, func(ctx context.Context) error { return nil })
+	if !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("Expected ErrTooManyJobs, got %v", err)
+	}
+
+	_, err = m.Submit(context.Background(), Spec{UserID: "b"}, func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Errorf("Expected other users to be unaffected, got %v", err)
+	}
+}
+
+func TestManager_CancelReportsCancelled(t *testing.T) {
+	m := NewManager(Limits{Workers: map[Backend]int{BackendLLM: 1}})
+	rec := &recorder{}
+
+	job, err := m.Submit(context.Background(), Spec{UserID: "a", Progress: rec.progress}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if err := m.Cancel("someone-else", job.ID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected other users not to cancel the job, got %v", err)
+	}
+	if err := m.Cancel("a", job.ID()); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+
+	waitFor(t, func() bool { return rec.last().State == StateCancelled })
+	if len(m.List("a")) != 0 {
+		t.Error("Expected cancelled job to be removed from the list")
+	}
+}
+
+func TestManager_CancelWhileQueuedFreesPosition(t *testing.T) {
+	m := NewManager(Limits{Workers: map[Backend]int{BackendLLM: 1}})
+
+	release := make(chan struct{})
+	work := func(ctx context.Context) error {
+		done, err := Acquire(ctx, BackendLLM, "Thinking")
+		if err != nil {
+			return err
+		}
+		defer done()
+		<-release
+		return nil
+	}
+
+	first, _ := m.Submit(context.Background(), Spec{UserID: "a"}, work)
+	waitFor(t, func() bool { return m.Status(first).Stage == "Thinking" })
+	second, _ := m.Submit(context.Background(), Spec{UserID: "b"}, work)
+	waitFor(t, func() bool { return m.Status(second).QueuePosition == 1 })
+	third, _ := m.Submit(context.Background(), Spec{UserID: "c"}, work)
+	waitFor(t, func() bool { return m.Status(third).QueuePosition == 2 })
+
+	m.Cancel("b", second.ID())
+	waitFor(t, func() bool { return m.Status(third).QueuePosition == 1 })
+
+	close(release)
+	waitFor(t, func() bool { return m.Active() == 0 })
+}
+
+func TestManager_ShutdownInterruptsAfterGracePeriod(t *testing.T) {
+	m := NewManager(Limits{})
+	rec := &recorder{}
+
+	_, err := m.Submit(context.Background(), Spec{UserID: "a", Progress: rec.progress}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if interrupted := m.Shutdown(ctx, time.Second); interrupted != 1 {
+		t.Errorf("Expected 1 interrupted job, got %d", interrupted)
+	}
+	if rec.last().State != StateInterrupted {
+		t.Errorf("Expected final state interrupted, got %s", rec.last().State)
+	}
+
+	_, err = m.Submit(context.Background(), Spec{UserID: "a"}, func(ctx context.Context) error { return nil })
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown after shutdown, got %v", err)
+	}
+}
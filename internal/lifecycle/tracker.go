@@ -0,0 +1,111 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Tracker records interactions that are being handled so shutdown can
+// refuse new work and wait for running work to finish.
+type Tracker struct {
+	mu       sync.Mutex
+	draining bool
+	nextID   uint64
+	active   map[uint64]*Entry
+	idle     chan struct{}
+}
+
+type Entry struct {
+	Command     string
+	Interaction *discordgo.Interaction
+	// Message is set instead of Interaction for work started by a
+	// message, such as a reply continuing an /ai conversation.
+	Message *discordgo.Message
+	Started time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		active: make(map[uint64]*Entry),
+	}
+}
+
+// Begin registers a running command. It returns ok=false once Drain has
+// been called; otherwise done must be called when the command finishes.
+func (t *Tracker) Begin(command string, i *discordgo.Interaction) (done func(), ok bool) {
+	return t.begin(&Entry{Command: command, Interaction: i})
+}
+
+// BeginMessage registers running work started by a message, as Begin
+// does for an interaction.
+func (t *Tracker) BeginMessage(command string, m *discordgo.Message) (done func(), ok bool) {
+	return t.begin(&Entry{Command: command, Message: m})
+}
+
+func (t *Tracker) begin(entry *Entry) (done func(), ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return nil, false
+	}
+
+	t.nextID++
+	id := t.nextID
+	entry.Started = time.Now()
+	t.active[id] = entry
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { t.finish(id) })
+	}, true
+}
+
+func (t *Tracker) finish(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.active, id)
+	if len(t.active) == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// Active returns the number of commands currently running.
+func (t *Tracker) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.active)
+}
+
+// Drain stops accepting new commands and waits until every running command
+// has finished or ctx is done. It returns the commands still running.
+func (t *Tracker) Drain(ctx context.Context) []*Entry {
+	t.mu.Lock()
+	t.draining = true
+	if len(t.active) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	idle := make(chan struct{})
+	t.idle = idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	remaining := make([]*Entry, 0, len(t.active))
+	for _, entry := range t.active {
+		remaining = append(remaining, entry)
+	}
+	return remaining
+}
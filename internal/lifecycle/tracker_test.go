@@ -0,0 +1,72 @@
+package lifecycle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestTracker_DrainWaitsForRunningCommands(t *testing.T) {
+	tracker := NewTracker()
+
+	done, ok := tracker.Begin("pdf", &discordgo.Interaction{ID: "1"})
+	if !ok {
+		t.Fatal("Expected Begin to succeed before drain")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if remaining := tracker.Drain(ctx); len(remaining) != 0 {
+		t.Errorf("Expected no remaining commands, got %d", len(remaining))
+	}
+
+	if _, ok := tracker.Begin("ping", &discordgo.Interaction{ID: "2"}); ok {
+		t.Error("Expected Begin to be refused while draining")
+	}
+}
+
+func TestTracker_DrainReturnsUnfinishedAfterGracePeriod(t *testing.T) {
+	tracker := NewTracker()
+
+	finished, _ := tracker.Begin("stock", &discordgo.Interaction{ID: "1"})
+	_, _ = tracker.Begin("imagine", &discordgo.Interaction{ID: "2"})
+	finished()
+	finished()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	remaining := tracker.Drain(ctx)
+	if len(remaining) != 1 {
+		t.Fatalf("Expected 1 remaining command, got %d", len(remaining))
+	}
+	if remaining[0].Command != "imagine" {
+		t.Errorf("Expected imagine to be remaining, got %s", remaining[0].Command)
+	}
+}
+
+func TestTracker_DrainReturnsUnfinishedMessages(t *testing.T) {
+	tracker := NewTracker()
+
+	_, _ = tracker.BeginMessage("ai", &discordgo.Message{ID: "1", ChannelID: "2"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	remaining := tracker.Drain(ctx)
+	if len(remaining) != 1 || remaining[0].Message == nil || remaining[0].Interaction != nil {
+		t.Fatalf("Expected the reply to be remaining with its message, got %+v", remaining)
+	}
+
+	if _, ok := tracker.BeginMessage("ai", &discordgo.Message{ID: "3"}); ok {
+		t.Error("Expected BeginMessage to be refused while draining")
+	}
+}
@@ -0,0 +1,196 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tool is a function advertised to the model in the OpenAI tools schema.
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+type FunctionDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Parameters is a JSON Schema object describing the arguments.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is the model asking for a tool to be run.
+type ToolCall struct {
+	// Index identifies which call a streamed fragment belongs to. It is
+	// only meaningful in stream deltas.
+	Index int `json:"index,omitempty"`
+
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name"`
+
+	// Arguments is a JSON object encoded as a string, as the model wrote it.
+	Arguments string `json:"arguments"`
+}
+
+// ToolHandler runs a tool with the model's arguments. The returned text is
+// what the model sees; errors are reported to the model too, so it can try
+// something else.
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+type AgentTool struct {
+	Definition FunctionDefinition
+	Handler    ToolHandler
+}
+
+// Completer is the part of Client the agent needs, so tests can script the
+// model's replies.
+type Completer interface {
+	Complete(ctx context.Context, messages []ChatMessage, opts Options) (*Completion, error)
+	CompleteStream(ctx context.Context, messages []ChatMessage, opts Options, onDelta func(delta string) error) (*Completion, error)
+}
+
+// maxToolResultLen keeps one chatty tool from filling the context window.
+const maxToolResultLen = 4000
+
+// Agent lets the model call tools until it produces a final answer.
+type Agent struct {
+	model    Completer
+	tools    map[string]AgentTool
+	defs     []Tool
+	maxSteps int
+}
+
+// NewAgent returns an agent that allows at most maxSteps rounds of tool
+// calls per request.
+func NewAgent(model Completer, maxSteps int, tools ...AgentTool) *Agent {
+	a := &Agent{
+		model:    model,
+		tools:    make(map[string]AgentTool, len(tools)),
+		maxSteps: maxSteps,
+	}
+	for _, tool := range tools {
+		a.tools[tool.Definition.Name] = tool
+		a.defs = append(a.defs, Tool{Type: "function", Function: tool.Definition})
+	}
+	return a
+}
+
+// AgentStep records one tool call made while answering.
+type AgentStep struct {
+	Tool      string
+	Arguments string
+	Result    string
+	Err       error
+}
+
+type AgentResult struct {
+	Content string
+	Steps   []AgentStep
+	Usage   Usage
+}
+
+// Run sends messages with the tools advertised, runs any tool calls the
+// model makes and feeds the results back until it answers. Once maxSteps
+// rounds have been used the tools are withdrawn so the model has to answer
+// with what it has.
+//
+// If onDelta is not nil every request is streamed and content is passed
+// to it as it arrives, including any text the model writes alongside its
+// tool calls.
+func (a *Agent) Run(ctx context.Context, messages []ChatMessage, onDelta func(delta string) error) (*AgentResult, error) {
+	result := &AgentResult{}
+	messages = append([]ChatMessage(nil), messages...)
+
+	for step := 0; ; step++ {
+		opts := Options{}
+		if step < a.maxSteps {
+			opts.Tools = a.defs
+		}
+
+		var completion *Completion
+		var err error
+		if onDelta != nil {
+			completion, err = a.model.CompleteStream(ctx, messages, opts, onDelta)
+		} else {
+			completion, err = a.model.Complete(ctx, messages, opts)
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Usage.add(completion.Usage)
+
+		if len(completion.ToolCalls) == 0 {
+			result.Content = completion.Content
+			return result, nil
+		}
+		if step >= a.maxSteps {
+			return result, fmt.Errorf("model kept calling tools after %d steps", a.maxSteps)
+		}
+
+		messages = append(messages, ChatMessage{
+			Role:      "assistant",
+			Content:   completion.Content,
+			ToolCalls: completion.ToolCalls,
+		})
+		for _, call := range completion.ToolCalls {
+			agentStep := a.call(ctx, call)
+			result.Steps = append(result.Steps, agentStep)
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+
+			content := agentStep.Result
+			if agentStep.Err != nil {
+				content = "error: " + agentStep.Err.Error()
+			}
+			messages = append(messages, ChatMessage{
+				Role:       "tool",
+				Content:    content,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
+
+func (a *Agent) call(ctx context.Context, call ToolCall) AgentStep {
+	step := AgentStep{Tool: call.Function.Name, Arguments: call.Function.Arguments}
+
+	tool, ok := a.tools[call.Function.Name]
+	if !ok {
+		step.Err = fmt.Errorf("unknown tool %q", call.Function.Name)
+		return step
+	}
+
+	args := strings.TrimSpace(call.Function.Arguments)
+	if args == "" {
+		args = "{}"
+	}
+	if !json.Valid([]byte(args)) {
+		step.Err = fmt.Errorf("arguments are not valid JSON")
+		return step
+	}
+
+	slog.Info("Running LLM tool", "tool", step.Tool, "arguments", args)
+
+	step.Result, step.Err = tool.Handler(ctx, json.RawMessage(args))
+	if step.Err != nil {
+		slog.Warn("LLM tool failed", "tool", step.Tool, "error", step.Err)
+	}
+	if len(step.Result) > maxToolResultLen {
+		cut := maxToolResultLen
+		for cut > 0 && !utf8.RuneStart(step.Result[cut]) {
+			cut--
+		}
+		step.Result = step.Result[:cut] + "\n[truncated]"
+	}
+	return step
+}
@@ -0,0 +1,215 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// scriptedModel replies with each completion in turn and records what it
+// was sent.
+type scriptedModel struct {
+	replies  []*Completion
+	requests [][]ChatMessage
+	options  []Options
+}
+
+func (m *scriptedModel) Complete(ctx context.Context, messages []ChatMessage, opts Options) (*Completion, error) {
+	m.requests = append(m.requests, append([]ChatMessage(nil), messages...))
+	m.options = append(m.options, opts)
+	if len(m.replies) == 0 {
+		return nil, errors.New("script exhausted")
+	}
+	reply := m.replies[0]
+	m.replies = m.replies[1:]
+	return reply, nil
+}
+
+func (m *scriptedModel) CompleteStream(ctx context.Context, messages []ChatMessage, opts Options, onDelta func(string) error) (*Completion, error) {
+	reply, err := m.Complete(ctx, messages, opts)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Content != "" {
+		if err := onDelta(reply.Content); err != nil {
+			return reply, err
+		}
+	}
+	return reply, nil
+}
+
+func call(id, name, args string) ToolCall {
+	return ToolCall{ID: id, Type: "function", Function: FunctionCall{Name: name, Arguments: args}}
+}
+
+func echoTool(name string, calls *[]string) AgentTool {
+	return AgentTool{
+		Definition: FunctionDefinition{Name: name, Parameters: json.RawMessage(`{"type":"object"}`)},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			*calls = append(*calls, string(args))
+			return name + " result", nil
+		},
+	}
+}
+
+func TestAgent_RunsToolsUntilAnswer(t *testing.T) {
+	model := &scriptedModel{replies: []*Completion{
+		{ToolCalls: []ToolCall{call("1", "news", `{"ticker":"AAPL"}`), call("2", "sentiment", `{"ticker":"AAPL"}`)}, Usage: Usage{TotalTokens: 10}},
+		{Content: "AAPL looks good.", Usage: Usage{TotalTokens: 5}},
+	}}
+
+	var newsCalls, sentimentCalls []string
+	agent := NewAgent(model, 3, echoTool("news", &newsCalls), echoTool("sentiment", &sentimentCalls))
+
+	result, err := agent.Run(context.Background(), []ChatMessage{{Role: "user", Content: "How is AAPL?"}}, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if result.Content != "AAPL looks good." {
+		t.Errorf("Expected final answer, got %q", result.Content)
+	}
+	if len(newsCalls) != 1 || newsCalls[0] != `{"ticker":"AAPL"}` || len(sentimentCalls) != 1 {
+		t.Errorf("Expected each tool to run once, got news=%q sentiment=%q", newsCalls, sentimentCalls)
+	}
+	if len(result.Steps) != 2 || result.Usage.TotalTokens != 15 {
+		t.Errorf("Expected 2 steps and 15 tokens, got %d steps and %+v", len(result.Steps), result.Usage)
+	}
+
+	if len(model.options[0].Tools) != 2 || model.options[0].Tools[0].Function.Name != "news" {
+		t.Errorf("Expected tools to be advertised, got %+v", model.options[0].Tools)
+	}
+
+	second := model.requests[1]
+	if len(second) != 4 {
+		t.Fatalf("Expected user, assistant and two tool messages, got %+v", second)
+	}
+	if second[1].Role != "assistant" || len(second[1].ToolCalls) != 2 {
+		t.Errorf("Expected the assistant's tool calls to be sent back, got %+v", second[1])
+	}
+	if second[2].Role != "tool" || second[2].ToolCallID != "1" || second[2].Content != "news result" {
+		t.Errorf("Expected news result for call 1, got %+v", second[2])
+	}
+	if second[3].ToolCallID != "2" || second[3].Content != "sentiment result" {
+		t.Errorf("Expected sentiment result for call 2, got %+v", second[3])
+	}
+}
+
+func TestAgent_ReportsToolErrorsToModel(t *testing.T) {
+	model := &scriptedModel{replies: []*Completion{
+		{ToolCalls: []ToolCall{call("1", "missing", `{}`), call("2", "broken", `{}`), call("3", "broken", `not json`)}},
+		{Content: "Sorry, I couldn't look that up."},
+	}}
+
+	broken := AgentTool{
+		Definition: FunctionDefinition{Name: "broken"},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "", errors.New("service down")
+		},
+	}
+	agent := NewAgent(model, 3, broken)
+
+	result, err := agent.Run(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Content != "Sorry, I couldn't look that up." {
+		t.Errorf("Unexpected answer %q", result.Content)
+	}
+
+	toolMessages := model.requests[1][2:]
+	for i, want := range []string{`unknown tool "missing"`, "service down", "not valid JSON"} {
+		if !strings.HasPrefix(toolMessages[i].Content, "error: ") || !strings.Contains(toolMessages[i].Content, want) {
+			t.Errorf("Tool message %d: expected error mentioning %q, got %q", i, want, toolMessages[i].Content)
+		}
+	}
+}
+
+func TestAgent_MaxStepsWithdrawsTools(t *testing.T) {
+	loop := []ToolCall{call("1", "news", `{}`)}
+	model := &scriptedModel{replies: []*Completion{
+		{ToolCalls: loop},
+		{ToolCalls: loop},
+		{Content: "Here's what I found."},
+	}}
+
+	var calls []string
+	agent := NewAgent(model, 2, echoTool("news", &calls))
+
+	result, err := agent.Run(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Content != "Here's what I found." || len(calls) != 2 {
+		t.Errorf("Expected 2 tool runs then an answer, got %d runs and %q", len(calls), result.Content)
+	}
+	if len(model.options[2].Tools) != 0 {
+		t.Errorf("Expected tools to be withdrawn on the last request, got %+v", model.options[2].Tools)
+	}
+}
+
+func TestAgent_MaxStepsStopsRunawayModel(t *testing.T) {
+	loop := []ToolCall{call("1", "news", `{}`)}
+	model := &scriptedModel{replies: []*Completion{{ToolCalls: loop}, {ToolCalls: loop}}}
+
+	var calls []string
+	agent := NewAgent(model, 1, echoTool("news", &calls))
+
+	_, err := agent.Run(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "after 1 steps") {
+		t.Errorf("Expected max steps error, got %v", err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("Expected the tool to run once, got %d", len(calls))
+	}
+}
+
+func TestAgent_StreamsContent(t *testing.T) {
+	model := &scriptedModel{replies: []*Completion{
+		{Content: "Let me check. ", ToolCalls: []ToolCall{call("1", "news", `{}`)}},
+		{Content: "Done."},
+	}}
+
+	var calls []string
+	agent := NewAgent(model, 2, echoTool("news", &calls))
+
+	var streamed strings.Builder
+	result, err := agent.Run(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if streamed.String() != "Let me check. Done." {
+		t.Errorf("Expected all content to be streamed, got %q", streamed.String())
+	}
+	if result.Content != "Done." {
+		t.Errorf("Expected final content, got %q", result.Content)
+	}
+}
+
+func TestAgent_TruncatesLongResultsOnRuneBoundary(t *testing.T) {
+	model := &scriptedModel{replies: []*Completion{
+		{ToolCalls: []ToolCall{call("1", "long", `{}`)}},
+		{Content: "done"},
+	}}
+	long := AgentTool{
+		Definition: FunctionDefinition{Name: "long"},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "a" + strings.Repeat("é", maxToolResultLen), nil
+		},
+	}
+
+	result, err := NewAgent(model, 3, long).Run(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	got := result.Steps[0].Result
+	if !strings.HasSuffix(got, "\n[truncated]") || !utf8.ValidString(got) {
+		t.Errorf("Expected a valid truncated result, got %q", got[len(got)-20:])
+	}
+}
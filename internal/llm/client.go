@@ -1,100 +1,423 @@
 package llm
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL      string
+	model        string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+
+	mu    sync.Mutex
+	usage Usage
 }
 
 type ChatRequest struct {
-	Model    string        `json:"model"`
-	Messages []ChatMessage `json:"messages"`
+	Model          string          `json:"model"`
+	Messages       []ChatMessage   `json:"messages"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Stop           []string        `json:"stop,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	Tools          []Tool          `json:"tools,omitempty"`
+	ToolChoice     string          `json:"tool_choice,omitempty"`
+
+	// Grammar is a GBNF grammar; it is a llama.cpp server extension.
+	Grammar string `json:"grammar,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+
+	// ToolCalls is set on assistant messages that call tools, and
+	// ToolCallID on the "tool" message answering each call.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+
+	// Parts are sent after Content as a content array, e.g. images for a
+	// vision model. Content stays the message's text either way.
+	Parts []ContentPart `json:"-"`
 }
 
 type ChatResponse struct {
+	Model   string       `json:"model,omitempty"`
 	Choices []ChatChoice `json:"choices"`
+	Usage   *Usage       `json:"usage,omitempty"`
 }
 
 type ChatChoice struct {
-	Message ChatMessage `json:"message"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason,omitempty"`
+}
+
+// ChatStreamChunk is one server-sent event from a streaming completion.
+type ChatStreamChunk struct {
+	Choices []ChatStreamChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type ChatStreamChoice struct {
+	Delta        ChatMessage `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// Usage is the token accounting the server reports for a completion.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
 }
 
-func NewClient(baseURL string) *Client {
-	return &Client{
+// Options tune a single completion. Zero values leave the server's
+// defaults in place.
+type Options struct {
+	// Model overrides the client's model for this request.
+	Model string
+
+	// SystemPrompt is sent as a system message ahead of the conversation.
+	SystemPrompt string
+
+	Temperature *float64
+	MaxTokens   int
+	Stop        []string
+
+	// JSON asks the server to constrain the reply to a JSON object.
+	JSON bool
+
+	// Grammar constrains the reply to a GBNF grammar (llama.cpp only).
+	Grammar string
+
+	// Tools are advertised to the model, which may answer with ToolCalls
+	// instead of content. ToolChoice is "auto", "none" or "required".
+	Tools      []Tool
+	ToolChoice string
+}
+
+// Temperature returns a pointer for Options.Temperature, since zero is a
+// meaningful temperature.
+func Temperature(t float64) *float64 {
+	return &t
+}
+
+// Completion is the assistant's reply along with what the server reported
+// about it.
+type Completion struct {
+	Content      string
+	ToolCalls    []ToolCall
+	Model        string
+	FinishReason string
+	Usage        Usage
+}
+
+type ClientOption func(*Client)
+
+// WithRetries retries failed requests up to maxRetries times, waiting
+// backoff before the first retry and doubling it for each one after.
+func WithRetries(maxRetries int, backoff time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+func NewClient(baseURL, model string, timeout time.Duration, opts ...ClientOption) *Client {
+	c := &Client{
 		baseURL: baseURL,
+		model:   model,
 		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+			Timeout: timeout,
 		},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-func (c *Client) Chat(prompt string) (string, error) {
-	requestBody := ChatRequest{
-		Model: "llama",
-		Messages: []ChatMessage{
-			{Role: "user", Content: prompt},
-		},
+func (c *Client) Chat(ctx context.Context, prompt string) (string, error) {
+	return c.ChatMessages(ctx, []ChatMessage{
+		{Role: "user", Content: prompt},
+	})
+}
+
+// ChatMessages sends a full conversation, e.g. a system prompt followed by
+// earlier turns, and returns the assistant's reply.
+func (c *Client) ChatMessages(ctx context.Context, messages []ChatMessage) (string, error) {
+	completion, err := c.Complete(ctx, messages, Options{})
+	if err != nil {
+		return "", err
 	}
+	return completion.Content, nil
+}
 
-	jsonData, err := json.Marshal(requestBody)
+// Usage returns the tokens used by every completion this client has made.
+func (c *Client) Usage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+func (c *Client) recordUsage(u Usage) {
+	c.mu.Lock()
+	c.usage.add(u)
+	c.mu.Unlock()
+}
+
+// newRequest builds the request body. The options' system prompt, any
+// system message the caller sent first and the context's persona are
+// merged into one leading system message, since many chat templates only
+// accept a single one.
+func (c *Client) newRequest(ctx context.Context, messages []ChatMessage, opts Options) ChatRequest {
+	req := ChatRequest{
+		Model:       c.model,
+		Messages:    messages,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
+		Stop:        opts.Stop,
+		Grammar:     opts.Grammar,
+		Tools:       opts.Tools,
+		ToolChoice:  opts.ToolChoice,
+	}
+	if opts.Model != "" {
+		req.Model = opts.Model
+	}
+
+	persona := PersonaFromContext(ctx)
+	if opts.SystemPrompt != "" || persona != "" {
+		var system []string
+		if opts.SystemPrompt != "" {
+			system = append(system, opts.SystemPrompt)
+		}
+		if len(messages) > 0 && messages[0].Role == "system" {
+			system = append(system, messages[0].Content)
+			messages = messages[1:]
+		}
+		if persona != "" {
+			system = append(system, persona)
+		}
+		req.Messages = append([]ChatMessage{{Role: "system", Content: strings.Join(system, "\n\n")}}, messages...)
+	}
+	if opts.JSON {
+		req.ResponseFormat = &ResponseFormat{Type: "json_object"}
+	}
+	return req
+}
+
+// Complete sends messages with opts and the context's persona applied and
+// returns the reply.
+func (c *Client) Complete(ctx context.Context, messages []ChatMessage, opts Options) (*Completion, error) {
+	jsonData, err := json.Marshal(c.newRequest(ctx, messages, opts))
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/v1/chat/completions", c.baseURL)
 
-	slog.Info("Sending request to LLM", "url", url)
+	slog.Info("Sending request to LLM", "url", url, "messages", len(messages))
 
-	resp, err := c.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := c.post(ctx, c.httpClient, url, jsonData, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var chatResp ChatResponse
 	if err := json.Unmarshal(body, &chatResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	if len(chatResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from LLM")
+		return nil, fmt.Errorf("no response from LLM")
 	}
 
-	content := chatResp.Choices[0].Message.Content
+	completion := &Completion{
+		Content:      chatResp.Choices[0].Message.Content,
+		ToolCalls:    chatResp.Choices[0].Message.ToolCalls,
+		Model:        chatResp.Model,
+		FinishReason: chatResp.Choices[0].FinishReason,
+	}
+	if chatResp.Usage != nil {
+		completion.Usage = *chatResp.Usage
+		c.recordUsage(completion.Usage)
+	}
+
+	slog.Info("LLM response received",
+		"length", len(completion.Content),
+		"tool_calls", len(completion.ToolCalls),
+		"prompt_tokens", completion.Usage.PromptTokens,
+		"completion_tokens", completion.Usage.CompletionTokens,
+	)
+
+	return completion, nil
+}
+
+// post sends body to url, retrying network errors, 429s and 5xx responses
+// with exponential backoff. A Retry-After header in seconds is honoured if
+// it asks for a longer wait. The returned response always has status 200.
+func (c *Client) post(ctx context.Context, client *http.Client, url string, body []byte, header http.Header) (*http.Response, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		var wait time.Duration
+		resp, err := client.Do(req)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("failed to send request: %w", streamErr(ctx, err))
+			if ctx.Err() != nil {
+				return nil, err
+			}
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		default:
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				return nil, err
+			}
+			if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				wait = time.Duration(secs) * time.Second
+			}
+		}
+
+		if attempt >= c.maxRetries {
+			return nil, err
+		}
 
-	slog.Info("LLM response received", "length", len(content))
+		wait = max(wait, backoff)
+		backoff *= 2
+		slog.Warn("LLM request failed, retrying", "attempt", attempt+1, "wait", wait, "error", err)
 
-	return content, nil
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to send request: %w", streamErr(ctx, ctx.Err()))
+		case <-time.After(wait):
+		}
+	}
+}
+
+// StatusError is a non-200 response from the server.
+type StatusError struct {
+	StatusCode int
+	Body       string
 }
 
-func (c *Client) HealthCheck() error {
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Embed returns one embedding vector per input, in the same order. The
+// server must have embeddings enabled (llama-server --embeddings).
+func (c *Client) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(EmbeddingRequest{Model: c.model, Input: inputs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/embeddings", c.baseURL)
+
+	resp, err := c.post(ctx, c.httpClient, url, jsonData, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embResp EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	vectors := make([][]float32, len(inputs))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if len(v) == 0 {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+	if embResp.Usage != nil {
+		c.recordUsage(*embResp.Usage)
+	}
+
+	slog.Info("Embeddings received", "inputs", len(inputs), "dimensions", len(vectors[0]))
+
+	return vectors, nil
+}
+
+func (c *Client) HealthCheck(ctx context.Context) error {
 	url := fmt.Sprintf("%s/health", c.baseURL)
 
-	resp, err := c.httpClient.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
@@ -106,3 +429,152 @@ func (c *Client) HealthCheck() error {
 
 	return nil
 }
+
+// ChatStream sends messages with streaming enabled and calls onDelta with
+// each piece of content as it arrives. It returns the full reply. If
+// onDelta returns an error the stream is abandoned and that error is
+// returned.
+func (c *Client) ChatStream(ctx context.Context, messages []ChatMessage, onDelta func(delta string) error) (string, error) {
+	completion, err := c.CompleteStream(ctx, messages, Options{}, onDelta)
+	return completion.Content, err
+}
+
+// CompleteStream is Complete with the reply's content streamed to onDelta.
+// Tool calls are assembled from their deltas and returned in the
+// completion, which holds whatever arrived even when err is not nil.
+//
+// The client timeout is applied to the gap between events rather than the
+// whole response, since long answers legitimately take minutes to stream.
+func (c *Client) CompleteStream(ctx context.Context, messages []ChatMessage, opts Options, onDelta func(delta string) error) (*Completion, error) {
+	requestBody := c.newRequest(ctx, messages, opts)
+	requestBody.Stream = true
+	requestBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+	completion := &Completion{Model: requestBody.Model}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return completion, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/chat/completions", c.baseURL)
+
+	slog.Info("Sending streaming request to LLM", "url", url, "messages", len(messages))
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	idle := c.httpClient.Timeout
+	if idle <= 0 {
+		idle = time.Minute
+	}
+	idleTimer := time.AfterFunc(idle, func() {
+		cancel(fmt.Errorf("no data from LLM for %s", idle))
+	})
+	defer idleTimer.Stop()
+
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := c.post(ctx, streamClient, url, jsonData, http.Header{"Accept": {"text/event-stream"}})
+	if err != nil {
+		return completion, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var toolCalls []ToolCall
+	defer func() {
+		completion.Content = content.String()
+		completion.ToolCalls = toolCalls
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		idleTimer.Reset(idle)
+
+		line := scanner.Text()
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			// Blank separators, comments and other SSE fields.
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk ChatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return completion, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return completion, fmt.Errorf("LLM stream error: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			completion.Usage = *chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				completion.FinishReason = *choice.FinishReason
+			}
+			toolCalls = mergeToolCallDeltas(toolCalls, choice.Delta.ToolCalls)
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return completion, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return completion, fmt.Errorf("failed to read stream: %w", streamErr(ctx, err))
+	}
+
+	if content.Len() == 0 && len(toolCalls) == 0 {
+		return completion, fmt.Errorf("no response from LLM")
+	}
+
+	c.recordUsage(completion.Usage)
+
+	slog.Info("LLM stream finished",
+		"length", content.Len(),
+		"tool_calls", len(toolCalls),
+		"prompt_tokens", completion.Usage.PromptTokens,
+		"completion_tokens", completion.Usage.CompletionTokens,
+	)
+
+	return completion, nil
+}
+
+// mergeToolCallDeltas folds streamed tool call fragments into calls. The
+// first fragment for an index carries the ID and name; later ones append
+// to the arguments.
+func mergeToolCallDeltas(calls []ToolCall, deltas []ToolCall) []ToolCall {
+	for _, d := range deltas {
+		for len(calls) <= d.Index {
+			calls = append(calls, ToolCall{Type: "function"})
+		}
+		call := &calls[d.Index]
+		if d.ID != "" {
+			call.ID = d.ID
+		}
+		if d.Type != "" {
+			call.Type = d.Type
+		}
+		call.Function.Name += d.Function.Name
+		call.Function.Arguments += d.Function.Arguments
+	}
+	return calls
+}
+
+// streamErr prefers the idle-timeout cause over the generic "context
+// canceled" it produces.
+func streamErr(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) && !errors.Is(cause, context.DeadlineExceeded) {
+		return cause
+	}
+	return err
+}
@@ -0,0 +1,637 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Chat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if req.Model != "test-model" {
+			t.Errorf("Expected model test-model, got %s", req.Model)
+		}
+		json.NewEncoder(w).Encode(ChatResponse{
+			Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: "hello"}}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	content, err := client.Chat(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if content != "hello" {
+		t.Errorf("Expected hello, got %q", content)
+	}
+}
+
+func TestClient_ChatMessagesSendsHistory(t *testing.T) {
+	var got []ChatMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		got = req.Messages
+		json.NewEncoder(w).Encode(ChatResponse{
+			Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: "blue"}}},
+		})
+	}))
+	defer server.Close()
+
+	history := []ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "My favourite colour is blue."},
+		{Role: "assistant", Content: "Noted."},
+		{Role: "user", Content: "What is my favourite colour?"},
+	}
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	if _, err := client.ChatMessages(context.Background(), history); err != nil {
+		t.Fatalf("ChatMessages failed: %v", err)
+	}
+
+	if len(got) != len(history) {
+		t.Fatalf("Expected %d messages, got %d", len(history), len(got))
+	}
+	for i := range history {
+		if !reflect.DeepEqual(got[i], history[i]) {
+			t.Errorf("Message %d: expected %+v, got %+v", i, history[i], got[i])
+		}
+	}
+}
+
+func TestClient_ChatCancellationAbortsSlowServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Consume the body so the server notices the client going away.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Chat(ctx, "hi")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Chat took %s after cancellation, expected it to abort promptly", elapsed)
+	}
+}
+
+// sseServer streams each chunk as an OpenAI-style server-sent event, then
+// optionally stalls before sending [DONE].
+func sseServer(t *testing.T, chunks []string, stall time.Duration) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if !req.Stream {
+			t.Error("Expected stream: true in request")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for _, chunk := range chunks {
+			data, _ := json.Marshal(ChatStreamChunk{
+				Choices: []ChatStreamChoice{{Delta: ChatMessage{Content: chunk}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+		if stall > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(stall):
+			}
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}))
+}
+
+func TestClient_ChatStream(t *testing.T) {
+	server := sseServer(t, []string{"Hel", "lo", ", ", "world"}, 0)
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+
+	var deltas []string
+	content, err := client.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+
+	if content != "Hello, world" {
+		t.Errorf("Expected full content %q, got %q", "Hello, world", content)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo|, |world" {
+		t.Errorf("Expected deltas in order, got %q", deltas)
+	}
+}
+
+func TestClient_ChatStreamErrorEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"error\":{\"message\":\"context size exceeded\"}}\n\n")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	_, err := client.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "context size exceeded") {
+		t.Errorf("Expected stream error to be reported, got %v", err)
+	}
+}
+
+func TestClient_ChatStreamIdleTimeout(t *testing.T) {
+	server := sseServer(t, []string{"partial"}, 5*time.Second)
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 100*time.Millisecond)
+
+	start := time.Now()
+	content, err := client.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "no data from LLM") {
+		t.Fatalf("Expected idle timeout error, got %v", err)
+	}
+	if content != "partial" {
+		t.Errorf("Expected partial content to be returned, got %q", content)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Idle timeout took %s", elapsed)
+	}
+}
+
+func TestClient_ChatStreamStopsWhenCallbackFails(t *testing.T) {
+	server := sseServer(t, []string{"a", "b", "c"}, 0)
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+
+	stop := errors.New("stop")
+	calls := 0
+	_, err := client.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(string) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected callback error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected stream to stop after the first delta, got %d calls", calls)
+	}
+}
+
+func TestClient_CompleteSendsOptions(t *testing.T) {
+	var got ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		json.NewEncoder(w).Encode(ChatResponse{
+			Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: `{"ok":true}`}, FinishReason: "stop"}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	completion, err := client.Complete(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, Options{
+		Model:        "other-model",
+		SystemPrompt: "Reply in JSON.",
+		Temperature:  Temperature(0),
+		MaxTokens:    128,
+		Stop:         []string{"\n\n"},
+		JSON:         true,
+		Grammar:      `root ::= "{}"`,
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if completion.Content != `{"ok":true}` || completion.FinishReason != "stop" {
+		t.Errorf("Unexpected completion: %+v", completion)
+	}
+
+	if got.Model != "other-model" {
+		t.Errorf("Expected model override, got %s", got.Model)
+	}
+	if len(got.Messages) != 2 || got.Messages[0].Role != "system" || got.Messages[0].Content != "Reply in JSON." {
+		t.Errorf("Expected system prompt before the user message, got %+v", got.Messages)
+	}
+	if got.Temperature == nil || *got.Temperature != 0 {
+		t.Errorf("Expected temperature 0 to be sent, got %v", got.Temperature)
+	}
+	if got.MaxTokens != 128 {
+		t.Errorf("Expected max_tokens 128, got %d", got.MaxTokens)
+	}
+	if len(got.Stop) != 1 || got.Stop[0] != "\n\n" {
+		t.Errorf("Expected stop sequence, got %q", got.Stop)
+	}
+	if got.ResponseFormat == nil || got.ResponseFormat.Type != "json_object" {
+		t.Errorf("Expected json_object response format, got %+v", got.ResponseFormat)
+	}
+	if got.Grammar != `root ::= "{}"` {
+		t.Errorf("Expected grammar, got %q", got.Grammar)
+	}
+}
+
+func TestClient_ChatOmitsUnsetOptions(t *testing.T) {
+	var raw map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		json.NewEncoder(w).Encode(ChatResponse{
+			Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: "ok"}}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	if _, err := client.Chat(context.Background(), "hi"); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	for _, key := range []string{"temperature", "max_tokens", "stop", "response_format", "grammar"} {
+		if _, ok := raw[key]; ok {
+			t.Errorf("Expected %s to be left to the server, got %v", key, raw[key])
+		}
+	}
+}
+
+func TestClient_UsageAccounting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		json.NewEncoder(w).Encode(ChatResponse{
+			Model:   "llama-3-8b",
+			Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: "ok"}}},
+			Usage:   &Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	completion, err := client.Complete(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, Options{})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if completion.Model != "llama-3-8b" {
+		t.Errorf("Expected reported model, got %q", completion.Model)
+	}
+	if completion.Usage.TotalTokens != 15 {
+		t.Errorf("Expected 15 total tokens, got %+v", completion.Usage)
+	}
+
+	if _, err := client.Chat(context.Background(), "again"); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	want := Usage{PromptTokens: 20, CompletionTokens: 10, TotalTokens: 30}
+	if got := client.Usage(); got != want {
+		t.Errorf("Expected cumulative usage %+v, got %+v", want, got)
+	}
+}
+
+func TestClient_RetriesServerErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		attempts++
+		switch attempts {
+		case 1:
+			http.Error(w, "loading model", http.StatusServiceUnavailable)
+		case 2:
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			json.NewEncoder(w).Encode(ChatResponse{
+				Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: "ok"}}},
+			})
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second, WithRetries(2, 10*time.Millisecond))
+	content, err := client.Chat(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if content != "ok" {
+		t.Errorf("Expected ok, got %q", content)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestClient_RetriesGiveUp(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		attempts++
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second, WithRetries(2, 10*time.Millisecond))
+	_, err := client.Chat(context.Background(), "hi")
+	if err == nil || !strings.Contains(err.Error(), "status 500") {
+		t.Errorf("Expected status 500 error, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestClient_DoesNotRetryClientErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		attempts++
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second, WithRetries(3, 10*time.Millisecond))
+	if _, err := client.Chat(context.Background(), "hi"); err == nil {
+		t.Fatal("Expected error for 400 response")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestClient_RetryBackoffRespectsCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "loading model", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second, WithRetries(5, time.Minute))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Chat(ctx, "hi")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Backoff took %s after cancellation, expected it to abort promptly", elapsed)
+	}
+}
+
+func TestClient_CompleteStreamReportsUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Error("Expected stream_options.include_usage in request")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		stop := "stop"
+		for _, chunk := range []ChatStreamChunk{
+			{Choices: []ChatStreamChoice{{Delta: ChatMessage{Content: "hi"}}}},
+			{Choices: []ChatStreamChoice{{FinishReason: &stop}}},
+			{Usage: &Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4}},
+		} {
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	completion, err := client.CompleteStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, Options{}, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("CompleteStream failed: %v", err)
+	}
+	if completion.Content != "hi" || completion.FinishReason != "stop" {
+		t.Errorf("Unexpected completion: %+v", completion)
+	}
+	if completion.Usage.TotalTokens != 4 || client.Usage().TotalTokens != 4 {
+		t.Errorf("Expected 4 tokens recorded, got %+v and %+v", completion.Usage, client.Usage())
+	}
+}
+
+func TestClient_PersonaMergedIntoSystemPrompt(t *testing.T) {
+	var got []ChatMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		got = req.Messages
+		json.NewEncoder(w).Encode(ChatResponse{
+			Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: "arr"}}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	ctx := WithPersona(context.Background(), "Talk like a pirate.")
+
+	if _, err := client.ChatMessages(ctx, []ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "hi"},
+	}); err != nil {
+		t.Fatalf("ChatMessages failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Role != "system" || got[0].Content != "Be brief.\n\nTalk like a pirate." {
+		t.Errorf("Expected one merged system message, got %+v", got)
+	}
+
+	if _, err := client.Chat(ctx, "hi"); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Content != "Talk like a pirate." {
+		t.Errorf("Expected persona as system message, got %+v", got)
+	}
+
+	if _, err := client.Chat(WithoutPersona(ctx), "hi"); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Role != "user" {
+		t.Errorf("Expected persona to be suppressed, got %+v", got)
+	}
+}
+
+func TestClient_CompleteParsesToolCalls(t *testing.T) {
+	var got ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		fmt.Fprint(w, `{"choices":[{"finish_reason":"tool_calls","message":{"role":"assistant","content":null,
+			"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_stock_news","arguments":"{\"ticker\":\"AAPL\"}"}}]}}]}`)
+	}))
+	defer server.Close()
+
+	tools := []Tool{{Type: "function", Function: FunctionDefinition{Name: "get_stock_news", Parameters: json.RawMessage(`{"type":"object"}`)}}}
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	completion, err := client.Complete(context.Background(), []ChatMessage{{Role: "user", Content: "news?"}}, Options{Tools: tools})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if len(got.Tools) != 1 || got.Tools[0].Function.Name != "get_stock_news" {
+		t.Errorf("Expected tools in request, got %+v", got.Tools)
+	}
+	if len(completion.ToolCalls) != 1 || completion.ToolCalls[0].Function.Arguments != `{"ticker":"AAPL"}` {
+		t.Errorf("Expected tool call to be parsed, got %+v", completion.ToolCalls)
+	}
+}
+
+func TestClient_CompleteStreamAssemblesToolCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_stock_news","arguments":""}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"ticker\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"AAPL\"}"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"get_stock_sentiment","arguments":"{}"}}]}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	completion, err := client.CompleteStream(context.Background(), []ChatMessage{{Role: "user", Content: "news?"}}, Options{}, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("CompleteStream failed: %v", err)
+	}
+
+	if len(completion.ToolCalls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %+v", completion.ToolCalls)
+	}
+	first := completion.ToolCalls[0]
+	if first.ID != "call_1" || first.Function.Name != "get_stock_news" || first.Function.Arguments != `{"ticker":"AAPL"}` {
+		t.Errorf("Unexpected first call %+v", first)
+	}
+	if completion.ToolCalls[1].Function.Name != "get_stock_sentiment" || completion.FinishReason != "tool_calls" {
+		t.Errorf("Unexpected completion %+v", completion)
+	}
+}
+
+func TestClient_Embed(t *testing.T) {
+	var got EmbeddingRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		// Out of order, as some servers return them.
+		fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}],"usage":{"prompt_tokens":4,"total_tokens":4}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "embed-model", 5*time.Second)
+	vectors, err := client.Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+
+	if got.Model != "embed-model" || len(got.Input) != 2 {
+		t.Errorf("Unexpected request %+v", got)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("Expected vectors in input order, got %v", vectors)
+	}
+	if client.Usage().PromptTokens != 4 {
+		t.Errorf("Expected embedding usage to be recorded, got %+v", client.Usage())
+	}
+}
+
+func TestClient_SendsImageParts(t *testing.T) {
+	var raw map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		json.NewEncoder(w).Encode(ChatResponse{
+			Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: "a cat"}}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-model", 5*time.Second)
+	messages := []ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "what's this?", Parts: []ContentPart{ImagePart("image/png", []byte("png-bytes"))}},
+	}
+	if _, err := client.ChatMessages(context.Background(), messages); err != nil {
+		t.Fatalf("ChatMessages failed: %v", err)
+	}
+
+	sent := raw["messages"].([]any)
+	if content, ok := sent[0].(map[string]any)["content"].(string); !ok || content != "Be brief." {
+		t.Errorf("Expected a text-only message to keep string content, got %#v", sent[0])
+	}
+
+	want := []any{
+		map[string]any{"type": "text", "text": "what's this?"},
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,cG5nLWJ5dGVz"}},
+	}
+	if got := sent[1].(map[string]any)["content"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected multimodal content:\ngot  %#v\nwant %#v", got, want)
+	}
+}
+
+func TestChatMessage_UnmarshalContentArray(t *testing.T) {
+	var m ChatMessage
+	data := `{"role":"user","content":[{"type":"text","text":"look"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AA=="}},{"type":"text","text":"here"}]}`
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if m.Content != "look\nhere" || len(m.Parts) != 1 || m.Parts[0].ImageURL.URL != "data:image/png;base64,AA==" {
+		t.Errorf("Unexpected message %+v", m)
+	}
+
+	for _, data := range []string{`{"role":"assistant","content":null}`, `{"role":"assistant"}`} {
+		m = ChatMessage{}
+		if err := json.Unmarshal([]byte(data), &m); err != nil || m.Content != "" || m.Role != "assistant" {
+			t.Errorf("Unmarshal(%s) = %+v, %v", data, m, err)
+		}
+	}
+}
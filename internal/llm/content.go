@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ContentPart is one part of a multimodal message in the OpenAI content
+// array format: text, or an image for a vision model (llama-server needs
+// a multimodal projector loaded with --mmproj).
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	// URL is usually a data: URL, since a local model server can't be
+	// expected to fetch from the internet.
+	URL string `json:"url"`
+}
+
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+// ImagePart embeds an image as a base64 data URL.
+func ImagePart(mimeType string, data []byte) ContentPart {
+	url := fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data))
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}}
+}
+
+// MarshalJSON sends content as a plain string unless the message has
+// Parts, in which case it becomes an array: Content as a text part
+// followed by Parts.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	parts := make([]ContentPart, 0, len(m.Parts)+1)
+	if m.Content != "" {
+		parts = append(parts, TextPart(m.Content))
+	}
+	parts = append(parts, m.Parts...)
+
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), parts})
+}
+
+// UnmarshalJSON accepts content as a string, null or a content array. The
+// text parts of an array are joined into Content and the rest kept in
+// Parts.
+func (m *ChatMessage) UnmarshalJSON(data []byte) error {
+	type plain ChatMessage
+	var raw struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = ChatMessage(raw.plain)
+
+	content := strings.TrimSpace(string(raw.Content))
+	switch {
+	case content == "" || content == "null":
+		return nil
+	case strings.HasPrefix(content, "["):
+		var parts []ContentPart
+		if err := json.Unmarshal(raw.Content, &parts); err != nil {
+			return err
+		}
+		var text []string
+		for _, p := range parts {
+			if p.Type == "text" {
+				text = append(text, p.Text)
+			} else {
+				m.Parts = append(m.Parts, p)
+			}
+		}
+		m.Content = strings.Join(text, "\n")
+		return nil
+	default:
+		return json.Unmarshal(raw.Content, &m.Content)
+	}
+}
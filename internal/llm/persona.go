@@ -0,0 +1,24 @@
+package llm
+
+import "context"
+
+type personaKey struct{}
+
+// WithPersona returns a context whose completions carry prompt as part of
+// the system prompt. The bot attaches the guild or channel persona to each
+// command's context so every request it makes picks it up.
+func WithPersona(ctx context.Context, prompt string) context.Context {
+	return context.WithValue(ctx, personaKey{}, prompt)
+}
+
+// WithoutPersona suppresses the persona for requests whose output must not
+// take on its tone, such as summaries and image prompts.
+func WithoutPersona(ctx context.Context) context.Context {
+	return context.WithValue(ctx, personaKey{}, "")
+}
+
+// PersonaFromContext returns the persona prompt attached to ctx, if any.
+func PersonaFromContext(ctx context.Context) string {
+	prompt, _ := ctx.Value(personaKey{}).(string)
+	return prompt
+}
@@ -0,0 +1,454 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capabilities describe what a backend's model can handle. A zero
+// ContextLength means unknown, and is not checked.
+type Capabilities struct {
+	ContextLength int
+	Vision        bool
+	Tools         bool
+}
+
+// Backend is one model server in a Router's pool.
+type Backend struct {
+	// Name identifies the backend to users picking a model.
+	Name   string
+	Client *Client
+
+	// Weight sets the backend's share of traffic among those that can
+	// serve a request.
+	Weight int
+	Capabilities
+}
+
+// BackendStatus is a snapshot of a backend for display.
+type BackendStatus struct {
+	Name    string
+	Model   string
+	Healthy bool
+	Capabilities
+
+	// LastError is the failure that opened the circuit, if it is open.
+	LastError string
+}
+
+type modelKey struct{}
+
+// WithModel asks for requests made with ctx to go to the named backend
+// only.
+func WithModel(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, modelKey{}, name)
+}
+
+// ModelFromContext returns the backend name set by WithModel, or "".
+func ModelFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(modelKey{}).(string)
+	return name
+}
+
+// ErrNoBackend is returned when no healthy backend can serve a request.
+var ErrNoBackend = errors.New("no LLM backend available")
+
+// Router spreads requests over a pool of backends by weight, sending each
+// only to backends that are healthy and have the capabilities it needs,
+// and fails over to the next one when a backend errors.
+//
+// Each backend has a circuit breaker: after FailureThreshold consecutive
+// failures it is skipped for the cooldown, then one trial request is let
+// through to see if it has recovered. Health checks close or open the
+// circuit too, so a restarted server is picked up without waiting for a
+// user's request to fail.
+type Router struct {
+	backends  []*routedBackend
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+}
+
+type routedBackend struct {
+	Backend
+
+	// current is the smooth weighted round-robin counter.
+	current int
+
+	failures  int
+	openUntil time.Time
+	trial     bool
+	lastErr   error
+}
+
+type RouterOption func(*Router)
+
+// WithCircuitBreaker opens a backend's circuit after threshold consecutive
+// failures and keeps it open for cooldown.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) RouterOption {
+	return func(r *Router) {
+		r.threshold = threshold
+		r.cooldown = cooldown
+	}
+}
+
+func NewRouter(backends []Backend, opts ...RouterOption) *Router {
+	r := &Router{
+		threshold: 3,
+		cooldown:  30 * time.Second,
+		now:       time.Now,
+	}
+	for _, b := range backends {
+		if b.Weight <= 0 {
+			b.Weight = 1
+		}
+		r.backends = append(r.backends, &routedBackend{Backend: b})
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// requirements is what a request needs from a backend.
+type requirements struct {
+	model  string
+	vision bool
+	tools  bool
+	tokens int
+}
+
+func (q requirements) String() string {
+	var needs []string
+	if q.model != "" {
+		needs = append(needs, fmt.Sprintf("model %q", q.model))
+	}
+	if q.vision {
+		needs = append(needs, "vision")
+	}
+	if q.tools {
+		needs = append(needs, "tools")
+	}
+	needs = append(needs, fmt.Sprintf("~%d tokens of context", q.tokens))
+	return strings.Join(needs, ", ")
+}
+
+func requirementsFor(ctx context.Context, messages []ChatMessage, opts Options) requirements {
+	q := requirements{
+		model:  ModelFromContext(ctx),
+		tools:  len(opts.Tools) > 0,
+		tokens: opts.MaxTokens + len(opts.SystemPrompt)/4 + len(PersonaFromContext(ctx))/4,
+	}
+	for _, m := range messages {
+		// About four characters per token, plus the chat template's
+		// per-message overhead.
+		q.tokens += len(m.Content)/4 + 4
+		for _, p := range m.Parts {
+			if p.Type == "image_url" {
+				q.vision = true
+			}
+		}
+	}
+	return q
+}
+
+func (b *routedBackend) meets(q requirements) bool {
+	if q.model != "" && b.Name != q.model {
+		return false
+	}
+	if (q.vision && !b.Vision) || (q.tools && !b.Tools) {
+		return false
+	}
+	return b.ContextLength == 0 || q.tokens <= b.ContextLength
+}
+
+// candidates returns the backends to try for q, in order: the first is
+// picked by weight among those that can serve it, and the rest follow as
+// failovers, heaviest first. Backends with an open circuit are left out,
+// except for a single trial request once their cooldown has passed.
+func (r *Router) candidates(q requirements) ([]*routedBackend, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	var capable, usable []*routedBackend
+	total := 0
+	for _, b := range r.backends {
+		if !b.meets(q) {
+			continue
+		}
+		capable = append(capable, b)
+		if !b.openUntil.IsZero() && (now.Before(b.openUntil) || b.trial) {
+			continue
+		}
+		usable = append(usable, b)
+		total += b.Weight
+	}
+
+	if len(capable) == 0 {
+		return nil, fmt.Errorf("%w: none can handle %s", ErrNoBackend, q)
+	}
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("%w: every backend that can handle %s is down", ErrNoBackend, q)
+	}
+
+	// Smooth weighted round-robin: deterministic, and it interleaves
+	// backends instead of sending runs of requests to the heaviest.
+	best := usable[0]
+	for _, b := range usable {
+		b.current += b.Weight
+		if b.current > best.current {
+			best = b
+		}
+	}
+	best.current -= total
+
+	ordered := []*routedBackend{best}
+	rest := make([]*routedBackend, 0, len(usable)-1)
+	for _, b := range usable {
+		if b != best {
+			rest = append(rest, b)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool { return rest[i].Weight > rest[j].Weight })
+	ordered = append(ordered, rest...)
+
+	for _, b := range ordered {
+		if !b.openUntil.IsZero() {
+			b.trial = true
+		}
+	}
+	return ordered, nil
+}
+
+// record updates a backend's circuit after a request or health check.
+func (r *Router) record(b *routedBackend, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b.trial = false
+	if err == nil {
+		if !b.openUntil.IsZero() {
+			slog.Info("LLM backend recovered", "backend", b.Name)
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.lastErr = nil
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if b.failures >= r.threshold || !b.openUntil.IsZero() {
+		if b.openUntil.IsZero() {
+			slog.Warn("LLM backend circuit opened", "backend", b.Name, "failures", b.failures, "error", err)
+		}
+		b.openUntil = r.now().Add(r.cooldown)
+	}
+}
+
+// release clears a trial reservation for a backend that wasn't tried.
+func (r *Router) release(backends []*routedBackend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range backends {
+		b.trial = false
+	}
+}
+
+// backendFault reports whether err means the backend is unwell, as
+// opposed to the request being bad or the caller giving up.
+func backendFault(ctx context.Context, err error) bool {
+	var stop *callerError
+	if ctx.Err() != nil || errors.As(err, &stop) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+	}
+	return true
+}
+
+// route runs call on each candidate backend in turn until one succeeds.
+// retryable is consulted after a failure to decide whether trying another
+// backend is still possible.
+func (r *Router) route(ctx context.Context, q requirements, call func(b *routedBackend) error, retryable func() bool) error {
+	backends, err := r.candidates(q)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for n, b := range backends {
+		err := call(b)
+		if err == nil {
+			r.record(b, nil)
+			r.release(backends[n+1:])
+			return nil
+		}
+		if !backendFault(ctx, err) {
+			// A bad request or the caller giving up says nothing about
+			// whether the backend is up.
+			r.release(backends[n:])
+			return err
+		}
+
+		r.record(b, err)
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		if !retryable() || n == len(backends)-1 {
+			r.release(backends[n+1:])
+			break
+		}
+		slog.Warn("LLM backend failed, trying the next one", "backend", b.Name, "next", backends[n+1].Name, "error", err)
+	}
+	if len(errs) == 1 {
+		return errors.Unwrap(errs[0])
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Router) Chat(ctx context.Context, prompt string) (string, error) {
+	return r.ChatMessages(ctx, []ChatMessage{{Role: "user", Content: prompt}})
+}
+
+func (r *Router) ChatMessages(ctx context.Context, messages []ChatMessage) (string, error) {
+	completion, err := r.Complete(ctx, messages, Options{})
+	if err != nil {
+		return "", err
+	}
+	return completion.Content, nil
+}
+
+func (r *Router) Complete(ctx context.Context, messages []ChatMessage, opts Options) (*Completion, error) {
+	var completion *Completion
+	err := r.route(ctx, requirementsFor(ctx, messages, opts), func(b *routedBackend) error {
+		var err error
+		completion, err = b.Client.Complete(ctx, messages, opts)
+		return err
+	}, func() bool { return true })
+	return completion, err
+}
+
+func (r *Router) ChatStream(ctx context.Context, messages []ChatMessage, onDelta func(delta string) error) (string, error) {
+	completion, err := r.CompleteStream(ctx, messages, Options{}, onDelta)
+	return completion.Content, err
+}
+
+// CompleteStream streams from the chosen backend. It can only fail over
+// until the first content has been passed to onDelta; after that an
+// error is returned with what was streamed so far.
+func (r *Router) CompleteStream(ctx context.Context, messages []ChatMessage, opts Options, onDelta func(delta string) error) (*Completion, error) {
+	completion := &Completion{}
+	streamed := false
+	var deltaErr error
+	err := r.route(ctx, requirementsFor(ctx, messages, opts), func(b *routedBackend) error {
+		var err error
+		completion, err = b.Client.CompleteStream(ctx, messages, opts, func(delta string) error {
+			streamed = true
+			deltaErr = onDelta(delta)
+			return deltaErr
+		})
+		if deltaErr != nil {
+			// The caller stopped reading; don't blame the backend.
+			return &callerError{deltaErr}
+		}
+		return err
+	}, func() bool { return !streamed })
+
+	var stop *callerError
+	if errors.As(err, &stop) {
+		err = stop.err
+	}
+	return completion, err
+}
+
+// callerError wraps an error from the caller's onDelta so the router
+// doesn't count it against the backend.
+type callerError struct{ err error }
+
+func (e *callerError) Error() string { return e.err.Error() }
+func (e *callerError) Unwrap() error { return e.err }
+
+// Usage returns the token usage of every backend combined.
+func (r *Router) Usage() Usage {
+	var total Usage
+	for _, b := range r.backends {
+		total.add(b.Client.Usage())
+	}
+	return total
+}
+
+// HealthCheck probes every backend, updating their circuits, and succeeds
+// if at least one is healthy.
+func (r *Router) HealthCheck(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(r.backends))
+	for n, b := range r.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[n] = b.Client.HealthCheck(ctx)
+			if ctx.Err() == nil {
+				r.record(b, errs[n])
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrNoBackend, errors.Join(errs...))
+}
+
+// StartHealthChecks probes the backends every interval until ctx is done.
+func (r *Router) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkCtx, cancel := context.WithTimeout(ctx, interval)
+				if err := r.HealthCheck(checkCtx); err != nil {
+					slog.Warn("No healthy LLM backend", "error", err)
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// Backends returns the pool's current state, in configuration order.
+func (r *Router) Backends() []BackendStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]BackendStatus, len(r.backends))
+	for n, b := range r.backends {
+		statuses[n] = BackendStatus{
+			Name:         b.Name,
+			Model:        b.Client.model,
+			Healthy:      b.openUntil.IsZero(),
+			Capabilities: b.Capabilities,
+		}
+		if b.lastErr != nil && !b.openUntil.IsZero() {
+			statuses[n].LastError = b.lastErr.Error()
+		}
+	}
+	return statuses
+}
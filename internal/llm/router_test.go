@@ -0,0 +1,359 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeBackend answers chat requests with its name, or with status if that
+// is set to something other than 200. Health checks get status too.
+type fakeBackend struct {
+	name   string
+	status atomic.Int32
+	hits   atomic.Int32
+	server *httptest.Server
+}
+
+func newFakeBackend(t *testing.T, name string) *fakeBackend {
+	t.Helper()
+	f := &fakeBackend{name: name}
+	f.status.Store(http.StatusOK)
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := int(f.status.Load())
+		if r.URL.Path == "/health" {
+			w.WriteHeader(status)
+			return
+		}
+		f.hits.Add(1)
+		if status != http.StatusOK {
+			http.Error(w, "unavailable", status)
+			return
+		}
+
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if !req.Stream {
+			json.NewEncoder(w).Encode(ChatResponse{
+				Choices: []ChatChoice{{Message: ChatMessage{Role: "assistant", Content: f.name}}},
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		data, _ := json.Marshal(ChatStreamChunk{
+			Choices: []ChatStreamChoice{{Delta: ChatMessage{Content: f.name}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeBackend) backend(weight int, caps Capabilities) Backend {
+	return Backend{
+		Name:         f.name,
+		Client:       NewClient(f.server.URL, f.name+"-model", 5*time.Second),
+		Weight:       weight,
+		Capabilities: caps,
+	}
+}
+
+var allCaps = Capabilities{Vision: true, Tools: true}
+
+func TestRouter_FailsOverOnServerError(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	a.status.Store(http.StatusServiceUnavailable)
+	router := NewRouter([]Backend{a.backend(2, allCaps), b.backend(1, allCaps)})
+
+	content, err := router.Chat(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if content != "b" {
+		t.Errorf("Expected failover to b, got %q", content)
+	}
+	if a.hits.Load() != 1 {
+		t.Errorf("Expected a to be tried first, got %d requests", a.hits.Load())
+	}
+}
+
+func TestRouter_DoesNotFailOverOnClientError(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	a.status.Store(http.StatusBadRequest)
+	router := NewRouter([]Backend{a.backend(2, allCaps), b.backend(1, allCaps)})
+
+	_, err := router.Chat(context.Background(), "hi")
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the 400 to be returned, got %v", err)
+	}
+	if b.hits.Load() != 0 {
+		t.Errorf("Expected no failover for a bad request, got %d requests to b", b.hits.Load())
+	}
+	if !router.Backends()[0].Healthy {
+		t.Error("Expected a bad request not to count against the backend")
+	}
+}
+
+func TestRouter_CircuitBreaker(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	a.status.Store(http.StatusInternalServerError)
+	router := NewRouter([]Backend{a.backend(100, allCaps), b.backend(1, allCaps)}, WithCircuitBreaker(2, time.Minute))
+	now := time.Unix(1700000000, 0)
+	router.now = func() time.Time { return now }
+
+	for range 4 {
+		if _, err := router.Chat(context.Background(), "hi"); err != nil {
+			t.Fatalf("Chat failed: %v", err)
+		}
+	}
+	if a.hits.Load() != 2 {
+		t.Errorf("Expected the circuit to open after 2 failures, got %d requests to a", a.hits.Load())
+	}
+	if status := router.Backends()[0]; status.Healthy || !strings.Contains(status.LastError, "500") {
+		t.Errorf("Expected a to be reported down with its error, got %+v", status)
+	}
+
+	// After the cooldown one trial request is let through, and a success
+	// closes the circuit.
+	now = now.Add(time.Minute)
+	a.status.Store(http.StatusOK)
+	content, err := router.Chat(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if content != "a" {
+		t.Errorf("Expected the trial request to go to a, got %q", content)
+	}
+	if !router.Backends()[0].Healthy {
+		t.Error("Expected a's circuit to close after a successful trial")
+	}
+}
+
+func TestRouter_FailedTrialReopensCircuit(t *testing.T) {
+	a := newFakeBackend(t, "a")
+	a.status.Store(http.StatusInternalServerError)
+	router := NewRouter([]Backend{a.backend(1, allCaps)}, WithCircuitBreaker(1, time.Minute))
+	now := time.Unix(1700000000, 0)
+	router.now = func() time.Time { return now }
+
+	router.Chat(context.Background(), "hi")
+	if _, err := router.Chat(context.Background(), "hi"); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("Expected ErrNoBackend while the circuit is open, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	router.Chat(context.Background(), "hi")
+	if _, err := router.Chat(context.Background(), "hi"); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("Expected a failed trial to reopen the circuit, got %v", err)
+	}
+	if a.hits.Load() != 2 {
+		t.Errorf("Expected 2 requests to a, got %d", a.hits.Load())
+	}
+}
+
+func TestRouter_RoutesByCapabilities(t *testing.T) {
+	small, vision := newFakeBackend(t, "small"), newFakeBackend(t, "vision")
+	router := NewRouter([]Backend{
+		small.backend(10, Capabilities{ContextLength: 100, Tools: true}),
+		vision.backend(1, Capabilities{ContextLength: 10000, Vision: true}),
+	})
+
+	image := []ChatMessage{{Role: "user", Content: "what's this?", Parts: []ContentPart{ImagePart("image/png", []byte("png"))}}}
+	long := []ChatMessage{{Role: "user", Content: strings.Repeat("word ", 200)}}
+	tools := Options{Tools: []Tool{{Type: "function", Function: FunctionDefinition{Name: "lookup"}}}}
+
+	tests := []struct {
+		name     string
+		messages []ChatMessage
+		opts     Options
+		want     string
+	}{
+		{"image needs vision", image, Options{}, "vision"},
+		{"long prompt needs context", long, Options{}, "vision"},
+		{"reply budget counts", []ChatMessage{{Role: "user", Content: "hi"}}, Options{MaxTokens: 500}, "vision"},
+		{"tools", []ChatMessage{{Role: "user", Content: "hi"}}, tools, "small"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completion, err := router.Complete(context.Background(), tt.messages, tt.opts)
+			if err != nil {
+				t.Fatalf("Complete failed: %v", err)
+			}
+			if completion.Content != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, completion.Content)
+			}
+		})
+	}
+
+	_, err := router.Complete(context.Background(), image, tools)
+	if !errors.Is(err, ErrNoBackend) || !strings.Contains(err.Error(), "vision, tools") {
+		t.Errorf("Expected ErrNoBackend naming the needs, got %v", err)
+	}
+}
+
+func TestRouter_SpreadsByWeight(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	router := NewRouter([]Backend{a.backend(3, allCaps), b.backend(1, allCaps)})
+
+	var got []string
+	for range 8 {
+		content, err := router.Chat(context.Background(), "hi")
+		if err != nil {
+			t.Fatalf("Chat failed: %v", err)
+		}
+		got = append(got, content)
+	}
+	if want := "a a b a a a b a"; strings.Join(got, " ") != want {
+		t.Errorf("Expected %q, got %q", want, strings.Join(got, " "))
+	}
+}
+
+func TestRouter_NamedModel(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	router := NewRouter([]Backend{a.backend(10, allCaps), b.backend(1, allCaps)}, WithCircuitBreaker(1, time.Minute))
+
+	ctx := WithModel(context.Background(), "b")
+	for range 3 {
+		content, err := router.Chat(ctx, "hi")
+		if err != nil {
+			t.Fatalf("Chat failed: %v", err)
+		}
+		if content != "b" {
+			t.Errorf("Expected the named model, got %q", content)
+		}
+	}
+
+	// A named model is never swapped for another.
+	b.status.Store(http.StatusInternalServerError)
+	if _, err := router.Chat(ctx, "hi"); err == nil {
+		t.Error("Expected the named model's error")
+	}
+	if _, err := router.Chat(ctx, "hi"); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("Expected ErrNoBackend once b is down, got %v", err)
+	}
+	if a.hits.Load() != 0 {
+		t.Errorf("Expected no requests to a, got %d", a.hits.Load())
+	}
+
+	if _, err := router.Chat(WithModel(context.Background(), "c"), "hi"); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("Expected ErrNoBackend for an unknown model, got %v", err)
+	}
+}
+
+func TestRouter_HealthChecks(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	a.status.Store(http.StatusServiceUnavailable)
+	router := NewRouter([]Backend{a.backend(10, allCaps), b.backend(1, allCaps)}, WithCircuitBreaker(1, time.Hour))
+
+	if err := router.HealthCheck(context.Background()); err != nil {
+		t.Errorf("Expected the pool to be healthy while b is up, got %v", err)
+	}
+	if router.Backends()[0].Healthy {
+		t.Error("Expected a failed health check to open a's circuit")
+	}
+	content, _ := router.Chat(context.Background(), "hi")
+	if content != "b" || a.hits.Load() != 0 {
+		t.Errorf("Expected requests to skip a, got %q and %d requests to a", content, a.hits.Load())
+	}
+
+	// A passing check brings a back without waiting for the cooldown.
+	a.status.Store(http.StatusOK)
+	b.status.Store(http.StatusServiceUnavailable)
+	router.HealthCheck(context.Background())
+	if statuses := router.Backends(); !statuses[0].Healthy || statuses[1].Healthy {
+		t.Errorf("Expected a up and b down, got %+v", statuses)
+	}
+
+	a.status.Store(http.StatusServiceUnavailable)
+	if err := router.HealthCheck(context.Background()); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("Expected ErrNoBackend with every backend down, got %v", err)
+	}
+}
+
+func TestRouter_StreamFailsOverBeforeFirstDelta(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	a.status.Store(http.StatusBadGateway)
+	router := NewRouter([]Backend{a.backend(2, allCaps), b.backend(1, allCaps)})
+
+	var streamed strings.Builder
+	content, err := router.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	if content != "b" || streamed.String() != "b" {
+		t.Errorf("Expected b's reply, got %q streamed as %q", content, streamed.String())
+	}
+}
+
+func TestRouter_StreamDoesNotFailOverAfterDeltas(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		data, _ := json.Marshal(ChatStreamChunk{
+			Choices: []ChatStreamChoice{{Delta: ChatMessage{Content: "Hel"}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		fmt.Fprint(w, "data: {\"error\":{\"message\":\"out of memory\"}}\n\n")
+	}))
+	defer broken.Close()
+	b := newFakeBackend(t, "b")
+
+	router := NewRouter([]Backend{
+		{Name: "broken", Client: NewClient(broken.URL, "m", 5*time.Second), Weight: 2, Capabilities: allCaps},
+		b.backend(1, allCaps),
+	})
+
+	var streamed strings.Builder
+	content, err := router.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "out of memory") {
+		t.Errorf("Expected the stream error, got %v", err)
+	}
+	if content != "Hel" || streamed.String() != "Hel" {
+		t.Errorf("Expected the partial reply only, got %q streamed as %q", content, streamed.String())
+	}
+	if b.hits.Load() != 0 {
+		t.Errorf("Expected no failover after content was streamed, got %d requests to b", b.hits.Load())
+	}
+}
+
+func TestRouter_StreamCallbackErrorIsNotBackendFault(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	router := NewRouter([]Backend{a.backend(2, allCaps), b.backend(1, allCaps)}, WithCircuitBreaker(1, time.Minute))
+
+	stop := errors.New("message deleted")
+	_, err := router.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}, func(string) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected the callback's error, got %v", err)
+	}
+	if b.hits.Load() != 0 || !router.Backends()[0].Healthy {
+		t.Errorf("Expected no failover and a still healthy, got %d requests to b and %+v", b.hits.Load(), router.Backends()[0])
+	}
+}
+
+func TestRouter_UsageSumsBackends(t *testing.T) {
+	a, b := newFakeBackend(t, "a"), newFakeBackend(t, "b")
+	router := NewRouter([]Backend{a.backend(1, allCaps), b.backend(1, allCaps)})
+	router.backends[0].Client.usage.add(Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15})
+	router.backends[1].Client.usage.add(Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3})
+
+	if got := router.Usage(); got.TotalTokens != 18 || got.PromptTokens != 11 {
+		t.Errorf("Expected summed usage, got %+v", got)
+	}
+}
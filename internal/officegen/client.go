@@ -1,26 +1,34 @@
 package officegen
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
 	"strings"
+
+	"github.com/josh/discord-bot/internal/llm"
 )
 
+// structuredOptions ask for a JSON object at a lower temperature so the
+// outline parses reliably. cleanJSONResponse is still applied for servers
+// that ignore response_format.
+var structuredOptions = llm.Options{
+	JSON:        true,
+	Temperature: llm.Temperature(0.4),
+}
+
 type Client struct {
-	llmURL string
+	llm llm.Completer
 }
 
-func NewClient(llmURL string) *Client {
+func NewClient(llmClient llm.Completer) *Client {
 	return &Client{
-		llmURL: llmURL,
+		llm: llmClient,
 	}
 }
 
-func (c *Client) GenerateDocument(prompt string, targetPages int, includeImages bool) (*DocumentContent, error) {
+func (c *Client) GenerateDocument(ctx context.Context, prompt string, targetPages int, includeImages bool) (*DocumentContent, error) {
 	targetWords := targetPages * 500
 	if targetWords == 0 {
 		targetWords = 1500
@@ -49,7 +57,7 @@ Return in this exact format:
   ]
 }`, prompt, targetWords, imageDirective)
 
-	response, err := c.callLLM(systemPrompt)
+	response, err := c.callLLM(ctx, systemPrompt, structuredOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +78,7 @@ Return in this exact format:
 	return &content, nil
 }
 
-func (c *Client) GenerateSpreadsheet(prompt string) (*SpreadsheetContent, error) {
+func (c *Client) GenerateSpreadsheet(ctx context.Context, prompt string) (*SpreadsheetContent, error) {
 	systemPrompt := fmt.Sprintf(`Generate spreadsheet data about: %s
 
 Requirements:
@@ -95,7 +103,7 @@ Return in this exact format:
   ]
 }`, prompt)
 
-	response, err := c.callLLM(systemPrompt)
+	response, err := c.callLLM(ctx, systemPrompt, structuredOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +128,7 @@ Return in this exact format:
 	return &content, nil
 }
 
-func (c *Client) GeneratePresentation(prompt string, targetSlides int) (*PresentationContent, error) {
+func (c *Client) GeneratePresentation(ctx context.Context, prompt string, targetSlides int) (*PresentationContent, error) {
 	if targetSlides == 0 {
 		targetSlides = 5
 	}
@@ -148,7 +156,7 @@ Return in this exact format:
   ]
 }`, targetSlides, prompt, targetSlides)
 
-	response, err := c.callLLM(systemPrompt)
+	response, err := c.callLLM(ctx, systemPrompt, structuredOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -191,58 +199,14 @@ func cleanJSONResponse(response string) string {
 	return strings.TrimSpace(response)
 }
 
-func (c *Client) GenerateText(prompt string) (string, error) {
-	return c.callLLM(prompt)
+func (c *Client) GenerateText(ctx context.Context, prompt string) (string, error) {
+	return c.callLLM(ctx, prompt, llm.Options{})
 }
 
-func (c *Client) callLLM(prompt string) (string, error) {
-	requestBody := map[string]any{
-		"model": "llama",
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := http.Post(c.llmURL+"/v1/chat/completions", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+func (c *Client) callLLM(ctx context.Context, prompt string, opts llm.Options) (string, error) {
+	completion, err := c.llm.Complete(ctx, []llm.ChatMessage{{Role: "user", Content: prompt}}, opts)
 	if err != nil {
 		return "", err
 	}
-
-	var response map[string]any
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", err
-	}
-
-	choices, ok := response["choices"].([]any)
-	if !ok || len(choices) == 0 {
-		return "", fmt.Errorf("invalid response from AI server")
-	}
-
-	choice, ok := choices[0].(map[string]any)
-	if !ok {
-		return "", fmt.Errorf("invalid response structure")
-	}
-
-	message, ok := choice["message"].(map[string]any)
-	if !ok {
-		return "", fmt.Errorf("invalid message structure")
-	}
-
-	content, ok := message["content"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid content")
-	}
-
-	return content, nil
+	return completion.Content, nil
 }
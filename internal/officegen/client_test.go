@@ -1,8 +1,16 @@
 package officegen
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/josh/discord-bot/internal/llm"
 )
 
 func TestCleanJSONResponse(t *testing.T) {
@@ -212,3 +220,39 @@ func TestImagePromptsJSON_Parsing(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_GenerateTextCancellationAbortsSlowServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Consume the body so the server notices the client going away.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(llm.NewClient(server.URL, "llama", time.Minute))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GenerateText(ctx, "hello")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GenerateText took %s after cancellation, expected it to abort promptly", elapsed)
+	}
+}
+
+func TestPDFExporter_CancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewPDFExporter().ConvertHTMLToPDF(ctx, "<html></html>")
+	if err == nil {
+		t.Fatal("Expected error converting with a cancelled context")
+	}
+}
@@ -1,10 +1,13 @@
 package officegen
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"regexp"
 	"strings"
+
+	"github.com/josh/discord-bot/internal/jobs"
 )
 
 type DocumentGenerator struct {
@@ -12,6 +15,7 @@ type DocumentGenerator struct {
 	imageGen  *ImageGenerator
 	htmlGen   *HTMLGenerator
 	pdfExport *PDFExporter
+	ooxml     *OOXMLWriter
 }
 
 func NewDocumentGenerator(llmClient *Client, imageGen *ImageGenerator) *DocumentGenerator {
@@ -20,13 +24,24 @@ func NewDocumentGenerator(llmClient *Client, imageGen *ImageGenerator) *Document
 		imageGen:  imageGen,
 		htmlGen:   NewHTMLGenerator(),
 		pdfExport: NewPDFExporter(),
+		ooxml:     NewOOXMLWriter(),
 	}
 }
 
-func (dg *DocumentGenerator) Generate(req *DocumentRequest) (*GeneratedDocument, error) {
+func (dg *DocumentGenerator) Generate(ctx context.Context, req *DocumentRequest) (*GeneratedDocument, error) {
 	slog.Info("Generating document", "prompt", req.Prompt, "target_pages", req.TargetPages)
 
-	content, err := dg.llmClient.GenerateDocument(req.Prompt, req.TargetPages, true)
+	format, err := outputFormat(req.Format, FormatDOCX)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := jobs.Acquire(ctx, jobs.BackendLLM, "Writing content")
+	if err != nil {
+		return nil, err
+	}
+	content, err := dg.llmClient.GenerateDocument(ctx, req.Prompt, req.TargetPages, true)
+	release()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate content: %w", err)
 	}
@@ -40,12 +55,12 @@ func (dg *DocumentGenerator) Generate(req *DocumentRequest) (*GeneratedDocument,
 		imageCount := min(3, len(content.Sections)/2+1)
 
 		slog.Info("Generating image prompts", "count", imageCount)
-		prompts, err := dg.imageGen.GenerateImagePrompts(content, imageCount)
+		prompts, err := dg.imageGen.GenerateImagePrompts(ctx, content, imageCount)
 		if err != nil {
 			slog.Warn("Failed to generate image prompts", "error", err)
 		} else {
 			slog.Info("Generating images", "prompts", prompts)
-			images, err = dg.imageGen.GenerateImages(prompts)
+			images, err = dg.imageGen.GenerateImages(ctx, prompts)
 			if err != nil {
 				slog.Warn("Failed to generate images", "error", err)
 			} else {
@@ -54,23 +69,51 @@ func (dg *DocumentGenerator) Generate(req *DocumentRequest) (*GeneratedDocument,
 		}
 	}
 
-	html, err := dg.htmlGen.GenerateDocumentHTML(content, images)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate HTML: %w", err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	pdfData, err := dg.pdfExport.ConvertHTMLToPDF(html)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert to PDF: %w", err)
+	var data []byte
+	if format == FormatDOCX {
+		data, err = dg.ooxml.WriteDocumentDOCX(content, images)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write DOCX: %w", err)
+		}
+	} else {
+		html, err := dg.htmlGen.GenerateDocumentHTML(content, images)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate HTML: %w", err)
+		}
+
+		data, err = dg.pdfExport.ConvertHTMLToPDF(ctx, html)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert to PDF: %w", err)
+		}
 	}
 
-	filename := sanitizeFilename(content.Title) + ".pdf"
-	slog.Info("Document generated", "filename", filename, "size", len(pdfData))
+	doc := newGeneratedDocument(content.Title, format, data)
+	slog.Info("Document generated", "filename", doc.Filename, "size", len(data))
+	return doc, nil
+}
 
+// outputFormat returns the format to write: PDF unless the content's own
+// Office format is asked for.
+func outputFormat(requested, native Format) (Format, error) {
+	switch requested {
+	case "", FormatPDF:
+		return FormatPDF, nil
+	case native:
+		return native, nil
+	}
+	return "", fmt.Errorf("can't write this as %s, only as %s or %s", requested, FormatPDF, native)
+}
+
+func newGeneratedDocument(title string, format Format, data []byte) *GeneratedDocument {
 	return &GeneratedDocument{
-		Data:     pdfData,
-		Filename: filename,
-	}, nil
+		Data:        data,
+		Filename:    sanitizeFilename(title) + "." + string(format),
+		ContentType: format.ContentType(),
+	}
 }
 
 func sanitizeFilename(name string) string {
@@ -0,0 +1,103 @@
+package officegen
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+const (
+	// A Letter page with inch margins leaves 6.5in by 9in for content.
+	docxImageMaxWidth  = 6 * 914400
+	docxImageMaxHeight = 4 * 914400
+)
+
+// WriteDocumentDOCX writes a Word document. As in the PDF, image i goes
+// after section i.
+func (w *OOXMLWriter) WriteDocumentDOCX(content *DocumentContent, images [][]byte) ([]byte, error) {
+	pkg := newOOXMLPackage()
+	pkg.addRels("_rels/.rels", []relationship{
+		{"rId1", relOfficeDocument, "word/document.xml"},
+		{"rId2", relCoreProperties, "docProps/core.xml"},
+	})
+	pkg.addCoreProperties(content.Title)
+
+	rels := []relationship{{"rId1", relStyles, "styles.xml"}}
+	var body strings.Builder
+	docxParagraph(&body, "Title", content.Title)
+	for idx, section := range content.Sections {
+		if section.Heading != "" {
+			docxParagraph(&body, "Heading1", section.Heading)
+		}
+		if len(section.Paragraphs) > 0 {
+			for _, p := range section.Paragraphs {
+				docxParagraph(&body, "", p)
+			}
+		} else if section.Content != "" {
+			docxParagraph(&body, "", section.Content)
+		}
+
+		if idx >= len(images) || len(images[idx]) == 0 {
+			continue
+		}
+		img, err := pkg.addImage("word", images[idx])
+		if err != nil {
+			slog.Warn("Skipping image in DOCX", "index", idx, "error", err)
+			continue
+		}
+		id := fmt.Sprintf("rId%d", len(rels)+1)
+		rels = append(rels, relationship{id, relImage, "media/" + img.name})
+		docxPicture(&body, id, pkg.images, img)
+	}
+
+	pkg.addRels("word/_rels/document.xml.rels", rels)
+	pkg.addXML("word/document.xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml",
+		`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="`+nsRelationships+`"`+
+			` xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing" xmlns:a="`+nsDrawingML+`" xmlns:pic="`+nsPicture+`">`+
+			`<w:body>`+body.String()+
+			`<w:sectPr><w:pgSz w:w="12240" w:h="15840"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="720" w:footer="720" w:gutter="0"/></w:sectPr>`+
+			`</w:body></w:document>`)
+	pkg.addXML("word/styles.xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml", docxStyles)
+	return pkg.bytes()
+}
+
+// docxParagraph writes a paragraph, with line breaks kept.
+func docxParagraph(b *strings.Builder, style, text string) {
+	b.WriteString(`<w:p>`)
+	if style != "" {
+		fmt.Fprintf(b, `<w:pPr><w:pStyle w:val="%s"/></w:pPr>`, style)
+	}
+	b.WriteString(`<w:r>`)
+	for n, line := range strings.Split(text, "\n") {
+		if n > 0 {
+			b.WriteString(`<w:br/>`)
+		}
+		fmt.Fprintf(b, `<w:t xml:space="preserve">%s</w:t>`, escapeXML(line))
+	}
+	b.WriteString(`</w:r></w:p>`)
+}
+
+// docxPicture writes a centred paragraph holding an embedded image.
+func docxPicture(b *strings.Builder, relID string, id int, img embeddedImage) {
+	cx, cy := img.fit(docxImageMaxWidth, docxImageMaxHeight)
+	fmt.Fprintf(b, `<w:p><w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:drawing>`+
+		`<wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%[3]d" cy="%[4]d"/><wp:docPr id="%[2]d" name="Picture %[2]d"/>`+
+		`<a:graphic><a:graphicData uri="`+nsPicture+`"><pic:pic>`+
+		`<pic:nvPicPr><pic:cNvPr id="%[2]d" name="%[5]s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%[1]s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%[3]d" cy="%[4]d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>`,
+		relID, id, cx, cy, img.name)
+}
+
+// docxStyles defines the styles the document uses, so headings show in
+// Word's navigation pane and can be restyled together.
+const docxStyles = `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:cs="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/>` +
+	`<w:pPr><w:spacing w:after="320"/></w:pPr><w:rPr><w:b/><w:color w:val="1F2A44"/><w:sz w:val="52"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/>` +
+	`<w:pPr><w:keepNext/><w:spacing w:before="360" w:after="120"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:color w:val="2F5496"/><w:sz w:val="32"/></w:rPr></w:style>` +
+	`</w:styles>`
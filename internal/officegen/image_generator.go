@@ -1,26 +1,29 @@
 package officegen
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 
 	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/jobs"
+	"github.com/josh/discord-bot/internal/llm"
 )
 
 type ImageGenerator struct {
-	sdClient  *imagegen.Client
+	sdClient  imagegen.ImageBackend
 	llmClient *Client
 }
 
-func NewImageGenerator(sdClient *imagegen.Client, llmClient *Client) *ImageGenerator {
+func NewImageGenerator(sdClient imagegen.ImageBackend, llmClient *Client) *ImageGenerator {
 	return &ImageGenerator{
 		sdClient:  sdClient,
 		llmClient: llmClient,
 	}
 }
 
-func (ig *ImageGenerator) GenerateImagePrompts(content any, count int) ([]string, error) {
+func (ig *ImageGenerator) GenerateImagePrompts(ctx context.Context, content any, count int) ([]string, error) {
 	if count == 0 {
 		count = 2
 	}
@@ -44,7 +47,14 @@ Format: ["prompt1", "prompt2", ...]
 
 Content: %s`, count, string(contentJSON))
 
-	response, err := ig.llmClient.GenerateText(prompt)
+	release, err := jobs.Acquire(ctx, jobs.BackendLLM, "Planning illustrations")
+	if err != nil {
+		return nil, err
+	}
+	// Image prompts are read by Stable Diffusion, not people, so the
+	// persona's tone would only get in the way.
+	response, err := ig.llmClient.GenerateText(llm.WithoutPersona(ctx), prompt)
+	release()
 	if err != nil {
 		return nil, err
 	}
@@ -67,12 +77,16 @@ Content: %s`, count, string(contentJSON))
 	return prompts, nil
 }
 
-func (ig *ImageGenerator) GenerateImages(prompts []string) ([][]byte, error) {
-	if err := ig.sdClient.HealthCheck(); err != nil {
+func (ig *ImageGenerator) GenerateImages(ctx context.Context, prompts []string) ([][]byte, error) {
+	if err := ig.sdClient.HealthCheck(ctx); err != nil {
 		slog.Warn("SD WebUI not available, skipping image generation", "error", err)
 		return nil, fmt.Errorf("image generation service unavailable: %w", err)
 	}
 
+	// Illustrations wait behind /imagine requests; nobody is watching them
+	// come in.
+	ctx = imagegen.WithPriority(ctx, imagegen.PriorityBackground)
+
 	var images [][]byte
 
 	for i, prompt := range prompts {
@@ -85,8 +99,15 @@ func (ig *ImageGenerator) GenerateImages(prompts []string) ([][]byte, error) {
 			Steps:  20,
 		}
 
-		resp, err := ig.sdClient.GenerateImage(req)
+		stage := fmt.Sprintf("Generating image %d/%d", i+1, len(prompts))
+		jobs.SetStage(ctx, stage)
+		resp, err := ig.sdClient.GenerateImage(imagegen.WithProgress(ctx, func(p imagegen.Progress) {
+			jobs.SetStage(ctx, p.Describe(stage))
+		}), req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return images, ctx.Err()
+			}
 			slog.Warn("Failed to generate image", "prompt", prompt, "error", err)
 			continue
 		}
@@ -96,7 +117,7 @@ func (ig *ImageGenerator) GenerateImages(prompts []string) ([][]byte, error) {
 			continue
 		}
 
-		imageData, err := ig.sdClient.DecodeImage(resp.Images[0])
+		imageData, err := imagegen.DecodeImage(resp.Images[0])
 		if err != nil {
 			slog.Warn("Failed to decode image", "prompt", prompt, "error", err)
 			continue
@@ -106,5 +127,8 @@ func (ig *ImageGenerator) GenerateImages(prompts []string) ([][]byte, error) {
 		slog.Info("Image generated successfully", "index", i+1, "size", len(imageData))
 	}
 
+	if len(images) < len(prompts) {
+		slog.Warn("Some images could not be generated", "generated", len(images), "requested", len(prompts))
+	}
 	return images, nil
 }
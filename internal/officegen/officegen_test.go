@@ -1,14 +1,18 @@
 package officegen
 
 import (
+	"context"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/josh/discord-bot/internal/llm"
 )
 
 func TestDocumentGenerator_BasicGeneration(t *testing.T) {
 	t.Skip("Integration test - requires LLM server")
 
-	client := NewClient("http://localhost:8081")
+	client := NewClient(llm.NewClient("http://localhost:8081", "llama", time.Minute))
 	gen := NewDocumentGenerator(client, nil)
 
 	req := &DocumentRequest{
@@ -17,7 +21,7 @@ func TestDocumentGenerator_BasicGeneration(t *testing.T) {
 		TargetPages: 1,
 	}
 
-	result, err := gen.Generate(req)
+	result, err := gen.Generate(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Generate failed: %v", err)
 	}
@@ -38,7 +42,7 @@ func TestDocumentGenerator_BasicGeneration(t *testing.T) {
 func TestSpreadsheetGenerator_BasicGeneration(t *testing.T) {
 	t.Skip("Integration test - requires LLM server")
 
-	client := NewClient("http://localhost:8081")
+	client := NewClient(llm.NewClient("http://localhost:8081", "llama", time.Minute))
 	gen := NewSpreadsheetGenerator(client, nil)
 
 	req := &SpreadsheetRequest{
@@ -47,7 +51,7 @@ func TestSpreadsheetGenerator_BasicGeneration(t *testing.T) {
 		TargetPages: 1,
 	}
 
-	result, err := gen.Generate(req)
+	result, err := gen.Generate(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Generate failed: %v", err)
 	}
@@ -68,7 +72,7 @@ func TestSpreadsheetGenerator_BasicGeneration(t *testing.T) {
 func TestPresentationGenerator_BasicGeneration(t *testing.T) {
 	t.Skip("Integration test - requires LLM server")
 
-	client := NewClient("http://localhost:8081")
+	client := NewClient(llm.NewClient("http://localhost:8081", "llama", time.Minute))
 	gen := NewPresentationGenerator(client, nil)
 
 	req := &PresentationRequest{
@@ -77,7 +81,7 @@ func TestPresentationGenerator_BasicGeneration(t *testing.T) {
 		TargetSlides: 3,
 	}
 
-	result, err := gen.Generate(req)
+	result, err := gen.Generate(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Generate failed: %v", err)
 	}
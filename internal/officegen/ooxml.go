@@ -0,0 +1,174 @@
+package officegen
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+	"time"
+)
+
+// OOXMLWriter writes generated content as Office Open XML files: DOCX,
+// XLSX and PPTX that Word, Excel, PowerPoint and LibreOffice can edit.
+type OOXMLWriter struct{}
+
+func NewOOXMLWriter() *OOXMLWriter {
+	return &OOXMLWriter{}
+}
+
+const (
+	nsRelationships = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsDrawingML     = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	nsPicture       = "http://schemas.openxmlformats.org/drawingml/2006/picture"
+
+	relOfficeDocument = nsRelationships + "/officeDocument"
+	relCoreProperties = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties"
+	relImage          = nsRelationships + "/image"
+	relStyles         = nsRelationships + "/styles"
+	relTheme          = nsRelationships + "/theme"
+	relWorksheet      = nsRelationships + "/worksheet"
+	relSlide          = nsRelationships + "/slide"
+	relSlideLayout    = nsRelationships + "/slideLayout"
+	relSlideMaster    = nsRelationships + "/slideMaster"
+
+	// emuPerPixel converts pixels at 96 DPI to the English Metric Units
+	// drawings are measured in.
+	emuPerPixel = 9525
+)
+
+// ooxmlPackage collects the parts of an OOXML file, and the content type
+// of each, before zipping them.
+type ooxmlPackage struct {
+	parts     []ooxmlPart
+	defaults  map[string]string
+	overrides [][2]string
+	images    int
+}
+
+type ooxmlPart struct {
+	name string
+	data []byte
+}
+
+type relationship struct {
+	id, kind, target string
+}
+
+func newOOXMLPackage() *ooxmlPackage {
+	return &ooxmlPackage{defaults: map[string]string{
+		"rels": "application/vnd.openxmlformats-package.relationships+xml",
+		"xml":  "application/xml",
+	}}
+}
+
+// add puts a part in the package. Parts whose type isn't known from their
+// extension need a contentType.
+func (p *ooxmlPackage) add(name, contentType string, data []byte) {
+	p.parts = append(p.parts, ooxmlPart{name: name, data: data})
+	if contentType != "" {
+		p.overrides = append(p.overrides, [2]string{"/" + name, contentType})
+	}
+}
+
+func (p *ooxmlPackage) addXML(name, contentType, body string) {
+	p.add(name, contentType, []byte(xml.Header+body))
+}
+
+func (p *ooxmlPackage) addRels(name string, rels []relationship) {
+	var b strings.Builder
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for _, r := range rels {
+		fmt.Fprintf(&b, `<Relationship Id="%s" Type="%s" Target="%s"/>`, r.id, r.kind, escapeXML(r.target))
+	}
+	b.WriteString(`</Relationships>`)
+	p.addXML(name, "", b.String())
+}
+
+// addCoreProperties records the title, which Office shows in the file's
+// properties and some viewers use as the window title.
+func (p *ooxmlPackage) addCoreProperties(title string) {
+	p.addXML("docProps/core.xml", "application/vnd.openxmlformats-package.core-properties+xml", fmt.Sprintf(
+		`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+			`<dc:title>%s</dc:title><dc:creator>Discord Bot</dc:creator>`+
+			`<dcterms:created xsi:type="dcterms:W3CDTF">%s</dcterms:created>`+
+			`</cp:coreProperties>`,
+		escapeXML(title), time.Now().UTC().Format(time.RFC3339)))
+}
+
+// embeddedImage is an image stored in the package's media folder.
+type embeddedImage struct {
+	name          string
+	width, height int
+}
+
+// addImage stores an image under dir/media and returns its name there
+// and size in pixels.
+func (p *ooxmlPackage) addImage(dir string, data []byte) (embeddedImage, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return embeddedImage{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	ext := map[string]string{"png": "png", "jpeg": "jpeg"}[format]
+	if ext == "" {
+		return embeddedImage{}, fmt.Errorf("can't embed %s images", format)
+	}
+	p.defaults[ext] = "image/" + ext
+	p.images++
+	name := fmt.Sprintf("image%d.%s", p.images, ext)
+	p.add(dir+"/media/"+name, "", data)
+	return embeddedImage{name: name, width: cfg.Width, height: cfg.Height}, nil
+}
+
+// fit scales the image to fit in a box, in EMUs, keeping its shape.
+func (img embeddedImage) fit(maxWidth, maxHeight int64) (int64, int64) {
+	width, height := int64(img.width)*emuPerPixel, int64(img.height)*emuPerPixel
+	if width > maxWidth {
+		height, width = height*maxWidth/width, maxWidth
+	}
+	if height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	return width, height
+}
+
+// bytes zips the package, with the content types first as readers expect.
+func (p *ooxmlPackage) bytes() ([]byte, error) {
+	var types strings.Builder
+	types.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	for _, ext := range []string{"rels", "xml", "png", "jpeg"} {
+		if contentType, ok := p.defaults[ext]; ok {
+			fmt.Fprintf(&types, `<Default Extension="%s" ContentType="%s"/>`, ext, contentType)
+		}
+	}
+	for _, o := range p.overrides {
+		fmt.Fprintf(&types, `<Override PartName="%s" ContentType="%s"/>`, o[0], o[1])
+	}
+	types.WriteString(`</Types>`)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	parts := append([]ooxmlPart{{name: "[Content_Types].xml", data: []byte(xml.Header + types.String())}}, p.parts...)
+	for _, part := range parts {
+		w, err := zw.Create(part.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", part.name, err)
+		}
+		if _, err := w.Write(part.data); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish package: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
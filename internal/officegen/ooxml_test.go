@@ -0,0 +1,210 @@
+package officegen
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+// unzipParts opens an OOXML file and returns its parts, checking that
+// every XML part is well-formed.
+func unzipParts(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Output is not a zip file: %v", err)
+	}
+	if zr.File[0].Name != "[Content_Types].xml" {
+		t.Errorf("Expected the content types first, got %s", zr.File[0].Name)
+	}
+
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(body)
+
+		if strings.HasSuffix(f.Name, ".xml") || strings.HasSuffix(f.Name, ".rels") {
+			dec := xml.NewDecoder(bytes.NewReader(body))
+			for {
+				if _, err := dec.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s is not well-formed: %v", f.Name, err)
+				}
+			}
+		}
+	}
+	return parts
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriteDocumentDOCX(t *testing.T) {
+	content := &DocumentContent{
+		Title: "Coffee & Tea",
+		Sections: []DocumentSection{
+			{Heading: "Beans", Paragraphs: []string{"Arabica <smooth>", "Robusta"}},
+			{Heading: "Leaves", Content: "Green and black"},
+		},
+	}
+	// The second image failed to generate.
+	data, err := NewOOXMLWriter().WriteDocumentDOCX(content, [][]byte{testPNG(t, 1024, 512), nil})
+	if err != nil {
+		t.Fatalf("WriteDocumentDOCX failed: %v", err)
+	}
+	parts := unzipParts(t, data)
+
+	doc := parts["word/document.xml"]
+	for _, want := range []string{"Coffee &amp; Tea", `<w:pStyle w:val="Heading1"/>`, "Arabica &lt;smooth&gt;", "Green and black", `r:embed="rId2"`} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("Expected document.xml to contain %q", want)
+		}
+	}
+	if strings.Count(doc, "<w:drawing>") != 1 {
+		t.Errorf("Expected one picture, got %d", strings.Count(doc, "<w:drawing>"))
+	}
+	// 1024x512 px is scaled down to 6in wide, keeping its shape.
+	if !strings.Contains(doc, `<wp:extent cx="5486400" cy="2743200"/>`) {
+		t.Error("Expected the picture scaled to the page width")
+	}
+	if strings.Index(doc, "<w:drawing>") > strings.Index(doc, "Leaves") {
+		t.Error("Expected the picture after the first section")
+	}
+
+	if _, ok := parts["word/media/image1.png"]; !ok {
+		t.Error("Expected the image in the media folder")
+	}
+	if !strings.Contains(parts["word/_rels/document.xml.rels"], `Target="media/image1.png"`) {
+		t.Error("Expected a relationship to the image")
+	}
+	types := parts["[Content_Types].xml"]
+	if !strings.Contains(types, `Extension="png"`) || !strings.Contains(types, "wordprocessingml.document.main+xml") {
+		t.Errorf("Unexpected content types: %s", types)
+	}
+}
+
+func TestWriteSpreadsheetXLSX(t *testing.T) {
+	content := &SpreadsheetContent{
+		Title: "Budget",
+		Sheets: []SpreadsheetData{
+			{
+				Name:    "Q1: Costs/Income",
+				Headers: []string{"Item", "Amount", "Share", "Due", "Count", "Code"},
+				Rows: [][]string{
+					{"Rent", "$1,200.50", "45%", "2024-03-01", "1,500", "00123"},
+					{"Misc <other>", "-$15", "2.5%", "March 5, 2024", "-3", "N/A"},
+				},
+			},
+			{Name: "Q1: Costs/Income"},
+		},
+	}
+	data, err := NewOOXMLWriter().WriteSpreadsheetXLSX(content)
+	if err != nil {
+		t.Fatalf("WriteSpreadsheetXLSX failed: %v", err)
+	}
+	parts := unzipParts(t, data)
+
+	workbook := parts["xl/workbook.xml"]
+	if !strings.Contains(workbook, `name="Q1 CostsIncome"`) || !strings.Contains(workbook, `name="Q1 CostsIncome (2)"`) {
+		t.Errorf("Expected valid, unique sheet names, got %s", workbook)
+	}
+	if _, ok := parts["xl/worksheets/sheet2.xml"]; !ok {
+		t.Error("Expected a part for each sheet")
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">Item</t></is></c>`,
+		`<c r="A2" s="0" t="inlineStr"><is><t xml:space="preserve">Rent</t></is></c>`,
+		`<c r="B2" s="3"><v>1200.5</v></c>`,
+		`<c r="C2" s="4"><v>0.45</v></c>`,
+		`<c r="D2" s="2"><v>45352</v></c>`,
+		`<c r="E2" s="5"><v>1500</v></c>`,
+		`<c r="F2" s="0" t="inlineStr"><is><t xml:space="preserve">00123</t></is></c>`,
+		`<c r="A3" s="0" t="inlineStr"><is><t xml:space="preserve">Misc &lt;other&gt;</t></is></c>`,
+		`<c r="B3" s="3"><v>-15</v></c>`,
+		`<c r="C3" s="4"><v>0.025</v></c>`,
+		`<c r="D3" s="2"><v>45356</v></c>`,
+		`<c r="E3" s="0"><v>-3</v></c>`,
+		`<autoFilter ref="A1:F3"/>`,
+		`state="frozen"`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("Expected sheet1.xml to contain %s", want)
+		}
+	}
+	if !strings.Contains(parts["xl/styles.xml"], `formatCode="yyyy-mm-dd"`) {
+		t.Error("Expected a date format in the styles")
+	}
+}
+
+func TestWritePresentationPPTX(t *testing.T) {
+	content := &PresentationContent{
+		Title:    "Solar Power",
+		Subtitle: "Why & how",
+		Slides: []PresentationSlide{
+			{Title: "Benefits", Bullets: []string{"Cheap", "Clean"}},
+			{Bullets: []string{"Rooftops"}},
+		},
+	}
+	data, err := NewOOXMLWriter().WritePresentationPPTX(content, [][]byte{testPNG(t, 512, 512)})
+	if err != nil {
+		t.Fatalf("WritePresentationPPTX failed: %v", err)
+	}
+	parts := unzipParts(t, data)
+
+	if n := strings.Count(parts["ppt/presentation.xml"], "<p:sldId "); n != 3 {
+		t.Errorf("Expected a title slide and 2 content slides, got %d", n)
+	}
+	for _, name := range []string{"ppt/slideMasters/slideMaster1.xml", "ppt/slideLayouts/slideLayout1.xml", "ppt/theme/theme1.xml", "ppt/media/image1.png"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("Expected %s in the package", name)
+		}
+	}
+
+	if title := parts["ppt/slides/slide1.xml"]; !strings.Contains(title, "Solar Power") || !strings.Contains(title, "Why &amp; how") {
+		t.Error("Expected the title and subtitle on the first slide")
+	}
+	first := parts["ppt/slides/slide2.xml"]
+	if !strings.Contains(first, "<a:t>Cheap</a:t>") || !strings.Contains(first, `<a:blip r:embed="rId2"/>`) {
+		t.Error("Expected the bullets and picture on the first content slide")
+	}
+	if !strings.Contains(parts["ppt/slides/_rels/slide2.xml.rels"], `Target="../media/image1.png"`) {
+		t.Error("Expected the slide to link the image")
+	}
+	second := parts["ppt/slides/slide3.xml"]
+	if !strings.Contains(second, "<a:t>Slide 2</a:t>") || strings.Contains(second, "<p:pic>") {
+		t.Error("Expected an untitled slide named by number, without a picture")
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	for requested, want := range map[Format]Format{"": FormatPDF, FormatPDF: FormatPDF, FormatDOCX: FormatDOCX} {
+		if got, err := outputFormat(requested, FormatDOCX); err != nil || got != want {
+			t.Errorf("outputFormat(%q) = %q, %v, want %q", requested, got, err, want)
+		}
+	}
+	if _, err := outputFormat(FormatPPTX, FormatDOCX); err == nil {
+		t.Error("Expected a document not to be written as PPTX")
+	}
+	if doc := newGeneratedDocument("Q1 Budget", FormatXLSX, nil); doc.Filename != "Q1_Budget.xlsx" || !strings.Contains(doc.ContentType, "spreadsheetml") {
+		t.Errorf("Unexpected result %+v", doc)
+	}
+}
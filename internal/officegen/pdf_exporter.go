@@ -10,6 +10,7 @@ import (
 
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
+	"github.com/josh/discord-bot/internal/jobs"
 )
 
 type PDFExporter struct {
@@ -22,15 +23,21 @@ func NewPDFExporter() *PDFExporter {
 	}
 }
 
-func (pe *PDFExporter) ConvertHTMLToPDF(html string) ([]byte, error) {
-	ctx, cancel := chromedp.NewContext(context.Background())
+func (pe *PDFExporter) ConvertHTMLToPDF(ctx context.Context, html string) ([]byte, error) {
+	release, err := jobs.Acquire(ctx, jobs.BackendChromium, "Rendering PDF")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := chromedp.NewContext(ctx)
 	defer cancel()
 
 	ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	var pdfBuf []byte
-	err := chromedp.Run(ctx,
+	err = chromedp.Run(ctx,
 		chromedp.Navigate("about:blank"),
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			frameTree, err := page.GetFrameTree().Do(ctx)
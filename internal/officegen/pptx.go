@@ -0,0 +1,197 @@
+package officegen
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Slides are 16:9, 13.333in by 7.5in.
+const (
+	slideWidth  = 12192000
+	slideHeight = 6858000
+	slideMargin = 457200
+)
+
+// WritePresentationPPTX writes a PowerPoint deck: a title slide, then a
+// slide for each of the content's slides with image i beside the bullets
+// of slide i, as in the PDF.
+func (w *OOXMLWriter) WritePresentationPPTX(content *PresentationContent, images [][]byte) ([]byte, error) {
+	pkg := newOOXMLPackage()
+	pkg.addRels("_rels/.rels", []relationship{
+		{"rId1", relOfficeDocument, "ppt/presentation.xml"},
+		{"rId2", relCoreProperties, "docProps/core.xml"},
+	})
+	pkg.addCoreProperties(content.Title)
+
+	slides := []string{titleSlideXML(content.Title, content.Subtitle)}
+	slideRels := [][]relationship{nil}
+	for idx, slide := range content.Slides {
+		title := slide.Title
+		if title == "" {
+			title = fmt.Sprintf("Slide %d", idx+1)
+		}
+
+		var picture *embeddedImage
+		var rels []relationship
+		if idx < len(images) && len(images[idx]) > 0 {
+			img, err := pkg.addImage("ppt", images[idx])
+			if err != nil {
+				slog.Warn("Skipping image in PPTX", "index", idx, "error", err)
+			} else {
+				picture = &img
+				rels = append(rels, relationship{"rId2", relImage, "../media/" + img.name})
+			}
+		}
+		slides = append(slides, contentSlideXML(title, slide.Bullets, picture))
+		slideRels = append(slideRels, rels)
+	}
+
+	presRels := []relationship{
+		{"rId1", relSlideMaster, "slideMasters/slideMaster1.xml"},
+		{"rId2", relTheme, "theme/theme1.xml"},
+	}
+	var slideIDs strings.Builder
+	for n, slide := range slides {
+		id := fmt.Sprintf("rId%d", n+3)
+		presRels = append(presRels, relationship{id, relSlide, fmt.Sprintf("slides/slide%d.xml", n+1)})
+		fmt.Fprintf(&slideIDs, `<p:sldId id="%d" r:id="%s"/>`, 256+n, id)
+
+		pkg.addRels(fmt.Sprintf("ppt/slides/_rels/slide%d.xml.rels", n+1),
+			append([]relationship{{"rId1", relSlideLayout, "../slideLayouts/slideLayout1.xml"}}, slideRels[n]...))
+		pkg.addXML(fmt.Sprintf("ppt/slides/slide%d.xml", n+1), "application/vnd.openxmlformats-officedocument.presentationml.slide+xml", slide)
+	}
+
+	pkg.addRels("ppt/_rels/presentation.xml.rels", presRels)
+	pkg.addXML("ppt/presentation.xml", "application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml",
+		`<p:presentation `+pptxNamespaces+` saveSubsetFonts="1">`+
+			`<p:sldMasterIdLst><p:sldMasterId id="2147483648" r:id="rId1"/></p:sldMasterIdLst>`+
+			`<p:sldIdLst>`+slideIDs.String()+`</p:sldIdLst>`+
+			fmt.Sprintf(`<p:sldSz cx="%d" cy="%d"/><p:notesSz cx="6858000" cy="9144000"/>`, slideWidth, slideHeight)+
+			`</p:presentation>`)
+	pkg.addRels("ppt/slideMasters/_rels/slideMaster1.xml.rels", []relationship{
+		{"rId1", relSlideLayout, "../slideLayouts/slideLayout1.xml"},
+		{"rId2", relTheme, "../theme/theme1.xml"},
+	})
+	pkg.addXML("ppt/slideMasters/slideMaster1.xml", "application/vnd.openxmlformats-officedocument.presentationml.slideMaster+xml", pptxSlideMaster)
+	pkg.addRels("ppt/slideLayouts/_rels/slideLayout1.xml.rels", []relationship{
+		{"rId1", relSlideMaster, "../slideMasters/slideMaster1.xml"},
+	})
+	pkg.addXML("ppt/slideLayouts/slideLayout1.xml", "application/vnd.openxmlformats-officedocument.presentationml.slideLayout+xml", pptxSlideLayout)
+	pkg.addXML("ppt/theme/theme1.xml", "application/vnd.openxmlformats-officedocument.theme+xml", pptxTheme)
+	return pkg.bytes()
+}
+
+func titleSlideXML(title, subtitle string) string {
+	var b strings.Builder
+	pptxTextBox(&b, 2, "Title", slideMargin, 2057400, slideWidth-2*slideMargin, 1371600, "b", "ctr",
+		[]string{title}, 4400, "FFFFFF", true, false)
+	if subtitle != "" {
+		pptxTextBox(&b, 3, "Subtitle", slideMargin, 3520440, slideWidth-2*slideMargin, 914400, "t", "ctr",
+			[]string{subtitle}, 2400, "D9E1F2", false, false)
+	}
+	return pptxSlide("1F2A44", b.String())
+}
+
+// contentSlideXML lays out a slide's title over its bullets, with the
+// bullets sharing the width with a picture when there is one.
+func contentSlideXML(title string, bullets []string, picture *embeddedImage) string {
+	const top, titleHeight = 365760, 1005840
+	bodyTop := int64(top + titleHeight + 182880)
+	bodyHeight := int64(slideHeight) - bodyTop - slideMargin
+	bodyWidth := int64(slideWidth - 2*slideMargin)
+
+	var b strings.Builder
+	pptxTextBox(&b, 2, "Title", slideMargin, top, bodyWidth, titleHeight, "ctr", "l",
+		[]string{title}, 3600, "1F2A44", true, false)
+
+	if picture != nil {
+		boxWidth := bodyWidth * 2 / 5
+		bodyWidth -= boxWidth + 228600
+		cx, cy := picture.fit(boxWidth, bodyHeight)
+		x := int64(slideWidth-slideMargin) - boxWidth + (boxWidth-cx)/2
+		y := bodyTop + (bodyHeight-cy)/2
+		fmt.Fprintf(&b, `<p:pic><p:nvPicPr><p:cNvPr id="4" name="%s"/><p:cNvPicPr><a:picLocks noChangeAspect="1"/></p:cNvPicPr><p:nvPr/></p:nvPicPr>`+
+			`<p:blipFill><a:blip r:embed="rId2"/><a:stretch><a:fillRect/></a:stretch></p:blipFill>`+
+			`<p:spPr><a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></p:spPr></p:pic>`,
+			picture.name, x, y, cx, cy)
+	}
+	if len(bullets) > 0 {
+		pptxTextBox(&b, 3, "Content", slideMargin, bodyTop, bodyWidth, bodyHeight, "t", "l",
+			bullets, 2000, "333333", false, true)
+	}
+	return pptxSlide("FFFFFF", b.String())
+}
+
+func pptxSlide(background, shapes string) string {
+	return `<p:sld ` + pptxNamespaces + `><p:cSld>` +
+		`<p:bg><p:bgPr><a:solidFill><a:srgbClr val="` + background + `"/></a:solidFill><a:effectLst/></p:bgPr></p:bg>` +
+		`<p:spTree><p:nvGrpSpPr><p:cNvPr id="1" name=""/><p:cNvGrpSpPr/><p:nvPr/></p:nvGrpSpPr><p:grpSpPr/>` +
+		shapes + `</p:spTree></p:cSld><p:clrMapOvr><a:masterClrMapping/></p:clrMapOvr></p:sld>`
+}
+
+// pptxTextBox writes a text box with a paragraph per line. Text shrinks
+// to fit when the model writes more than the box holds.
+func pptxTextBox(b *strings.Builder, id int, name string, x, y, cx, cy int64, anchor, align string, lines []string, size int, color string, bold, bulleted bool) {
+	fmt.Fprintf(b, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="%s"/><p:cNvSpPr txBox="1"/><p:nvPr/></p:nvSpPr>`+
+		`<p:spPr><a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom><a:noFill/></p:spPr>`+
+		`<p:txBody><a:bodyPr wrap="square" anchor="%s"><a:normAutofit/></a:bodyPr><a:lstStyle/>`,
+		id, name, x, y, cx, cy, anchor)
+	weight := ""
+	if bold {
+		weight = ` b="1"`
+	}
+	for _, line := range lines {
+		b.WriteString(`<a:p>`)
+		if bulleted {
+			b.WriteString(`<a:pPr marL="342900" indent="-342900"><a:spcBef><a:spcPts val="1200"/></a:spcBef><a:buFont typeface="Arial"/><a:buChar char="•"/></a:pPr>`)
+		} else {
+			fmt.Fprintf(b, `<a:pPr algn="%s"/>`, align)
+		}
+		fmt.Fprintf(b, `<a:r><a:rPr lang="en-US" sz="%d"%s dirty="0"><a:solidFill><a:srgbClr val="%s"/></a:solidFill></a:rPr><a:t>%s</a:t></a:r></a:p>`,
+			size, weight, color, escapeXML(line))
+	}
+	b.WriteString(`</p:txBody></p:sp>`)
+}
+
+const pptxNamespaces = `xmlns:a="` + nsDrawingML + `" xmlns:r="` + nsRelationships + `" xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main"`
+
+const pptxEmptyTree = `<p:spTree><p:nvGrpSpPr><p:cNvPr id="1" name=""/><p:cNvGrpSpPr/><p:nvPr/></p:nvGrpSpPr><p:grpSpPr/></p:spTree>`
+
+// The slides draw their own text boxes, so the master and its one layout
+// are blank.
+const pptxSlideMaster = `<p:sldMaster ` + pptxNamespaces + `><p:cSld>` + pptxEmptyTree + `</p:cSld>` +
+	`<p:clrMap bg1="lt1" tx1="dk1" bg2="lt2" tx2="dk2" accent1="accent1" accent2="accent2" accent3="accent3" accent4="accent4" accent5="accent5" accent6="accent6" hlink="hlink" folHlink="folHlink"/>` +
+	`<p:sldLayoutIdLst><p:sldLayoutId id="2147483649" r:id="rId1"/></p:sldLayoutIdLst>` +
+	`<p:txStyles><p:titleStyle><a:lvl1pPr><a:defRPr sz="4400"/></a:lvl1pPr></p:titleStyle>` +
+	`<p:bodyStyle><a:lvl1pPr><a:defRPr sz="2000"/></a:lvl1pPr></p:bodyStyle>` +
+	`<p:otherStyle><a:lvl1pPr><a:defRPr/></a:lvl1pPr></p:otherStyle></p:txStyles></p:sldMaster>`
+
+const pptxSlideLayout = `<p:sldLayout ` + pptxNamespaces + ` preserve="1"><p:cSld name="Blank">` + pptxEmptyTree + `</p:cSld>` +
+	`<p:clrMapOvr><a:masterClrMapping/></p:clrMapOvr></p:sldLayout>`
+
+const pptxTheme = `<a:theme xmlns:a="` + nsDrawingML + `" name="Office Theme"><a:themeElements>` +
+	`<a:clrScheme name="Office">` +
+	`<a:dk1><a:sysClr val="windowText" lastClr="000000"/></a:dk1><a:lt1><a:sysClr val="window" lastClr="FFFFFF"/></a:lt1>` +
+	`<a:dk2><a:srgbClr val="1F2A44"/></a:dk2><a:lt2><a:srgbClr val="E7E6E6"/></a:lt2>` +
+	`<a:accent1><a:srgbClr val="4472C4"/></a:accent1><a:accent2><a:srgbClr val="ED7D31"/></a:accent2>` +
+	`<a:accent3><a:srgbClr val="A5A5A5"/></a:accent3><a:accent4><a:srgbClr val="FFC000"/></a:accent4>` +
+	`<a:accent5><a:srgbClr val="5B9BD5"/></a:accent5><a:accent6><a:srgbClr val="70AD47"/></a:accent6>` +
+	`<a:hlink><a:srgbClr val="0563C1"/></a:hlink><a:folHlink><a:srgbClr val="954F72"/></a:folHlink>` +
+	`</a:clrScheme>` +
+	`<a:fontScheme name="Office">` +
+	`<a:majorFont><a:latin typeface="Calibri Light"/><a:ea typeface=""/><a:cs typeface=""/></a:majorFont>` +
+	`<a:minorFont><a:latin typeface="Calibri"/><a:ea typeface=""/><a:cs typeface=""/></a:minorFont>` +
+	`</a:fontScheme>` +
+	`<a:fmtScheme name="Office">` +
+	`<a:fillStyleLst>` + pptxPlainFill + pptxPlainFill + pptxPlainFill + `</a:fillStyleLst>` +
+	`<a:lnStyleLst>` + pptxPlainLine + pptxPlainLine + pptxPlainLine + `</a:lnStyleLst>` +
+	`<a:effectStyleLst>` + pptxNoEffect + pptxNoEffect + pptxNoEffect + `</a:effectStyleLst>` +
+	`<a:bgFillStyleLst>` + pptxPlainFill + pptxPlainFill + pptxPlainFill + `</a:bgFillStyleLst>` +
+	`</a:fmtScheme></a:themeElements></a:theme>`
+
+const (
+	pptxPlainFill = `<a:solidFill><a:schemeClr val="phClr"/></a:solidFill>`
+	pptxPlainLine = `<a:ln w="6350"><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:ln>`
+	pptxNoEffect  = `<a:effectStyle><a:effectLst/></a:effectStyle>`
+)
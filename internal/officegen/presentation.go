@@ -1,8 +1,11 @@
 package officegen
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+
+	"github.com/josh/discord-bot/internal/jobs"
 )
 
 type PresentationGenerator struct {
@@ -10,6 +13,7 @@ type PresentationGenerator struct {
 	imageGen  *ImageGenerator
 	htmlGen   *HTMLGenerator
 	pdfExport *PDFExporter
+	ooxml     *OOXMLWriter
 }
 
 func NewPresentationGenerator(llmClient *Client, imageGen *ImageGenerator) *PresentationGenerator {
@@ -18,13 +22,24 @@ func NewPresentationGenerator(llmClient *Client, imageGen *ImageGenerator) *Pres
 		imageGen:  imageGen,
 		htmlGen:   NewHTMLGenerator(),
 		pdfExport: NewPDFExporter(),
+		ooxml:     NewOOXMLWriter(),
 	}
 }
 
-func (pg *PresentationGenerator) Generate(req *PresentationRequest) (*GeneratedDocument, error) {
+func (pg *PresentationGenerator) Generate(ctx context.Context, req *PresentationRequest) (*GeneratedDocument, error) {
 	slog.Info("Generating presentation", "prompt", req.Prompt, "target_slides", req.TargetSlides)
 
-	content, err := pg.llmClient.GeneratePresentation(req.Prompt, req.TargetSlides)
+	format, err := outputFormat(req.Format, FormatPPTX)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := jobs.Acquire(ctx, jobs.BackendLLM, "Writing content")
+	if err != nil {
+		return nil, err
+	}
+	content, err := pg.llmClient.GeneratePresentation(ctx, req.Prompt, req.TargetSlides)
+	release()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate content: %w", err)
 	}
@@ -58,12 +73,12 @@ func (pg *PresentationGenerator) Generate(req *PresentationRequest) (*GeneratedD
 		imageCount := min(len(content.Slides), 5)
 
 		slog.Info("Generating image prompts for presentation", "count", imageCount)
-		prompts, err := pg.imageGen.GenerateImagePrompts(content, imageCount)
+		prompts, err := pg.imageGen.GenerateImagePrompts(ctx, content, imageCount)
 		if err != nil {
 			slog.Warn("Failed to generate image prompts", "error", err)
 		} else {
 			slog.Info("Generating images for presentation", "prompts", prompts)
-			images, err = pg.imageGen.GenerateImages(prompts)
+			images, err = pg.imageGen.GenerateImages(ctx, prompts)
 			if err != nil {
 				slog.Warn("Failed to generate images", "error", err)
 			} else {
@@ -72,21 +87,29 @@ func (pg *PresentationGenerator) Generate(req *PresentationRequest) (*GeneratedD
 		}
 	}
 
-	html, err := pg.htmlGen.GeneratePresentationHTML(content, images)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate HTML: %w", err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	pdfData, err := pg.pdfExport.ConvertHTMLToPDF(html)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert to PDF: %w", err)
-	}
+	var data []byte
+	if format == FormatPPTX {
+		data, err = pg.ooxml.WritePresentationPPTX(content, images)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write PPTX: %w", err)
+		}
+	} else {
+		html, err := pg.htmlGen.GeneratePresentationHTML(content, images)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate HTML: %w", err)
+		}
 
-	filename := sanitizeFilename(content.Title) + ".pdf"
-	slog.Info("Presentation generated", "filename", filename, "size", len(pdfData))
+		data, err = pg.pdfExport.ConvertHTMLToPDF(ctx, html)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert to PDF: %w", err)
+		}
+	}
 
-	return &GeneratedDocument{
-		Data:     pdfData,
-		Filename: filename,
-	}, nil
+	doc := newGeneratedDocument(content.Title, format, data)
+	slog.Info("Presentation generated", "filename", doc.Filename, "size", len(data))
+	return doc, nil
 }
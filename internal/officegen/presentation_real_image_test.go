@@ -2,6 +2,7 @@ package officegen
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -141,7 +142,7 @@ func TestPresentationWithRealGeneratedImages(t *testing.T) {
 		t.Logf("Saved test HTML to /tmp/test_real_images.html")
 	}
 
-	pdfData, err := pdfExport.ConvertHTMLToPDF(html)
+	pdfData, err := pdfExport.ConvertHTMLToPDF(context.Background(), html)
 	if err != nil {
 		t.Fatalf("Failed to convert HTML to PDF: %v", err)
 	}
@@ -1,6 +1,7 @@
 package officegen
 
 import (
+	"context"
 	"os"
 	"strings"
 	"testing"
@@ -90,7 +91,7 @@ func TestPresentationSecondSlideRendering(t *testing.T) {
 		}
 	})
 
-	pdfData, err := pdfExport.ConvertHTMLToPDF(html)
+	pdfData, err := pdfExport.ConvertHTMLToPDF(context.Background(), html)
 	if err != nil {
 		t.Fatalf("Failed to convert HTML to PDF: %v", err)
 	}
@@ -1,8 +1,11 @@
 package officegen
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+
+	"github.com/josh/discord-bot/internal/jobs"
 )
 
 type SpreadsheetGenerator struct {
@@ -10,6 +13,7 @@ type SpreadsheetGenerator struct {
 	imageGen  *ImageGenerator
 	htmlGen   *HTMLGenerator
 	pdfExport *PDFExporter
+	ooxml     *OOXMLWriter
 }
 
 func NewSpreadsheetGenerator(llmClient *Client, imageGen *ImageGenerator) *SpreadsheetGenerator {
@@ -18,13 +22,24 @@ func NewSpreadsheetGenerator(llmClient *Client, imageGen *ImageGenerator) *Sprea
 		imageGen:  imageGen,
 		htmlGen:   NewHTMLGenerator(),
 		pdfExport: NewPDFExporter(),
+		ooxml:     NewOOXMLWriter(),
 	}
 }
 
-func (sg *SpreadsheetGenerator) Generate(req *SpreadsheetRequest) (*GeneratedDocument, error) {
+func (sg *SpreadsheetGenerator) Generate(ctx context.Context, req *SpreadsheetRequest) (*GeneratedDocument, error) {
 	slog.Info("Generating spreadsheet", "prompt", req.Prompt, "target_pages", req.TargetPages)
 
-	content, err := sg.llmClient.GenerateSpreadsheet(req.Prompt)
+	format, err := outputFormat(req.Format, FormatXLSX)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := jobs.Acquire(ctx, jobs.BackendLLM, "Writing content")
+	if err != nil {
+		return nil, err
+	}
+	content, err := sg.llmClient.GenerateSpreadsheet(ctx, req.Prompt)
+	release()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate content: %w", err)
 	}
@@ -33,21 +48,29 @@ func (sg *SpreadsheetGenerator) Generate(req *SpreadsheetRequest) (*GeneratedDoc
 		content.Title = req.Title
 	}
 
-	html, err := sg.htmlGen.GenerateSpreadsheetHTML(content)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate HTML: %w", err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	pdfData, err := sg.pdfExport.ConvertHTMLToPDF(html)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert to PDF: %w", err)
-	}
+	var data []byte
+	if format == FormatXLSX {
+		data, err = sg.ooxml.WriteSpreadsheetXLSX(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write XLSX: %w", err)
+		}
+	} else {
+		html, err := sg.htmlGen.GenerateSpreadsheetHTML(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate HTML: %w", err)
+		}
 
-	filename := sanitizeFilename(content.Title) + ".pdf"
-	slog.Info("Spreadsheet generated", "filename", filename, "size", len(pdfData))
+		data, err = sg.pdfExport.ConvertHTMLToPDF(ctx, html)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert to PDF: %w", err)
+		}
+	}
 
-	return &GeneratedDocument{
-		Data:     pdfData,
-		Filename: filename,
-	}, nil
+	doc := newGeneratedDocument(content.Title, format, data)
+	slog.Info("Spreadsheet generated", "filename", doc.Filename, "size", len(data))
+	return doc, nil
 }
@@ -37,23 +37,27 @@ type DocumentRequest struct {
 	Prompt      string
 	Title       string
 	TargetPages int
+	Format      Format
 }
 
 type SpreadsheetRequest struct {
 	Prompt      string
 	Title       string
 	TargetPages int
+	Format      Format
 }
 
 type PresentationRequest struct {
 	Prompt       string
 	Title        string
 	TargetSlides int
+	Format       Format
 }
 
 type GeneratedDocument struct {
-	Data     []byte
-	Filename string
+	Data        []byte
+	Filename    string
+	ContentType string
 }
 
 type GeneratedSpreadsheet struct {
@@ -65,3 +69,28 @@ type GeneratedPresentation struct {
 	Data     []byte
 	Filename string
 }
+
+// Format is the kind of file a generator writes. PDF, the default, works
+// for everything; each kind of content also has its Office format.
+type Format string
+
+const (
+	FormatPDF  Format = "pdf"
+	FormatDOCX Format = "docx"
+	FormatXLSX Format = "xlsx"
+	FormatPPTX Format = "pptx"
+)
+
+// ContentType returns the format's MIME type.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatDOCX:
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FormatPPTX:
+		return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	default:
+		return "application/pdf"
+	}
+}
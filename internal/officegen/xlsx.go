@@ -0,0 +1,253 @@
+package officegen
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Cell styles, indexes into cellXfs in xlsxStyles.
+const (
+	xlsxStyleGeneral = iota
+	xlsxStyleHeader
+	xlsxStyleDate
+	xlsxStyleCurrency
+	xlsxStylePercent
+	xlsxStyleGrouped
+	xlsxStyleGroupedDecimal
+)
+
+var (
+	plainNumber   = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?$`)
+	groupedNumber = regexp.MustCompile(`^-?\d{1,3}(,\d{3})+(\.\d+)?$`)
+
+	dateLayouts = []string{
+		"2006-01-02", "2006/01/02", "01/02/2006", "1/2/2006",
+		"Jan 2, 2006", "January 2, 2006", "2 Jan 2006", "2 January 2006",
+	}
+
+	// Spreadsheet dates count days from 1899-12-30, which absorbs the
+	// 1900 leap year bug Excel kept from Lotus 1-2-3.
+	excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// WriteSpreadsheetXLSX writes an Excel workbook with a sheet for each of
+// the content's sheets. Numbers, amounts, percentages and dates are
+// written as values with a matching format, so they can be summed and
+// sorted; anything else is text.
+func (w *OOXMLWriter) WriteSpreadsheetXLSX(content *SpreadsheetContent) ([]byte, error) {
+	pkg := newOOXMLPackage()
+	pkg.addRels("_rels/.rels", []relationship{
+		{"rId1", relOfficeDocument, "xl/workbook.xml"},
+		{"rId2", relCoreProperties, "docProps/core.xml"},
+	})
+	pkg.addCoreProperties(content.Title)
+
+	sheets := content.Sheets
+	if len(sheets) == 0 {
+		sheets = []SpreadsheetData{{}}
+	}
+	var workbook strings.Builder
+	var rels []relationship
+	used := map[string]bool{}
+	for n, sheet := range sheets {
+		id := fmt.Sprintf("rId%d", n+1)
+		rels = append(rels, relationship{id, relWorksheet, fmt.Sprintf("worksheets/sheet%d.xml", n+1)})
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="%s"/>`, escapeXML(sheetName(sheet.Name, n, used)), n+1, id)
+		pkg.addXML(fmt.Sprintf("xl/worksheets/sheet%d.xml", n+1), "application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml", worksheetXML(sheet))
+	}
+	rels = append(rels, relationship{fmt.Sprintf("rId%d", len(sheets)+1), relStyles, "styles.xml"})
+
+	pkg.addRels("xl/_rels/workbook.xml.rels", rels)
+	pkg.addXML("xl/workbook.xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml",
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="`+nsRelationships+`">`+
+			`<sheets>`+workbook.String()+`</sheets></workbook>`)
+	pkg.addXML("xl/styles.xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml", xlsxStyles)
+	return pkg.bytes()
+}
+
+// worksheetXML writes a sheet with a bold, frozen and filterable header row.
+func worksheetXML(sheet SpreadsheetData) string {
+	columns := len(sheet.Headers)
+	widths := make([]int, 0, columns)
+	measure := func(row []string) {
+		for n, value := range row {
+			if n >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[n] = max(widths[n], utf8.RuneCountInString(value))
+		}
+		columns = max(columns, len(row))
+	}
+	measure(sheet.Headers)
+	for _, row := range sheet.Rows {
+		measure(row)
+	}
+
+	var b strings.Builder
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(sheet.Headers) > 0 {
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+	if len(widths) > 0 {
+		b.WriteString(`<cols>`)
+		for n, width := range widths {
+			fmt.Fprintf(&b, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, n+1, n+1, min(max(width, 8), 60)+2)
+		}
+		b.WriteString(`</cols>`)
+	}
+
+	b.WriteString(`<sheetData>`)
+	row := 1
+	if len(sheet.Headers) > 0 {
+		fmt.Fprintf(&b, `<row r="%d">`, row)
+		for n, header := range sheet.Headers {
+			writeTextCell(&b, cellRef(n, row), header, xlsxStyleHeader)
+		}
+		b.WriteString(`</row>`)
+		row++
+	}
+	for _, values := range sheet.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, row)
+		for n, value := range values {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			if number, style, ok := parseCell(value); ok {
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, cellRef(n, row), style, strconv.FormatFloat(number, 'f', -1, 64))
+			} else {
+				writeTextCell(&b, cellRef(n, row), value, xlsxStyleGeneral)
+			}
+		}
+		b.WriteString(`</row>`)
+		row++
+	}
+	b.WriteString(`</sheetData>`)
+
+	if len(sheet.Headers) > 0 {
+		fmt.Fprintf(&b, `<autoFilter ref="A1:%s"/>`, cellRef(len(sheet.Headers)-1, row-1))
+	}
+	b.WriteString(`</worksheet>`)
+	return b.String()
+}
+
+func writeTextCell(b *strings.Builder, ref, value string, style int) {
+	fmt.Fprintf(b, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escapeXML(value))
+}
+
+// parseCell reads a cell as a number or date, returning its value and the
+// style to show it with. Numbers with leading zeros, like IDs and ZIP
+// codes, stay text so the zeros aren't lost.
+func parseCell(value string) (float64, int, bool) {
+	if t, ok := parseDate(value); ok {
+		return t.Sub(excelEpoch).Hours() / 24, xlsxStyleDate, true
+	}
+
+	style := xlsxStyleGeneral
+	negative := strings.HasPrefix(value, "-")
+	number := strings.TrimPrefix(value, "-")
+	switch {
+	case strings.HasPrefix(number, "$"):
+		number, style = number[1:], xlsxStyleCurrency
+	case strings.HasSuffix(number, "%"):
+		number, style = number[:len(number)-1], xlsxStylePercent
+	}
+
+	if groupedNumber.MatchString(number) {
+		if style == xlsxStyleGeneral {
+			style = xlsxStyleGrouped
+			if strings.Contains(number, ".") {
+				style = xlsxStyleGroupedDecimal
+			}
+		}
+		number = strings.ReplaceAll(number, ",", "")
+	} else if !plainNumber.MatchString(number) {
+		return 0, 0, false
+	}
+
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if negative {
+		v = -v
+	}
+	if style == xlsxStylePercent {
+		v /= 100
+	}
+	return v, style, true
+}
+
+func parseDate(value string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// cellRef returns the A1-style reference of a zero-based column and a row.
+func cellRef(column, row int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return fmt.Sprintf("%s%d", name, row)
+}
+
+// sheetName makes a name Excel will accept: at most 31 characters, none
+// of []:*?/\, and not already used in the workbook.
+func sheetName(name string, n int, used map[string]bool) string {
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name))
+	name = strings.Trim(name, "'")
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", n+1)
+	}
+	name = truncateRunes(name, 31)
+
+	unique := name
+	for i := 2; used[strings.ToLower(unique)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		unique = truncateRunes(name, 31-len(suffix)) + suffix
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// xlsxStyles holds the cell formats, in the order of the xlsxStyle
+// constants.
+const xlsxStyles = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="&quot;$&quot;#,##0.00"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FFD9E1F2"/><bgColor indexed="64"/></patternFill></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="7">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="10" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="3" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
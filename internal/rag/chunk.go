@@ -0,0 +1,63 @@
+package rag
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Chunk splits text into pieces of at most size bytes that overlap by
+// about overlap bytes, so a sentence cut at a boundary still appears whole
+// in one of them. Breaks prefer paragraph ends, then sentence ends, then
+// spaces.
+func Chunk(text string, size, overlap int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if len(text) <= size {
+		return []string{text}
+	}
+
+	var chunks []string
+	start := 0
+	for start < len(text) {
+		end := start + size
+		if end >= len(text) {
+			chunks = append(chunks, strings.TrimSpace(text[start:]))
+			break
+		}
+		end = breakPoint(text, start, end)
+		chunks = append(chunks, strings.TrimSpace(text[start:end]))
+
+		next := end - overlap
+		if next <= start {
+			next = end
+		}
+		// Start the overlap at a word boundary rather than mid-word.
+		for next < end && !unicode.IsSpace(rune(text[next-1])) {
+			next++
+		}
+		start = next
+		for start < len(text) && unicode.IsSpace(rune(text[start])) {
+			start++
+		}
+	}
+	return chunks
+}
+
+// breakPoint finds where to end a chunk in text[start:end], looking only in
+// its second half so chunks don't get tiny.
+func breakPoint(text string, start, end int) int {
+	window := text[start:end]
+	half := len(window) / 2
+	for _, sep := range []string{"\n\n", ". ", "! ", "? ", "\n", " "} {
+		if i := strings.LastIndex(window, sep); i >= half {
+			return start + i + len(sep)
+		}
+	}
+	// No break found: cut without splitting a UTF-8 sequence.
+	for end > start && text[end]&0xC0 == 0x80 {
+		end--
+	}
+	return end
+}
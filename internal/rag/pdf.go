@@ -0,0 +1,297 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ExtractPDFText pulls the text out of a PDF's content streams. It is a
+// best-effort reader rather than a full PDF parser: it understands Flate
+// compression, the text-showing operators and ToUnicode maps for fonts
+// that use two-byte codes, which covers PDFs written by office suites and
+// browsers. Scanned PDFs have no text to find.
+func ExtractPDFText(data []byte) string {
+	streams := pdfStreams(data)
+
+	cmap := make(map[uint16]string)
+	for _, s := range streams {
+		if bytes.Contains(s, []byte("begincmap")) {
+			parseToUnicode(s, cmap)
+		}
+	}
+
+	var out strings.Builder
+	for _, s := range streams {
+		if bytes.Contains(s, []byte("BT")) && !bytes.Contains(s, []byte("begincmap")) {
+			extractText(s, cmap, &out)
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
+
+var streamPattern = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+
+// pdfStreams returns every stream's data, inflated if it was compressed.
+func pdfStreams(data []byte) [][]byte {
+	var streams [][]byte
+	for _, loc := range streamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		raw := bytes.TrimRight(data[start:start+end], "\r\n")
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			r, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			// Truncated streams still yield whatever inflated cleanly.
+			inflated, _ := io.ReadAll(io.LimitReader(r, 32<<20))
+			r.Close()
+			raw = inflated
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			// Images and other encodings carry no text.
+			continue
+		}
+		streams = append(streams, raw)
+	}
+	return streams
+}
+
+var (
+	bfcharPattern  = regexp.MustCompile(`(?s)beginbfchar(.*?)endbfchar`)
+	bfrangePattern = regexp.MustCompile(`(?s)beginbfrange(.*?)endbfrange`)
+	hexPattern     = regexp.MustCompile(`<([0-9A-Fa-f]+)>`)
+)
+
+// parseToUnicode adds a ToUnicode CMap's two-byte mappings to cmap. Maps
+// from different fonts are merged, which is wrong only when two fonts
+// reuse a code for different characters.
+func parseToUnicode(s []byte, cmap map[uint16]string) {
+	for _, block := range bfcharPattern.FindAllSubmatch(s, -1) {
+		hexes := hexPattern.FindAllSubmatch(block[1], -1)
+		for i := 0; i+1 < len(hexes); i += 2 {
+			code, err := strconv.ParseUint(string(hexes[i][1]), 16, 16)
+			if err != nil {
+				continue
+			}
+			cmap[uint16(code)] = decodeUTF16Hex(string(hexes[i+1][1]))
+		}
+	}
+	for _, block := range bfrangePattern.FindAllSubmatch(s, -1) {
+		for _, line := range bytes.Split(block[1], []byte("\n")) {
+			hexes := hexPattern.FindAllSubmatch(line, -1)
+			if len(hexes) < 3 || bytes.Contains(line, []byte("[")) {
+				continue
+			}
+			lo, err1 := strconv.ParseUint(string(hexes[0][1]), 16, 16)
+			hi, err2 := strconv.ParseUint(string(hexes[1][1]), 16, 16)
+			dst, err3 := strconv.ParseUint(string(hexes[2][1]), 16, 32)
+			if err1 != nil || err2 != nil || err3 != nil || hi < lo || hi-lo > 0xFFFF {
+				continue
+			}
+			for code := lo; code <= hi; code++ {
+				cmap[uint16(code)] = string(rune(dst + code - lo))
+			}
+		}
+	}
+}
+
+func decodeUTF16Hex(h string) string {
+	var units []uint16
+	for i := 0; i+4 <= len(h); i += 4 {
+		u, err := strconv.ParseUint(h[i:i+4], 16, 16)
+		if err != nil {
+			return ""
+		}
+		units = append(units, uint16(u))
+	}
+	return string(utf16.Decode(units))
+}
+
+// extractText interprets the text operators in a content stream: strings
+// shown with Tj, TJ, ' and " are written out, and line moves become
+// newlines.
+func extractText(s []byte, cmap map[uint16]string, out *strings.Builder) {
+	var operands []string
+	inText, inArray := false, false
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '(':
+			str, next := readLiteral(s, i)
+			operands = append(operands, str)
+			i = next
+		case c == '<' && i+1 < len(s) && s[i+1] != '<':
+			end := bytes.IndexByte(s[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, decodeHexString(string(s[i+1:i+end]), cmap))
+			i += end + 1
+		case c == '%':
+			for i < len(s) && s[i] != '\n' && s[i] != '\r' {
+				i++
+			}
+		case c == '[' || c == ']':
+			inArray = c == '['
+			i++
+		case isPDFDelimiter(c) || isPDFSpace(c):
+			i++
+		default:
+			start := i
+			for i < len(s) && !isPDFDelimiter(s[i]) && !isPDFSpace(s[i]) {
+				i++
+			}
+			switch string(s[start:i]) {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				out.WriteString("\n")
+			case "Tj", "TJ":
+				if inText {
+					out.WriteString(strings.Join(operands, ""))
+				}
+			case "'", "\"":
+				if inText {
+					out.WriteString("\n" + strings.Join(operands, ""))
+				}
+			case "T*", "Td", "TD":
+				if inText {
+					out.WriteString("\n")
+				}
+			}
+			tok := s[start:i]
+			if !isPDFOperand(tok) {
+				operands = operands[:0]
+			} else if inArray {
+				// Large negative kerning in a TJ array is how many PDFs
+				// write the gap between words.
+				if n, err := strconv.ParseFloat(string(tok), 64); err == nil && n < -200 {
+					operands = append(operands, " ")
+				}
+			}
+		}
+	}
+}
+
+// isPDFOperand reports whether a token is a number or name, which are
+// operands, rather than an operator.
+func isPDFOperand(tok []byte) bool {
+	if len(tok) == 0 {
+		return true
+	}
+	if tok[0] == '/' {
+		return true
+	}
+	_, err := strconv.ParseFloat(string(tok), 64)
+	return err == nil
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return c == '[' || c == ']' || c == '{' || c == '}' || c == '>' || c == ')'
+}
+
+// readLiteral decodes the literal string starting at s[i] == '(' and
+// returns it with the index just past its closing parenthesis.
+func readLiteral(s []byte, i int) (string, int) {
+	var b strings.Builder
+	depth := 0
+	for i++; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(s) {
+				return b.String(), i
+			}
+			switch e := s[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation.
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					j := 0
+					for ; j < 3 && i+j < len(s) && s[i+j] >= '0' && s[i+j] <= '7'; j++ {
+						n = n*8 + int(s[i+j]-'0')
+					}
+					i += j - 1
+					b.WriteRune(rune(n & 0xFF))
+				} else {
+					b.WriteByte(e)
+				}
+			}
+		case '(':
+			depth++
+			b.WriteByte(c)
+		case ')':
+			if depth == 0 {
+				return b.String(), i + 1
+			}
+			depth--
+			b.WriteByte(c)
+		default:
+			// Single-byte strings use Latin-1 style encodings; map bytes
+			// to the code points they most likely stand for.
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String(), i
+}
+
+// decodeHexString decodes <...> strings. With a ToUnicode map they are
+// treated as two-byte glyph codes; without one, as single bytes.
+func decodeHexString(h string, cmap map[uint16]string) string {
+	h = strings.Map(func(r rune) rune {
+		if isPDFSpace(byte(r)) {
+			return -1
+		}
+		return r
+	}, h)
+	if len(h)%2 == 1 {
+		h += "0"
+	}
+	raw := make([]byte, len(h)/2)
+	for i := range raw {
+		v, err := strconv.ParseUint(h[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return ""
+		}
+		raw[i] = byte(v)
+	}
+
+	var b strings.Builder
+	if len(cmap) > 0 && len(raw)%2 == 0 {
+		for i := 0; i < len(raw); i += 2 {
+			code := uint16(raw[i])<<8 | uint16(raw[i+1])
+			if text, ok := cmap[code]; ok {
+				b.WriteString(text)
+			}
+		}
+		return b.String()
+	}
+	for _, c := range raw {
+		b.WriteRune(rune(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF wraps content streams in just enough PDF structure for the
+// extractor: objects with stream dictionaries. Compressed streams are
+// Flate encoded.
+func buildPDF(t *testing.T, compress bool, streams ...string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for n, s := range streams {
+		data := []byte(s)
+		filter := ""
+		if compress {
+			var z bytes.Buffer
+			w := zlib.NewWriter(&z)
+			w.Write(data)
+			w.Close()
+			data = z.Bytes()
+			filter = " /Filter /FlateDecode"
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Length %d%s >>\nstream\n", n+1, len(data), filter)
+		b.Write(data)
+		b.WriteString("\nendstream\nendobj\n")
+	}
+	b.WriteString("%%EOF\n")
+	return b.Bytes()
+}
+
+func TestExtractPDFText_Literal(t *testing.T) {
+	content := "BT /F1 12 Tf 72 720 Td (Quarterly report) Tj 0 -14 Td (Revenue grew \\(a lot\\)) Tj ET"
+
+	for _, compress := range []bool{false, true} {
+		got := ExtractPDFText(buildPDF(t, compress, content))
+		want := "Quarterly report\nRevenue grew (a lot)"
+		if got != want {
+			t.Errorf("compress=%v: got %q, want %q", compress, got, want)
+		}
+	}
+}
+
+func TestExtractPDFText_TJKerningAndEscapes(t *testing.T) {
+	content := "BT [(Hel) -20 (lo) -300 (world)] TJ T* (caf\\351) Tj ET"
+
+	got := ExtractPDFText(buildPDF(t, true, content))
+	want := "Hello world\ncafé"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExtractPDFText_ToUnicode(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin
+begincmap
+2 beginbfchar
+<0001> <0048>
+<0002> <0069>
+endbfchar
+1 beginbfrange
+<0010> <0012> <0061>
+endbfrange
+endcmap`
+	content := "BT /F1 12 Tf <00010002> Tj T* <001000110012> Tj ET"
+
+	got := ExtractPDFText(buildPDF(t, true, cmap, content))
+	want := "Hi\nabc"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExtractPDFText_SkipsOtherFilters(t *testing.T) {
+	pdf := buildPDF(t, false, "BT (visible) Tj ET")
+	pdf = append(pdf, []byte("9 0 obj\n<< /Filter /DCTDecode /Length 4 >>\nstream\nBT (x) Tj ET\nendstream\nendobj\n")...)
+
+	got := ExtractPDFText(pdf)
+	if got != "visible" {
+		t.Errorf("got %q, want only the unfiltered stream's text", got)
+	}
+	if strings.Contains(got, "x") {
+		t.Errorf("text was read from an image stream: %q", got)
+	}
+}
@@ -0,0 +1,348 @@
+// Package rag indexes opted-in channels and retrieves the passages most
+// relevant to a question, for /ask.
+package rag
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+)
+
+// SourceMessage marks a chunk taken from a message's text rather than an
+// attachment.
+const SourceMessage = "message"
+
+// embedBatchSize keeps each embeddings request small enough for the
+// server's batch limit.
+const embedBatchSize = 32
+
+// Store persists chunks and which channels are indexed.
+type Store interface {
+	ChannelEnabled(channelID string) (bool, error)
+	ReplaceChunks(messageID string, chunks []db.RAGChunk) error
+	DeleteChunks(messageID string) error
+	GuildChunks(guildID string) ([]db.RAGChunk, error)
+}
+
+type dbStore struct{}
+
+// DBStore returns a Store backed by the bot's SQLite database.
+func DBStore() Store {
+	return dbStore{}
+}
+
+func (dbStore) ChannelEnabled(channelID string) (bool, error) {
+	return db.RAGChannelEnabled(channelID)
+}
+
+func (dbStore) ReplaceChunks(messageID string, chunks []db.RAGChunk) error {
+	return db.ReplaceRAGChunks(messageID, chunks)
+}
+
+func (dbStore) DeleteChunks(messageID string) error {
+	return db.DeleteRAGChunks(messageID)
+}
+
+func (dbStore) GuildChunks(guildID string) ([]db.RAGChunk, error) {
+	return db.GuildRAGChunks(guildID)
+}
+
+// Embedder turns text into vectors, e.g. llm.Client.Embed.
+type Embedder interface {
+	Embed(ctx context.Context, inputs []string) ([][]float32, error)
+}
+
+type Indexer struct {
+	store      Store
+	embedder   Embedder
+	settings   func() config.RAGConfig
+	httpClient *http.Client
+}
+
+func NewIndexer(store Store, embedder Embedder, settings func() config.RAGConfig) *Indexer {
+	return &Indexer{
+		store:      store,
+		embedder:   embedder,
+		settings:   settings,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// IndexMessage indexes a message if its channel is opted in. Messages that
+// already had chunks (i.e. edits) are replaced. It returns how many chunks
+// were stored.
+func (ix *Indexer) IndexMessage(ctx context.Context, guildID string, m *discordgo.Message) (int, error) {
+	enabled, err := ix.store.ChannelEnabled(m.ChannelID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check channel: %w", err)
+	}
+	if !enabled {
+		return 0, nil
+	}
+	return ix.index(ctx, guildID, []*discordgo.Message{m})
+}
+
+// DeleteMessage forgets a deleted message.
+func (ix *Indexer) DeleteMessage(messageID string) error {
+	return ix.store.DeleteChunks(messageID)
+}
+
+// Backfill indexes up to limit messages of a channel's history, newest
+// first. fetch returns the page of messages before beforeID, or the latest
+// page when beforeID is "".
+func (ix *Indexer) Backfill(ctx context.Context, guildID string, limit int, fetch func(beforeID string) ([]*discordgo.Message, error)) (messages, chunks int, err error) {
+	before := ""
+	for messages < limit {
+		page, err := fetch(before)
+		if err != nil {
+			return messages, chunks, fmt.Errorf("failed to fetch messages: %w", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > limit-messages {
+			page = page[:limit-messages]
+		}
+
+		n, err := ix.index(ctx, guildID, page)
+		chunks += n
+		if err != nil {
+			return messages, chunks, err
+		}
+		messages += len(page)
+		before = page[len(page)-1].ID
+	}
+	return messages, chunks, nil
+}
+
+type pending struct {
+	messageID string
+	chunk     db.RAGChunk
+	input     string
+}
+
+// index chunks and embeds messages together so a page of history costs a
+// few embedding requests rather than one per message.
+func (ix *Indexer) index(ctx context.Context, guildID string, messages []*discordgo.Message) (int, error) {
+	settings := ix.settings()
+
+	var all []pending
+	touched := make(map[string]bool)
+	for _, m := range messages {
+		if m.Author != nil && m.Author.Bot {
+			continue
+		}
+		touched[m.ID] = true
+		all = append(all, ix.prepare(ctx, guildID, m, settings)...)
+	}
+
+	for start := 0; start < len(all); start += embedBatchSize {
+		batch := all[start:min(start+embedBatchSize, len(all))]
+		inputs := make([]string, len(batch))
+		for i, p := range batch {
+			inputs[i] = p.input
+		}
+		vectors, err := ix.embedder.Embed(ctx, inputs)
+		if err != nil {
+			return 0, fmt.Errorf("failed to embed chunks: %w", err)
+		}
+		for i := range batch {
+			batch[i].chunk.Embedding = vectors[i]
+		}
+	}
+
+	byMessage := make(map[string][]db.RAGChunk)
+	for _, p := range all {
+		byMessage[p.messageID] = append(byMessage[p.messageID], p.chunk)
+	}
+	for id := range touched {
+		// Replacing with nothing clears a message edited below the
+		// minimum length.
+		if err := ix.store.ReplaceChunks(id, byMessage[id]); err != nil {
+			return 0, fmt.Errorf("failed to store chunks: %w", err)
+		}
+	}
+	return len(all), nil
+}
+
+func (ix *Indexer) prepare(ctx context.Context, guildID string, m *discordgo.Message, settings config.RAGConfig) []pending {
+	author := ""
+	if m.Author != nil {
+		author = m.Author.Username
+	}
+	base := db.RAGChunk{
+		GuildID:   guildID,
+		ChannelID: m.ChannelID,
+		MessageID: m.ID,
+		Author:    author,
+		CreatedAt: m.Timestamp,
+	}
+
+	var out []pending
+	add := func(source, text, label string) {
+		for _, piece := range Chunk(text, settings.ChunkSize, settings.ChunkOverlap) {
+			c := base
+			c.Source = source
+			c.Content = piece
+			out = append(out, pending{messageID: m.ID, chunk: c, input: label + piece})
+		}
+	}
+
+	if len(strings.TrimSpace(m.Content)) >= settings.MinMessageLength {
+		add(SourceMessage, m.Content, author+": ")
+	}
+
+	for _, a := range m.Attachments {
+		text, err := ix.attachmentText(ctx, a, settings.MaxAttachmentBytes)
+		if err != nil {
+			slog.Warn("Failed to index attachment", "message_id", m.ID, "file", a.Filename, "error", err)
+			continue
+		}
+		if text != "" {
+			add(a.Filename, text, a.Filename+": ")
+		}
+	}
+	return out
+}
+
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".csv": true, ".json": true, ".log": true,
+	".yaml": true, ".yml": true, ".xml": true, ".html": true, ".go": true,
+	".py": true, ".js": true, ".ts": true, ".sql": true, ".sh": true,
+}
+
+// attachmentText downloads a text or PDF attachment and returns its text.
+// Other files, and files over maxBytes, are skipped.
+func (ix *Indexer) attachmentText(ctx context.Context, a *discordgo.MessageAttachment, maxBytes int64) (string, error) {
+	ext := strings.ToLower(path.Ext(a.Filename))
+	contentType := strings.ToLower(a.ContentType)
+	isPDF := ext == ".pdf" || strings.HasPrefix(contentType, "application/pdf")
+	isText := textExtensions[ext] || strings.HasPrefix(contentType, "text/")
+	if !isPDF && !isText {
+		return "", nil
+	}
+	if maxBytes == 0 || int64(a.Size) > maxBytes {
+		return "", nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := ix.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return "", nil
+	}
+
+	if isPDF {
+		return ExtractPDFText(data), nil
+	}
+	return strings.ToValidUTF8(string(data), ""), nil
+}
+
+type Result struct {
+	db.RAGChunk
+	Score float64
+}
+
+// Search returns the chunks in a guild most similar to query, best first.
+// allow filters out channels the asker can't see; nil allows all.
+func (ix *Indexer) Search(ctx context.Context, guildID, query string, allow func(channelID string) bool) ([]Result, error) {
+	settings := ix.settings()
+
+	vectors, err := ix.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed question: %w", err)
+	}
+	queryVec := vectors[0]
+
+	chunks, err := ix.store.GuildChunks(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	var results []Result
+	for _, c := range chunks {
+		if allow != nil && !allow(c.ChannelID) {
+			continue
+		}
+		score := cosine(queryVec, c.Embedding)
+		if score < settings.MinScore {
+			continue
+		}
+		results = append(results, Result{RAGChunk: c, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > settings.TopK {
+		results = results[:settings.TopK]
+	}
+	return results, nil
+}
+
+// cosine returns the cosine similarity of a and b, or 0 if they can't be
+// compared (e.g. chunks embedded by a different model).
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// MessageURL links to a message in the Discord client.
+func MessageURL(guildID, channelID, messageID string) string {
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
+
+// Prompt asks the model to answer question from results only, citing them
+// by their [n] number.
+func Prompt(question string, results []Result) string {
+	var b strings.Builder
+	b.WriteString("Answer the question using only the numbered sources below, which come from this Discord server's messages and files. ")
+	b.WriteString("Cite the sources you use inline like [1] or [2][3]. ")
+	b.WriteString("If the sources don't contain the answer, say so instead of guessing.\n\n")
+	for n, r := range results {
+		fmt.Fprintf(&b, "[%d] %s", n+1, r.Author)
+		if r.Source != SourceMessage {
+			fmt.Fprintf(&b, " (file %s)", r.Source)
+		}
+		if !r.CreatedAt.IsZero() {
+			fmt.Fprintf(&b, " on %s", r.CreatedAt.UTC().Format("2006-01-02"))
+		}
+		fmt.Fprintf(&b, ":\n%s\n\n", r.Content)
+	}
+	fmt.Fprintf(&b, "Question: %s", question)
+	return b.String()
+}
@@ -0,0 +1,339 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+)
+
+type memStore struct {
+	enabled map[string]bool
+	chunks  map[string][]db.RAGChunk
+}
+
+func newMemStore(channels ...string) *memStore {
+	s := &memStore{enabled: make(map[string]bool), chunks: make(map[string][]db.RAGChunk)}
+	for _, c := range channels {
+		s.enabled[c] = true
+	}
+	return s
+}
+
+func (s *memStore) ChannelEnabled(channelID string) (bool, error) {
+	return s.enabled[channelID], nil
+}
+
+func (s *memStore) ReplaceChunks(messageID string, chunks []db.RAGChunk) error {
+	if len(chunks) == 0 {
+		delete(s.chunks, messageID)
+		return nil
+	}
+	s.chunks[messageID] = chunks
+	return nil
+}
+
+func (s *memStore) DeleteChunks(messageID string) error {
+	delete(s.chunks, messageID)
+	return nil
+}
+
+func (s *memStore) GuildChunks(guildID string) ([]db.RAGChunk, error) {
+	var out []db.RAGChunk
+	for _, chunks := range s.chunks {
+		for _, c := range chunks {
+			if c.GuildID == guildID {
+				out = append(out, c)
+			}
+		}
+	}
+	return out, nil
+}
+
+var vocabulary = []string{"deploy", "friday", "pizza", "budget", "server", "invoice"}
+
+// wordEmbedder embeds text as counts of a few known words, so similarity
+// follows shared vocabulary.
+type wordEmbedder struct {
+	calls  int
+	inputs []string
+}
+
+func (e *wordEmbedder) Embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	e.calls++
+	e.inputs = append(e.inputs, inputs...)
+	out := make([][]float32, len(inputs))
+	for i, in := range inputs {
+		v := make([]float32, len(vocabulary))
+		for j, w := range vocabulary {
+			v[j] = float32(strings.Count(strings.ToLower(in), w))
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func testSettings() config.RAGConfig {
+	return config.RAGConfig{
+		ChunkSize:          200,
+		ChunkOverlap:       30,
+		MinMessageLength:   10,
+		MaxAttachmentBytes: 1 << 20,
+		TopK:               3,
+		MinScore:           0.1,
+	}
+}
+
+func message(id, channelID, author, content string) *discordgo.Message {
+	return &discordgo.Message{
+		ID:        id,
+		ChannelID: channelID,
+		Content:   content,
+		Author:    &discordgo.User{Username: author},
+		Timestamp: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestChunk(t *testing.T) {
+	if got := Chunk("  short text  ", 100, 10); len(got) != 1 || got[0] != "short text" {
+		t.Errorf("short text: got %q", got)
+	}
+	if got := Chunk("   ", 100, 10); got != nil {
+		t.Errorf("blank text: got %q, want nil", got)
+	}
+
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40)
+	chunks := Chunk(text, 200, 40)
+	if len(chunks) < 9 {
+		t.Fatalf("expected the text to be split into many chunks, got %d", len(chunks))
+	}
+	for i, c := range chunks {
+		if len(c) > 200 {
+			t.Errorf("chunk %d is %d bytes, over the limit", i, len(c))
+		}
+		if c != strings.TrimSpace(c) {
+			t.Errorf("chunk %d isn't trimmed: %q", i, c)
+		}
+	}
+	// Breaks land on sentence ends, so every chunk but the last ends with one.
+	for i, c := range chunks[:len(chunks)-1] {
+		if !strings.HasSuffix(c, ".") {
+			t.Errorf("chunk %d doesn't end at a sentence: %q", i, c)
+		}
+	}
+	// Consecutive chunks overlap.
+	for i := 1; i < len(chunks); i++ {
+		tail := chunks[i-1][len(chunks[i-1])-10:]
+		if !strings.Contains(chunks[i], strings.TrimSpace(tail)) {
+			t.Errorf("chunk %d doesn't overlap the previous one", i)
+			break
+		}
+	}
+}
+
+func TestChunk_UTF8(t *testing.T) {
+	text := strings.Repeat("é", 500)
+	for i, c := range Chunk(text, 101, 10) {
+		if !strings.HasPrefix(c, "é") || !strings.HasSuffix(c, "é") {
+			t.Fatalf("chunk %d splits a character: %q", i, c)
+		}
+	}
+}
+
+func TestIndexMessage(t *testing.T) {
+	store := newMemStore("general")
+	embedder := &wordEmbedder{}
+	ix := NewIndexer(store, embedder, testSettings)
+	ctx := context.Background()
+
+	n, err := ix.IndexMessage(ctx, "g1", message("m1", "general", "alice", "We deploy the server every friday afternoon"))
+	if err != nil {
+		t.Fatalf("IndexMessage: %v", err)
+	}
+	if n != 1 || len(store.chunks["m1"]) != 1 {
+		t.Fatalf("expected one chunk for m1, got n=%d, stored=%v", n, store.chunks["m1"])
+	}
+	c := store.chunks["m1"][0]
+	if c.GuildID != "g1" || c.ChannelID != "general" || c.Author != "alice" || c.Source != SourceMessage {
+		t.Errorf("unexpected chunk metadata: %+v", c)
+	}
+	if embedder.inputs[0] != "alice: We deploy the server every friday afternoon" {
+		t.Errorf("expected the author to be embedded with the text, got %q", embedder.inputs[0])
+	}
+
+	// Channels that aren't opted in, bots and short messages are skipped.
+	skipped := []*discordgo.Message{
+		message("m2", "random", "bob", "We deploy the server every friday afternoon"),
+		message("m3", "general", "bob", "ok"),
+	}
+	bot := message("m4", "general", "helper", "We deploy the server every friday afternoon")
+	bot.Author.Bot = true
+	skipped = append(skipped, bot)
+	for _, m := range skipped {
+		if n, err := ix.IndexMessage(ctx, "g1", m); err != nil || n != 0 {
+			t.Errorf("message %s: expected it to be skipped, got n=%d err=%v", m.ID, n, err)
+		}
+		if _, ok := store.chunks[m.ID]; ok {
+			t.Errorf("message %s was stored", m.ID)
+		}
+	}
+
+	// An edit that makes the message too short removes its chunks.
+	if _, err := ix.IndexMessage(ctx, "g1", message("m1", "general", "alice", "nvm")); err != nil {
+		t.Fatalf("IndexMessage edit: %v", err)
+	}
+	if _, ok := store.chunks["m1"]; ok {
+		t.Error("expected the edited message's chunks to be removed")
+	}
+}
+
+func TestIndexMessage_Attachments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notes.txt":
+			fmt.Fprint(w, "The invoice budget for the server is approved.")
+		case "/report.pdf":
+			w.Write(buildPDF(t, true, "BT (Budget for pizza friday) Tj ET"))
+		default:
+			t.Errorf("unexpected download of %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	store := newMemStore("general")
+	ix := NewIndexer(store, &wordEmbedder{}, testSettings)
+
+	m := message("m1", "general", "alice", "files")
+	m.Attachments = []*discordgo.MessageAttachment{
+		{Filename: "notes.txt", URL: server.URL + "/notes.txt", Size: 47},
+		{Filename: "report.pdf", URL: server.URL + "/report.pdf", Size: 500},
+		{Filename: "photo.png", URL: server.URL + "/photo.png", Size: 100, ContentType: "image/png"},
+		{Filename: "huge.txt", URL: server.URL + "/huge.txt", Size: 2 << 20},
+	}
+
+	n, err := ix.IndexMessage(context.Background(), "g1", m)
+	if err != nil {
+		t.Fatalf("IndexMessage: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected a chunk for each readable attachment, got %d: %+v", n, store.chunks["m1"])
+	}
+	got := map[string]string{}
+	for _, c := range store.chunks["m1"] {
+		got[c.Source] = c.Content
+	}
+	if got["notes.txt"] != "The invoice budget for the server is approved." {
+		t.Errorf("unexpected text chunk %q", got["notes.txt"])
+	}
+	if got["report.pdf"] != "Budget for pizza friday" {
+		t.Errorf("unexpected PDF chunk %q", got["report.pdf"])
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	var history []*discordgo.Message
+	for i := 10; i > 0; i-- {
+		history = append(history, message(fmt.Sprintf("%02d", i), "general", "alice", fmt.Sprintf("message number %d about the budget", i)))
+	}
+	fetch := func(before string) ([]*discordgo.Message, error) {
+		start := 0
+		if before != "" {
+			for i, m := range history {
+				if m.ID == before {
+					start = i + 1
+				}
+			}
+		}
+		end := min(start+4, len(history))
+		return history[start:end], nil
+	}
+
+	store := newMemStore("general")
+	embedder := &wordEmbedder{}
+	ix := NewIndexer(store, embedder, testSettings)
+
+	messages, chunks, err := ix.Backfill(context.Background(), "g1", 7, fetch)
+	if err != nil {
+		t.Fatalf("Backfill: %v", err)
+	}
+	if messages != 7 || chunks != 7 {
+		t.Errorf("expected 7 messages and chunks, got %d and %d", messages, chunks)
+	}
+	if _, ok := store.chunks["10"]; !ok {
+		t.Error("expected the newest message to be indexed")
+	}
+	if _, ok := store.chunks["03"]; embedder.calls != 2 || ok {
+		t.Errorf("expected the limit to stop at message 04 in two pages, got %d embed calls", embedder.calls)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := newMemStore("general", "private")
+	ix := NewIndexer(store, &wordEmbedder{}, testSettings)
+	ctx := context.Background()
+
+	for _, m := range []*discordgo.Message{
+		message("m1", "general", "alice", "pizza on friday after the deploy"),
+		message("m2", "general", "bob", "the budget invoice is due"),
+		message("m3", "private", "carol", "friday deploy of the new server"),
+		message("m4", "general", "dave", "lunch thoughts, nothing relevant"),
+	} {
+		if _, err := ix.IndexMessage(ctx, "g1", m); err != nil {
+			t.Fatalf("IndexMessage: %v", err)
+		}
+	}
+
+	results, err := ix.Search(ctx, "g1", "when do we deploy the server?", nil)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 2 || results[0].MessageID != "m3" || results[1].MessageID != "m1" {
+		t.Fatalf("expected m3 then m1, got %+v", results)
+	}
+	if results[0].Score <= results[1].Score {
+		t.Errorf("expected results best first, got %v then %v", results[0].Score, results[1].Score)
+	}
+
+	onlyGeneral := func(channelID string) bool { return channelID == "general" }
+	results, err = ix.Search(ctx, "g1", "when do we deploy the server?", onlyGeneral)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 1 || results[0].MessageID != "m1" {
+		t.Errorf("expected hidden channels to be filtered out, got %+v", results)
+	}
+
+	if results, _ := ix.Search(ctx, "other-guild", "deploy", nil); len(results) != 0 {
+		t.Errorf("expected no results from another guild, got %+v", results)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	results := []Result{
+		{RAGChunk: db.RAGChunk{Author: "alice", Source: SourceMessage, Content: "deploys are on friday", CreatedAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}},
+		{RAGChunk: db.RAGChunk{Author: "bob", Source: "runbook.pdf", Content: "roll back with make revert"}},
+	}
+	prompt := Prompt("When do we deploy?", results)
+
+	for _, want := range []string{
+		"[1] alice on 2026-03-01:\ndeploys are on friday",
+		"[2] bob (file runbook.pdf):\nroll back with make revert",
+		"Question: When do we deploy?",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+
+	if got := MessageURL("1", "2", "3"); got != "https://discord.com/channels/1/2/3" {
+		t.Errorf("unexpected message URL %q", got)
+	}
+}
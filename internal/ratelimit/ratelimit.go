@@ -0,0 +1,345 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+)
+
+const (
+	scopeUser  = "user"
+	scopeGuild = "guild"
+)
+
+// Clock lets tests control time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Store persists daily quota usage and exemptions.
+type Store interface {
+	Usage(scope, scopeID, command, day string) (int, error)
+	AddUsage(scope, scopeID, command, day string, amount int) error
+	// Prune deletes usage recorded before beforeDay.
+	Prune(beforeDay string) error
+	Exemptions(guildID string) ([]db.RateLimitExemption, error)
+}
+
+type dbStore struct{}
+
+// DBStore returns a Store backed by the bot's SQLite database.
+func DBStore() Store {
+	return dbStore{}
+}
+
+func (dbStore) Usage(scope, scopeID, command, day string) (int, error) {
+	return db.GetQuotaUsage(scope, scopeID, command, day)
+}
+
+func (dbStore) AddUsage(scope, scopeID, command, day string, amount int) error {
+	return db.AddQuotaUsage(scope, scopeID, command, day, amount)
+}
+
+func (dbStore) Prune(beforeDay string) error {
+	return db.PruneQuotaUsage(beforeDay)
+}
+
+func (dbStore) Exemptions(guildID string) ([]db.RateLimitExemption, error) {
+	return db.ListRateLimitExemptions(guildID)
+}
+
+// Rules returns the limits for a command, if it has any.
+type Rules func(command string) (config.RateLimitConfig, bool)
+
+type Request struct {
+	Command string
+	GuildID string
+	UserID  string
+	RoleIDs []string
+
+	// Cost is how many uses the request counts as. Values below 1 count
+	// as 1.
+	Cost int
+}
+
+type Decision struct {
+	Allowed bool
+	Exempt  bool
+
+	// Reason and RetryAfter explain a denial.
+	Reason     string
+	RetryAfter time.Duration
+
+	// UserUsed and UserLimit describe the user's daily quota after the
+	// request. A zero UserLimit means there is no daily quota.
+	UserUsed  int
+	UserLimit int
+
+	// Day is the UTC day an allowed request's use was recorded on, for
+	// Refund.
+	Day string
+}
+
+// Remaining returns how many uses the user has left today, or -1 if the
+// command has no daily quota.
+func (d Decision) Remaining() int {
+	if d.UserLimit == 0 {
+		return -1
+	}
+	return max(d.UserLimit-d.UserUsed, 0)
+}
+
+type Limiter struct {
+	rules Rules
+	store Store
+	clock Clock
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// prunedDay is the day old usage was last pruned on.
+	prunedDay string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rules Rules, store Store, clock Clock) *Limiter {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &Limiter{
+		rules:   rules,
+		store:   store,
+		clock:   clock,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow checks req against the command's token bucket and daily quotas and
+// records the usage if it is allowed. Exempt users are always allowed and
+// nothing is recorded for them.
+func (l *Limiter) Allow(req Request) (Decision, error) {
+	return l.check(req, true)
+}
+
+// Peek reports the user's quota for req.Command without using any of it.
+func (l *Limiter) Peek(req Request) (Decision, error) {
+	return l.check(req, false)
+}
+
+// Refund gives back a use Allow recorded for req on day, the allowing
+// decision's Day, for a request that was rejected before it did any work.
+// It's taken off that day's usage, so a refund after midnight doesn't
+// push the new day's below zero.
+func (l *Limiter) Refund(req Request, day string) error {
+	rule, ok := l.rules(req.Command)
+	if !ok {
+		return nil
+	}
+	exempt, err := l.isExempt(req)
+	if err != nil {
+		return fmt.Errorf("failed to load exemptions: %w", err)
+	}
+	if exempt {
+		return nil
+	}
+
+	cost := max(req.Cost, 1)
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.store.AddUsage(scopeUser, req.UserID, req.Command, day, -cost); err != nil {
+		return fmt.Errorf("failed to refund user usage: %w", err)
+	}
+	if req.GuildID != "" {
+		if err := l.store.AddUsage(scopeGuild, req.GuildID, req.Command, day, -cost); err != nil {
+			return fmt.Errorf("failed to refund guild usage: %w", err)
+		}
+	}
+	if rule.Burst > 0 {
+		b := l.refill(req.Command+":"+req.UserID, rule, now)
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+float64(min(cost, rule.Burst)))
+	}
+	return nil
+}
+
+func (l *Limiter) check(req Request, consume bool) (Decision, error) {
+	rule, ok := l.rules(req.Command)
+	if !ok {
+		return Decision{Allowed: true}, nil
+	}
+
+	exempt, err := l.isExempt(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to load exemptions: %w", err)
+	}
+	if exempt {
+		return Decision{Allowed: true, Exempt: true}, nil
+	}
+
+	cost := max(req.Cost, 1)
+	now := l.clock.Now()
+	day := now.UTC().Format(time.DateOnly)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	decision := Decision{UserLimit: userLimit(rule, req.RoleIDs)}
+	decision.UserUsed, err = l.store.Usage(scopeUser, req.UserID, req.Command, day)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to load user usage: %w", err)
+	}
+
+	if !consume {
+		decision.Allowed = decision.UserLimit == 0 || decision.UserUsed < decision.UserLimit
+		return decision, nil
+	}
+
+	l.prune(now)
+
+	var b *bucket
+	if rule.Burst > 0 {
+		b = l.refill(req.Command+":"+req.UserID, rule, now)
+		need := float64(min(cost, rule.Burst))
+		if b.tokens < need {
+			wait := time.Duration((need - b.tokens) / rule.PerMinute * float64(time.Minute))
+			decision.Reason = fmt.Sprintf("You're using `/%s` too quickly", req.Command)
+			decision.RetryAfter = wait.Round(time.Second)
+			return decision, nil
+		}
+	}
+
+	untilReset := nextUTCMidnight(now).Sub(now).Round(time.Minute)
+
+	if decision.UserLimit > 0 && decision.UserUsed+cost > decision.UserLimit {
+		decision.Reason = fmt.Sprintf("You've used your daily `/%s` quota", req.Command)
+		decision.RetryAfter = untilReset
+		return decision, nil
+	}
+
+	if rule.GuildDaily > 0 && req.GuildID != "" {
+		guildUsed, err := l.store.Usage(scopeGuild, req.GuildID, req.Command, day)
+		if err != nil {
+			return Decision{}, fmt.Errorf("failed to load guild usage: %w", err)
+		}
+		if guildUsed+cost > rule.GuildDaily {
+			decision.Reason = fmt.Sprintf("This server has used its daily `/%s` quota", req.Command)
+			decision.RetryAfter = untilReset
+			return decision, nil
+		}
+	}
+
+	if err := l.store.AddUsage(scopeUser, req.UserID, req.Command, day, cost); err != nil {
+		return Decision{}, fmt.Errorf("failed to record user usage: %w", err)
+	}
+	if req.GuildID != "" {
+		if err := l.store.AddUsage(scopeGuild, req.GuildID, req.Command, day, cost); err != nil {
+			return Decision{}, fmt.Errorf("failed to record guild usage: %w", err)
+		}
+	}
+	if b != nil {
+		b.tokens -= float64(min(cost, rule.Burst))
+	}
+
+	decision.Allowed = true
+	decision.UserUsed += cost
+	decision.Day = day
+	return decision, nil
+}
+
+// prune deletes usage from before yesterday on the first use each day, so
+// the table only ever holds a couple of days. Yesterday's is kept for
+// requests that straddled midnight. Buckets that have refilled since they
+// were last used are dropped too: a new one starts full, so they make no
+// difference. l.mu must be held.
+func (l *Limiter) prune(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if day == l.prunedDay {
+		return
+	}
+	l.prunedDay = day
+	if err := l.store.Prune(now.UTC().AddDate(0, 0, -1).Format(time.DateOnly)); err != nil {
+		slog.Warn("Failed to prune old quota usage", "error", err)
+	}
+
+	for key, b := range l.buckets {
+		command, _, _ := strings.Cut(key, ":")
+		rule, ok := l.rules(command)
+		if !ok || b.tokens+now.Sub(b.last).Minutes()*rule.PerMinute >= float64(rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// refill returns the bucket for key topped up for the time since it was
+// last used. New buckets start full.
+func (l *Limiter) refill(key string, rule config.RateLimitConfig, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), last: now}
+		l.buckets[key] = b
+		return b
+	}
+	elapsed := now.Sub(b.last).Minutes()
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+elapsed*rule.PerMinute)
+	b.last = now
+	return b
+}
+
+func (l *Limiter) isExempt(req Request) (bool, error) {
+	if req.GuildID == "" {
+		return false, nil
+	}
+	exemptions, err := l.store.Exemptions(req.GuildID)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range exemptions {
+		switch e.SubjectType {
+		case "user":
+			if e.SubjectID == req.UserID {
+				return true, nil
+			}
+		case "role":
+			if slices.Contains(req.RoleIDs, e.SubjectID) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func userLimit(rule config.RateLimitConfig, roleIDs []string) int {
+	limit := rule.UserDaily
+	if limit == 0 {
+		return 0
+	}
+	for _, role := range roleIDs {
+		if quota, ok := rule.RoleDaily[role]; ok && quota > limit {
+			limit = quota
+		}
+	}
+	return limit
+}
+
+func nextUTCMidnight(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,296 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+type memoryStore struct {
+	usage      map[string]int
+	exemptions map[string][]db.RateLimitExemption
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		usage:      make(map[string]int),
+		exemptions: make(map[string][]db.RateLimitExemption),
+	}
+}
+
+func (s *memoryStore) Usage(scope, scopeID, command, day string) (int, error) {
+	return s.usage[strings.Join([]string{scope, scopeID, command, day}, "|")], nil
+}
+
+func (s *memoryStore) AddUsage(scope, scopeID, command, day string, amount int) error {
+	s.usage[strings.Join([]string{scope, scopeID, command, day}, "|")] += amount
+	return nil
+}
+
+func (s *memoryStore) Prune(beforeDay string) error {
+	for key := range s.usage {
+		if key[strings.LastIndex(key, "|")+1:] < beforeDay {
+			delete(s.usage, key)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Exemptions(guildID string) ([]db.RateLimitExemption, error) {
+	return s.exemptions[guildID], nil
+}
+
+func newTestLimiter(rule config.RateLimitConfig) (*Limiter, *fakeClock, *memoryStore) {
+	clock := &fakeClock{now: time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)}
+	store := newMemoryStore()
+	rules := func(command string) (config.RateLimitConfig, bool) {
+		return rule, command == "imagine"
+	}
+	return NewLimiter(rules, store, clock), clock, store
+}
+
+func allow(t *testing.T, l *Limiter, req Request) Decision {
+	t.Helper()
+	d, err := l.Allow(req)
+	if err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+	return d
+}
+
+func TestLimiter_TokenBucketRefills(t *testing.T) {
+	l, clock, _ := newTestLimiter(config.RateLimitConfig{Burst: 2, PerMinute: 1})
+	req := Request{Command: "imagine", GuildID: "g", UserID: "u"}
+
+	for i := 0; i < 2; i++ {
+		if d := allow(t, l, req); !d.Allowed {
+			t.Fatalf("Expected request %d within burst to be allowed: %s", i+1, d.Reason)
+		}
+	}
+
+	d := allow(t, l, req)
+	if d.Allowed {
+		t.Fatal("Expected third request to be rate limited")
+	}
+	if d.RetryAfter != time.Minute {
+		t.Errorf("Expected retry after 1m, got %s", d.RetryAfter)
+	}
+
+	clock.Advance(30 * time.Second)
+	if d := allow(t, l, req); d.Allowed {
+		t.Error("Expected half a token not to be enough")
+	}
+
+	clock.Advance(30 * time.Second)
+	if d := allow(t, l, req); !d.Allowed {
+		t.Errorf("Expected request to be allowed after refill: %s", d.Reason)
+	}
+}
+
+func TestLimiter_BucketsArePerUser(t *testing.T) {
+	l, _, _ := newTestLimiter(config.RateLimitConfig{Burst: 1, PerMinute: 1})
+
+	allow(t, l, Request{Command: "imagine", UserID: "a"})
+	if d := allow(t, l, Request{Command: "imagine", UserID: "b"}); !d.Allowed {
+		t.Error("Expected another user to have their own bucket")
+	}
+}
+
+func TestLimiter_DailyQuotaResetsAtMidnightUTC(t *testing.T) {
+	l, clock, _ := newTestLimiter(config.RateLimitConfig{UserDaily: 3})
+	req := Request{Command: "imagine", GuildID: "g", UserID: "u"}
+
+	d := allow(t, l, req)
+	if d.Remaining() != 2 {
+		t.Errorf("Expected 2 remaining, got %d", d.Remaining())
+	}
+
+	// A 2048x2048 image costs 4 and would exceed what's left.
+	d = allow(t, l, Request{Command: "imagine", GuildID: "g", UserID: "u", Cost: 4})
+	if d.Allowed {
+		t.Fatal("Expected expensive request to exceed the quota")
+	}
+	if d.RetryAfter != 12*time.Hour {
+		t.Errorf("Expected retry at midnight UTC (12h), got %s", d.RetryAfter)
+	}
+	if d.Remaining() != 2 {
+		t.Errorf("Denied request must not use quota, got %d remaining", d.Remaining())
+	}
+
+	allow(t, l, req)
+	allow(t, l, req)
+	if d := allow(t, l, req); d.Allowed || d.Remaining() != 0 {
+		t.Errorf("Expected quota exhausted, got allowed=%v remaining=%d", d.Allowed, d.Remaining())
+	}
+
+	clock.Advance(12 * time.Hour)
+	if d := allow(t, l, req); !d.Allowed || d.Remaining() != 2 {
+		t.Errorf("Expected quota reset after midnight, got allowed=%v remaining=%d", d.Allowed, d.Remaining())
+	}
+}
+
+func TestLimiter_PrunesOldUsage(t *testing.T) {
+	l, clock, store := newTestLimiter(config.RateLimitConfig{UserDaily: 3})
+	req := Request{Command: "imagine", GuildID: "g", UserID: "u"}
+	used := func(day string) int {
+		return store.usage["user|u|imagine|"+day]
+	}
+
+	allow(t, l, req)
+	clock.Advance(24 * time.Hour)
+	allow(t, l, req)
+	if used("2026-03-14") != 1 {
+		t.Error("Expected yesterday's usage to be kept")
+	}
+
+	clock.Advance(24 * time.Hour)
+	allow(t, l, req)
+	if _, ok := store.usage["user|u|imagine|2026-03-14"]; ok {
+		t.Error("Expected usage from before yesterday to be pruned")
+	}
+	if used("2026-03-15") != 1 || used("2026-03-16") != 1 {
+		t.Errorf("Expected recent usage to be kept, got %v", store.usage)
+	}
+}
+
+func TestLimiter_PruneEvictsFullBuckets(t *testing.T) {
+	l, clock, _ := newTestLimiter(config.RateLimitConfig{Burst: 2, PerMinute: 0.01})
+	req := func(user string) Request {
+		return Request{Command: "imagine", GuildID: "g", UserID: user}
+	}
+
+	allow(t, l, req("idle"))
+	clock.Advance(11*time.Hour + 59*time.Minute)
+	allow(t, l, req("recent"))
+	// The first use after midnight prunes.
+	clock.Advance(2 * time.Minute)
+	allow(t, l, req("new"))
+
+	if _, ok := l.buckets["imagine:idle"]; ok {
+		t.Error("Expected the refilled bucket to be evicted")
+	}
+	if _, ok := l.buckets["imagine:recent"]; !ok {
+		t.Error("Expected the bucket still refilling to be kept")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("Expected 2 buckets left, got %d", len(l.buckets))
+	}
+}
+
+func TestLimiter_RefundGivesBackUse(t *testing.T) {
+	l, _, store := newTestLimiter(config.RateLimitConfig{Burst: 2, PerMinute: 1, UserDaily: 5, GuildDaily: 10})
+	req := Request{Command: "imagine", GuildID: "g", UserID: "u", Cost: 2}
+
+	d := allow(t, l, req)
+	if err := l.Refund(req, d.Day); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if used := store.usage["user|u|imagine|2026-03-14"]; used != 0 {
+		t.Errorf("Expected the user's usage refunded, got %d", used)
+	}
+	if used := store.usage["guild|g|imagine|2026-03-14"]; used != 0 {
+		t.Errorf("Expected the guild's usage refunded, got %d", used)
+	}
+	if d := allow(t, l, req); !d.Allowed || d.Remaining() != 3 {
+		t.Errorf("Expected the burst and quota back, got allowed=%v remaining=%d", d.Allowed, d.Remaining())
+	}
+}
+
+func TestLimiter_RefundAfterMidnight(t *testing.T) {
+	l, clock, store := newTestLimiter(config.RateLimitConfig{UserDaily: 5})
+	req := Request{Command: "imagine", UserID: "u"}
+
+	clock.Advance(11*time.Hour + 59*time.Minute)
+	d := allow(t, l, req)
+	clock.Advance(2 * time.Minute)
+	if err := l.Refund(req, d.Day); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if used := store.usage["user|u|imagine|2026-03-14"]; used != 0 {
+		t.Errorf("Expected the use refunded on the day it was made, got %d", used)
+	}
+	if used := store.usage["user|u|imagine|2026-03-15"]; used != 0 {
+		t.Errorf("Expected the new day untouched, got %d", used)
+	}
+}
+
+func TestLimiter_RoleQuotaReplacesUserQuota(t *testing.T) {
+	l, _, _ := newTestLimiter(config.RateLimitConfig{
+		UserDaily: 1,
+		RoleDaily: map[string]int{"patron": 5, "booster": 3},
+	})
+
+	d := allow(t, l, Request{Command: "imagine", UserID: "u", RoleIDs: []string{"booster", "patron"}})
+	if d.UserLimit != 5 {
+		t.Errorf("Expected highest role quota 5, got %d", d.UserLimit)
+	}
+
+	d = allow(t, l, Request{Command: "imagine", UserID: "v"})
+	if d.UserLimit != 1 {
+		t.Errorf("Expected default user quota 1, got %d", d.UserLimit)
+	}
+}
+
+func TestLimiter_GuildQuotaIsShared(t *testing.T) {
+	l, _, _ := newTestLimiter(config.RateLimitConfig{GuildDaily: 2})
+
+	allow(t, l, Request{Command: "imagine", GuildID: "g", UserID: "a"})
+	allow(t, l, Request{Command: "imagine", GuildID: "g", UserID: "b"})
+
+	d := allow(t, l, Request{Command: "imagine", GuildID: "g", UserID: "c"})
+	if d.Allowed || !strings.Contains(d.Reason, "server") {
+		t.Errorf("Expected guild quota denial, got allowed=%v reason=%q", d.Allowed, d.Reason)
+	}
+
+	if d := allow(t, l, Request{Command: "imagine", GuildID: "other", UserID: "c"}); !d.Allowed {
+		t.Error("Expected other guilds to be unaffected")
+	}
+}
+
+func TestLimiter_ExemptionsSkipLimitsAndUsage(t *testing.T) {
+	l, _, store := newTestLimiter(config.RateLimitConfig{Burst: 1, PerMinute: 1, UserDaily: 1})
+	store.exemptions["g"] = []db.RateLimitExemption{
+		{SubjectType: "user", SubjectID: "admin"},
+		{SubjectType: "role", SubjectID: "mods"},
+	}
+
+	for i := 0; i < 3; i++ {
+		if d := allow(t, l, Request{Command: "imagine", GuildID: "g", UserID: "admin"}); !d.Allowed || !d.Exempt {
+			t.Fatalf("Expected exempt user to be allowed, got %+v", d)
+		}
+		if d := allow(t, l, Request{Command: "imagine", GuildID: "g", UserID: "m", RoleIDs: []string{"mods"}}); !d.Allowed || !d.Exempt {
+			t.Fatalf("Expected exempt role to be allowed, got %+v", d)
+		}
+	}
+	if len(store.usage) != 0 {
+		t.Errorf("Expected no usage recorded for exempt users, got %v", store.usage)
+	}
+}
+
+func TestLimiter_UnlimitedCommand(t *testing.T) {
+	l, _, store := newTestLimiter(config.RateLimitConfig{UserDaily: 1})
+
+	for i := 0; i < 3; i++ {
+		if d := allow(t, l, Request{Command: "ping", UserID: "u"}); !d.Allowed || d.Remaining() != -1 {
+			t.Fatalf("Expected commands without rules to be unlimited, got %+v", d)
+		}
+	}
+	if len(store.usage) != 0 {
+		t.Errorf("Expected no usage recorded, got %v", store.usage)
+	}
+}
@@ -0,0 +1,78 @@
+package safety
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPClassifier asks a local safety-checker service to score images. It
+// POSTs {"image": "<base64>"} to the URL and reads back {"score": 0.93};
+// a checker that only says yes or no may answer {"nsfw": true} instead.
+type HTTPClassifier struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewHTTPClassifier(url string, timeout time.Duration) *HTTPClassifier {
+	return &HTTPClassifier{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+type checkRequest struct {
+	Image string `json:"image"`
+}
+
+type checkResponse struct {
+	Score *float64 `json:"score"`
+	NSFW  *bool    `json:"nsfw"`
+}
+
+func (c *HTTPClassifier) Classify(ctx context.Context, image []byte) (float64, error) {
+	jsonData, err := json.Marshal(checkRequest{Image: base64.StdEncoding.EncodeToString(image)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("safety checker returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result checkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	switch {
+	case result.Score != nil:
+		if *result.Score < 0 || *result.Score > 1 {
+			return 0, fmt.Errorf("safety checker returned score %g, outside 0 to 1", *result.Score)
+		}
+		return *result.Score, nil
+	case result.NSFW != nil && *result.NSFW:
+		return 1, nil
+	case result.NSFW != nil:
+		return 0, nil
+	}
+	return 0, errors.New("safety checker returned neither a score nor a verdict")
+}
@@ -0,0 +1,268 @@
+// Package safety keeps generated images out of channels they aren't fit
+// for. Prompts are screened for blocked terms before generation, and the
+// images that come back are scored by a classifier, then posted, posted as
+// spoilers or withheld depending on whether the channel is age-restricted.
+package safety
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/moderation"
+)
+
+// Classifier scores how likely an image is to be NSFW, from 0 to 1.
+type Classifier interface {
+	Classify(ctx context.Context, image []byte) (float64, error)
+}
+
+// Action is what happens to a generated image.
+type Action string
+
+const (
+	ActionAllow   Action = "allow"
+	ActionSpoiler Action = "spoiler"
+	ActionBlock   Action = "block"
+)
+
+// Decision is the gate's ruling on one image. Score is the classifier's,
+// or -1 if it couldn't score the image.
+type Decision struct {
+	Action Action
+	Score  float64
+	Reason string
+}
+
+// Gate applies a server's image safety settings.
+type Gate struct {
+	settings   func(guildID string) *config.Config
+	classifier Classifier
+
+	mu    sync.Mutex
+	rules map[string]*rules
+}
+
+// NewGate returns a gate that reads each server's settings when it checks
+// a prompt or images, so reloads take effect at once. classifier may be
+// nil, in which case every image is allowed.
+func NewGate(settings func(guildID string) *config.Config, classifier Classifier) *Gate {
+	return &Gate{
+		settings:   settings,
+		classifier: classifier,
+		rules:      make(map[string]*rules),
+	}
+}
+
+// rules are a server's blocked and rewritten terms, compiled.
+type rules struct {
+	blocked  *regexp.Regexp
+	rewrites []rewrite
+}
+
+type rewrite struct {
+	term *regexp.Regexp
+	with string
+}
+
+func termPattern(terms ...string) *regexp.Regexp {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+func (g *Gate) compiled(cfg config.ImageSafetyConfig) *rules {
+	terms := make([]string, 0, len(cfg.RewriteTerms))
+	for term := range cfg.RewriteTerms {
+		terms = append(terms, term)
+	}
+	// Longer terms first, so "nude beach" is rewritten before "nude".
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i]) != len(terms[j]) {
+			return len(terms[i]) > len(terms[j])
+		}
+		return terms[i] < terms[j]
+	})
+	var key strings.Builder
+	key.WriteString(strings.Join(cfg.BlockedTerms, "\x00"))
+	for _, term := range terms {
+		key.WriteString("\x01" + term + "\x00" + cfg.RewriteTerms[term])
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r, ok := g.rules[key.String()]; ok {
+		return r
+	}
+
+	r := &rules{blocked: termPattern(cfg.BlockedTerms...)}
+	for _, term := range terms {
+		if re := termPattern(term); re != nil {
+			r.rewrites = append(r.rewrites, rewrite{term: re, with: cfg.RewriteTerms[term]})
+		}
+	}
+
+	// Settings rarely change, so this only grows on reloads.
+	g.rules[key.String()] = r
+	return r
+}
+
+// CheckPrompt screens an image prompt. It returns the prompt with the
+// server's rewrites applied, and the blocked terms it contains; a prompt
+// with any must not be generated. Refusals are reported to the mod-log.
+func (g *Gate) CheckPrompt(s moderation.Sender, sub moderation.Subject, prompt string) (string, []string) {
+	if g == nil {
+		return prompt, nil
+	}
+	cfg := g.settings(sub.GuildID)
+	if !cfg.ImageSafety.Enabled {
+		return prompt, nil
+	}
+
+	r := g.compiled(cfg.ImageSafety)
+	if r.blocked != nil {
+		if found := uniqueLower(r.blocked.FindAllString(prompt, -1)); len(found) > 0 {
+			slog.Info("Image safety refused prompt", "guild_id", sub.GuildID, "user_id", sub.UserID, "command", sub.Command, "terms", found)
+			reasons := make([]string, len(found))
+			for n, term := range found {
+				reasons[n] = fmt.Sprintf("blocked term %q", term)
+			}
+			report(s, cfg.Moderation.LogChannel, sub, "🚩 Image prompt refused", "```\n"+excerpt(prompt)+"\n```", reasons)
+			return prompt, found
+		}
+	}
+
+	rewritten := prompt
+	for _, rw := range r.rewrites {
+		rewritten = rw.term.ReplaceAllLiteralString(rewritten, rw.with)
+	}
+	if rewritten != prompt {
+		rewritten = tidy(rewritten)
+		slog.Info("Image safety rewrote prompt", "guild_id", sub.GuildID, "user_id", sub.UserID, "command", sub.Command, "from", prompt, "to", rewritten)
+	}
+	return rewritten, nil
+}
+
+// ClassifiesImages reports whether CheckImages does anything for a
+// server, so callers know whether what they show along the way, like live
+// previews, has been checked.
+func (g *Gate) ClassifiesImages(guildID string) bool {
+	return g != nil && g.classifier != nil && g.settings(guildID).ImageSafety.Enabled
+}
+
+// CheckImages decides how each of images may be posted in a channel, nsfw
+// saying whether it is age-restricted. Images scoring at or over the
+// threshold are spoilers in NSFW channels and blocked elsewhere; images
+// the classifier fails on are spoilers everywhere. Every decision is
+// logged, and blocked images are reported to the mod-log.
+func (g *Gate) CheckImages(ctx context.Context, s moderation.Sender, sub moderation.Subject, nsfw bool, images [][]byte) []Decision {
+	decisions := make([]Decision, len(images))
+	for n := range decisions {
+		decisions[n] = Decision{Action: ActionAllow, Score: -1}
+	}
+	if !g.ClassifiesImages(sub.GuildID) {
+		return decisions
+	}
+	cfg := g.settings(sub.GuildID)
+
+	var reasons []string
+	for n, image := range images {
+		d := decide(ctx, g.classifier, cfg.ImageSafety.Threshold, nsfw, image)
+		decisions[n] = d
+		slog.Info("Image safety decision", "guild_id", sub.GuildID, "channel_id", sub.ChannelID, "user_id", sub.UserID, "command", sub.Command,
+			"image", n+1, "nsfw_channel", nsfw, "action", d.Action, "score", d.Score, "reason", d.Reason)
+		if d.Action == ActionBlock {
+			reasons = append(reasons, fmt.Sprintf("image %d: %s", n+1, d.Reason))
+		}
+	}
+	if len(reasons) > 0 {
+		report(s, cfg.Moderation.LogChannel, sub, "🚩 Generated image withheld", "", reasons)
+	}
+	return decisions
+}
+
+func decide(ctx context.Context, classifier Classifier, threshold float64, nsfw bool, image []byte) Decision {
+	score, err := classifier.Classify(ctx, image)
+	if err != nil {
+		slog.Warn("Image safety checker failed, posting as a spoiler", "error", err)
+		return Decision{Action: ActionSpoiler, Score: -1, Reason: "the safety checker couldn't score it"}
+	}
+	reason := fmt.Sprintf("NSFW score %.2f", score)
+	switch {
+	case score < threshold:
+		return Decision{Action: ActionAllow, Score: score, Reason: reason}
+	case nsfw:
+		return Decision{Action: ActionSpoiler, Score: score, Reason: reason}
+	default:
+		return Decision{Action: ActionBlock, Score: score, Reason: reason + " in a channel that isn't age-restricted"}
+	}
+}
+
+// report posts a refusal or withheld image to the mod-log channel, if the
+// server has one.
+func report(s moderation.Sender, channelID string, sub moderation.Subject, title, description string, reasons []string) {
+	if s == nil || channelID == "" {
+		return
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       title,
+		Description: description,
+		Color:       0xE67E22,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "User", Value: "<@" + sub.UserID + ">", Inline: true},
+			{Name: "Channel", Value: "<#" + sub.ChannelID + ">", Inline: true},
+			{Name: "Command", Value: "/" + sub.Command, Inline: true},
+			{Name: "Reasons", Value: strings.Join(reasons, "\n")},
+		},
+	}
+	if _, err := s.ChannelMessageSendEmbed(channelID, embed); err != nil {
+		slog.Error("Failed to post to mod-log", "guild_id", sub.GuildID, "channel_id", channelID, "error", err)
+	}
+}
+
+func excerpt(text string) string {
+	runes := []rune(strings.ReplaceAll(text, "```", "'''"))
+	if len(runes) > 1000 {
+		runes = append(runes[:1000], '…')
+	}
+	return string(runes)
+}
+
+// tidy cleans up after terms rewritten to nothing: doubled spaces and
+// commas, and commas left at either end.
+func tidy(prompt string) string {
+	parts := strings.Split(prompt, ",")
+	kept := parts[:0]
+	for _, part := range parts {
+		if part = strings.Join(strings.Fields(part), " "); part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
+
+func uniqueLower(matches []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, m := range matches {
+		m = strings.ToLower(m)
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,196 @@
+package safety
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/moderation"
+)
+
+type fakeSender struct {
+	channelID string
+	embeds    []*discordgo.MessageEmbed
+}
+
+func (s *fakeSender) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.channelID = channelID
+	s.embeds = append(s.embeds, embed)
+	return &discordgo.Message{}, nil
+}
+
+// stubClassifier scores each image by its contents, and fails on any it
+// has no score for.
+type stubClassifier map[string]float64
+
+func (c stubClassifier) Classify(ctx context.Context, image []byte) (float64, error) {
+	score, ok := c[string(image)]
+	if !ok {
+		return 0, errors.New("checker down")
+	}
+	return score, nil
+}
+
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.ImageSafety.BlockedTerms = []string{"gore", "two words"}
+	cfg.ImageSafety.RewriteTerms = map[string]string{
+		"nude":       "clothed",
+		"nude beach": "crowded beach",
+		"bloody":     "",
+	}
+	cfg.Moderation.LogChannel = "555"
+	return cfg
+}
+
+func newTestGate(cfg *config.Config, classifier Classifier) *Gate {
+	return NewGate(func(string) *config.Config { return cfg }, classifier)
+}
+
+var sub = moderation.Subject{GuildID: "1", ChannelID: "2", UserID: "3", Command: "imagine"}
+
+func TestGate_CheckPrompt(t *testing.T) {
+	s := &fakeSender{}
+	g := newTestGate(testConfig(), nil)
+
+	prompt, refused := g.CheckPrompt(s, sub, "a GORE scene with Two  Words")
+	if strings.Join(refused, ",") != "gore" {
+		t.Errorf("Expected gore to be refused, got %v", refused)
+	}
+	if prompt != "a GORE scene with Two  Words" {
+		t.Errorf("Expected a refused prompt back as it was, got %q", prompt)
+	}
+	if len(s.embeds) != 1 || s.channelID != "555" || !strings.Contains(s.embeds[0].Fields[3].Value, `"gore"`) {
+		t.Errorf("Expected the refusal in the mod-log, got %+v", s.embeds)
+	}
+
+	tests := map[string]string{
+		"a cat":                           "a cat",
+		"Nude figure study":               "clothed figure study",
+		"a nude beach at dusk":            "a crowded beach at dusk",
+		"bloody knight, castle":           "knight, castle",
+		"a castle, bloody, oil painting":  "a castle, oil painting",
+		"denuded hillside, goreme valley": "denuded hillside, goreme valley",
+	}
+	for in, want := range tests {
+		got, refused := g.CheckPrompt(s, sub, in)
+		if got != want || refused != nil {
+			t.Errorf("CheckPrompt(%q) = %q, %v, want %q", in, got, refused, want)
+		}
+	}
+	if len(s.embeds) != 1 {
+		t.Errorf("Expected rewrites to stay out of the mod-log, got %d posts", len(s.embeds))
+	}
+}
+
+func TestGate_CheckImages(t *testing.T) {
+	classifier := stubClassifier{"safe": 0.1, "edge": 0.5, "explicit": 0.97}
+	images := [][]byte{[]byte("safe"), []byte("edge"), []byte("explicit"), []byte("broken")}
+
+	s := &fakeSender{}
+	g := newTestGate(testConfig(), classifier)
+	var got []Action
+	for _, d := range g.CheckImages(context.Background(), s, sub, false, images) {
+		got = append(got, d.Action)
+	}
+	if want := "allow,block,block,spoiler"; joinActions(got) != want {
+		t.Errorf("In a SFW channel expected %s, got %s", want, joinActions(got))
+	}
+	if len(s.embeds) != 1 || !strings.Contains(s.embeds[0].Fields[3].Value, "image 3: NSFW score 0.97") {
+		t.Errorf("Expected the blocked images in the mod-log, got %+v", s.embeds)
+	}
+
+	s = &fakeSender{}
+	got = nil
+	for _, d := range g.CheckImages(context.Background(), s, sub, true, images) {
+		got = append(got, d.Action)
+	}
+	if want := "allow,spoiler,spoiler,spoiler"; joinActions(got) != want {
+		t.Errorf("In a NSFW channel expected %s, got %s", want, joinActions(got))
+	}
+	if len(s.embeds) != 0 {
+		t.Errorf("Expected spoilers to stay out of the mod-log, got %+v", s.embeds)
+	}
+}
+
+func joinActions(actions []Action) string {
+	parts := make([]string, len(actions))
+	for n, a := range actions {
+		parts[n] = string(a)
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestGate_DisabledAllowsEverything(t *testing.T) {
+	cfg := testConfig()
+	cfg.ImageSafety.Enabled = false
+	g := newTestGate(cfg, stubClassifier{})
+
+	if prompt, refused := g.CheckPrompt(nil, sub, "nude gore"); prompt != "nude gore" || refused != nil {
+		t.Errorf("Expected the prompt untouched, got %q, %v", prompt, refused)
+	}
+	if g.ClassifiesImages("1") {
+		t.Error("Expected a disabled gate not to classify images")
+	}
+	if d := g.CheckImages(context.Background(), nil, sub, false, [][]byte{[]byte("anything")}); d[0].Action != ActionAllow {
+		t.Errorf("Expected the image allowed, got %+v", d)
+	}
+
+	// No classifier configured, and no gate at all.
+	if d := newTestGate(testConfig(), nil).CheckImages(context.Background(), nil, sub, false, [][]byte{nil}); d[0].Action != ActionAllow {
+		t.Errorf("Expected the image allowed without a classifier, got %+v", d)
+	}
+	var nilGate *Gate
+	if d := nilGate.CheckImages(context.Background(), nil, sub, false, [][]byte{nil}); d[0].Action != ActionAllow {
+		t.Errorf("Expected a nil gate to allow the image, got %+v", d)
+	}
+}
+
+func TestHTTPClassifier(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req checkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		image, _ := base64.StdEncoding.DecodeString(req.Image)
+		received = string(image)
+		switch received {
+		case "scored":
+			w.Write([]byte(`{"score": 0.82}`))
+		case "flagged":
+			w.Write([]byte(`{"nsfw": true}`))
+		case "clean":
+			w.Write([]byte(`{"nsfw": false}`))
+		case "odd":
+			w.Write([]byte(`{"label": "unknown"}`))
+		default:
+			http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	c := NewHTTPClassifier(server.URL, 5*time.Second)
+	for image, want := range map[string]float64{"scored": 0.82, "flagged": 1, "clean": 0} {
+		score, err := c.Classify(context.Background(), []byte(image))
+		if err != nil || score != want {
+			t.Errorf("Classify(%s) = %g, %v, want %g", image, score, err, want)
+		}
+		if received != image {
+			t.Errorf("Expected the checker to receive %q, got %q", image, received)
+		}
+	}
+	for _, image := range []string{"odd", "error"} {
+		if _, err := c.Classify(context.Background(), []byte(image)); err == nil {
+			t.Errorf("Expected Classify(%s) to fail", image)
+		}
+	}
+}
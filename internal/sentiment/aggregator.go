@@ -1,6 +1,7 @@
 package sentiment
 
 import (
+	"context"
 	"log/slog"
 )
 
@@ -16,8 +17,11 @@ func NewAggregator() *Aggregator {
 	}
 }
 
-func (a *Aggregator) GetSentiment(ticker string) (SentimentData, error) {
-	redditSentiment, err := a.reddit.GetSentiment(ticker)
+func (a *Aggregator) GetSentiment(ctx context.Context, ticker string) (SentimentData, error) {
+	redditSentiment, err := a.reddit.GetSentiment(ctx, ticker)
+	if ctx.Err() != nil {
+		return SentimentData{}, ctx.Err()
+	}
 	if err != nil {
 		slog.Warn("Reddit sentiment failed, using defaults", "error", err, "ticker", ticker)
 		redditSentiment = PlatformSentiment{
@@ -27,7 +31,10 @@ func (a *Aggregator) GetSentiment(ticker string) (SentimentData, error) {
 		}
 	}
 
-	xSentiment, err := a.x.GetSentiment(ticker)
+	xSentiment, err := a.x.GetSentiment(ctx, ticker)
+	if ctx.Err() != nil {
+		return SentimentData{}, ctx.Err()
+	}
 	if err != nil {
 		slog.Warn("X sentiment failed, using defaults", "error", err, "ticker", ticker)
 		xSentiment = PlatformSentiment{
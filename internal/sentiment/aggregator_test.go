@@ -0,0 +1,17 @@
+package sentiment
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAggregator_CancelledContextSkipsDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewAggregator().GetSentiment(ctx, "AAPL")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context canceled instead of default sentiment, got %v", err)
+	}
+}
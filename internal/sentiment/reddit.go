@@ -1,6 +1,7 @@
 package sentiment
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -22,7 +23,7 @@ func NewRedditScraper() *RedditScraper {
 	}
 }
 
-func (s *RedditScraper) GetSentiment(ticker string) (PlatformSentiment, error) {
+func (s *RedditScraper) GetSentiment(ctx context.Context, ticker string) (PlatformSentiment, error) {
 	subreddits := []string{"wallstreetbets", "stocks", "investing"}
 
 	positive := 0
@@ -34,7 +35,7 @@ func (s *RedditScraper) GetSentiment(ticker string) (PlatformSentiment, error) {
 
 		slog.Info("Scraping Reddit", "subreddit", subreddit, "ticker", ticker)
 
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
 			slog.Warn("Failed to create Reddit request", "error", err)
 			continue
@@ -44,6 +45,9 @@ func (s *RedditScraper) GetSentiment(ticker string) (PlatformSentiment, error) {
 
 		resp, err := s.httpClient.Do(req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return PlatformSentiment{}, ctx.Err()
+			}
 			slog.Warn("Failed to fetch Reddit", "error", err)
 			continue
 		}
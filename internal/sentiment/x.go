@@ -1,6 +1,7 @@
 package sentiment
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -22,12 +23,12 @@ func NewXScraper() *XScraper {
 	}
 }
 
-func (s *XScraper) GetSentiment(ticker string) (PlatformSentiment, error) {
+func (s *XScraper) GetSentiment(ctx context.Context, ticker string) (PlatformSentiment, error) {
 	url := fmt.Sprintf("https://x.com/search?q=$%s&src=typed_query&f=live", ticker)
 
 	slog.Info("Scraping X.com", "ticker", ticker)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return PlatformSentiment{}, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -36,6 +37,9 @@ func (s *XScraper) GetSentiment(ticker string) (PlatformSentiment, error) {
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return PlatformSentiment{}, ctx.Err()
+		}
 		slog.Warn("Failed to fetch X.com, using defaults", "error", err)
 		return PlatformSentiment{
 			Positive: 40,
@@ -1,6 +1,7 @@
 package stocknews
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -50,7 +51,7 @@ func NewAlphaVantageClient(apiKey string) *AlphaVantageClient {
 	}
 }
 
-func (c *AlphaVantageClient) GetNews(ticker string, days int) ([]NewsItem, error) {
+func (c *AlphaVantageClient) GetNews(ctx context.Context, ticker string, days int) ([]NewsItem, error) {
 	params := url.Values{}
 	params.Add("function", "NEWS_SENTIMENT")
 	params.Add("tickers", ticker)
@@ -66,7 +67,12 @@ func (c *AlphaVantageClient) GetNews(ticker string, days int) ([]NewsItem, error
 
 	slog.Info("Fetching Alpha Vantage news", "ticker", ticker, "days", days)
 
-	resp, err := c.httpClient.Get(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch news: %w", err)
 	}
@@ -114,7 +120,7 @@ func (c *AlphaVantageClient) GetNews(ticker string, days int) ([]NewsItem, error
 	return news, nil
 }
 
-func (c *AlphaVantageClient) GetTrendingNews(days int) ([]NewsItem, error) {
+func (c *AlphaVantageClient) GetTrendingNews(ctx context.Context, days int) ([]NewsItem, error) {
 	params := url.Values{}
 	params.Add("function", "NEWS_SENTIMENT")
 	params.Add("apikey", c.apiKey)
@@ -129,7 +135,12 @@ func (c *AlphaVantageClient) GetTrendingNews(days int) ([]NewsItem, error) {
 
 	slog.Info("Fetching Alpha Vantage trending news", "days", days)
 
-	resp, err := c.httpClient.Get(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch news: %w", err)
 	}
@@ -177,7 +188,7 @@ func (c *AlphaVantageClient) GetTrendingNews(days int) ([]NewsItem, error) {
 	return news, nil
 }
 
-func (c *AlphaVantageClient) HealthCheck() error {
+func (c *AlphaVantageClient) HealthCheck(ctx context.Context) error {
 	params := url.Values{}
 	params.Add("function", "NEWS_SENTIMENT")
 	params.Add("apikey", c.apiKey)
@@ -185,7 +196,12 @@ func (c *AlphaVantageClient) HealthCheck() error {
 
 	endpoint := fmt.Sprintf("%s?%s", c.baseURL, params.Encode())
 
-	resp, err := c.httpClient.Get(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
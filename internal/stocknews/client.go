@@ -1,6 +1,7 @@
 package stocknews
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 )
@@ -17,16 +18,19 @@ func NewFallbackClient(primary, fallback Client) *FallbackClient {
 	}
 }
 
-func (c *FallbackClient) GetNews(ticker string, days int) ([]NewsItem, error) {
-	news, err := c.primary.GetNews(ticker, days)
+func (c *FallbackClient) GetNews(ctx context.Context, ticker string, days int) ([]NewsItem, error) {
+	news, err := c.primary.GetNews(ctx, ticker, days)
 	if err == nil {
 		slog.Info("Using primary news client", "ticker", ticker)
 		return news, nil
 	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 
 	slog.Warn("Primary news client failed, using fallback", "error", err, "ticker", ticker)
 
-	news, err = c.fallback.GetNews(ticker, days)
+	news, err = c.fallback.GetNews(ctx, ticker, days)
 	if err != nil {
 		return nil, fmt.Errorf("both primary and fallback clients failed: %w", err)
 	}
@@ -35,16 +39,19 @@ func (c *FallbackClient) GetNews(ticker string, days int) ([]NewsItem, error) {
 	return news, nil
 }
 
-func (c *FallbackClient) GetTrendingNews(days int) ([]NewsItem, error) {
-	news, err := c.primary.GetTrendingNews(days)
+func (c *FallbackClient) GetTrendingNews(ctx context.Context, days int) ([]NewsItem, error) {
+	news, err := c.primary.GetTrendingNews(ctx, days)
 	if err == nil {
 		slog.Info("Using primary news client for trending")
 		return news, nil
 	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 
 	slog.Warn("Primary news client failed for trending, using fallback", "error", err)
 
-	news, err = c.fallback.GetTrendingNews(days)
+	news, err = c.fallback.GetTrendingNews(ctx, days)
 	if err != nil {
 		return nil, fmt.Errorf("both primary and fallback clients failed: %w", err)
 	}
@@ -53,10 +60,10 @@ func (c *FallbackClient) GetTrendingNews(days int) ([]NewsItem, error) {
 	return news, nil
 }
 
-func (c *FallbackClient) HealthCheck() error {
-	if err := c.primary.HealthCheck(); err != nil {
+func (c *FallbackClient) HealthCheck(ctx context.Context) error {
+	if err := c.primary.HealthCheck(ctx); err != nil {
 		slog.Warn("Primary client health check failed", "error", err)
-		return c.fallback.HealthCheck()
+		return c.fallback.HealthCheck(ctx)
 	}
 	return nil
 }
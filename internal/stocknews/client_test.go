@@ -0,0 +1,79 @@
+package stocknews
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type countingClient struct {
+	calls int
+}
+
+func (c *countingClient) GetNews(ctx context.Context, ticker string, days int) ([]NewsItem, error) {
+	c.calls++
+	return nil, nil
+}
+
+func (c *countingClient) GetTrendingNews(ctx context.Context, days int) ([]NewsItem, error) {
+	c.calls++
+	return nil, nil
+}
+
+func (c *countingClient) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+func slowServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+}
+
+func TestMarketAuxClient_CancellationAbortsSlowServer(t *testing.T) {
+	server := slowServer()
+	defer server.Close()
+
+	client := NewMarketAuxClient("key")
+	client.baseURL = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetNews(ctx, "AAPL", 7)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetNews took %s after cancellation, expected it to abort promptly", elapsed)
+	}
+}
+
+func TestFallbackClient_DoesNotFallBackAfterCancellation(t *testing.T) {
+	server := slowServer()
+	defer server.Close()
+
+	primary := NewAlphaVantageClient("key")
+	primary.baseURL = server.URL
+	fallback := &countingClient{}
+
+	client := NewFallbackClient(primary, fallback)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.GetTrendingNews(ctx, 7)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if fallback.calls != 0 {
+		t.Errorf("Expected fallback not to be called after cancellation, got %d calls", fallback.calls)
+	}
+}
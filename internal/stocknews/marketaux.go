@@ -1,6 +1,7 @@
 package stocknews
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -54,7 +55,7 @@ func NewMarketAuxClient(apiKey string) *MarketAuxClient {
 	}
 }
 
-func (c *MarketAuxClient) GetNews(ticker string, days int) ([]NewsItem, error) {
+func (c *MarketAuxClient) GetNews(ctx context.Context, ticker string, days int) ([]NewsItem, error) {
 	params := url.Values{}
 	params.Add("api_token", c.apiKey)
 	params.Add("symbols", ticker)
@@ -70,7 +71,12 @@ func (c *MarketAuxClient) GetNews(ticker string, days int) ([]NewsItem, error) {
 
 	slog.Info("Fetching MarketAux news", "ticker", ticker, "days", days)
 
-	resp, err := c.httpClient.Get(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch news: %w", err)
 	}
@@ -113,7 +119,7 @@ func (c *MarketAuxClient) GetNews(ticker string, days int) ([]NewsItem, error) {
 	return news, nil
 }
 
-func (c *MarketAuxClient) GetTrendingNews(days int) ([]NewsItem, error) {
+func (c *MarketAuxClient) GetTrendingNews(ctx context.Context, days int) ([]NewsItem, error) {
 	params := url.Values{}
 	params.Add("api_token", c.apiKey)
 	params.Add("language", "en")
@@ -128,7 +134,12 @@ func (c *MarketAuxClient) GetTrendingNews(days int) ([]NewsItem, error) {
 
 	slog.Info("Fetching MarketAux trending news", "days", days)
 
-	resp, err := c.httpClient.Get(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch news: %w", err)
 	}
@@ -171,14 +182,19 @@ func (c *MarketAuxClient) GetTrendingNews(days int) ([]NewsItem, error) {
 	return news, nil
 }
 
-func (c *MarketAuxClient) HealthCheck() error {
+func (c *MarketAuxClient) HealthCheck(ctx context.Context) error {
 	params := url.Values{}
 	params.Add("api_token", c.apiKey)
 	params.Add("limit", "1")
 
 	endpoint := fmt.Sprintf("%s/news/all?%s", c.baseURL, params.Encode())
 
-	resp, err := c.httpClient.Get(endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
@@ -1,6 +1,9 @@
 package stocknews
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type NewsItem struct {
 	Title       string    `json:"title"`
@@ -12,7 +15,7 @@ type NewsItem struct {
 }
 
 type Client interface {
-	GetNews(ticker string, days int) ([]NewsItem, error)
-	GetTrendingNews(days int) ([]NewsItem, error)
-	HealthCheck() error
+	GetNews(ctx context.Context, ticker string, days int) ([]NewsItem, error)
+	GetTrendingNews(ctx context.Context, days int) ([]NewsItem, error)
+	HealthCheck(ctx context.Context) error
 }
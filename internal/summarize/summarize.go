@@ -0,0 +1,215 @@
+// Package summarize condenses a range of chat messages into a digest of
+// topics, decisions and action items. Ranges too long for the model's
+// context are summarized in chunks whose notes are then merged
+// (map-reduce).
+package summarize
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josh/discord-bot/internal/jobs"
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+type Message struct {
+	ID      string
+	Author  string
+	Content string
+	Time    time.Time
+}
+
+// charsPerToken matches the estimate used for conversation history.
+const charsPerToken = 4
+
+type Summarizer struct {
+	model      llm.Completer
+	chunkChars int
+}
+
+// NewSummarizer returns a Summarizer that sends the model at most about
+// chunkTokens of transcript or notes per request.
+func NewSummarizer(model llm.Completer, chunkTokens int) *Summarizer {
+	return &Summarizer{
+		model:      model,
+		chunkChars: chunkTokens * charsPerToken,
+	}
+}
+
+var summaryOptions = llm.Options{Temperature: llm.Temperature(0.2)}
+
+// Summarize returns a digest of messages, which must be in chronological
+// order. The digest cites messages as [mN], N being the message's 1-based
+// position in messages; see LinkCitations.
+func (s *Summarizer) Summarize(ctx context.Context, messages []Message) (string, error) {
+	if len(messages) == 0 {
+		return "", fmt.Errorf("no messages to summarize")
+	}
+
+	chunks := s.transcriptChunks(messages)
+	if len(chunks) == 1 {
+		jobs.SetStage(ctx, "Summarizing")
+		return s.complete(ctx, digestPrompt("a chat transcript", chunks[0]))
+	}
+
+	// Map: notes for each chunk. Intermediate steps don't use the persona,
+	// which only needs to shape the final digest.
+	internal := llm.WithoutPersona(ctx)
+	notes := make([]string, len(chunks))
+	for n, chunk := range chunks {
+		jobs.SetStage(ctx, fmt.Sprintf("Reading messages (part %d/%d)", n+1, len(chunks)))
+		note, err := s.complete(internal, notesPrompt(chunk))
+		if err != nil {
+			return "", fmt.Errorf("failed to summarize part %d: %w", n+1, err)
+		}
+		notes[n] = note
+	}
+
+	// Reduce: merge notes until they fit in one request.
+	for round := 1; totalLen(notes) > s.chunkChars; round++ {
+		groups := pack(notes, s.chunkChars)
+		if len(groups) == len(notes) {
+			// Every note is too long to pair with another; merging can't
+			// shrink them further, so the digest works from what fits.
+			break
+		}
+		merged := make([]string, len(groups))
+		for n, group := range groups {
+			jobs.SetStage(ctx, fmt.Sprintf("Merging notes (round %d, %d/%d)", round, n+1, len(groups)))
+			note, err := s.complete(internal, mergePrompt(group))
+			if err != nil {
+				return "", fmt.Errorf("failed to merge notes: %w", err)
+			}
+			merged[n] = note
+		}
+		slog.Debug("Merged summary notes", "round", round, "from", len(notes), "to", len(merged))
+		notes = merged
+	}
+
+	jobs.SetStage(ctx, "Writing digest")
+	combined := strings.Join(notes, "\n\n")
+	if len(combined) > s.chunkChars {
+		combined = combined[:s.chunkChars]
+	}
+	return s.complete(ctx, digestPrompt("notes taken on consecutive parts of a chat transcript", combined))
+}
+
+func (s *Summarizer) complete(ctx context.Context, prompt string) (string, error) {
+	completion, err := s.model.Complete(ctx, []llm.ChatMessage{{Role: "user", Content: prompt}}, summaryOptions)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(completion.Content), nil
+}
+
+// transcriptChunks formats messages one per line, tagged with their [mN]
+// reference, and packs the lines into chunks of at most chunkChars.
+func (s *Summarizer) transcriptChunks(messages []Message) []string {
+	lines := make([]string, len(messages))
+	for n, m := range messages {
+		content := strings.Join(strings.Fields(m.Content), " ")
+		// Leave room for other lines in the chunk, so one wall of text
+		// doesn't get a request to itself.
+		if limit := s.chunkChars / 4; len(content) > limit {
+			content = truncate(content, limit) + "…"
+		}
+		lines[n] = fmt.Sprintf("[m%d] %s %s: %s", n+1, m.Time.Format("15:04"), m.Author, content)
+	}
+
+	var chunks []string
+	for _, group := range pack(lines, s.chunkChars) {
+		chunks = append(chunks, strings.Join(group, "\n"))
+	}
+	return chunks
+}
+
+// pack groups consecutive items so each group's length, counting a
+// separator between items, stays within limit. An item over the limit gets
+// a group of its own.
+func pack(items []string, limit int) [][]string {
+	var groups [][]string
+	var current []string
+	size := 0
+	for _, item := range items {
+		if len(current) > 0 && size+len(item)+2 > limit {
+			groups = append(groups, current)
+			current, size = nil, 0
+		}
+		current = append(current, item)
+		size += len(item) + 2
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups
+}
+
+func totalLen(items []string) int {
+	total := 0
+	for _, item := range items {
+		total += len(item) + 2
+	}
+	return total
+}
+
+// truncate cuts s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+const citeRule = "Each message is tagged like [m12]. After every point, cite the messages it comes from with those tags, e.g. [m3][m7]."
+
+func notesPrompt(transcript string) string {
+	return fmt.Sprintf(`Take notes on this part of a Discord chat transcript. List the topics discussed, any decisions made and any action items (with who is responsible, if anyone), as short bullet points. %s Reply with the notes only.
+
+%s`, citeRule, transcript)
+}
+
+func mergePrompt(notes []string) string {
+	return fmt.Sprintf(`Merge these notes on consecutive parts of a Discord chat into one set of notes. Combine duplicate points, keep every decision and action item, and keep the [mN] citations. Reply with the notes only.
+
+%s`, strings.Join(notes, "\n\n---\n\n"))
+}
+
+func digestPrompt(what, material string) string {
+	return fmt.Sprintf(`Write a digest of this Discord channel from the %s below, for someone who missed it. Use exactly these sections:
+
+**Topics**
+- one bullet per topic, one sentence each
+
+**Decisions**
+- what was agreed
+
+**Action items**
+- what needs doing, and by whom if known
+
+Write "None" under a section with nothing in it. %s Reply with the digest only.
+
+%s`, what, citeRule, material)
+}
+
+var citationPattern = regexp.MustCompile(`\[m(\d+)\]`)
+
+// LinkCitations replaces the [mN] citations in a digest with links made by
+// url from the cited message's ID. Citations of messages that don't exist
+// are dropped.
+func LinkCitations(digest string, messages []Message, url func(messageID string) string) string {
+	return citationPattern.ReplaceAllStringFunc(digest, func(tag string) string {
+		n, err := strconv.Atoi(citationPattern.FindStringSubmatch(tag)[1])
+		if err != nil || n < 1 || n > len(messages) {
+			return ""
+		}
+		return fmt.Sprintf("[↗](<%s>)", url(messages[n-1].ID))
+	})
+}
@@ -0,0 +1,201 @@
+package summarize
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+// fakeLLM answers each kind of prompt with a short, recognisable reply
+// that carries forward the citations it was given, so tests can follow
+// every message through the map and reduce steps.
+type fakeLLM struct {
+	prompts []string
+	persona []string
+}
+
+var tagPattern = regexp.MustCompile(`\[m\d+\]`)
+
+func (f *fakeLLM) Complete(ctx context.Context, messages []llm.ChatMessage, opts llm.Options) (*llm.Completion, error) {
+	prompt := messages[0].Content
+	f.prompts = append(f.prompts, prompt)
+	f.persona = append(f.persona, llm.PersonaFromContext(ctx))
+
+	tags := strings.Join(citedTags(prompt), "")
+	var reply string
+	switch {
+	case strings.HasPrefix(prompt, "Take notes"):
+		reply = "- notes on this part of the chat, in some detail " + tags
+	case strings.HasPrefix(prompt, "Merge"):
+		reply = "- merged " + tags
+	case strings.HasPrefix(prompt, "Write a digest"):
+		reply = "**Topics**\n- digest " + tags
+	default:
+		return nil, fmt.Errorf("unexpected prompt %q", prompt)
+	}
+	return &llm.Completion{Content: reply}, nil
+}
+
+func (f *fakeLLM) CompleteStream(ctx context.Context, messages []llm.ChatMessage, opts llm.Options, onDelta func(string) error) (*llm.Completion, error) {
+	return f.Complete(ctx, messages, opts)
+}
+
+// citedTags returns the [mN] tags in a prompt, leaving out the examples in
+// the instructions.
+func citedTags(prompt string) []string {
+	return tagPattern.FindAllString(strings.ReplaceAll(prompt, citeRule, ""), -1)
+}
+
+func (f *fakeLLM) count(prefix string) int {
+	n := 0
+	for _, p := range f.prompts {
+		if strings.HasPrefix(p, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func testMessages(n int, text string) []Message {
+	start := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	messages := make([]Message, n)
+	for i := range messages {
+		messages[i] = Message{
+			ID:      fmt.Sprintf("id%d", i+1),
+			Author:  fmt.Sprintf("user%d", i%3),
+			Content: text,
+			Time:    start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return messages
+}
+
+func citedAll(t *testing.T, digest string, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		if !strings.Contains(digest, fmt.Sprintf("[m%d]", i)) {
+			t.Errorf("digest lost message m%d: %q", i, digest)
+			return
+		}
+	}
+}
+
+func TestSummarize_SingleChunk(t *testing.T) {
+	model := &fakeLLM{}
+	s := NewSummarizer(model, 1000)
+
+	digest, err := s.Summarize(llm.WithPersona(context.Background(), "be a pirate"), testMessages(5, "shall we ship on friday?"))
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+
+	if len(model.prompts) != 1 || model.count("Write a digest") != 1 {
+		t.Fatalf("expected a single digest request, got %d prompts", len(model.prompts))
+	}
+	if !strings.Contains(model.prompts[0], "[m2] 09:01 user1: shall we ship on friday?") {
+		t.Errorf("transcript line not formatted as expected:\n%s", model.prompts[0])
+	}
+	if model.persona[0] != "be a pirate" {
+		t.Errorf("expected the digest to use the persona, got %q", model.persona[0])
+	}
+	citedAll(t, digest, 5)
+}
+
+func TestSummarize_MapReduce(t *testing.T) {
+	model := &fakeLLM{}
+	// 100 tokens is 400 characters: about seven of these lines per chunk.
+	s := NewSummarizer(model, 100)
+	messages := testMessages(40, "a message about the release plan")
+
+	digest, err := s.Summarize(llm.WithPersona(context.Background(), "be a pirate"), messages)
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+
+	maps := model.count("Take notes")
+	if maps < 4 {
+		t.Fatalf("expected the transcript to be split into several chunks, got %d", maps)
+	}
+	if model.count("Write a digest") != 1 {
+		t.Errorf("expected one digest request, got %d", model.count("Write a digest"))
+	}
+
+	// Every message is in exactly one chunk.
+	seen := make(map[string]int)
+	for _, p := range model.prompts {
+		if !strings.HasPrefix(p, "Take notes") {
+			continue
+		}
+		if len(p) > len(notesPrompt(""))+400 {
+			t.Errorf("chunk over the size limit: %d bytes", len(p)-len(notesPrompt("")))
+		}
+		for _, tag := range citedTags(p) {
+			seen[tag]++
+		}
+	}
+	for i := 1; i <= len(messages); i++ {
+		if tag := fmt.Sprintf("[m%d]", i); seen[tag] != 1 {
+			t.Errorf("%s appeared in %d chunks", tag, seen[tag])
+		}
+	}
+
+	// Nothing is sent to the model over the limit, so the notes had to be
+	// merged before the digest, and citations survive the merge.
+	if model.count("Merge") == 0 {
+		t.Error("expected notes to be merged before the digest")
+	}
+	citedAll(t, digest, len(messages))
+
+	for n, p := range model.persona {
+		final := strings.HasPrefix(model.prompts[n], "Write a digest")
+		if (p != "") != final {
+			t.Errorf("request %d: persona %q, only the digest should use it", n, p)
+		}
+	}
+}
+
+func TestSummarize_LongMessageTruncated(t *testing.T) {
+	model := &fakeLLM{}
+	s := NewSummarizer(model, 100)
+
+	long := strings.Repeat("word ", 500)
+	if _, err := s.Summarize(context.Background(), testMessages(1, long)); err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+	if len(model.prompts) != 1 {
+		t.Fatalf("expected one request, got %d", len(model.prompts))
+	}
+	if strings.Count(model.prompts[0], "word") > 100 {
+		t.Error("expected the long message to be truncated")
+	}
+}
+
+func TestSummarize_Empty(t *testing.T) {
+	if _, err := NewSummarizer(&fakeLLM{}, 100).Summarize(context.Background(), nil); err == nil {
+		t.Error("expected an error for no messages")
+	}
+}
+
+func TestPack(t *testing.T) {
+	groups := pack([]string{"aaaa", "bbbb", "cccc", strings.Repeat("x", 20), "dd"}, 12)
+	want := [][]string{{"aaaa", "bbbb"}, {"cccc"}, {strings.Repeat("x", 20)}, {"dd"}}
+	if fmt.Sprint(groups) != fmt.Sprint(want) {
+		t.Errorf("pack = %q, want %q", groups, want)
+	}
+}
+
+func TestLinkCitations(t *testing.T) {
+	messages := testMessages(3, "hi")
+	url := func(id string) string { return "https://example.com/" + id }
+
+	got := LinkCitations("- ship friday [m1][m3]\n- unknown [m9]", messages, url)
+	want := "- ship friday [↗](<https://example.com/id1>)[↗](<https://example.com/id3>)\n- unknown "
+	if got != want {
+		t.Errorf("LinkCitations:\ngot  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,323 @@
+// Package tools exposes the bot's own features to the LLM agent loop.
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/llm"
+	"github.com/josh/discord-bot/internal/sentiment"
+	"github.com/josh/discord-bot/internal/stocknews"
+)
+
+// Invocation describes where the agent is answering, and collects the
+// files tools produce so they can be posted with the reply.
+type Invocation struct {
+	GuildID   string
+	ChannelID string
+	UserID    string
+
+	// Images, if set, holds generated images to the server's rules.
+	Images ImageGuard
+
+	mu    sync.Mutex
+	files []*discordgo.File
+}
+
+type invocationKey struct{}
+
+func WithInvocation(ctx context.Context, inv *Invocation) context.Context {
+	return context.WithValue(ctx, invocationKey{}, inv)
+}
+
+func invocationFrom(ctx context.Context) *Invocation {
+	inv, _ := ctx.Value(invocationKey{}).(*Invocation)
+	if inv == nil {
+		return &Invocation{}
+	}
+	return inv
+}
+
+func (inv *Invocation) attach(f *discordgo.File) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.files = append(inv.files, f)
+}
+
+// Files returns the files tools attached while answering.
+func (inv *Invocation) Files() []*discordgo.File {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.files
+}
+
+// ImageGuard holds images the agent generates to the same rules as
+// /imagine's.
+type ImageGuard interface {
+	// Admit screens a request before it's generated, and may rewrite its
+	// prompt. Its error is shown to the model. refund gives back what
+	// admitting it cost, if the image then can't be generated.
+	Admit(ctx context.Context, req *imagegen.GenerationRequest) (refund func(), err error)
+	// Review says how a generated image may be posted: as a spoiler, or
+	// withheld and not posted at all.
+	Review(ctx context.Context, image []byte) (spoiler, withheld bool)
+}
+
+type SentimentSource interface {
+	GetSentiment(ctx context.Context, ticker string) (sentiment.SentimentData, error)
+}
+
+// QueueFunc adds a song to a guild's music queue, e.g. voice.AddToQueue.
+type QueueFunc func(guildID, url, title string)
+
+// All returns every tool the bot offers the model.
+func All(news stocknews.Client, sentiment SentimentSource, images imagegen.ImageBackend, queue QueueFunc) []llm.AgentTool {
+	return []llm.AgentTool{
+		StockNews(news),
+		StockSentiment(sentiment),
+		GenerateImage(images),
+		QueueSong(queue),
+	}
+}
+
+func decodeArgs(raw json.RawMessage, v any) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func StockNews(client stocknews.Client) llm.AgentTool {
+	return llm.AgentTool{
+		Definition: llm.FunctionDefinition{
+			Name:        "get_stock_news",
+			Description: "Get recent news headlines for a stock ticker.",
+			Parameters: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"ticker": {"type": "string", "description": "Stock ticker symbol, e.g. AAPL"},
+					"days": {"type": "integer", "description": "How many days back to look (1-30, default 7)"}
+				},
+				"required": ["ticker"]
+			}`),
+		},
+		Handler: func(ctx context.Context, raw json.RawMessage) (string, error) {
+			var args struct {
+				Ticker string `json:"ticker"`
+				Days   int    `json:"days"`
+			}
+			if err := decodeArgs(raw, &args); err != nil {
+				return "", err
+			}
+			ticker := strings.ToUpper(strings.TrimSpace(args.Ticker))
+			if ticker == "" {
+				return "", fmt.Errorf("ticker is required")
+			}
+			days := args.Days
+			if days < 1 || days > 30 {
+				days = 7
+			}
+
+			news, err := client.GetNews(ctx, ticker, days)
+			if err != nil {
+				return "", fmt.Errorf("failed to get news: %w", err)
+			}
+			if len(news) == 0 {
+				return fmt.Sprintf("No news found for %s in the last %d days.", ticker, days), nil
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "News for %s in the last %d days:\n", ticker, days)
+			for n, item := range news {
+				if n == 8 {
+					break
+				}
+				fmt.Fprintf(&b, "- %s (%s, %s", item.Title, item.Source, item.Date.Format("2006-01-02"))
+				if item.Sentiment != "" {
+					fmt.Fprintf(&b, ", sentiment %s", item.Sentiment)
+				}
+				b.WriteString(")")
+				if item.Description != "" {
+					b.WriteString(": " + truncate(item.Description, 200))
+				}
+				b.WriteString("\n")
+			}
+			return b.String(), nil
+		},
+	}
+}
+
+func StockSentiment(source SentimentSource) llm.AgentTool {
+	return llm.AgentTool{
+		Definition: llm.FunctionDefinition{
+			Name:        "get_stock_sentiment",
+			Description: "Get Reddit and X sentiment for a stock ticker.",
+			Parameters: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"ticker": {"type": "string", "description": "Stock ticker symbol, e.g. AAPL"}
+				},
+				"required": ["ticker"]
+			}`),
+		},
+		Handler: func(ctx context.Context, raw json.RawMessage) (string, error) {
+			var args struct {
+				Ticker string `json:"ticker"`
+			}
+			if err := decodeArgs(raw, &args); err != nil {
+				return "", err
+			}
+			ticker := strings.ToUpper(strings.TrimSpace(args.Ticker))
+			if ticker == "" {
+				return "", fmt.Errorf("ticker is required")
+			}
+
+			data, err := source.GetSentiment(ctx, ticker)
+			if err != nil {
+				return "", fmt.Errorf("failed to get sentiment: %w", err)
+			}
+			return fmt.Sprintf("Sentiment for %s: overall %s. Reddit %d%% positive, %d%% negative, %d%% neutral. X %d%% positive, %d%% negative, %d%% neutral.",
+				ticker, data.OverallSentiment,
+				data.RedditSentiment.Positive, data.RedditSentiment.Negative, data.RedditSentiment.Neutral,
+				data.XSentiment.Positive, data.XSentiment.Negative, data.XSentiment.Neutral,
+			), nil
+		},
+	}
+}
+
+// GenerateImage makes one image per reply; it is attached to the answer
+// rather than described to the model.
+func GenerateImage(client imagegen.ImageBackend) llm.AgentTool {
+	return llm.AgentTool{
+		Definition: llm.FunctionDefinition{
+			Name:        "generate_image",
+			Description: "Generate an image with Stable Diffusion. The image is attached to your reply automatically.",
+			Parameters: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"prompt": {"type": "string", "description": "Detailed description of the image"},
+					"negative_prompt": {"type": "string", "description": "Things to avoid in the image"}
+				},
+				"required": ["prompt"]
+			}`),
+		},
+		Handler: func(ctx context.Context, raw json.RawMessage) (string, error) {
+			var args struct {
+				Prompt         string `json:"prompt"`
+				NegativePrompt string `json:"negative_prompt"`
+			}
+			if err := decodeArgs(raw, &args); err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(args.Prompt) == "" {
+				return "", fmt.Errorf("prompt is required")
+			}
+
+			inv := invocationFrom(ctx)
+			if len(inv.Files()) > 0 {
+				return "", fmt.Errorf("only one image can be generated per reply")
+			}
+
+			req := &imagegen.GenerationRequest{
+				Prompt:         args.Prompt,
+				NegativePrompt: args.NegativePrompt,
+			}
+			refund := func() {}
+			if inv.Images != nil {
+				var err error
+				if refund, err = inv.Images.Admit(ctx, req); err != nil {
+					return "", err
+				}
+			}
+
+			resp, err := client.GenerateImage(imagegen.WithPriority(ctx, imagegen.PriorityInteractive), req)
+			if err == nil && len(resp.Images) == 0 {
+				err = fmt.Errorf("no image was generated")
+			}
+			var data []byte
+			if err == nil {
+				data, err = imagegen.DecodeImage(resp.Images[0])
+			}
+			if err != nil {
+				refund()
+				return "", fmt.Errorf("failed to generate image: %w", err)
+			}
+
+			name := "image.png"
+			if inv.Images != nil {
+				spoiler, withheld := inv.Images.Review(ctx, data)
+				if withheld {
+					return "The image was withheld by this server's content filter, so nothing will be attached. Tell the user, without describing the image.", nil
+				}
+				if spoiler {
+					name = "SPOILER_" + name
+				}
+			}
+
+			inv.attach(&discordgo.File{
+				Name:        name,
+				ContentType: "image/png",
+				Reader:      bytes.NewReader(data),
+			})
+			return fmt.Sprintf("Generated an image of %q (seed %d). It will be attached to your reply; don't include it or a link yourself.", req.Prompt, resp.Parameters.Seed), nil
+		},
+	}
+}
+
+func QueueSong(queue QueueFunc) llm.AgentTool {
+	return llm.AgentTool{
+		Definition: llm.FunctionDefinition{
+			Name:        "queue_song",
+			Description: "Add a song to this server's music queue by MP3 URL.",
+			Parameters: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"url": {"type": "string", "description": "http(s) URL of an MP3 file"},
+					"title": {"type": "string", "description": "Song title, if known"}
+				},
+				"required": ["url"]
+			}`),
+		},
+		Handler: func(ctx context.Context, raw json.RawMessage) (string, error) {
+			var args struct {
+				URL   string `json:"url"`
+				Title string `json:"title"`
+			}
+			if err := decodeArgs(raw, &args); err != nil {
+				return "", err
+			}
+			if !strings.HasPrefix(args.URL, "http://") && !strings.HasPrefix(args.URL, "https://") {
+				return "", fmt.Errorf("url must start with http:// or https://")
+			}
+			if !strings.HasSuffix(args.URL, ".mp3") {
+				return "", fmt.Errorf("only MP3 URLs are supported")
+			}
+
+			inv := invocationFrom(ctx)
+			if inv.GuildID == "" {
+				return "", fmt.Errorf("songs can only be queued in a server")
+			}
+
+			title := strings.TrimSpace(args.Title)
+			if title == "" {
+				title = args.URL
+			}
+			queue(inv.GuildID, args.URL, title)
+			return fmt.Sprintf("Added %q to the queue. It plays after the current song.", title), nil
+		},
+	}
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,247 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/sentiment"
+	"github.com/josh/discord-bot/internal/stocknews"
+)
+
+type fakeNews struct {
+	ticker string
+	days   int
+	items  []stocknews.NewsItem
+	err    error
+}
+
+func (f *fakeNews) GetNews(ctx context.Context, ticker string, days int) ([]stocknews.NewsItem, error) {
+	f.ticker, f.days = ticker, days
+	return f.items, f.err
+}
+
+func (f *fakeNews) GetTrendingNews(ctx context.Context, days int) ([]stocknews.NewsItem, error) {
+	return nil, nil
+}
+
+func (f *fakeNews) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+type fakeSentiment struct{}
+
+func (fakeSentiment) GetSentiment(ctx context.Context, ticker string) (sentiment.SentimentData, error) {
+	return sentiment.SentimentData{
+		Ticker:           ticker,
+		RedditSentiment:  sentiment.PlatformSentiment{Positive: 60, Negative: 20, Neutral: 20},
+		XSentiment:       sentiment.PlatformSentiment{Positive: 50, Negative: 30, Neutral: 20},
+		OverallSentiment: "Bullish",
+	}, nil
+}
+
+func TestStockNews(t *testing.T) {
+	news := &fakeNews{items: []stocknews.NewsItem{
+		{Title: "Apple beats estimates", Source: "Reuters", Date: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), Sentiment: "positive"},
+	}}
+	tool := StockNews(news)
+
+	result, err := tool.Handler(context.Background(), json.RawMessage(`{"ticker":"aapl","days":90}`))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if news.ticker != "AAPL" || news.days != 7 {
+		t.Errorf("Expected AAPL over the default 7 days, got %s over %d", news.ticker, news.days)
+	}
+	if !strings.Contains(result, "Apple beats estimates (Reuters, 2026-01-02, sentiment positive)") {
+		t.Errorf("Unexpected result %q", result)
+	}
+
+	news.err = errors.New("rate limited")
+	if _, err := tool.Handler(context.Background(), json.RawMessage(`{"ticker":"AAPL"}`)); err == nil {
+		t.Error("Expected news error to be returned")
+	}
+	if _, err := tool.Handler(context.Background(), json.RawMessage(`{}`)); err == nil {
+		t.Error("Expected missing ticker to be rejected")
+	}
+}
+
+func TestStockSentiment(t *testing.T) {
+	result, err := StockSentiment(fakeSentiment{}).Handler(context.Background(), json.RawMessage(`{"ticker":"tsla"}`))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if !strings.Contains(result, "TSLA: overall Bullish") || !strings.Contains(result, "Reddit 60% positive") {
+		t.Errorf("Unexpected result %q", result)
+	}
+}
+
+func TestQueueSong(t *testing.T) {
+	var queued []string
+	tool := QueueSong(func(guildID, url, title string) {
+		queued = append(queued, guildID+" "+url+" "+title)
+	})
+
+	ctx := WithInvocation(context.Background(), &Invocation{GuildID: "g1"})
+	if _, err := tool.Handler(ctx, json.RawMessage(`{"url":"https://example.com/song.mp3","title":"Song"}`)); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if len(queued) != 1 || queued[0] != "g1 https://example.com/song.mp3 Song" {
+		t.Errorf("Expected song queued in g1, got %q", queued)
+	}
+
+	if _, err := tool.Handler(ctx, json.RawMessage(`{"url":"file:///etc/passwd.mp3"}`)); err == nil {
+		t.Error("Expected non-http URL to be rejected")
+	}
+	if _, err := tool.Handler(ctx, json.RawMessage(`{"url":"https://example.com/video"}`)); err == nil {
+		t.Error("Expected non-MP3 URL to be rejected")
+	}
+	if _, err := tool.Handler(context.Background(), json.RawMessage(`{"url":"https://example.com/song.mp3"}`)); err == nil {
+		t.Error("Expected queueing outside a server to be rejected")
+	}
+	if len(queued) != 1 {
+		t.Errorf("Expected rejected songs not to be queued, got %q", queued)
+	}
+}
+
+func TestGenerateImageAttachesFile(t *testing.T) {
+	png := []byte("\x89PNG fake image")
+	var gotPrompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req imagegen.GenerationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotPrompt = req.Prompt
+		json.NewEncoder(w).Encode(map[string]any{
+			"images":     []string{base64.StdEncoding.EncodeToString(png)},
+			"parameters": map[string]any{"seed": 42},
+		})
+	}))
+	defer server.Close()
+
+	tool := GenerateImage(imagegen.NewClient(server.URL, 5*time.Second))
+	inv := &Invocation{}
+	ctx := WithInvocation(context.Background(), inv)
+
+	result, err := tool.Handler(ctx, json.RawMessage(`{"prompt":"a red fox"}`))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if gotPrompt != "a red fox" || !strings.Contains(result, "seed 42") {
+		t.Errorf("Unexpected prompt %q or result %q", gotPrompt, result)
+	}
+
+	files := inv.Files()
+	if len(files) != 1 {
+		t.Fatalf("Expected one attached file, got %d", len(files))
+	}
+	data, _ := io.ReadAll(files[0].Reader)
+	if string(data) != string(png) {
+		t.Errorf("Expected decoded image to be attached, got %q", data)
+	}
+
+	if _, err := tool.Handler(ctx, json.RawMessage(`{"prompt":"another"}`)); err == nil {
+		t.Error("Expected a second image in the same reply to be refused")
+	}
+}
+
+type fakeGuard struct {
+	refuse            error
+	spoiler, withheld bool
+	refunded          bool
+}
+
+func (g *fakeGuard) Admit(ctx context.Context, req *imagegen.GenerationRequest) (func(), error) {
+	req.Prompt = strings.ToUpper(req.Prompt)
+	return func() { g.refunded = true }, g.refuse
+}
+
+func (g *fakeGuard) Review(ctx context.Context, image []byte) (bool, bool) {
+	return g.spoiler, g.withheld
+}
+
+func TestGenerateImageGuard(t *testing.T) {
+	var generated []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req imagegen.GenerationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		generated = append(generated, req.Prompt)
+		json.NewEncoder(w).Encode(map[string]any{
+			"images":     []string{base64.StdEncoding.EncodeToString([]byte("png"))},
+			"parameters": map[string]any{"seed": 1},
+		})
+	}))
+	defer server.Close()
+	tool := GenerateImage(imagegen.NewClient(server.URL, 5*time.Second))
+
+	tests := []struct {
+		name  string
+		guard *fakeGuard
+		file  string
+	}{
+		{"refused", &fakeGuard{refuse: errors.New("not allowed")}, ""},
+		{"withheld", &fakeGuard{withheld: true}, ""},
+		{"spoilered", &fakeGuard{spoiler: true}, "SPOILER_image.png"},
+		{"allowed", &fakeGuard{}, "image.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated = nil
+			inv := &Invocation{Images: tt.guard}
+			_, err := tool.Handler(WithInvocation(context.Background(), inv), json.RawMessage(`{"prompt":"a fox"}`))
+			if (err != nil) != (tt.guard.refuse != nil) {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if tt.guard.refuse != nil {
+				if len(generated) != 0 {
+					t.Error("Expected a refused prompt not to be generated")
+				}
+				return
+			}
+			if len(generated) != 1 || generated[0] != "A FOX" {
+				t.Errorf("Expected the guard's prompt to be generated, got %q", generated)
+			}
+
+			files := inv.Files()
+			if tt.file == "" {
+				if len(files) != 0 {
+					t.Errorf("Expected nothing attached, got %d files", len(files))
+				}
+				return
+			}
+			if len(files) != 1 || files[0].Name != tt.file {
+				t.Errorf("Expected %s attached, got %v", tt.file, files)
+			}
+		})
+	}
+}
+
+func TestGenerateImageRefundsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of memory", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	guard := &fakeGuard{}
+	inv := &Invocation{Images: guard}
+	tool := GenerateImage(imagegen.NewClient(server.URL, 5*time.Second))
+	if _, err := tool.Handler(WithInvocation(context.Background(), inv), json.RawMessage(`{"prompt":"a fox"}`)); err == nil {
+		t.Fatal("Expected the failed generation to be reported")
+	}
+	if !guard.refunded {
+		t.Error("Expected a failed generation to be refunded")
+	}
+}
+
+func TestTruncateKeepsRunes(t *testing.T) {
+	if got := truncate("héllo wörld", 7); got != "héllo w..." {
+		t.Errorf("truncate = %q", got)
+	}
+}
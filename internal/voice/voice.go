@@ -44,6 +44,14 @@ func LeaveVoiceChannel(guildID string) {
 	}
 }
 
+// LeaveAllVoiceChannels stops playback and disconnects from every guild,
+// used on shutdown so the bot doesn't linger in voice channels.
+func LeaveAllVoiceChannels() {
+	for guildID := range connections {
+		LeaveVoiceChannel(guildID)
+	}
+}
+
 func AddToQueue(guildID, url, title string) {
 	queues[guildID] = append(queues[guildID], Song{URL: url, Title: title})
 }
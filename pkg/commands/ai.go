@@ -1,44 +1,170 @@
 package commands
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/conversation"
+	"github.com/josh/discord-bot/internal/llm"
+	"github.com/josh/discord-bot/internal/moderation"
+	"github.com/josh/discord-bot/internal/ratelimit"
+	"github.com/josh/discord-bot/internal/safety"
+	"github.com/josh/discord-bot/internal/tools"
 )
 
-type AICommand struct{}
+type AICommand struct {
+	conv      *conversation.Manager
+	router    *llm.Router
+	moderator *moderation.Filter
+	safety    *safety.Gate
+	cfg       *config.Manager
+	limiter   *ratelimit.Limiter
+}
+
+// NewAICommand creates the /ai command. Images its agent generates are
+// held to /imagine's safety gate, limits and quota.
+func NewAICommand(conv *conversation.Manager, router *llm.Router, moderator *moderation.Filter, gate *safety.Gate, cfg *config.Manager, limiter *ratelimit.Limiter) *AICommand {
+	return &AICommand{
+		conv:      conv,
+		router:    router,
+		moderator: moderator,
+		safety:    gate,
+		cfg:       cfg,
+		limiter:   limiter,
+	}
+}
 
 func (c *AICommand) Name() string {
 	return "ai"
 }
 
 func (c *AICommand) Description() string {
-	return "Ask the AI a question"
+	return "Chat with the AI"
 }
 
 func (c *AICommand) Data() *discordgo.ApplicationCommand {
+	askOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "prompt",
+			Description: "The prompt to send to the AI",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+			Name:        "image",
+			Description: "An image for the AI to look at, e.g. a screenshot",
+			Required:    false,
+		},
+	}
+	if choices := c.modelChoices(); len(choices) > 1 {
+		askOptions = append(askOptions, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "model",
+			Description: "Which model answers (default: whichever is free)",
+			Required:    false,
+			Choices:     choices,
+		})
+	}
+
 	return &discordgo.ApplicationCommand{
 		Name:        c.Name(),
 		Description: c.Description(),
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "prompt",
-				Description: "The prompt to send to the AI",
-				Required:    true,
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "ask",
+				Description: "Ask the AI something; it remembers the conversation in this channel",
+				Options:     askOptions,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Forget the conversation in this channel",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "history",
+				Description: "Show what the AI remembers of this channel's conversation",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "models",
+				Description: "List the models the AI can use and whether they're up",
 			},
 		},
 	}
 }
 
-func (c *AICommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
-	prompt := i.ApplicationCommandData().Options[0].StringValue()
+// modelChoices offers the router's backends by name. Discord allows at
+// most 25 choices.
+func (c *AICommand) modelChoices() []*discordgo.ApplicationCommandOptionChoice {
+	if c.router == nil {
+		return nil
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, b := range c.router.Backends() {
+		if len(choices) == 25 {
+			break
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: b.Name, Value: b.Name})
+	}
+	return choices
+}
+
+func (c *AICommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+
+	session := conversation.SessionForChannel(i.ChannelID)
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "ask":
+		var prompt string
+		var image *discordgo.MessageAttachment
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "prompt":
+				prompt = opt.StringValue()
+			case "image":
+				if data.Resolved != nil {
+					image = data.Resolved.Attachments[opt.Value.(string)]
+				}
+			case "model":
+				ctx = llm.WithModel(ctx, opt.StringValue())
+			}
+		}
+		return c.ask(ctx, s, i, session, prompt, image)
+	case "reset":
+		if err := c.conv.Reset(session); err != nil {
+			return respondEphemeral(s, i, "Error resetting conversation: "+err.Error())
+		}
+		slog.Info("AI conversation reset", "session", session, "user_id", interactionUserID(i))
+		return respondEphemeral(s, i, "🧹 Conversation in this channel forgotten.")
+	case "history":
+		history, err := c.conv.History(session)
+		if err != nil {
+			return respondEphemeral(s, i, "Error loading conversation: "+err.Error())
+		}
+		return respondEphemeral(s, i, formatHistory(history))
+	case "models":
+		if c.router == nil {
+			return respondEphemeral(s, i, "No models are configured.")
+		}
+		return respondEphemeral(s, i, formatModels(c.router.Backends()))
+	default:
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+}
 
+func (c *AICommand) ask(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, session, prompt string, image *discordgo.MessageAttachment) error {
 	username := "Unknown"
 	userID := "Unknown"
 	if i.Member != nil && i.Member.User != nil {
@@ -50,88 +176,221 @@ func (c *AICommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate
 		"user", username,
 		"user_id", userID,
 		"guild_id", i.GuildID,
+		"session", session,
 		"prompt", prompt,
+		"image", image != nil,
 	)
 
+	if image != nil && !isImageAttachment(image) {
+		return respondEphemeral(s, i, fmt.Sprintf("❌ %s isn't a PNG, JPEG, GIF, WebP or BMP image.", image.Filename))
+	}
+
 	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 	}); err != nil {
 		return err
 	}
 
-	requestBody := map[string]interface{}{
-		"model": "llama",
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
+	var images []llm.ContentPart
+	if image != nil {
+		part, err := downloadImage(ctx, image)
+		if err != nil {
+			slog.Error("Failed to fetch AI image", "session", session, "error", err)
+			_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+				Content: strPtr("❌ " + err.Error()),
+			})
+			return editErr
+		}
+		images = append(images, part)
+		prompt += fmt.Sprintf(" [attached image: %s]", image.Filename)
 	}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return err
+	var roleIDs []string
+	if i.Member != nil {
+		roleIDs = i.Member.Roles
 	}
+	sub := interactionSubject(i, c.Name())
+	inv := &tools.Invocation{GuildID: i.GuildID, ChannelID: i.ChannelID, UserID: userID, Images: c.imageGuard(s, sub, roleIDs)}
+	ctx = tools.WithInvocation(ctx, inv)
 
-	resp, err := http.Post("http://localhost:8081/v1/chat/completions", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	live := moderatedLive(&interactionSink{s: s, interaction: i.Interaction}, c.moderator, i.GuildID)
+	err := c.streamTurn(ctx, s, live, sub, session, username, prompt, images...)
+
+	if files := inv.Files(); len(files) > 0 {
+		if _, fileErr := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{Files: files}); fileErr != nil {
+			slog.Error("Failed to post AI attachments", "session", session, "error", fileErr)
+		}
 	}
-	defer resp.Body.Close()
+	return err
+}
 
-	body, err := io.ReadAll(resp.Body)
+// streamTurn streams the reply to prompt into live and saves the turn once
+// it has been delivered. If the LLM fails part way through, what was
+// already shown is kept and the error is appended. A reply the moderation
+// filter withholds is not saved, so later turns don't build on it.
+func (c *AICommand) streamTurn(ctx context.Context, s *discordgo.Session, live *liveMessage, sub moderation.Subject, session, author, prompt string, images ...llm.ContentPart) error {
+	turn, err := c.conv.AskStream(ctx, session, author, prompt, live.Write, images...)
 	if err != nil {
+		slog.Error("Failed to get AI response", "session", session, "error", err)
+		if live.Posted() {
+			live.Write(fmt.Sprintf("\n\n❌ Response interrupted: %v", err))
+		} else {
+			live.Write(fmt.Sprintf("❌ Failed to get AI response: %v", err))
+		}
+		if closeErr := live.Close(); closeErr != nil {
+			return closeErr
+		}
 		return err
 	}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(body, &response); err != nil {
+	if err := live.Close(); err != nil {
 		return err
 	}
+	if withholdFlagged(ctx, s, c.moderator, sub, live, turn.Assistant.Content) {
+		return nil
+	}
 
-	choices, ok := response["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		return fmt.Errorf("invalid response from AI server")
+	if err := c.conv.Save(turn, live.IDs()...); err != nil {
+		slog.Error("Failed to save AI conversation", "session", session, "error", err)
 	}
+	return nil
+}
 
-	choice, ok := choices[0].(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("invalid response structure")
+func formatModels(backends []llm.BackendStatus) string {
+	var b strings.Builder
+	b.WriteString("**Models**\n")
+	for _, backend := range backends {
+		status := "🟢"
+		if !backend.Healthy {
+			status = "🔴"
+		}
+		fmt.Fprintf(&b, "%s **%s** (`%s`)", status, backend.Name, backend.Model)
+
+		var features []string
+		if backend.ContextLength > 0 {
+			features = append(features, fmt.Sprintf("%dk context", backend.ContextLength/1000))
+		}
+		if backend.Vision {
+			features = append(features, "images")
+		}
+		if backend.Tools {
+			features = append(features, "tools")
+		}
+		if len(features) > 0 {
+			b.WriteString(": " + strings.Join(features, ", "))
+		}
+		if backend.LastError != "" {
+			b.WriteString("\n  ↳ " + truncate(backend.LastError, 150))
+		}
+		b.WriteString("\n")
 	}
+	return b.String()
+}
 
-	message, ok := choice["message"].(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("invalid message structure")
+// HandleReply continues a conversation when someone replies to one of the
+// bot's /ai answers, with text, images or both. Other messages are
+// ignored. admit is asked before a continuation runs, so it's limited like
+// /ai itself; if it refuses it has told the user why, and otherwise its
+// done func is called once the reply has been answered.
+func (c *AICommand) HandleReply(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, admit func() (done func(), ok bool)) {
+	if m.Author == nil || m.Author.Bot || m.MessageReference == nil {
+		return
+	}
+	if strings.TrimSpace(m.Content) == "" && !hasImageAttachment(m.Attachments) {
+		return
+	}
+
+	session, err := c.conv.SessionForMessage(m.MessageReference.MessageID)
+	if err != nil {
+		slog.Error("Failed to look up AI conversation", "message_id", m.MessageReference.MessageID, "error", err)
+		return
+	}
+	if session == "" {
+		return
 	}
 
-	content, ok := message["content"].(string)
+	slog.Info("AI reply received",
+		"user", m.Author.Username,
+		"user_id", m.Author.ID,
+		"guild_id", m.GuildID,
+		"session", session,
+	)
+
+	done, ok := admit()
 	if !ok {
-		return fmt.Errorf("invalid content")
+		return
+	}
+	defer done()
+
+	sub := moderation.Subject{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID, Command: c.Name()}
+	if c.moderator.CheckPrompt(ctx, s, sub, m.Content).Blocked {
+		if _, err := s.ChannelMessageSendReply(m.ChannelID, blockedPromptMessage, m.Reference()); err != nil {
+			slog.Error("Failed to report blocked AI reply", "session", session, "error", err)
+		}
+		return
 	}
 
-	const maxLen = 2000
+	s.ChannelTyping(m.ChannelID)
 
-	chunks := make([]string, 0)
-	for len(content) > 0 {
-		end := maxLen
-		if end > len(content) {
-			end = len(content)
+	images, note, err := promptImages(ctx, m.Attachments)
+	if err != nil {
+		slog.Error("Failed to fetch AI images", "session", session, "error", err)
+		if _, err := s.ChannelMessageSendReply(m.ChannelID, "❌ "+err.Error(), m.Reference()); err != nil {
+			slog.Error("Failed to report AI image error", "session", session, "error", err)
 		}
-		chunks = append(chunks, content[:end])
-		content = content[end:]
+		return
 	}
 
-	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &chunks[0],
-	}); err != nil {
-		return err
+	var roleIDs []string
+	if m.Member != nil {
+		roleIDs = m.Member.Roles
+	}
+	inv := &tools.Invocation{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID, Images: c.imageGuard(s, sub, roleIDs)}
+	ctx = tools.WithInvocation(ctx, inv)
+
+	live := moderatedLive(&channelSink{s: s, channelID: m.ChannelID, reference: m.Reference()}, c.moderator, m.GuildID)
+	if err := c.streamTurn(ctx, s, live, sub, session, m.Author.Username, strings.TrimSpace(m.Content+note), images...); err != nil {
+		slog.Error("Failed to answer AI reply", "session", session, "error", err)
 	}
 
-	for _, chunk := range chunks[1:] {
-		if _, err := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-			Content: chunk,
-		}); err != nil {
-			return err
+	if files := inv.Files(); len(files) > 0 {
+		if _, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Files: files, Reference: m.Reference()}); err != nil {
+			slog.Error("Failed to post AI attachments", "session", session, "error", err)
 		}
 	}
+}
 
-	return nil
+// formatHistory lists the most recent messages that fit in one Discord
+// message.
+func formatHistory(history []llm.ChatMessage) string {
+	if len(history) == 0 {
+		return "No conversation yet. Start one with `/ai ask`."
+	}
+
+	const maxLen = 1900
+	var lines []string
+	total := 0
+	for n := len(history) - 1; n >= 0; n-- {
+		m := history[n]
+		var line string
+		switch m.Role {
+		case "assistant":
+			line = "🤖 " + truncate(m.Content, 200)
+		case "system":
+			line = "📝 " + truncate(m.Content, 300)
+		default:
+			line = "💬 " + truncate(m.Content, 200)
+		}
+		if total+len(line)+1 > maxLen {
+			break
+		}
+		total += len(line) + 1
+		lines = append([]string{line}, lines...)
+	}
+
+	header := fmt.Sprintf("**Conversation in this channel** (%d messages", len(history))
+	if len(lines) < len(history) {
+		header += fmt.Sprintf(", showing the last %d", len(lines))
+	}
+	return header + "):\n" + strings.Join(lines, "\n")
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/llm"
 )
 
 func TestAICommand_LongResponse(t *testing.T) {
@@ -66,3 +67,27 @@ func TestAICommand_LongResponse(t *testing.T) {
 
 	t.Log("Test setup complete - manual verification needed for actual Discord API calls")
 }
+
+func TestFormatHistory_ShowsMostRecentThatFit(t *testing.T) {
+	var history []llm.ChatMessage
+	for i := 0; i < 30; i++ {
+		history = append(history,
+			llm.ChatMessage{Role: "user", Content: "alice: " + strings.Repeat("q", 150)},
+			llm.ChatMessage{Role: "assistant", Content: strings.Repeat("a", 150)},
+		)
+	}
+	history = append(history, llm.ChatMessage{Role: "user", Content: "alice: latest"})
+
+	out := formatHistory(history)
+	t.Logf("History output length: %d", len(out))
+
+	if len(out) > 2000 {
+		t.Errorf("Expected history to fit in one message, got %d characters", len(out))
+	}
+	if !strings.HasSuffix(out, "💬 alice: latest") {
+		t.Error("Expected the newest message to be shown last")
+	}
+	if !strings.Contains(out, "showing the last") {
+		t.Error("Expected the header to say older messages were omitted")
+	}
+}
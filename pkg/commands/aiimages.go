@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/moderation"
+	"github.com/josh/discord-bot/internal/ratelimit"
+	"github.com/josh/discord-bot/internal/safety"
+)
+
+// aiImageGuard holds images the /ai agent generates to /imagine's rules:
+// the safety gate's prompt rules, the server's size limits and the
+// /imagine quota before, and the classifier's verdict for the channel
+// after.
+type aiImageGuard struct {
+	s       *discordgo.Session
+	gate    *safety.Gate
+	limits  config.ImagineConfig
+	limiter *ratelimit.Limiter
+	sub     moderation.Subject
+	roleIDs []string
+}
+
+func (c *AICommand) imageGuard(s *discordgo.Session, sub moderation.Subject, roleIDs []string) *aiImageGuard {
+	g := &aiImageGuard{s: s, gate: c.safety, limiter: c.limiter, sub: sub, roleIDs: roleIDs}
+	if c.cfg != nil {
+		g.limits = c.cfg.ForGuild(sub.GuildID).Imagine
+	}
+	return g
+}
+
+func (g *aiImageGuard) Admit(ctx context.Context, req *imagegen.GenerationRequest) (func(), error) {
+	prompt, refused := g.gate.CheckPrompt(g.s, g.sub, req.Prompt)
+	if len(refused) > 0 {
+		return nil, fmt.Errorf("this server doesn't allow %s in image prompts", strings.Join(refused, ", "))
+	}
+	req.Prompt = prompt
+	if msg := checkImagineLimits(req, g.limits); msg != "" {
+		return nil, errors.New(strings.TrimPrefix(msg, "❌ "))
+	}
+	return g.charge(req)
+}
+
+// charge uses the /imagine quota for req, priced as /imagine prices it,
+// and returns a func that gives it back. Limiter errors fail open, as they
+// do for commands.
+func (g *aiImageGuard) charge(req *imagegen.GenerationRequest) (func(), error) {
+	noRefund := func() {}
+	if g.limiter == nil {
+		return noRefund, nil
+	}
+	quota := ratelimit.Request{
+		Command: "imagine",
+		GuildID: g.sub.GuildID,
+		UserID:  g.sub.UserID,
+		RoleIDs: g.roleIDs,
+		Cost:    imageCost(cmp.Or(req.Width, 1024), cmp.Or(req.Height, 1024)),
+	}
+	decision, err := g.limiter.Allow(quota)
+	if err != nil {
+		slog.Error("Rate limit check failed, allowing AI image", "user_id", g.sub.UserID, "error", err)
+		return noRefund, nil
+	}
+	if !decision.Allowed {
+		return nil, fmt.Errorf("the user can't generate images right now: %s, try again in %s", decision.Reason, decision.RetryAfter)
+	}
+	return func() {
+		if err := g.limiter.Refund(quota, decision.Day); err != nil {
+			slog.Error("Failed to refund AI image", "user_id", g.sub.UserID, "error", err)
+		}
+	}, nil
+}
+
+func (g *aiImageGuard) Review(ctx context.Context, image []byte) (spoiler, withheld bool) {
+	nsfw := false
+	if g.gate.ClassifiesImages(g.sub.GuildID) {
+		nsfw = channelNSFW(g.s, g.sub.ChannelID)
+	}
+	decision := g.gate.CheckImages(ctx, g.s, g.sub, nsfw, [][]byte{image})[0]
+	return decision.Action == safety.ActionSpoiler, decision.Action == safety.ActionBlock
+}
@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/moderation"
+	"github.com/josh/discord-bot/internal/ratelimit"
+	"github.com/josh/discord-bot/internal/safety"
+)
+
+type scoreClassifier float64
+
+func (c scoreClassifier) Classify(ctx context.Context, image []byte) (float64, error) {
+	return float64(c), nil
+}
+
+func TestAIImageGuard(t *testing.T) {
+	cfg := config.Default()
+	cfg.ImageSafety.Enabled = true
+	cfg.ImageSafety.BlockedTerms = []string{"gore"}
+	cfg.ImageSafety.RewriteTerms = map[string]string{"nude": "clothed"}
+	cfg.ImageSafety.Threshold = 0.5
+
+	s := &discordgo.Session{State: discordgo.NewState()}
+	s.State.GuildAdd(&discordgo.Guild{ID: "g", Channels: []*discordgo.Channel{
+		{ID: "sfw", GuildID: "g"},
+		{ID: "nsfw", GuildID: "g", NSFW: true},
+	}})
+
+	guard := func(channelID string, score float64) *aiImageGuard {
+		c := NewAICommand(nil, nil, nil, safety.NewGate(func(string) *config.Config { return cfg }, scoreClassifier(score)), nil, nil)
+		return c.imageGuard(s, moderation.Subject{GuildID: "g", ChannelID: channelID, UserID: "u", Command: "ai"}, nil)
+	}
+
+	req := &imagegen.GenerationRequest{Prompt: "a gore scene"}
+	if _, err := guard("sfw", 0).Admit(context.Background(), req); err == nil {
+		t.Error("Expected a blocked term to be refused")
+	}
+	req = &imagegen.GenerationRequest{Prompt: "a nude statue"}
+	if _, err := guard("sfw", 0).Admit(context.Background(), req); err != nil || req.Prompt != "a clothed statue" {
+		t.Errorf("Expected the prompt rewritten, got %q, %v", req.Prompt, err)
+	}
+
+	tests := []struct {
+		channelID         string
+		score             float64
+		spoiler, withheld bool
+	}{
+		{"sfw", 0.1, false, false},
+		{"sfw", 0.9, false, true},
+		{"nsfw", 0.9, true, false},
+	}
+	for _, tt := range tests {
+		spoiler, withheld := guard(tt.channelID, tt.score).Review(context.Background(), []byte("png"))
+		if spoiler != tt.spoiler || withheld != tt.withheld {
+			t.Errorf("Review in %s at %.1f = %v, %v, want %v, %v", tt.channelID, tt.score, spoiler, withheld, tt.spoiler, tt.withheld)
+		}
+	}
+}
+
+// usageStore keeps quota usage in memory, keyed by scope and command.
+type usageStore map[string]int
+
+func (u usageStore) Usage(scope, scopeID, command, day string) (int, error) {
+	return u[scope+"|"+command], nil
+}
+
+func (u usageStore) AddUsage(scope, scopeID, command, day string, amount int) error {
+	u[scope+"|"+command] += amount
+	return nil
+}
+
+func (u usageStore) Prune(beforeDay string) error {
+	return nil
+}
+
+func (u usageStore) Exemptions(guildID string) ([]db.RateLimitExemption, error) {
+	return nil, nil
+}
+
+func TestAIImageGuardQuota(t *testing.T) {
+	usage := usageStore{}
+	rules := func(command string) (config.RateLimitConfig, bool) {
+		return config.RateLimitConfig{Burst: 10, PerMinute: 1, UserDaily: 2}, command == "imagine"
+	}
+	guard := &aiImageGuard{
+		limits:  config.ImagineConfig{MaxWidth: 1024, MaxHeight: 1024, MaxSteps: 50},
+		limiter: ratelimit.NewLimiter(rules, usage, nil),
+		sub:     moderation.Subject{GuildID: "g", UserID: "u", Command: "ai"},
+	}
+
+	if _, err := guard.Admit(context.Background(), &imagegen.GenerationRequest{Prompt: "a fox", Width: 2048}); err == nil {
+		t.Error("Expected an image over the size limit to be refused")
+	}
+	if usage["user|imagine"] != 0 {
+		t.Errorf("Expected a refused image not to use the quota, used %d", usage["user|imagine"])
+	}
+
+	var refund func()
+	for n := range 2 {
+		var err error
+		if refund, err = guard.Admit(context.Background(), &imagegen.GenerationRequest{Prompt: "a fox"}); err != nil {
+			t.Fatalf("Image %d: %v", n+1, err)
+		}
+	}
+	if usage["user|imagine"] != 2 {
+		t.Errorf("Expected each image to use the /imagine quota, used %d", usage["user|imagine"])
+	}
+
+	// The second image failed to generate.
+	refund()
+	if usage["user|imagine"] != 1 {
+		t.Errorf("Expected a failed image to be refunded, used %d", usage["user|imagine"])
+	}
+	if _, err := guard.Admit(context.Background(), &imagegen.GenerationRequest{Prompt: "a fox"}); err != nil {
+		t.Fatalf("Expected the refunded use to be available again: %v", err)
+	}
+	if _, err := guard.Admit(context.Background(), &imagegen.GenerationRequest{Prompt: "a fox"}); err == nil {
+		t.Error("Expected an image over the daily quota to be refused")
+	}
+}
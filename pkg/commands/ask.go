@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/llm"
+	"github.com/josh/discord-bot/internal/moderation"
+	"github.com/josh/discord-bot/internal/rag"
+)
+
+type AskCommand struct {
+	index     *rag.Indexer
+	llm       llm.Completer
+	moderator *moderation.Filter
+}
+
+func NewAskCommand(index *rag.Indexer, llmClient llm.Completer, moderator *moderation.Filter) *AskCommand {
+	return &AskCommand{
+		index:     index,
+		llm:       llmClient,
+		moderator: moderator,
+	}
+}
+
+func (c *AskCommand) Name() string {
+	return "ask"
+}
+
+func (c *AskCommand) Description() string {
+	return "Answer a question from this server's indexed messages and files"
+}
+
+func (c *AskCommand) Data() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        c.Name(),
+		Description: c.Description(),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "question",
+				Description: "What you want to know",
+				Required:    true,
+				MaxLength:   500,
+			},
+		},
+	}
+}
+
+func (c *AskCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	if i.GuildID == "" {
+		return respondEphemeral(s, i, "This command can only be used in a server")
+	}
+	question := strings.TrimSpace(i.ApplicationCommandData().Options[0].StringValue())
+	userID := interactionUserID(i)
+
+	slog.Info("Ask command received", "user_id", userID, "guild_id", i.GuildID, "question", question)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		return err
+	}
+
+	results, err := c.index.Search(ctx, i.GuildID, question, canShareChannel(s, userID, i.ChannelID))
+	if err != nil {
+		slog.Error("Failed to search index", "guild_id", i.GuildID, "error", err)
+		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: strPtr(fmt.Sprintf("❌ Failed to search this server's messages: %v", err)),
+		})
+		return editErr
+	}
+	if len(results) == 0 {
+		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: strPtr("🔍 I couldn't find anything about that in the indexed channels everyone here can read. Admins can add channels with `/index enable`."),
+		})
+		return err
+	}
+
+	live := moderatedLive(&interactionSink{s: s, interaction: i.Interaction}, c.moderator, i.GuildID)
+	messages := []llm.ChatMessage{{Role: "user", Content: rag.Prompt(question, results)}}
+	completion, err := c.llm.CompleteStream(ctx, messages, llm.Options{}, live.Write)
+	if err != nil {
+		slog.Error("Failed to answer question", "guild_id", i.GuildID, "error", err)
+		if live.Posted() {
+			live.Write(fmt.Sprintf("\n\n❌ Response interrupted: %v", err))
+		} else {
+			live.Write(fmt.Sprintf("❌ Failed to get AI response: %v", err))
+		}
+		return live.Close()
+	}
+
+	live.Write(formatSources(i.GuildID, results, completion.Content))
+	if err := live.Close(); err != nil {
+		return err
+	}
+	withholdFlagged(ctx, s, c.moderator, interactionSubject(i, c.Name()), live, completion.Content)
+	return nil
+}
+
+// canReadChannel returns a filter that keeps results from channels the user
+// can't read out of their answers. Lookups are cached for one question.
+func canReadChannel(s *discordgo.Session, userID string) func(channelID string) bool {
+	seen := make(map[string]bool)
+	return func(channelID string) bool {
+		if ok, cached := seen[channelID]; cached {
+			return ok
+		}
+		perms, err := s.UserChannelPermissions(userID, channelID)
+		if err != nil {
+			slog.Warn("Failed to check channel permissions", "user_id", userID, "channel_id", channelID, "error", err)
+		}
+		ok := err == nil && perms&readHistory == readHistory
+		seen[channelID] = ok
+		return ok
+	}
+}
+
+var citationPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// formatSources lists the results the answer cites as links to their
+// messages. If the answer cites nothing every result is listed, since it
+// was still drawn from them.
+func formatSources(guildID string, results []rag.Result, answer string) string {
+	cited := make(map[int]bool)
+	for _, m := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 && n <= len(results) {
+			cited[n] = true
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\n**Sources:**")
+	for n, r := range results {
+		if len(cited) > 0 && !cited[n+1] {
+			continue
+		}
+		what := r.Author
+		if r.Source != rag.SourceMessage {
+			what = fmt.Sprintf("%s from %s", r.Source, r.Author)
+		}
+		fmt.Fprintf(&b, "\n[%d] %s in <#%s>: <%s>", n+1, what, r.ChannelID, rag.MessageURL(guildID, r.ChannelID, r.MessageID))
+	}
+	return b.String()
+}
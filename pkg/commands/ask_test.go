@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/rag"
+)
+
+func TestFormatSources(t *testing.T) {
+	results := []rag.Result{
+		{RAGChunk: db.RAGChunk{ChannelID: "c1", MessageID: "m1", Author: "alice", Source: rag.SourceMessage}},
+		{RAGChunk: db.RAGChunk{ChannelID: "c2", MessageID: "m2", Author: "bob", Source: "runbook.pdf"}},
+		{RAGChunk: db.RAGChunk{ChannelID: "c1", MessageID: "m3", Author: "carol", Source: rag.SourceMessage}},
+	}
+
+	got := formatSources("g", results, "Deploys happen on Friday [2], see the runbook [2][9].")
+	want := "\n\n**Sources:**\n[2] runbook.pdf from bob in <#c2>: <https://discord.com/channels/g/c2/m2>"
+	if got != want {
+		t.Errorf("cited sources:\ngot  %q\nwant %q", got, want)
+	}
+
+	got = formatSources("g", results, "Deploys happen on Friday.")
+	if strings.Count(got, "\n[") != 3 || !strings.Contains(got, "[1] alice in <#c1>: <https://discord.com/channels/g/c1/m1>") {
+		t.Errorf("expected every source when none are cited, got %q", got)
+	}
+}
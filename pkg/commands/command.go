@@ -1,10 +1,35 @@
 package commands
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"context"
+
+	"github.com/bwmarrin/discordgo"
+)
 
 type Command interface {
 	Name() string
 	Description() string
 	Data() *discordgo.ApplicationCommand
-	Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error
+	Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error
+}
+
+// Coster is implemented by commands whose rate limit cost depends on their
+// options. Other commands count as one use. A cost of 0 means the
+// interaction is free, like a button that only opens a form.
+type Coster interface {
+	Cost(i *discordgo.InteractionCreate) int
+}
+
+// ComponentHandler is implemented by commands that put buttons on their
+// messages or open forms. Their components' custom IDs start with the
+// command name and a colon, e.g. "imagine:reroll:12:0", so presses and
+// form submissions are routed back to the command.
+type ComponentHandler interface {
+	HandleComponent(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error
+}
+
+// Autocompleter is implemented by commands with options that suggest
+// values as the user types.
+type Autocompleter interface {
+	Autocomplete(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error
 }
@@ -0,0 +1,223 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+)
+
+type ConfigCommand struct {
+	cfg *config.Manager
+}
+
+func NewConfigCommand(cfg *config.Manager) *ConfigCommand {
+	return &ConfigCommand{
+		cfg: cfg,
+	}
+}
+
+func (c *ConfigCommand) Name() string {
+	return "config"
+}
+
+func (c *ConfigCommand) Description() string {
+	return "View or change bot settings for this server"
+}
+
+func (c *ConfigCommand) Data() *discordgo.ApplicationCommand {
+	var keyChoices []*discordgo.ApplicationCommandOptionChoice
+	for _, key := range config.GuildKeys() {
+		keyChoices = append(keyChoices, &discordgo.ApplicationCommandOptionChoice{Name: key, Value: key})
+	}
+
+	manageGuild := int64(discordgo.PermissionManageGuild)
+
+	return &discordgo.ApplicationCommand{
+		Name:                     c.Name(),
+		Description:              c.Description(),
+		DefaultMemberPermissions: &manageGuild,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "view",
+				Description: "Show the effective settings for this server",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Override a setting for this server",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "key",
+						Description: "Setting to change",
+						Required:    true,
+						Choices:     keyChoices,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "value",
+						Description: "New value",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "unset",
+				Description: "Remove a server override and use the global default",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "key",
+						Description: "Setting to reset",
+						Required:    true,
+						Choices:     keyChoices,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "exempt",
+				Description: "Exempt a user or role from rate limits and quotas",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionMentionable,
+						Name:        "target",
+						Description: "User or role to exempt",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "remove",
+						Description: "Remove the exemption instead",
+						Required:    false,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (c *ConfigCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || i.GuildID == "" {
+		return respondEphemeral(s, i, "This command can only be used in a server")
+	}
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "view":
+		return respondEphemeral(s, i, c.describe(i.GuildID))
+	case "set":
+		key := sub.Options[0].StringValue()
+		value := strings.TrimSpace(sub.Options[1].StringValue())
+		if err := config.ValidateGuildSetting(key, value); err != nil {
+			return respondEphemeral(s, i, "❌ "+err.Error())
+		}
+		if err := db.SetGuildSetting(i.GuildID, key, value); err != nil {
+			return respondEphemeral(s, i, "Error saving setting: "+err.Error())
+		}
+		slog.Info("Guild setting changed", "guild_id", i.GuildID, "key", key, "value", value)
+		return respondEphemeral(s, i, fmt.Sprintf("✅ `%s` set to `%s`", key, value))
+	case "unset":
+		key := sub.Options[0].StringValue()
+		if err := db.DeleteGuildSetting(i.GuildID, key); err != nil {
+			return respondEphemeral(s, i, "Error removing setting: "+err.Error())
+		}
+		slog.Info("Guild setting removed", "guild_id", i.GuildID, "key", key)
+		return respondEphemeral(s, i, fmt.Sprintf("✅ `%s` reset to the global default", key))
+	case "exempt":
+		return c.exempt(s, i, data, sub)
+	default:
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+}
+
+func (c *ConfigCommand) exempt(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData, sub *discordgo.ApplicationCommandInteractionDataOption) error {
+	targetID := sub.Options[0].Value.(string)
+	remove := len(sub.Options) > 1 && sub.Options[1].BoolValue()
+
+	subjectType, mention := "user", "<@"+targetID+">"
+	if data.Resolved != nil && data.Resolved.Roles[targetID] != nil {
+		subjectType, mention = "role", "<@&"+targetID+">"
+	}
+
+	if remove {
+		removed, err := db.RemoveRateLimitExemption(i.GuildID, subjectType, targetID)
+		if err != nil {
+			return respondEphemeral(s, i, "Error removing exemption: "+err.Error())
+		}
+		if !removed {
+			return respondEphemeral(s, i, fmt.Sprintf("%s is not exempt", mention))
+		}
+		slog.Info("Rate limit exemption removed", "guild_id", i.GuildID, "type", subjectType, "id", targetID)
+		return respondEphemeral(s, i, fmt.Sprintf("✅ %s is subject to rate limits again", mention))
+	}
+
+	if err := db.AddRateLimitExemption(i.GuildID, subjectType, targetID); err != nil {
+		return respondEphemeral(s, i, "Error saving exemption: "+err.Error())
+	}
+	slog.Info("Rate limit exemption added", "guild_id", i.GuildID, "type", subjectType, "id", targetID)
+	return respondEphemeral(s, i, fmt.Sprintf("✅ %s is now exempt from rate limits and quotas", mention))
+}
+
+func (c *ConfigCommand) describe(guildID string) string {
+	cfg := c.cfg.ForGuild(guildID)
+	overrides, err := db.GetGuildSettings(guildID)
+	if err != nil {
+		slog.Warn("Failed to load guild settings", "guild_id", guildID, "error", err)
+	}
+
+	values := map[string]string{
+		"imagine.max_width":       fmt.Sprint(cfg.Imagine.MaxWidth),
+		"imagine.max_height":      fmt.Sprint(cfg.Imagine.MaxHeight),
+		"imagine.max_steps":       fmt.Sprint(cfg.Imagine.MaxSteps),
+		"stock_news.default_days": fmt.Sprint(cfg.StockNews.DefaultDays),
+		"moderation.log_channel":  "none",
+	}
+	if cfg.Moderation.LogChannel != "" {
+		values["moderation.log_channel"] = "<#" + cfg.Moderation.LogChannel + ">"
+	}
+
+	var b strings.Builder
+	b.WriteString("**Server settings:**\n")
+	for _, key := range config.GuildKeys() {
+		marker := ""
+		if _, ok := overrides[key]; ok {
+			marker = " *(server override)*"
+		}
+		b.WriteString(fmt.Sprintf("- `%s`: %s%s\n", key, values[key], marker))
+	}
+
+	exemptions, err := db.ListRateLimitExemptions(guildID)
+	if err != nil {
+		slog.Warn("Failed to load rate limit exemptions", "guild_id", guildID, "error", err)
+	}
+	if len(exemptions) > 0 {
+		b.WriteString("\n**Exempt from rate limits:**\n")
+		for _, e := range exemptions {
+			if e.SubjectType == "role" {
+				b.WriteString(fmt.Sprintf("- <@&%s>\n", e.SubjectID))
+			} else {
+				b.WriteString(fmt.Sprintf("- <@%s>\n", e.SubjectID))
+			}
+		}
+	}
+	return b.String()
+}
+
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
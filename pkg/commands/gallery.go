@@ -0,0 +1,237 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/gallery"
+)
+
+// maxGallerySearch keeps the search text, which rides along in the page
+// buttons' custom IDs, inside Discord's 100 characters.
+const maxGallerySearch = 60
+
+// maxGalleryPrompt keeps a page of ten embeds inside Discord's 6000
+// characters for all of a message's embeds.
+const maxGalleryPrompt = 300
+
+type GalleryCommand struct {
+	cfg *config.Manager
+}
+
+func NewGalleryCommand(cfg *config.Manager) *GalleryCommand {
+	return &GalleryCommand{cfg: cfg}
+}
+
+func (c *GalleryCommand) Name() string {
+	return "gallery"
+}
+
+func (c *GalleryCommand) Description() string {
+	return "Browse and remix images made with /imagine"
+}
+
+func (c *GalleryCommand) Data() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        c.Name(),
+		Description: c.Description(),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "mine",
+				Description: "Images you made in this server",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "guild",
+				Description: "Images everyone made in this server",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "search",
+				Description: "Images in this server whose prompt has all these words",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "text",
+						Description: "Words to look for in the prompt",
+						Required:    true,
+						MaxLength:   maxGallerySearch,
+					},
+				},
+			},
+		},
+	}
+}
+
+// galleryQuery is a page of a gallery listing. It's kept in the custom
+// IDs of the page buttons as gallery:page:<scope>:<page>:<text>.
+type galleryQuery struct {
+	scope string
+	page  int
+	text  string
+}
+
+func (q galleryQuery) customID() string {
+	return fmt.Sprintf("gallery:page:%s:%d:%s", q.scope, q.page, q.text)
+}
+
+func parseGalleryQuery(customID string) (galleryQuery, error) {
+	parts := strings.SplitN(customID, ":", 5)
+	if len(parts) != 5 || parts[0] != "gallery" || parts[1] != "page" {
+		return galleryQuery{}, fmt.Errorf("unknown component %q", customID)
+	}
+	page, err := strconv.Atoi(parts[3])
+	if err != nil || page < 0 {
+		return galleryQuery{}, fmt.Errorf("unknown component %q", customID)
+	}
+	return galleryQuery{scope: parts[2], page: page, text: parts[4]}, nil
+}
+
+func (c *GalleryCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	if i.GuildID == "" {
+		return respondEphemeral(s, i, "❌ The gallery only works in a server.")
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+	sub := data.Options[0]
+	q := galleryQuery{scope: sub.Name}
+	if sub.Name == "search" {
+		q.text = strings.TrimSpace(sub.Options[0].StringValue())
+	}
+
+	page, err := c.render(s, i, q)
+	if err != nil {
+		return err
+	}
+	page.Flags = discordgo.MessageFlagsEphemeral
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: page,
+	})
+}
+
+// HandleComponent turns the page.
+func (c *GalleryCommand) HandleComponent(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	q, err := parseGalleryQuery(i.MessageComponentData().CustomID)
+	if err != nil {
+		return err
+	}
+	page, err := c.render(s, i, q)
+	if err != nil {
+		return err
+	}
+	// The thumbnails of the page before go with it.
+	page.Attachments = &[]*discordgo.MessageAttachment{}
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: page,
+	})
+}
+
+// render loads a page of q for whoever's asking, in the channel they're
+// asking in: NSFW images are only listed in age-restricted channels.
+func (c *GalleryCommand) render(s *discordgo.Session, i *discordgo.InteractionCreate, q galleryQuery) (*discordgo.InteractionResponseData, error) {
+	cfg := c.cfg.Current().Gallery
+	filter := db.GalleryFilter{GuildID: i.GuildID, Search: q.text, NSFW: channelNSFW(s, i.ChannelID)}
+	if q.scope == "mine" {
+		filter.UserID = interactionUserID(i)
+	}
+
+	images, total, err := db.ListGalleryImages(filter, q.page*cfg.PageSize, cfg.PageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gallery images: %w", err)
+	}
+	// The listing shrank since the page was shown; go to its new last page.
+	if len(images) == 0 && q.page > 0 && total > 0 {
+		q.page = (total - 1) / cfg.PageSize
+		if images, total, err = db.ListGalleryImages(filter, q.page*cfg.PageSize, cfg.PageSize); err != nil {
+			return nil, fmt.Errorf("failed to list gallery images: %w", err)
+		}
+	}
+
+	page := galleryPage(q, images, total, cfg.PageSize)
+	store := gallery.NewStore(cfg.Dir, cfg.ThumbnailSize)
+	for n, img := range images {
+		if img.Thumbnail == "" {
+			continue
+		}
+		thumb, err := store.Read(img.Thumbnail)
+		if err != nil {
+			slog.Warn("Failed to read gallery thumbnail", "id", img.ID, "error", err)
+			continue
+		}
+		name := fmt.Sprintf("thumb_%d.jpg", img.ID)
+		page.Files = append(page.Files, &discordgo.File{Name: name, ContentType: "image/jpeg", Reader: bytes.NewReader(thumb)})
+		page.Embeds[n].Thumbnail = &discordgo.MessageEmbedThumbnail{URL: "attachment://" + name}
+	}
+	return page, nil
+}
+
+// galleryPage lays out a page of images: an embed for each, buttons to
+// turn the page, and a remix button per image.
+func galleryPage(q galleryQuery, images []db.GalleryImage, total, pageSize int) *discordgo.InteractionResponseData {
+	title := map[string]string{
+		"mine":   "🖼️ **Your images**",
+		"guild":  "🖼️ **This server's images**",
+		"search": fmt.Sprintf("🔎 **Images matching** “%s”", q.text),
+	}[q.scope]
+	if len(images) == 0 {
+		empty := "🖼️ Nothing here yet. Make something with `/imagine create`!"
+		if q.scope == "search" {
+			empty = fmt.Sprintf("🔎 No images match “%s”.", q.text)
+		}
+		return &discordgo.InteractionResponseData{Content: empty, Components: []discordgo.MessageComponent{}}
+	}
+
+	pages := (total + pageSize - 1) / pageSize
+	data := &discordgo.InteractionResponseData{
+		Content: fmt.Sprintf("%s: page %d of %d, %d in all", title, q.page+1, pages, total),
+	}
+
+	var remix []discordgo.MessageComponent
+	for n, img := range images {
+		data.Embeds = append(data.Embeds, &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("%d. Image #%d", n+1, img.ID),
+			URL:         gallery.MessageLink(img.GuildID, img.ChannelID, img.MessageID),
+			Description: truncate(img.Prompt, maxGalleryPrompt),
+			Color:       0x5865F2,
+			Fields: []*discordgo.MessageEmbedField{
+				{Name: "Seed", Value: strconv.FormatInt(img.Seed, 10), Inline: true},
+				{Name: "Size", Value: fmt.Sprintf("%dx%d", img.Width, img.Height), Inline: true},
+				{Name: "Steps", Value: strconv.Itoa(img.Steps), Inline: true},
+				{Name: "By", Value: "<@" + img.UserID + ">", Inline: true},
+			},
+			Timestamp: img.CreatedAt.UTC().Format(time.RFC3339),
+		})
+		remix = append(remix, discordgo.Button{
+			Label:    fmt.Sprintf("Remix %d", n+1),
+			Emoji:    &discordgo.ComponentEmoji{Name: "🎨"},
+			Style:    discordgo.SecondaryButton,
+			CustomID: imagineAction{action: actionRemix, generation: img.ID}.customID(),
+		})
+	}
+
+	prev, next := q, q
+	prev.page, next.page = q.page-1, q.page+1
+	data.Components = []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+		discordgo.Button{Label: "Previous", Emoji: &discordgo.ComponentEmoji{Name: "◀️"}, Style: discordgo.PrimaryButton, CustomID: prev.customID(), Disabled: q.page == 0},
+		discordgo.Button{Label: "Next", Emoji: &discordgo.ComponentEmoji{Name: "▶️"}, Style: discordgo.PrimaryButton, CustomID: next.customID(), Disabled: q.page+1 >= pages},
+	}}}
+	for len(remix) > 0 {
+		row := remix[:min(5, len(remix))]
+		remix = remix[len(row):]
+		data.Components = append(data.Components, discordgo.ActionsRow{Components: row})
+	}
+	return data
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/db"
+)
+
+func TestGalleryQueryCustomID(t *testing.T) {
+	q := galleryQuery{scope: "search", page: 3, text: "cat: the sequel"}
+	got, err := parseGalleryQuery(q.customID())
+	if err != nil || got != q {
+		t.Errorf("Expected %+v back, got %+v, %v", q, got, err)
+	}
+	for _, bad := range []string{"gallery:page:mine:x:", "gallery:page:mine:-1:", "imagine:page:mine:0:", "gallery:page:mine"} {
+		if _, err := parseGalleryQuery(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func galleryImages(n int) []db.GalleryImage {
+	images := make([]db.GalleryImage, n)
+	for i := range images {
+		images[i] = db.GalleryImage{
+			ID: int64(100 - i), GuildID: "1", ChannelID: "2", MessageID: "3", UserID: "4",
+			Prompt: "a fox", Seed: 42, Width: 1024, Height: 768, Steps: 30,
+			CreatedAt: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC),
+		}
+	}
+	return images
+}
+
+func buttonsIn(row discordgo.MessageComponent) []discordgo.Button {
+	var buttons []discordgo.Button
+	for _, c := range row.(discordgo.ActionsRow).Components {
+		buttons = append(buttons, c.(discordgo.Button))
+	}
+	return buttons
+}
+
+func TestGalleryPage(t *testing.T) {
+	q := galleryQuery{scope: "mine", page: 1}
+	page := galleryPage(q, galleryImages(7), 17, 7)
+
+	if page.Content != "🖼️ **Your images**: page 2 of 3, 17 in all" {
+		t.Errorf("Unexpected content %q", page.Content)
+	}
+	if len(page.Embeds) != 7 {
+		t.Fatalf("Expected an embed per image, got %d", len(page.Embeds))
+	}
+	e := page.Embeds[0]
+	if e.Title != "1. Image #100" || e.URL != "https://discord.com/channels/1/2/3" || e.Fields[1].Value != "1024x768" || e.Timestamp != "2026-05-01T12:00:00Z" {
+		t.Errorf("Unexpected embed %+v", e)
+	}
+
+	if len(page.Components) != 3 {
+		t.Fatalf("Expected page buttons and two rows of remixes, got %d rows", len(page.Components))
+	}
+	nav := buttonsIn(page.Components[0])
+	if nav[0].Disabled || nav[1].Disabled {
+		t.Error("Expected both page buttons on a middle page")
+	}
+	if prev, _ := parseGalleryQuery(nav[0].CustomID); prev.page != 0 || prev.scope != "mine" {
+		t.Errorf("Unexpected previous page %+v", prev)
+	}
+	remix := append(buttonsIn(page.Components[1]), buttonsIn(page.Components[2])...)
+	if len(remix) != 7 {
+		t.Fatalf("Expected a remix button per image, got %d", len(remix))
+	}
+	a, err := parseImagineAction(remix[6].CustomID)
+	if err != nil || a.action != actionRemix || a.generation != 94 {
+		t.Errorf("Expected a remix of image 94, got %+v, %v", a, err)
+	}
+
+	last := galleryPage(galleryQuery{scope: "guild", page: 2}, galleryImages(3), 17, 7)
+	if nav := buttonsIn(last.Components[0]); nav[0].Disabled || !nav[1].Disabled {
+		t.Error("Expected only the previous button on the last page")
+	}
+}
+
+func TestGalleryPageEmpty(t *testing.T) {
+	page := galleryPage(galleryQuery{scope: "search", text: "dragon"}, nil, 0, 5)
+	if page.Content != "🔎 No images match “dragon”." || len(page.Embeds) != 0 || len(page.Components) != 0 {
+		t.Errorf("Unexpected empty page %+v", page)
+	}
+}
@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"context"
+
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -21,7 +23,7 @@ func (c *HelpCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *HelpCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *HelpCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	// Since commandMap is in main, we can't access it here.
 	// For simplicity, hardcode the list.
 	content := "**Available Commands:**\n" +
@@ -39,11 +41,22 @@ func (c *HelpCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCrea
 		"- `/playlist add <name> <url>`: Add song to playlist\n" +
 		"- `/playlist play <name>`: Play a playlist\n" +
 		"- `/playlist list`: List your playlists\n" +
-		"- `/ai <prompt>`: Generate AI content\n" +
-		"- `/imagine <prompt>`: Generate images with Stable Diffusion\n" +
-		"- `/pdf`: Generate PDF documents with AI\n" +
+		"- `/ai ask <prompt> [image]`: Chat with the AI (reply to its answer to keep going) and show it images; it can look up stock news and sentiment, draw images and queue songs\n" +
+		"- `/ai reset|history`: Forget or show this channel's AI conversation\n" +
+		"- `/ai models`: List the models `/ai ask model:` can pick and whether they're up\n" +
+		"- `/summarize [channel] [since]`: Digest of a channel or thread's topics, decisions and action items, with links (since: 2h, 3d, today or a message link)\n" +
+		"- `/ask <question>`: Answer from this server's indexed messages and files, with links to the sources\n" +
+		"- `/index enable|disable|status`: Choose which channels `/ask` can answer from (admins)\n" +
+		"- `/persona list|create|set`: Manage the AI's persona for this server or channel\n" +
+		"- `/imagine create <prompt> [preset] [model]`: Generate images with Stable Diffusion, in a style preset or on another model\n" +
+		"- `/imagine edit|upscale`: Redraw part or all of an image from a prompt, or enlarge it\n" +
+		"- `/gallery mine|guild|search <text>`: Browse this server's /imagine results and remix one with new settings\n" +
+		"- `/pdf`: Generate documents with AI as PDF, or as Word, Excel or PowerPoint files\n" +
 		"  • Types: Document/Report, Presentation/Slides, Spreadsheet/Table\n" +
 		"  • Automatically includes AI-generated images\n" +
+		"- `/jobs list|cancel`: See or cancel your running /pdf, /imagine, /stock and /summarize jobs\n" +
+		"- `/quota`: See how many /imagine, /pdf, /stock and /summarize uses you have left today\n" +
+		"- `/config view|set|unset|exempt`: Manage bot settings and rate limit exemptions for this server (admins)\n" +
 		"- `/help`: Show this help"
 
 	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+)
+
+// maxUpscaleSide keeps upscaled images to a size Stable Diffusion can hold
+// in memory and Discord will accept.
+const maxUpscaleSide = 4096
+
+// maxUploadBytes is Discord's attachment limit in servers without boosts.
+const maxUploadBytes = 10 << 20
+
+// recentImageMessages is how far back /imagine edit and upscale look for
+// the last image generated in the channel.
+const recentImageMessages = 50
+
+// sourceImage is an image to edit or upscale, with its size.
+type sourceImage struct {
+	data          []byte
+	width, height int
+}
+
+func resolvedAttachment(data discordgo.ApplicationCommandInteractionData, opt *discordgo.ApplicationCommandInteractionDataOption) *discordgo.MessageAttachment {
+	if data.Resolved == nil {
+		return nil
+	}
+	return data.Resolved.Attachments[opt.Value.(string)]
+}
+
+// sourceImage finds the image to work on: the attachment if there is one,
+// else the first image in the message linked by from, else the last image
+// the bot posted in this channel.
+func (c *ImagineCommand) sourceImage(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, attachment *discordgo.MessageAttachment, from string) (*sourceImage, error) {
+	switch {
+	case attachment != nil:
+	case strings.TrimSpace(from) != "":
+		m := messageLinkPattern.FindStringSubmatch(strings.TrimSpace(from))
+		if m == nil {
+			return nil, errors.New("`from` should be a message link (right-click a message, Copy Message Link)")
+		}
+		if m[1] != i.GuildID {
+			return nil, errors.New("that message isn't in this server")
+		}
+		channelID, messageID := m[2], m[3]
+		if !canReadChannel(s, interactionUserID(i))(channelID) {
+			return nil, fmt.Errorf("you can't read <#%s>", channelID)
+		}
+		msg, err := s.ChannelMessage(channelID, messageID, discordgo.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't fetch that message: %w", err)
+		}
+		if attachment = firstImage(msg); attachment == nil {
+			return nil, errors.New("that message has no image")
+		}
+	default:
+		history, err := s.ChannelMessages(i.ChannelID, recentImageMessages, "", "", "", discordgo.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read this channel: %w", err)
+		}
+		if attachment = latestImage(history, s.State.User.ID); attachment == nil {
+			return nil, errors.New("there's no recent image here; attach one or link to it with `from`")
+		}
+	}
+
+	data, _, err := fetchImage(ctx, attachment)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a PNG, JPEG or GIF to edit", attachment.Filename)
+	}
+	return &sourceImage{data: data, width: cfg.Width, height: cfg.Height}, nil
+}
+
+func firstImage(m *discordgo.Message) *discordgo.MessageAttachment {
+	return imageAt(m, 0)
+}
+
+// latestImage returns the newest image attachment posted by botID in
+// messages, which are newest first as Discord returns them.
+func latestImage(messages []*discordgo.Message, botID string) *discordgo.MessageAttachment {
+	for _, m := range messages {
+		if m.Author == nil || m.Author.ID != botID {
+			continue
+		}
+		if a := firstImage(m); a != nil {
+			return a
+		}
+	}
+	return nil
+}
+
+// editSize picks the img2img output size for a width x height source:
+// the same shape, scaled down to fit the server's limits, in the
+// multiples of 8 Stable Diffusion works in.
+func editSize(width, height int, limits config.ImagineConfig) (int, int) {
+	if width <= 0 || height <= 0 {
+		return 1024, 1024
+	}
+	scale := min(1, float64(limits.MaxWidth)/float64(width), float64(limits.MaxHeight)/float64(height))
+	w := max(64, int(float64(width)*scale)/8*8)
+	h := max(64, int(float64(height)*scale)/8*8)
+	return w, h
+}
+
+// uploadableImage wraps a PNG for posting, re-encoding it as a JPEG if it
+// is too big for Discord.
+func uploadableImage(data []byte, name string) (*discordgo.File, error) {
+	if len(data) <= maxUploadBytes {
+		return &discordgo.File{Name: name + ".png", ContentType: "image/png", Reader: bytes.NewReader(data)}, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	if buf.Len() > maxUploadBytes {
+		return nil, fmt.Errorf("the result is %d MB, over Discord's %d MB limit", buf.Len()>>20, maxUploadBytes>>20)
+	}
+	return &discordgo.File{Name: name + ".jpg", ContentType: "image/jpeg", Reader: &buf}, nil
+}
+
+// editError reports err in place of the deferred response, and of any
+// live preview, and returns it so the job is recorded as failed.
+func editError(s *discordgo.Session, i *discordgo.InteractionCreate, err error) error {
+	slog.Error("Imagine job failed", "error", err)
+	if _, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:     strPtr("❌ " + capitalize(err.Error())),
+		Attachments: &[]*discordgo.MessageAttachment{},
+	}); editErr != nil {
+		return editErr
+	}
+	return err
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
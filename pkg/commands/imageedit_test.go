@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+)
+
+func TestEditSize(t *testing.T) {
+	limits := config.ImagineConfig{MaxWidth: 1024, MaxHeight: 1024}
+
+	tests := []struct {
+		width, height int
+		wantW, wantH  int
+	}{
+		{512, 512, 512, 512},
+		{2048, 1024, 1024, 512},
+		{1000, 3000, 336, 1024},
+		{1023, 767, 1016, 760},
+		{4000, 20, 1024, 64},
+		{0, 0, 1024, 1024},
+	}
+	for _, tt := range tests {
+		w, h := editSize(tt.width, tt.height, limits)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("editSize(%d, %d) = %dx%d, want %dx%d", tt.width, tt.height, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestLatestImage(t *testing.T) {
+	bot := &discordgo.User{ID: "bot"}
+	user := &discordgo.User{ID: "user"}
+	images := func(name string) []*discordgo.MessageAttachment {
+		return []*discordgo.MessageAttachment{{Filename: name, ContentType: "image/png"}}
+	}
+
+	messages := []*discordgo.Message{
+		{Author: user, Attachments: images("theirs.png")},
+		{Author: bot, Content: "no image"},
+		{Author: bot, Attachments: []*discordgo.MessageAttachment{{Filename: "report.pdf", ContentType: "application/pdf"}}},
+		{Author: bot, Attachments: append(images(gridFilename), images("newest.png")...)},
+		{Author: bot, Attachments: images("older.png")},
+	}
+	if a := latestImage(messages, "bot"); a == nil || a.Filename != "newest.png" {
+		t.Errorf("Expected newest.png, got %+v", a)
+	}
+	if a := latestImage(messages[:3], "bot"); a != nil {
+		t.Errorf("Expected no image, got %+v", a)
+	}
+}
+
+func TestUploadableImageKeepsSmallPNG(t *testing.T) {
+	var data bytes.Buffer
+	png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	file, err := uploadableImage(data.Bytes(), "upscaled")
+	if err != nil {
+		t.Fatalf("uploadableImage failed: %v", err)
+	}
+	if file.Name != "upscaled.png" || file.ContentType != "image/png" {
+		t.Errorf("Expected the PNG unchanged, got %s (%s)", file.Name, file.ContentType)
+	}
+}
+
+func TestImagineCost(t *testing.T) {
+	c := &ImagineCommand{}
+	interaction := func(sub string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+		return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type: discordgo.InteractionApplicationCommand,
+			Data: discordgo.ApplicationCommandInteractionData{
+				Name: "imagine",
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: sub, Type: discordgo.ApplicationCommandOptionSubCommand, Options: opts},
+				},
+			},
+		}}
+	}
+	integer := func(name string, v int) *discordgo.ApplicationCommandInteractionDataOption {
+		return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionInteger, Value: float64(v)}
+	}
+
+	tests := []struct {
+		name string
+		i    *discordgo.InteractionCreate
+		want int
+	}{
+		{"create default", interaction("create"), 1},
+		{"create large", interaction("create", integer("width", 2048), integer("height", 1536)), 3},
+		{"create batch", interaction("create", integer("count", 4)), 4},
+		{"create large batch", interaction("create", integer("width", 2048), integer("count", 2)), 4},
+		{"edit", interaction("edit"), 1},
+		{"upscale 2x", interaction("upscale", integer("scale", 2)), 1},
+		{"upscale 4x", interaction("upscale", integer("scale", 4)), 2},
+	}
+	for _, tt := range tests {
+		if got := c.Cost(tt.i); got != tt.want {
+			t.Errorf("%s: expected cost %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
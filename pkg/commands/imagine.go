@@ -2,20 +2,51 @@ package commands
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"image"
+	"image/png"
 	"log/slog"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
 	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/jobs"
+	"github.com/josh/discord-bot/internal/llm"
+	"github.com/josh/discord-bot/internal/moderation"
+	"github.com/josh/discord-bot/internal/safety"
 )
 
+// maxBatchCount is the most images one /imagine makes, which is also how
+// many U and V buttons fit in a row.
+const maxBatchCount = 4
+
+// gridFilename is the contact sheet posted before the images of a batch.
+// imageAt skips it, so button indexes count only the images themselves.
+const gridFilename = "grid.png"
+
+// gridCellSize keeps grids of 1024x1024 images to a 2x2 sheet about
+// 1024px across.
+const gridCellSize = 512
+
 type ImagineCommand struct {
-	client *imagegen.Client
+	client    imagegen.ImageBackend
+	llm       llm.Completer
+	cfg       *config.Manager
+	jobs      *jobs.Manager
+	moderator *moderation.Filter
+	safety    *safety.Gate
 }
 
-func NewImagineCommand(imageGenURL string) *ImagineCommand {
+func NewImagineCommand(client imagegen.ImageBackend, llmClient llm.Completer, cfg *config.Manager, jobManager *jobs.Manager, moderator *moderation.Filter, gate *safety.Gate) *ImagineCommand {
 	return &ImagineCommand{
-		client: imagegen.NewClient(imageGenURL),
+		client:    client,
+		llm:       llmClient,
+		cfg:       cfg,
+		jobs:      jobManager,
+		moderator: moderator,
+		safety:    gate,
 	}
 }
 
@@ -24,44 +55,149 @@ func (c *ImagineCommand) Name() string {
 }
 
 func (c *ImagineCommand) Description() string {
-	return "Generate an image from a text prompt using AI"
+	return "Generate, edit or upscale images with AI"
 }
 
 func (c *ImagineCommand) Data() *discordgo.ApplicationCommand {
+	minStrength, maxStrength := 0.05, 1.0
+	minCount := 1.0
+	sourceOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+			Name:        "image",
+			Description: "Image to use (default: the last one generated in this channel)",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "from",
+			Description: "Link to a message with the image to use, instead of attaching it",
+			Required:    false,
+		},
+	}
+
 	return &discordgo.ApplicationCommand{
 		Name:        c.Name(),
 		Description: c.Description(),
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "prompt",
-				Description: "Describe the image you want to generate",
-				Required:    true,
-			},
-			{
-				Type:        discordgo.ApplicationCommandOptionInteger,
-				Name:        "width",
-				Description: "Image width (default: 1024, max: 2048)",
-				Required:    false,
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "create",
+				Description: "Generate an image from a text prompt",
+				Options: append([]*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "prompt",
+						Description: "Describe the image you want to generate",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "width",
+						Description: "Image width (default: 1024, max: 2048)",
+						Required:    false,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "height",
+						Description: "Image height (default: 1024, max: 2048)",
+						Required:    false,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "steps",
+						Description: "Number of generation steps (default: 30, max: 50)",
+						Required:    false,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "count",
+						Description: "How many images to make, shown in a grid to pick from (default: 1)",
+						Required:    false,
+						MinValue:    &minCount,
+						MaxValue:    maxBatchCount,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "enhance",
+						Description: "Let the AI expand your prompt into a detailed one first",
+						Required:    false,
+					},
+				}, styleOptions()...),
 			},
 			{
-				Type:        discordgo.ApplicationCommandOptionInteger,
-				Name:        "height",
-				Description: "Image height (default: 1024, max: 2048)",
-				Required:    false,
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "edit",
+				Description: "Redraw an image from a prompt, or just the white part of a mask",
+				Options: append([]*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "prompt",
+						Description: "What the result should look like",
+						Required:    true,
+					},
+				}, append(append(sourceOptions,
+					&discordgo.ApplicationCommandOption{
+						Type:        discordgo.ApplicationCommandOptionAttachment,
+						Name:        "mask",
+						Description: "Black and white image the same size; only the white area is redrawn",
+						Required:    false,
+					},
+					&discordgo.ApplicationCommandOption{
+						Type:        discordgo.ApplicationCommandOptionNumber,
+						Name:        "strength",
+						Description: "How much to change, from 0.05 (barely) to 1 (completely) (default: 0.6)",
+						Required:    false,
+						MinValue:    &minStrength,
+						MaxValue:    maxStrength,
+					},
+					&discordgo.ApplicationCommandOption{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "steps",
+						Description: "Number of generation steps (default: 30, max: 50)",
+						Required:    false,
+					},
+				), styleOptions()...)...),
 			},
 			{
-				Type:        discordgo.ApplicationCommandOptionInteger,
-				Name:        "steps",
-				Description: "Number of generation steps (default: 30, max: 50)",
-				Required:    false,
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "upscale",
+				Description: "Enlarge an image with an AI upscaler",
+				Options: append(sourceOptions, &discordgo.ApplicationCommandOption{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "scale",
+					Description: "How much bigger (default: 2x)",
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "2x", Value: 2},
+						{Name: "4x", Value: 4},
+					},
+				}),
 			},
 		},
 	}
 }
 
-func (c *ImagineCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
-	options := i.ApplicationCommandData().Options
+func (c *ImagineCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "create":
+		return c.create(ctx, s, i, sub.Options)
+	case "edit":
+		return c.edit(ctx, s, i, data, sub.Options)
+	case "upscale":
+		return c.upscale(ctx, s, i, data, sub.Options)
+	default:
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+}
+
+func (c *ImagineCommand) create(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) error {
 	prompt := options[0].StringValue()
 
 	username := "Unknown"
@@ -78,20 +214,16 @@ func (c *ImagineCommand) Execute(s *discordgo.Session, i *discordgo.InteractionC
 		"prompt", prompt,
 	)
 
-	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: "🎨 Generating your image... This may take 30-60 seconds.",
-		},
-	}); err != nil {
-		return err
-	}
-
 	req := &imagegen.GenerationRequest{
 		Prompt: prompt,
 	}
 
+	var style imagineStyle
+	enhance := false
 	for _, opt := range options[1:] {
+		if style.set(opt) {
+			continue
+		}
 		switch opt.Name {
 		case "width":
 			req.Width = int(opt.IntValue())
@@ -99,14 +231,71 @@ func (c *ImagineCommand) Execute(s *discordgo.Session, i *discordgo.InteractionC
 			req.Height = int(opt.IntValue())
 		case "steps":
 			req.Steps = int(opt.IntValue())
+		case "count":
+			req.BatchSize = int(opt.IntValue())
+		case "enhance":
+			enhance = opt.BoolValue()
 		}
 	}
 
-	resp, err := c.client.GenerateImage(req)
+	limits := c.cfg.ForGuild(i.GuildID).Imagine
+	if msg := applyStyle(req, style, limits); msg != "" {
+		return rejectRequest(ctx, s, i, msg)
+	}
+	if msg := checkImagineLimits(req, limits); msg != "" {
+		return rejectRequest(ctx, s, i, msg)
+	}
+	if _, refused := c.safety.CheckPrompt(s, interactionSubject(i, c.Name()), req.Prompt); len(refused) > 0 {
+		return rejectRequest(ctx, s, i, refusedPromptMessage(refused))
+	}
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "🎨 Generating your image... This may take 30-60 seconds.",
+		},
+	}); err != nil {
+		return err
+	}
+
+	return submitJob(ctx, s, i, c.jobs, c.Name(), truncate(prompt, 80), func(ctx context.Context) error {
+		if err := c.resolveStyle(ctx, req, style); err != nil {
+			return editError(s, i, err)
+		}
+		original := ""
+		if enhance {
+			enhanced, err := c.enhance(ctx, s, i, req.Prompt)
+			if err != nil {
+				return err
+			}
+			if enhanced != req.Prompt {
+				original, req.Prompt = req.Prompt, enhanced
+			}
+		}
+		return c.generate(ctx, s, i, req, username, original)
+	})
+}
+
+// generate makes the images for req and posts them. original is the
+// prompt the user wrote if req's was enhanced, or "".
+func (c *ImagineCommand) generate(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, req *imagegen.GenerationRequest, username, original string) error {
+	// Checked here too for prompts from buttons and the enhancer.
+	prompt, ok, err := c.screenImagePrompt(s, i, req.Prompt)
+	if !ok {
+		return err
+	}
+	req.Prompt = prompt
+
+	jobs.SetStage(ctx, "Generating image")
+	resp, err := c.client.GenerateImage(withSDProgress(ctx, s, i, "Generating image", c.previews(s, i)), req)
 	if err != nil {
 		slog.Error("Failed to generate image", "error", err)
+		if ctx.Err() != nil {
+			return err
+		}
 		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: strPtr(fmt.Sprintf("❌ Failed to generate image: %v", err)),
+			Content:     strPtr(fmt.Sprintf("❌ Failed to generate image: %v", err)),
+			Attachments: &[]*discordgo.MessageAttachment{},
 		})
 		if editErr != nil {
 			return editErr
@@ -114,6 +303,63 @@ func (c *ImagineCommand) Execute(s *discordgo.Session, i *discordgo.InteractionC
 		return err
 	}
 
+	replays := make([]imagegen.GenerationRequest, len(resp.Images))
+	for n := range replays {
+		replays[n] = resp.Replay(n)
+		replays[n].Checkpoint = req.Checkpoint
+	}
+	seed := replays[0].Seed
+
+	content := fmt.Sprintf("✨ **Generated Image**\n**Prompt:** %s\n**Size:** %dx%d | **Steps:** %d | **Seed:** %d",
+		resp.Parameters.Prompt,
+		resp.Parameters.Width,
+		resp.Parameters.Height,
+		resp.Parameters.Steps,
+		seed,
+	)
+	if len(resp.Images) > 1 && resp.Parameters.SubseedStrength == 0 {
+		content = fmt.Sprintf("✨ **Generated %d Images**\n**Prompt:** %s\n**Size:** %dx%d | **Steps:** %d | **Seeds:** %d–%d",
+			len(resp.Images),
+			resp.Parameters.Prompt,
+			resp.Parameters.Width,
+			resp.Parameters.Height,
+			resp.Parameters.Steps,
+			seed,
+			replays[len(replays)-1].Seed,
+		)
+	} else if len(resp.Images) > 1 {
+		content = fmt.Sprintf("🔀 **Variations**\n**Prompt:** %s\n**Size:** %dx%d | **Steps:** %d | **Seed:** %d | **Variation strength:** %.2f",
+			resp.Parameters.Prompt,
+			resp.Parameters.Width,
+			resp.Parameters.Height,
+			resp.Parameters.Steps,
+			seed,
+			resp.Parameters.SubseedStrength,
+		)
+	}
+	if original != "" {
+		content += "\n**Enhanced from:** " + truncate(original, maxOriginalShown)
+	}
+	content += styleLine(req)
+	generation := saveGeneration(i, replays, original)
+	if err := c.sendResult(ctx, s, i, resp, replays, content, resultComponents(generation, len(resp.Images), original != "")); err != nil {
+		return err
+	}
+
+	slog.Info("Image sent successfully",
+		"user", username,
+		"seed", seed,
+	)
+
+	return nil
+}
+
+// sendResult posts the images in resp with content as their caption and
+// components under them, and records them in the gallery with the
+// requests that reproduce them. Several images are posted after a
+// numbered grid of them all. Images the safety gate flags are posted as
+// spoilers, or withheld.
+func (c *ImagineCommand) sendResult(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, resp *imagegen.GenerationResponse, replays []imagegen.GenerationRequest, content string, components []discordgo.MessageComponent) error {
 	if len(resp.Images) == 0 {
 		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
 			Content: strPtr("❌ No image was generated"),
@@ -121,48 +367,358 @@ func (c *ImagineCommand) Execute(s *discordgo.Session, i *discordgo.InteractionC
 		return err
 	}
 
-	imageData, err := c.client.DecodeImage(resp.Images[0])
-	if err != nil {
-		slog.Error("Failed to decode image", "error", err)
-		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: strPtr(fmt.Sprintf("❌ Failed to decode image: %v", err)),
+	images := make([][]byte, len(resp.Images))
+	for n, encoded := range resp.Images {
+		imageData, err := imagegen.DecodeImage(encoded)
+		if err != nil {
+			slog.Error("Failed to decode image", "error", err)
+			_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+				Content: strPtr(fmt.Sprintf("❌ Failed to decode image: %v", err)),
+			})
+			if editErr != nil {
+				return editErr
+			}
+			return err
+		}
+		images[n] = imageData
+	}
+
+	decisions := c.checkImages(ctx, s, i, images)
+	files := make([]*discordgo.File, len(images))
+	decoded := make([]image.Image, 0, len(images))
+	var withheld []string
+	spoiler := false
+	for n, imageData := range images {
+		name := "generated_image.png"
+		if len(resp.Images) > 1 {
+			name = fmt.Sprintf("generated_image_%d.png", n+1)
+		}
+		switch decisions[n].Action {
+		case safety.ActionBlock:
+			imageData = withheldImage(imageData)
+			withheld = append(withheld, fmt.Sprint(n+1))
+		case safety.ActionSpoiler:
+			name = spoilerPrefix + name
+			spoiler = true
+		}
+		files[n] = &discordgo.File{
+			Name:        name,
+			ContentType: "image/png",
+			Reader:      bytes.NewReader(imageData),
+		}
+		if len(resp.Images) > 1 {
+			if img, _, err := image.Decode(bytes.NewReader(imageData)); err == nil {
+				decoded = append(decoded, img)
+			}
+		}
+	}
+
+	if len(withheld) == len(images) {
+		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:     strPtr(imageWithheldMessage),
+			Components:  &[]discordgo.MessageComponent{},
+			Attachments: &[]*discordgo.MessageAttachment{},
 		})
-		if editErr != nil {
-			return editErr
+		return err
+	}
+	switch {
+	case len(withheld) == 1:
+		content += fmt.Sprintf("\n🚫 Image %s was withheld by this server's content filter.", withheld[0])
+	case len(withheld) > 1:
+		content += fmt.Sprintf("\n🚫 Images %s were withheld by this server's content filter.", strings.Join(withheld, ", "))
+	}
+
+	if len(decoded) > 1 && len(decoded) == len(files) {
+		var grid bytes.Buffer
+		if err := png.Encode(&grid, imagegen.Grid(decoded, gridCellSize)); err != nil {
+			slog.Warn("Failed to encode image grid", "error", err)
+		} else {
+			name := gridFilename
+			if spoiler {
+				name = spoilerPrefix + name
+			}
+			files = append([]*discordgo.File{{Name: name, ContentType: "image/png", Reader: &grid}}, files...)
 		}
+	}
+
+	msg, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Files:      files,
+		Components: &components,
+		// Replaces the live preview.
+		Attachments: &[]*discordgo.MessageAttachment{},
+	})
+	if err != nil {
+		slog.Error("Failed to send image", "error", err)
 		return err
 	}
+	c.recordResult(i, msg, replays, images, decisions)
+	return nil
+}
 
-	file := &discordgo.File{
-		Name:        "generated_image.png",
-		ContentType: "image/png",
-		Reader:      bytes.NewReader(imageData),
+func (c *ImagineCommand) edit(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData, options []*discordgo.ApplicationCommandInteractionDataOption) error {
+	var prompt, from string
+	var image, mask *discordgo.MessageAttachment
+	req := &imagegen.Img2ImgRequest{}
+	var style imagineStyle
+	for _, opt := range options {
+		if style.set(opt) {
+			continue
+		}
+		switch opt.Name {
+		case "prompt":
+			prompt = opt.StringValue()
+		case "from":
+			from = opt.StringValue()
+		case "image":
+			image = resolvedAttachment(data, opt)
+		case "mask":
+			mask = resolvedAttachment(data, opt)
+		case "strength":
+			req.DenoisingStrength = opt.FloatValue()
+		case "steps":
+			req.Steps = int(opt.IntValue())
+		}
 	}
+	req.Prompt = prompt
 
-	content := fmt.Sprintf("✨ **Generated Image**\n**Prompt:** %s\n**Size:** %dx%d | **Steps:** %d | **Seed:** %d",
-		resp.Parameters.Prompt,
-		resp.Parameters.Width,
-		resp.Parameters.Height,
-		resp.Parameters.Steps,
-		resp.Parameters.Seed,
-	)
+	limits := c.cfg.ForGuild(i.GuildID).Imagine
+	if msg := applyStyle(&req.GenerationRequest, style, limits); msg != "" {
+		return rejectRequest(ctx, s, i, msg)
+	}
+	if msg := checkImagineLimits(&req.GenerationRequest, limits); msg != "" {
+		return rejectRequest(ctx, s, i, msg)
+	}
+	if mask != nil && !isImageAttachment(mask) {
+		return rejectRequest(ctx, s, i, fmt.Sprintf("❌ %s isn't a PNG, JPEG, GIF, WebP or BMP image.", mask.Filename))
+	}
+	if _, refused := c.safety.CheckPrompt(s, interactionSubject(i, c.Name()), req.Prompt); len(refused) > 0 {
+		return rejectRequest(ctx, s, i, refusedPromptMessage(refused))
+	}
 
-	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &content,
-		Files:   []*discordgo.File{file},
+	slog.Info("Imagine edit received", "user_id", interactionUserID(i), "guild_id", i.GuildID, "prompt", prompt, "inpaint", mask != nil)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		return err
+	}
+
+	return submitJob(ctx, s, i, c.jobs, c.Name(), "edit: "+truncate(prompt, 74), func(ctx context.Context) error {
+		if err := c.resolveStyle(ctx, &req.GenerationRequest, style); err != nil {
+			return editError(s, i, err)
+		}
+		return c.editImage(ctx, s, i, req, image, mask, from, limits)
 	})
+}
 
+// editImage runs img2img on the source image picked by image and from,
+// redrawing only the white part of mask if there is one.
+func (c *ImagineCommand) editImage(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, req *imagegen.Img2ImgRequest, image, mask *discordgo.MessageAttachment, from string, limits config.ImagineConfig) error {
+	source, err := c.sourceImage(ctx, s, i, image, from)
 	if err != nil {
-		slog.Error("Failed to send image", "error", err)
+		return editError(s, i, err)
+	}
+	req.InitImages = []string{imagegen.EncodeImage(source.data)}
+	req.Width, req.Height = editSize(source.width, source.height, limits)
+	if mask != nil {
+		maskData, _, err := fetchImage(ctx, mask)
+		if err != nil {
+			return editError(s, i, err)
+		}
+		req.Mask = imagegen.EncodeImage(maskData)
+	}
+
+	prompt, ok, err := c.screenImagePrompt(s, i, req.Prompt)
+	if !ok {
 		return err
 	}
+	req.Prompt = prompt
 
-	slog.Info("Image sent successfully",
-		"user", username,
-		"seed", resp.Parameters.Seed,
-	)
+	jobs.SetStage(ctx, "Editing image")
+	resp, err := c.client.ImageToImage(withSDProgress(ctx, s, i, "Editing image", c.previews(s, i)), req)
+	if err != nil {
+		slog.Error("Failed to edit image", "error", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return editError(s, i, fmt.Errorf("failed to edit image: %w", err))
+	}
 
-	return nil
+	what := "Edited"
+	if mask != nil {
+		what = "Inpainted"
+	}
+	content := fmt.Sprintf("✨ **%s Image**\n**Prompt:** %s\n**Size:** %dx%d | **Strength:** %.2f | **Steps:** %d | **Seed:** %d",
+		what,
+		req.Prompt,
+		req.Width,
+		req.Height,
+		req.DenoisingStrength,
+		req.Steps,
+		resp.Replay(0).Seed,
+	) + styleLine(&req.GenerationRequest)
+	replays := make([]imagegen.GenerationRequest, len(resp.Images))
+	for n := range replays {
+		replays[n] = resp.Replay(n)
+		replays[n].Checkpoint = req.Checkpoint
+	}
+	return c.sendResult(ctx, s, i, resp, replays, content, resultComponents(0, len(resp.Images), false))
+}
+
+func (c *ImagineCommand) upscale(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData, options []*discordgo.ApplicationCommandInteractionDataOption) error {
+	var from string
+	var image *discordgo.MessageAttachment
+	scale := 2
+	for _, opt := range options {
+		switch opt.Name {
+		case "from":
+			from = opt.StringValue()
+		case "image":
+			image = resolvedAttachment(data, opt)
+		case "scale":
+			scale = int(opt.IntValue())
+		}
+	}
+
+	slog.Info("Imagine upscale received", "user_id", interactionUserID(i), "guild_id", i.GuildID, "scale", scale)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		return err
+	}
+
+	return submitJob(ctx, s, i, c.jobs, c.Name(), fmt.Sprintf("upscale %dx", scale), func(ctx context.Context) error {
+		return c.upscaleImage(ctx, s, i, image, from, scale)
+	})
+}
+
+// upscaleImage enlarges the source image picked by image and from.
+func (c *ImagineCommand) upscaleImage(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, image *discordgo.MessageAttachment, from string, scale int) error {
+	source, err := c.sourceImage(ctx, s, i, image, from)
+	if err != nil {
+		return editError(s, i, err)
+	}
+	width, height := source.width*scale, source.height*scale
+	if width > maxUpscaleSide || height > maxUpscaleSide {
+		return editError(s, i, fmt.Errorf("%dx%d at %dx would be over %dpx on a side", source.width, source.height, scale, maxUpscaleSide))
+	}
+
+	jobs.SetStage(ctx, "Upscaling image")
+	resp, err := c.client.Upscale(withSDProgress(ctx, s, i, "Upscaling image", c.previews(s, i)), &imagegen.UpscaleRequest{
+		Image:           imagegen.EncodeImage(source.data),
+		UpscalingResize: float64(scale),
+	})
+	if err != nil {
+		slog.Error("Failed to upscale image", "error", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return editError(s, i, fmt.Errorf("failed to upscale image: %w", err))
+	}
+
+	imageData, err := imagegen.DecodeImage(resp.Image)
+	if err != nil {
+		return editError(s, i, err)
+	}
+	decision := c.checkImages(ctx, s, i, [][]byte{imageData})[0]
+	if decision.Action == safety.ActionBlock {
+		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:     strPtr(imageWithheldMessage),
+			Attachments: &[]*discordgo.MessageAttachment{},
+		})
+		return err
+	}
+	file, err := uploadableImage(imageData, "upscaled_image")
+	if err != nil {
+		return editError(s, i, err)
+	}
+	if decision.Action == safety.ActionSpoiler {
+		file.Name = spoilerPrefix + file.Name
+	}
+
+	content := fmt.Sprintf("🔍 **Upscaled %dx** to %dx%d", scale, width, height)
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:     &content,
+		Files:       []*discordgo.File{file},
+		Attachments: &[]*discordgo.MessageAttachment{},
+	})
+	return err
+}
+
+// Cost counts each started megapixel of a new image as one use, so a
+// 2048x2048 image costs four times as much as the default 1024x1024, as
+// do four 1024x1024 images. An edit counts as one and a 4x upscale as two.
+func (c *ImagineCommand) Cost(i *discordgo.InteractionCreate) int {
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		return buttonCost(i.MessageComponentData().CustomID)
+	case discordgo.InteractionModalSubmit:
+		if a, err := parseImagineAction(i.ModalSubmitData().CustomID); err == nil && a.action == actionRemix {
+			return remixCost(i)
+		}
+		return 1
+	}
+
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return 1
+	}
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "create":
+		width, height := 1024, 1024
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "width":
+				width = int(opt.IntValue())
+			case "height":
+				height = int(opt.IntValue())
+			}
+		}
+		count := 1
+		for _, opt := range sub.Options {
+			if opt.Name == "count" {
+				count = int(opt.IntValue())
+			}
+		}
+		return max(1, count) * imageCost(width, height)
+	case "upscale":
+		for _, opt := range sub.Options {
+			if opt.Name == "scale" {
+				return max(1, int(opt.IntValue())/2)
+			}
+		}
+	}
+	return 1
+}
+
+func imageCost(width, height int) int {
+	const megapixel = 1024 * 1024
+	return max(1, (width*height+megapixel-1)/megapixel)
+}
+
+// checkImagineLimits returns a user-facing message if req exceeds the
+// guild's configured limits, or "" if it is allowed.
+func checkImagineLimits(req *imagegen.GenerationRequest, limits config.ImagineConfig) string {
+	if req.Width > limits.MaxWidth {
+		return fmt.Sprintf("❌ Width %d exceeds this server's limit of %d", req.Width, limits.MaxWidth)
+	}
+	if req.Height > limits.MaxHeight {
+		return fmt.Sprintf("❌ Height %d exceeds this server's limit of %d", req.Height, limits.MaxHeight)
+	}
+	if req.Steps > limits.MaxSteps {
+		return fmt.Sprintf("❌ Steps %d exceeds this server's limit of %d", req.Steps, limits.MaxSteps)
+	}
+	if req.Width < 0 || req.Height < 0 || req.Steps < 0 {
+		return "❌ Width, height and steps must be positive"
+	}
+	if req.BatchSize < 0 || req.BatchSize > maxBatchCount {
+		return fmt.Sprintf("❌ Count must be from 1 to %d", maxBatchCount)
+	}
+	return ""
 }
 
 func strPtr(s string) *string {
@@ -0,0 +1,450 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/imagegen"
+)
+
+// Buttons under /imagine results.
+const (
+	actionReroll  = "reroll"
+	actionVary    = "vary"
+	actionUpscale = "upscale"
+	actionInit    = "init"
+
+	// actionOriginal regenerates an enhanced prompt's result from the
+	// prompt the user wrote, with the same seeds.
+	actionOriginal = "original"
+)
+
+// variationCount and variationStrength shape the Variations button: four
+// images from the same seed, each with a little of another seed mixed in.
+const (
+	variationCount    = maxBatchCount
+	variationStrength = 0.15
+)
+
+var errGenerationGone = errors.New("that image's settings are no longer available")
+
+// imagineAction is what a button under a result does. generation is the
+// stored generation the result came from, or 0 for edits, which can't be
+// replayed; index is which of the message's images it acts on.
+type imagineAction struct {
+	action     string
+	generation int64
+	index      int
+}
+
+func (a imagineAction) customID() string {
+	return fmt.Sprintf("imagine:%s:%d:%d", a.action, a.generation, a.index)
+}
+
+func parseImagineAction(customID string) (imagineAction, error) {
+	parts := strings.Split(customID, ":")
+	if len(parts) != 4 || parts[0] != "imagine" {
+		return imagineAction{}, fmt.Errorf("unknown component %q", customID)
+	}
+	generation, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return imagineAction{}, fmt.Errorf("unknown component %q", customID)
+	}
+	index, err := strconv.Atoi(parts[3])
+	if err != nil || index < 0 {
+		return imagineAction{}, fmt.Errorf("unknown component %q", customID)
+	}
+	return imagineAction{action: parts[1], generation: generation, index: index}, nil
+}
+
+// resultComponents lays out the buttons under a result with count images.
+// Reroll and variations need the stored generation, so results without
+// one only get upscale and init buttons. Results of an enhanced prompt
+// also get a button to try the original.
+func resultComponents(generation int64, count int, enhanced bool) []discordgo.MessageComponent {
+	button := func(label, emoji string, a imagineAction) discordgo.MessageComponent {
+		b := discordgo.Button{Label: label, Style: discordgo.SecondaryButton, CustomID: a.customID()}
+		if emoji != "" {
+			b.Emoji = &discordgo.ComponentEmoji{Name: emoji}
+		}
+		return b
+	}
+	reroll := []discordgo.MessageComponent{button("Reroll", "🎲", imagineAction{actionReroll, generation, 0})}
+	if enhanced && generation > 0 {
+		reroll = append(reroll, button("Original prompt", "📝", imagineAction{actionOriginal, generation, 0}))
+	}
+
+	if count == 1 {
+		var row []discordgo.MessageComponent
+		if generation > 0 {
+			row = append(reroll, button("Variations", "🔀", imagineAction{actionVary, generation, 0}))
+		}
+		row = append(row,
+			button("Upscale 2x", "🔍", imagineAction{actionUpscale, generation, 0}),
+			button("Use as init image", "🖼️", imagineAction{actionInit, generation, 0}),
+		)
+		return []discordgo.MessageComponent{discordgo.ActionsRow{Components: row}}
+	}
+
+	var upscale, vary []discordgo.MessageComponent
+	for n := range min(count, maxBatchCount) {
+		upscale = append(upscale, button(fmt.Sprintf("U%d", n+1), "", imagineAction{actionUpscale, generation, n}))
+		vary = append(vary, button(fmt.Sprintf("V%d", n+1), "", imagineAction{actionVary, generation, n}))
+	}
+	rows := []discordgo.MessageComponent{discordgo.ActionsRow{Components: upscale}}
+	if generation > 0 {
+		rows = append(rows,
+			discordgo.ActionsRow{Components: vary},
+			discordgo.ActionsRow{Components: reroll},
+		)
+	}
+	return rows
+}
+
+// storedRequest is a request as saved for the buttons. The checkpoint
+// isn't part of the web UI's request, so it's kept alongside, as is the
+// prompt the user wrote if the LLM enhanced it.
+type storedRequest struct {
+	imagegen.GenerationRequest
+	Model    string `json:"model,omitempty"`
+	Original string `json:"original,omitempty"`
+}
+
+func encodeReplays(replays []imagegen.GenerationRequest, original string) ([]byte, error) {
+	stored := make([]storedRequest, len(replays))
+	for n, r := range replays {
+		stored[n] = storedRequest{GenerationRequest: r, Model: r.Checkpoint, Original: original}
+	}
+	return json.Marshal(stored)
+}
+
+func decodeReplays(params string) ([]imagegen.GenerationRequest, string, error) {
+	var stored []storedRequest
+	if err := json.Unmarshal([]byte(params), &stored); err != nil {
+		return nil, "", err
+	}
+	if len(stored) == 0 {
+		return nil, "", errGenerationGone
+	}
+	replays := make([]imagegen.GenerationRequest, len(stored))
+	for n, r := range stored {
+		replays[n] = r.GenerationRequest
+		replays[n].Checkpoint = r.Model
+	}
+	return replays, stored[0].Original, nil
+}
+
+// saveGeneration stores the requests that reproduce a result's images for
+// its buttons, and the original prompt if it was enhanced. It returns 0 if
+// they couldn't be stored, and the result is posted without the buttons
+// that need them.
+func saveGeneration(i *discordgo.InteractionCreate, replays []imagegen.GenerationRequest, original string) int64 {
+	params, err := encodeReplays(replays, original)
+	if err != nil {
+		slog.Error("Failed to encode image parameters", "error", err)
+		return 0
+	}
+	id, err := db.SaveImageGeneration(db.ImageGeneration{
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		UserID:    interactionUserID(i),
+		Params:    string(params),
+	})
+	if err != nil {
+		slog.Error("Failed to save image parameters", "error", err)
+		return 0
+	}
+	return id
+}
+
+// loadGeneration returns the requests and original prompt stored by
+// saveGeneration. Buttons only work in the server the image was made in.
+func loadGeneration(guildID string, id int64) ([]imagegen.GenerationRequest, string, error) {
+	if id <= 0 {
+		return nil, "", errGenerationGone
+	}
+	g, err := db.GetImageGeneration(id)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load image parameters: %w", err)
+	}
+	if g == nil || g.GuildID != guildID {
+		return nil, "", errGenerationGone
+	}
+	replays, original, err := decodeReplays(g.Params)
+	if err != nil {
+		return nil, "", errGenerationGone
+	}
+	return replays, original, nil
+}
+
+// rerollRequest repeats a result with new randomness: new seeds, or new
+// variations of the same seed if it was a set of variations.
+func rerollRequest(replays []imagegen.GenerationRequest) imagegen.GenerationRequest {
+	req := replays[0]
+	if len(replays) > 1 {
+		req.BatchSize = len(replays)
+		if req.SubseedStrength > 0 {
+			req.Subseed = 0
+			return req
+		}
+	}
+	req.Seed = 0
+	req.Subseed, req.SubseedStrength = 0, 0
+	return req
+}
+
+// variationRequest makes variations of one image: its seed, with a little
+// of new random subseeds mixed in.
+func variationRequest(replay imagegen.GenerationRequest) imagegen.GenerationRequest {
+	req := replay
+	req.Subseed = 0
+	req.SubseedStrength = variationStrength
+	req.BatchSize = variationCount
+	return req
+}
+
+// buttonCost is Cost for buttons. Opening the init image and remix forms
+// is free; the forms are charged when they're submitted.
+func buttonCost(customID string) int {
+	a, err := parseImagineAction(customID)
+	if err != nil {
+		return 1
+	}
+	switch a.action {
+	case actionInit, actionRemix:
+		return 0
+	case actionReroll, actionVary, actionOriginal:
+		// Cost runs before the interaction is handled, when the guild
+		// check hasn't been done, so this only reads the size.
+		g, err := db.GetImageGeneration(a.generation)
+		if err != nil || g == nil {
+			return 1
+		}
+		replays, _, err := decodeReplays(g.Params)
+		if err != nil {
+			return 1
+		}
+		count := len(replays)
+		if a.action == actionVary {
+			count = variationCount
+		}
+		return count * imageCost(replays[0].Width, replays[0].Height)
+	}
+	return 1
+}
+
+// imageAt returns the index'th image attached to m, not counting the
+// grid.
+func imageAt(m *discordgo.Message, index int) *discordgo.MessageAttachment {
+	if m == nil {
+		return nil
+	}
+	var images []*discordgo.MessageAttachment
+	for _, a := range m.Attachments {
+		if isImageAttachment(a) && strings.TrimPrefix(a.Filename, spoilerPrefix) != gridFilename {
+			images = append(images, a)
+		}
+	}
+	if index >= len(images) {
+		return nil
+	}
+	return images[index]
+}
+
+// HandleComponent runs the buttons under /imagine results, the init
+// image form and gallery remixes.
+func (c *ImagineCommand) HandleComponent(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	customID := ""
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		customID = i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		customID = i.ModalSubmitData().CustomID
+	}
+	a, err := parseImagineAction(customID)
+	if err != nil {
+		return err
+	}
+
+	if i.Type == discordgo.InteractionModalSubmit {
+		if a.action == actionRemix {
+			return c.submitRemixForm(ctx, s, i, a)
+		}
+		return c.submitInitForm(ctx, s, i, a)
+	}
+	switch a.action {
+	case actionReroll, actionVary, actionOriginal:
+		return c.replay(ctx, s, i, a)
+	case actionUpscale:
+		image := imageAt(i.Message, a.index)
+		if image == nil {
+			return rejectRequest(ctx, s, i, "❌ That image is no longer there.")
+		}
+		slog.Info("Imagine upscale button pressed", "user_id", interactionUserID(i), "guild_id", i.GuildID)
+		if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		}); err != nil {
+			return err
+		}
+		return submitJob(ctx, s, i, c.jobs, c.Name(), "upscale 2x", func(ctx context.Context) error {
+			return c.upscaleImage(ctx, s, i, image, "", 2)
+		})
+	case actionInit:
+		return c.openInitForm(s, i, a)
+	case actionRemix:
+		return c.openRemixForm(s, i, a)
+	default:
+		return fmt.Errorf("unknown imagine action %q", a.action)
+	}
+}
+
+// originalRequest redoes an enhanced result with the original prompt and
+// the same seeds, so only the prompt differs.
+func originalRequest(replays []imagegen.GenerationRequest, original string) imagegen.GenerationRequest {
+	req := replays[0]
+	req.Prompt = original
+	if len(replays) > 1 {
+		req.BatchSize = len(replays)
+	}
+	return req
+}
+
+// replay runs a reroll, variations or original prompt button. Rerolls and
+// variations of an enhanced prompt keep the original for their own button.
+func (c *ImagineCommand) replay(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, a imagineAction) error {
+	replays, original, err := loadGeneration(i.GuildID, a.generation)
+	if errors.Is(err, errGenerationGone) {
+		return rejectRequest(ctx, s, i, "❌ "+capitalize(err.Error())+".")
+	}
+	if err != nil {
+		return err
+	}
+
+	var req imagegen.GenerationRequest
+	description := "reroll: "
+	switch a.action {
+	case actionReroll:
+		req = rerollRequest(replays)
+	case actionOriginal:
+		if original == "" {
+			return rejectRequest(ctx, s, i, "❌ "+capitalize(errGenerationGone.Error())+".")
+		}
+		req = originalRequest(replays, original)
+		original = ""
+		description = "original: "
+	default:
+		if a.index >= len(replays) {
+			return rejectRequest(ctx, s, i, "❌ "+capitalize(errGenerationGone.Error())+".")
+		}
+		req = variationRequest(replays[a.index])
+		description = "variations: "
+	}
+
+	if msg := checkImagineLimits(&req, c.cfg.ForGuild(i.GuildID).Imagine); msg != "" {
+		return rejectRequest(ctx, s, i, msg)
+	}
+
+	username := "Unknown"
+	if i.Member != nil && i.Member.User != nil {
+		username = i.Member.User.Username
+	}
+	slog.Info("Imagine button pressed", "action", a.action, "user", username, "guild_id", i.GuildID, "prompt", req.Prompt)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		return err
+	}
+	return submitJob(ctx, s, i, c.jobs, c.Name(), description+truncate(req.Prompt, 70), func(ctx context.Context) error {
+		return c.generate(ctx, s, i, &req, username, original)
+	})
+}
+
+// openInitForm asks for the prompt and strength to redraw an image with,
+// starting from its original prompt.
+func (c *ImagineCommand) openInitForm(s *discordgo.Session, i *discordgo.InteractionCreate, a imagineAction) error {
+	if imageAt(i.Message, a.index) == nil {
+		return respondEphemeral(s, i, "❌ That image is no longer there.")
+	}
+	prompt := ""
+	if replays, _, err := loadGeneration(i.GuildID, a.generation); err == nil && a.index < len(replays) {
+		prompt = replays[a.index].Prompt
+	}
+
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: a.customID(),
+			Title:    "Edit this image",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:  "prompt",
+						Label:     "What the result should look like",
+						Style:     discordgo.TextInputParagraph,
+						Value:     prompt,
+						Required:  true,
+						MaxLength: 1000,
+					},
+				}},
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:    "strength",
+						Label:       "How much to change, from 0.05 to 1",
+						Style:       discordgo.TextInputShort,
+						Placeholder: "0.6",
+						Required:    false,
+						MaxLength:   4,
+					},
+				}},
+			},
+		},
+	})
+}
+
+// submitInitForm runs img2img on the image the form was opened from.
+func (c *ImagineCommand) submitInitForm(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, a imagineAction) error {
+	image := imageAt(i.Message, a.index)
+	if image == nil {
+		return rejectRequest(ctx, s, i, "❌ That image is no longer there.")
+	}
+
+	req := &imagegen.Img2ImgRequest{}
+	for _, input := range textInputs(i.ModalSubmitData().Components) {
+		switch input.CustomID {
+		case "prompt":
+			req.Prompt = strings.TrimSpace(input.Value)
+		case "strength":
+			if v := strings.TrimSpace(input.Value); v != "" {
+				strength, err := strconv.ParseFloat(v, 64)
+				if err != nil || strength < 0.05 || strength > 1 {
+					return rejectRequest(ctx, s, i, "❌ Strength should be a number from 0.05 to 1.")
+				}
+				req.DenoisingStrength = strength
+			}
+		}
+	}
+	if req.Prompt == "" {
+		return rejectRequest(ctx, s, i, "❌ Describe what the result should look like.")
+	}
+
+	slog.Info("Imagine init image submitted", "user_id", interactionUserID(i), "guild_id", i.GuildID, "prompt", req.Prompt)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		return err
+	}
+	limits := c.cfg.ForGuild(i.GuildID).Imagine
+	applyStyle(&req.GenerationRequest, imagineStyle{}, limits)
+	return submitJob(ctx, s, i, c.jobs, c.Name(), "edit: "+truncate(req.Prompt, 74), func(ctx context.Context) error {
+		return c.editImage(ctx, s, i, req, image, nil, "", limits)
+	})
+}
@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/imagegen"
+)
+
+func TestImagineActionCustomID(t *testing.T) {
+	a := imagineAction{action: actionVary, generation: 12, index: 3}
+	if id := a.customID(); id != "imagine:vary:12:3" {
+		t.Fatalf("Unexpected custom ID %q", id)
+	}
+	got, err := parseImagineAction(a.customID())
+	if err != nil || got != a {
+		t.Errorf("Expected %+v back, got %+v (%v)", a, got, err)
+	}
+
+	for _, id := range []string{"imagine:vary:12", "ai:vary:12:3", "imagine:vary:x:3", "imagine:vary:12:-1"} {
+		if _, err := parseImagineAction(id); err == nil {
+			t.Errorf("Expected %q to be rejected", id)
+		}
+	}
+}
+
+func buttonIDs(rows []discordgo.MessageComponent) [][]string {
+	var ids [][]string
+	for _, row := range rows {
+		var line []string
+		for _, c := range row.(discordgo.ActionsRow).Components {
+			line = append(line, c.(discordgo.Button).CustomID)
+		}
+		ids = append(ids, line)
+	}
+	return ids
+}
+
+func TestResultComponents(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation int64
+		count      int
+		enhanced   bool
+		want       [][]string
+	}{
+		{"single", 7, 1, false, [][]string{{"imagine:reroll:7:0", "imagine:vary:7:0", "imagine:upscale:7:0", "imagine:init:7:0"}}},
+		{"edit", 0, 1, false, [][]string{{"imagine:upscale:0:0", "imagine:init:0:0"}}},
+		{"variations", 7, 2, false, [][]string{
+			{"imagine:upscale:7:0", "imagine:upscale:7:1"},
+			{"imagine:vary:7:0", "imagine:vary:7:1"},
+			{"imagine:reroll:7:0"},
+		}},
+		{"unsaved variations", 0, 2, false, [][]string{{"imagine:upscale:0:0", "imagine:upscale:0:1"}}},
+		{"enhanced", 7, 1, true, [][]string{{"imagine:reroll:7:0", "imagine:original:7:0", "imagine:vary:7:0", "imagine:upscale:7:0", "imagine:init:7:0"}}},
+		{"enhanced batch", 7, 2, true, [][]string{
+			{"imagine:upscale:7:0", "imagine:upscale:7:1"},
+			{"imagine:vary:7:0", "imagine:vary:7:1"},
+			{"imagine:reroll:7:0", "imagine:original:7:0"},
+		}},
+	}
+	for _, tt := range tests {
+		got := buttonIDs(resultComponents(tt.generation, tt.count, tt.enhanced))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+			continue
+		}
+		for r := range got {
+			if len(got[r]) != len(tt.want[r]) {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+				break
+			}
+			for b := range got[r] {
+				if got[r][b] != tt.want[r][b] {
+					t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestRerollAndVariationRequests(t *testing.T) {
+	original := imagegen.GenerationRequest{Prompt: "a fox", Width: 768, Height: 512, Steps: 20, Seed: 1234}
+
+	reroll := rerollRequest([]imagegen.GenerationRequest{original})
+	if reroll.Seed != 0 || reroll.Prompt != "a fox" || reroll.Width != 768 || reroll.BatchSize != 0 {
+		t.Errorf("Expected the same request with a random seed, got %+v", reroll)
+	}
+
+	vary := variationRequest(original)
+	if vary.Seed != 1234 || vary.Subseed != 0 || vary.SubseedStrength != variationStrength || vary.BatchSize != variationCount {
+		t.Errorf("Expected variations of seed 1234, got %+v", vary)
+	}
+
+	batch := []imagegen.GenerationRequest{
+		{Prompt: "a fox", Seed: 1234, Subseed: 77, SubseedStrength: variationStrength},
+		{Prompt: "a fox", Seed: 1234, Subseed: 78, SubseedStrength: variationStrength},
+	}
+	again := rerollRequest(batch)
+	if again.Seed != 1234 || again.Subseed != 0 || again.SubseedStrength != variationStrength || again.BatchSize != 2 {
+		t.Errorf("Expected new variations of the same seed, got %+v", again)
+	}
+}
+
+func TestRerollBatch(t *testing.T) {
+	batch := []imagegen.GenerationRequest{
+		{Prompt: "a fox", Width: 512, Height: 512, Seed: 1234},
+		{Prompt: "a fox", Width: 512, Height: 512, Seed: 1235},
+		{Prompt: "a fox", Width: 512, Height: 512, Seed: 1236},
+	}
+	req := rerollRequest(batch)
+	if req.Seed != 0 || req.BatchSize != 3 || req.SubseedStrength != 0 {
+		t.Errorf("Expected a new batch of 3 with random seeds, got %+v", req)
+	}
+
+	// V2 varies the second image, which had the next seed.
+	if vary := variationRequest(batch[1]); vary.Seed != 1235 || vary.BatchSize != variationCount {
+		t.Errorf("Expected variations of seed 1235, got %+v", vary)
+	}
+}
+
+func TestImageAt(t *testing.T) {
+	m := &discordgo.Message{Attachments: []*discordgo.MessageAttachment{
+		{Filename: gridFilename, ContentType: "image/png"},
+		{Filename: "notes.txt", ContentType: "text/plain"},
+		{Filename: "a.png", ContentType: "image/png"},
+		{Filename: "b.png", ContentType: "image/png"},
+	}}
+	if a := imageAt(m, 1); a == nil || a.Filename != "b.png" {
+		t.Errorf("Expected b.png, got %+v", a)
+	}
+	if a := imageAt(m, 2); a != nil {
+		t.Errorf("Expected no third image, got %+v", a)
+	}
+	if a := imageAt(nil, 0); a != nil {
+		t.Errorf("Expected nothing from a missing message, got %+v", a)
+	}
+
+	spoilered := &discordgo.Message{Attachments: []*discordgo.MessageAttachment{
+		{Filename: spoilerPrefix + gridFilename, ContentType: "image/png"},
+		{Filename: spoilerPrefix + "a.png", ContentType: "image/png"},
+	}}
+	if a := imageAt(spoilered, 0); a == nil || a.Filename != spoilerPrefix+"a.png" {
+		t.Errorf("Expected the spoilered grid skipped, got %+v", a)
+	}
+}
+
+func TestButtonCost(t *testing.T) {
+	if cost := buttonCost("imagine:init:7:0"); cost != 0 {
+		t.Errorf("Expected opening the form to be free, got %d", cost)
+	}
+	if cost := buttonCost("imagine:upscale:7:0"); cost != 1 {
+		t.Errorf("Expected an upscale to cost 1, got %d", cost)
+	}
+}
+
+func TestTextInputs(t *testing.T) {
+	components := []discordgo.MessageComponent{
+		&discordgo.ActionsRow{Components: []discordgo.MessageComponent{&discordgo.TextInput{CustomID: "prompt", Value: "a cat"}}},
+		&discordgo.ActionsRow{Components: []discordgo.MessageComponent{&discordgo.TextInput{CustomID: "strength", Value: "0.4"}}},
+	}
+	inputs := textInputs(components)
+	if len(inputs) != 2 || inputs[0].Value != "a cat" || inputs[1].CustomID != "strength" {
+		t.Errorf("Unexpected inputs: %+v", inputs)
+	}
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/jobs"
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+const enhancePromptTemplate = `Rewrite this idea as one detailed prompt for Stable Diffusion.
+
+Requirements:
+- 30-60 words, as comma-separated phrases
+- Keep the subject and everything the idea asks for; don't change its meaning
+- Add concrete visual details: setting, lighting, colours, composition, camera or medium
+- If the idea already names a style, keep it; otherwise add one (e.g. "digital art", "photorealistic", "oil painting")
+- End with quality tags such as "highly detailed, sharp focus"
+
+IMPORTANT: Reply with ONLY the prompt, with no quotes, labels or explanation.
+
+Idea: %s`
+
+// maxOriginalShown keeps a long original prompt from pushing the caption
+// past Discord's 2000 characters.
+const maxOriginalShown = 300
+
+// enhanceOptions leaves room for a 60 word prompt and a little chatter.
+var enhanceOptions = llm.Options{Temperature: llm.Temperature(0.7), MaxTokens: 160}
+
+// enhancePrompt asks the LLM to expand a short prompt into a detailed,
+// style-tagged one.
+func enhancePrompt(ctx context.Context, model llm.Completer, prompt string) (string, error) {
+	release, err := jobs.Acquire(ctx, jobs.BackendLLM, "Enhancing prompt")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	// The prompt is read by Stable Diffusion, so the persona stays out.
+	completion, err := model.Complete(llm.WithoutPersona(ctx), []llm.ChatMessage{
+		{Role: "user", Content: fmt.Sprintf(enhancePromptTemplate, prompt)},
+	}, enhanceOptions)
+	if err != nil {
+		return "", fmt.Errorf("failed to enhance prompt: %w", err)
+	}
+	enhanced := cleanEnhancedPrompt(completion.Content)
+	if enhanced == "" {
+		return "", errors.New("failed to enhance prompt: the model returned nothing")
+	}
+	return enhanced, nil
+}
+
+// enhance returns prompt enhanced by the LLM. If that fails, or the
+// moderation filter or image safety gate blocks the result, it returns
+// prompt as it was.
+func (c *ImagineCommand) enhance(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, prompt string) (string, error) {
+	enhanced, err := enhancePrompt(ctx, c.llm, prompt)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		slog.Warn("Failed to enhance prompt, using it as written", "error", err)
+		return prompt, nil
+	}
+	// The enhanced prompt is posted and drawn like one the user typed, so
+	// it's screened like one.
+	if v := c.moderator.CheckPrompt(ctx, s, interactionSubject(i, c.Name()), enhanced); v.Blocked {
+		slog.Warn("Enhanced prompt blocked by moderation, using it as written", "reasons", v.Reasons)
+		return prompt, nil
+	}
+	if _, refused := c.safety.CheckPrompt(s, interactionSubject(i, c.Name()), enhanced); len(refused) > 0 {
+		slog.Warn("Enhanced prompt refused by image safety, using it as written", "terms", refused)
+		return prompt, nil
+	}
+	slog.Info("Enhanced prompt", "from", prompt, "to", enhanced)
+	return enhanced, nil
+}
+
+// cleanEnhancedPrompt strips what models add around the prompt despite
+// being told not to: a "Prompt:" label, quotes, code fences and a note
+// after it.
+func cleanEnhancedPrompt(s string) string {
+	s = strings.ReplaceAll(s, "```", "")
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if label, rest, ok := strings.Cut(line, ":"); ok && len(label) < 40 && strings.Contains(strings.ToLower(label), "prompt") {
+			line = strings.TrimSpace(rest)
+			if line == "" {
+				continue
+			}
+		}
+		line = strings.Trim(line, "\"'`*")
+		return strings.TrimSpace(line)
+	}
+	return ""
+}
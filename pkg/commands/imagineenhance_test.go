@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+// fakeCompleter answers every request with reply, or fails with err.
+type fakeCompleter struct {
+	reply    string
+	err      error
+	messages []llm.ChatMessage
+}
+
+func (f *fakeCompleter) Complete(ctx context.Context, messages []llm.ChatMessage, opts llm.Options) (*llm.Completion, error) {
+	f.messages = messages
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &llm.Completion{Content: f.reply}, nil
+}
+
+func (f *fakeCompleter) CompleteStream(ctx context.Context, messages []llm.ChatMessage, opts llm.Options, onDelta func(string) error) (*llm.Completion, error) {
+	return f.Complete(ctx, messages, opts)
+}
+
+func TestEnhancePrompt(t *testing.T) {
+	model := &fakeCompleter{reply: "Prompt: \"a fluffy orange cat on a windowsill, golden hour, digital art, highly detailed\"\n\nThis adds lighting."}
+	enhanced, err := enhancePrompt(context.Background(), model, "a cat")
+	if err != nil {
+		t.Fatalf("enhancePrompt failed: %v", err)
+	}
+	if enhanced != "a fluffy orange cat on a windowsill, golden hour, digital art, highly detailed" {
+		t.Errorf("Unexpected prompt %q", enhanced)
+	}
+	if !strings.HasSuffix(model.messages[0].Content, "Idea: a cat") {
+		t.Errorf("Expected the idea in the request, got %q", model.messages[0].Content)
+	}
+
+	if _, err := enhancePrompt(context.Background(), &fakeCompleter{reply: "  \n```\n```"}, "a cat"); err == nil {
+		t.Error("Expected an empty reply to fail")
+	}
+	if _, err := enhancePrompt(context.Background(), &fakeCompleter{err: errors.New("down")}, "a cat"); err == nil {
+		t.Error("Expected the LLM's error")
+	}
+}
+
+func TestCleanEnhancedPrompt(t *testing.T) {
+	tests := map[string]string{
+		"a cat, oil painting":                          "a cat, oil painting",
+		"```\na cat, oil painting\n```":                "a cat, oil painting",
+		"Enhanced prompt:\n'a cat, oil painting'":      "a cat, oil painting",
+		"**a cat, oil painting**\nNote: I added style": "a cat, oil painting",
+		// A colon inside the prompt itself is left alone.
+		"a cat reading a book titled: Dreams": "a cat reading a book titled: Dreams",
+	}
+	for in, want := range tests {
+		if got := cleanEnhancedPrompt(in); got != want {
+			t.Errorf("cleanEnhancedPrompt(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOriginalRequest(t *testing.T) {
+	replays := []imagegen.GenerationRequest{
+		{Prompt: "a detailed fox", Seed: 10, Checkpoint: "anime"},
+		{Prompt: "a detailed fox", Seed: 11, Checkpoint: "anime"},
+	}
+	req := originalRequest(replays, "a fox")
+	if req.Prompt != "a fox" || req.Seed != 10 || req.BatchSize != 2 || req.Checkpoint != "anime" {
+		t.Errorf("Expected the original prompt on the same seeds, got %+v", req)
+	}
+}
+
+func TestStoredOriginalRoundTrip(t *testing.T) {
+	params, err := encodeReplays([]imagegen.GenerationRequest{{Prompt: "a detailed fox", Checkpoint: "anime"}}, "a fox")
+	if err != nil {
+		t.Fatalf("encodeReplays failed: %v", err)
+	}
+	replays, original, err := decodeReplays(string(params))
+	if err != nil || original != "a fox" || replays[0].Checkpoint != "anime" {
+		t.Errorf("Expected the original and model back, got %+v %q %v", replays, original, err)
+	}
+
+	// Rows saved before prompts could be enhanced have neither.
+	replays, original, err = decodeReplays(`[{"prompt":"a fox","seed":5}]`)
+	if err != nil || original != "" || replays[0].Seed != 5 {
+		t.Errorf("Expected an old row to load, got %+v %q %v", replays, original, err)
+	}
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/jobs"
+)
+
+// previewInterval keeps live previews to one message edit every few
+// seconds, well inside Discord's rate limit.
+const previewInterval = 3 * time.Second
+
+// previewMode is how live previews, which haven't been through the
+// safety gate, may be shown.
+type previewMode int
+
+const (
+	previewsShown previewMode = iota
+	previewsSpoilered
+	previewsHidden
+)
+
+// previewsFor picks how previews are shown. Where the gate checks images,
+// the worst an age-restricted channel gets is a spoiler, so previews are
+// spoilers there; anywhere else the result might be withheld, so none are
+// shown.
+func previewsFor(classifies, nsfw bool) previewMode {
+	switch {
+	case !classifies:
+		return previewsShown
+	case nsfw:
+		return previewsSpoilered
+	default:
+		return previewsHidden
+	}
+}
+
+// withSDProgress shows where an image request is in the job's stage,
+// described as what, and posts the web UI's live previews in place of the
+// deferred response until the result replaces them.
+func withSDProgress(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, what string, previews previewMode) context.Context {
+	var lastPreview time.Time
+	return imagegen.WithProgress(ctx, func(p imagegen.Progress) {
+		jobs.SetStage(ctx, p.Describe(what))
+		// Previews only come while the request runs, from one goroutine.
+		if p.Preview == nil || previews == previewsHidden || time.Since(lastPreview) < previewInterval {
+			return
+		}
+		lastPreview = time.Now()
+
+		contentType := http.DetectContentType(p.Preview)
+		format, ok := strings.CutPrefix(contentType, "image/")
+		if !ok {
+			return
+		}
+		name := "preview." + format
+		if previews == previewsSpoilered {
+			name = spoilerPrefix + name
+		}
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Files:       []*discordgo.File{{Name: name, ContentType: contentType, Reader: bytes.NewReader(p.Preview)}},
+			Attachments: &[]*discordgo.MessageAttachment{},
+		}); err != nil {
+			slog.Warn("Failed to post image preview", "error", err)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/gallery"
+	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/safety"
+)
+
+// actionRemix opens a form preloaded with a gallery image's settings, and
+// generates from it when it's sent. Its generation is the gallery image's
+// ID rather than a stored generation's.
+const actionRemix = "remix"
+
+var errGalleryImageGone = errors.New("that image is no longer in the gallery")
+
+// recordResult adds the images of a posted result to the gallery, and
+// stores them on disk if the gallery keeps them. Withheld images are left
+// out. Failures are logged; the result is already posted.
+func (c *ImagineCommand) recordResult(i *discordgo.InteractionCreate, msg *discordgo.Message, replays []imagegen.GenerationRequest, images [][]byte, decisions []safety.Decision) {
+	cfg := c.cfg.Current().Gallery
+	store := gallery.NewStore(cfg.Dir, cfg.ThumbnailSize)
+	for n, replay := range replays {
+		if n >= len(images) || decisions[n].Action == safety.ActionBlock {
+			continue
+		}
+		params, err := encodeReplays([]imagegen.GenerationRequest{replay}, "")
+		if err != nil {
+			slog.Error("Failed to encode image parameters", "error", err)
+			continue
+		}
+		id, err := db.SaveGalleryImage(db.GalleryImage{
+			GuildID:   i.GuildID,
+			ChannelID: i.ChannelID,
+			MessageID: msg.ID,
+			UserID:    interactionUserID(i),
+			Prompt:    replay.Prompt,
+			Params:    string(params),
+			Seed:      replay.Seed,
+			Width:     replay.Width,
+			Height:    replay.Height,
+			Steps:     replay.Steps,
+			NSFW:      decisions[n].Action == safety.ActionSpoiler,
+		})
+		if err != nil {
+			slog.Error("Failed to add image to gallery", "error", err)
+			continue
+		}
+		if !cfg.StoreImages {
+			continue
+		}
+		file, thumbnail, err := store.Save(i.GuildID, id, images[n])
+		if err != nil {
+			slog.Error("Failed to store gallery image", "id", id, "error", err)
+			continue
+		}
+		if err := db.SetGalleryFiles(id, file, thumbnail); err != nil {
+			slog.Error("Failed to record gallery image files", "id", id, "error", err)
+		}
+	}
+}
+
+// loadGalleryImage returns the request that reproduces a gallery image.
+// Like the buttons, remixes only work in the server the image was made in.
+func loadGalleryImage(guildID string, id int64) (imagegen.GenerationRequest, error) {
+	g, err := db.GetGalleryImage(id)
+	if err != nil {
+		return imagegen.GenerationRequest{}, fmt.Errorf("failed to load gallery image: %w", err)
+	}
+	if g == nil || g.GuildID != guildID {
+		return imagegen.GenerationRequest{}, errGalleryImageGone
+	}
+	replays, _, err := decodeReplays(g.Params)
+	if err != nil {
+		return imagegen.GenerationRequest{}, errGalleryImageGone
+	}
+	return replays[0], nil
+}
+
+// openRemixForm shows a gallery image's prompt, seed, size and steps for
+// the user to change before generating.
+func (c *ImagineCommand) openRemixForm(s *discordgo.Session, i *discordgo.InteractionCreate, a imagineAction) error {
+	req, err := loadGalleryImage(i.GuildID, a.generation)
+	if errors.Is(err, errGalleryImageGone) {
+		return respondEphemeral(s, i, "❌ "+capitalize(err.Error())+".")
+	}
+	if err != nil {
+		return err
+	}
+
+	input := func(id, label, value string, style discordgo.TextInputStyle, required bool, maxLength int) discordgo.MessageComponent {
+		return discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.TextInput{CustomID: id, Label: label, Style: style, Value: value, Required: required, MaxLength: maxLength},
+		}}
+	}
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: a.customID(),
+			Title:    "Remix this image",
+			Components: []discordgo.MessageComponent{
+				input("prompt", "Prompt", req.Prompt, discordgo.TextInputParagraph, true, 1000),
+				input("negative_prompt", "Negative prompt", req.NegativePrompt, discordgo.TextInputParagraph, false, 1000),
+				input("seed", "Seed (empty for a random one)", strconv.FormatInt(req.Seed, 10), discordgo.TextInputShort, false, 20),
+				input("size", "Size, width x height", fmt.Sprintf("%dx%d", req.Width, req.Height), discordgo.TextInputShort, true, 9),
+				input("steps", "Steps", strconv.Itoa(req.Steps), discordgo.TextInputShort, true, 3),
+			},
+		},
+	})
+}
+
+// remixRequest applies the remix form's fields to the gallery image's
+// request. The sampler, CFG scale and model stay as they were.
+func remixRequest(req imagegen.GenerationRequest, inputs []*discordgo.TextInput) (imagegen.GenerationRequest, string) {
+	req.Subseed, req.SubseedStrength, req.BatchSize = 0, 0, 0
+	for _, input := range inputs {
+		v := strings.TrimSpace(input.Value)
+		switch input.CustomID {
+		case "prompt":
+			req.Prompt = v
+		case "negative_prompt":
+			req.NegativePrompt = v
+		case "seed":
+			req.Seed = 0
+			if v != "" {
+				seed, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || seed < 0 {
+					return req, "❌ The seed should be a whole number, or empty for a random one."
+				}
+				req.Seed = seed
+			}
+		case "size":
+			width, height, ok := parseSize(v)
+			if !ok {
+				return req, "❌ The size should look like 1024x768."
+			}
+			req.Width, req.Height = width, height
+		case "steps":
+			steps, err := strconv.Atoi(v)
+			if err != nil || steps < 1 {
+				return req, "❌ Steps should be a whole number."
+			}
+			req.Steps = steps
+		}
+	}
+	if req.Prompt == "" {
+		return req, "❌ Describe what the image should show."
+	}
+	return req, ""
+}
+
+func parseSize(v string) (int, int, bool) {
+	w, h, ok := strings.Cut(strings.ToLower(strings.ReplaceAll(v, " ", "")), "x")
+	if !ok {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return 0, 0, false
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// submitRemixForm generates from the remix form.
+func (c *ImagineCommand) submitRemixForm(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, a imagineAction) error {
+	stored, err := loadGalleryImage(i.GuildID, a.generation)
+	if errors.Is(err, errGalleryImageGone) {
+		return rejectRequest(ctx, s, i, "❌ "+capitalize(err.Error())+".")
+	}
+	if err != nil {
+		return err
+	}
+	req, msg := remixRequest(stored, textInputs(i.ModalSubmitData().Components))
+	if msg == "" {
+		msg = checkImagineLimits(&req, c.cfg.ForGuild(i.GuildID).Imagine)
+	}
+	if msg != "" {
+		return rejectRequest(ctx, s, i, msg)
+	}
+
+	username := "Unknown"
+	if i.Member != nil && i.Member.User != nil {
+		username = i.Member.User.Username
+	}
+	slog.Info("Imagine remix submitted", "user", username, "guild_id", i.GuildID, "from", a.generation, "prompt", req.Prompt)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		return err
+	}
+	return submitJob(ctx, s, i, c.jobs, c.Name(), "remix: "+truncate(req.Prompt, 72), func(ctx context.Context) error {
+		return c.generate(ctx, s, i, &req, username, "")
+	})
+}
+
+// remixCost reads the size from a remix form, which is all Cost needs.
+func remixCost(i *discordgo.InteractionCreate) int {
+	for _, input := range textInputs(i.ModalSubmitData().Components) {
+		if input.CustomID == "size" {
+			if width, height, ok := parseSize(input.Value); ok {
+				return imageCost(width, height)
+			}
+		}
+	}
+	return 1
+}
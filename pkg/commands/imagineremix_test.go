@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/imagegen"
+)
+
+func remixInputs(values map[string]string) []*discordgo.TextInput {
+	var inputs []*discordgo.TextInput
+	for id, v := range values {
+		inputs = append(inputs, &discordgo.TextInput{CustomID: id, Value: v})
+	}
+	return inputs
+}
+
+func TestRemixRequest(t *testing.T) {
+	stored := imagegen.GenerationRequest{
+		Prompt: "a fox", NegativePrompt: "blurry", Seed: 42, Width: 1024, Height: 1024, Steps: 30,
+		SamplerName: "Euler a", CfgScale: 7, Subseed: 9, SubseedStrength: 0.15, Checkpoint: "anime",
+	}
+
+	req, msg := remixRequest(stored, remixInputs(map[string]string{
+		"prompt": " a red fox ", "negative_prompt": "", "seed": "", "size": "768 x 512", "steps": "20",
+	}))
+	if msg != "" {
+		t.Fatalf("Unexpected message %q", msg)
+	}
+	if req.Prompt != "a red fox" || req.NegativePrompt != "" || req.Seed != 0 || req.Width != 768 || req.Height != 512 || req.Steps != 20 {
+		t.Errorf("Expected the form's values, got %+v", req)
+	}
+	if req.SamplerName != "Euler a" || req.CfgScale != 7 || req.Checkpoint != "anime" || req.SubseedStrength != 0 {
+		t.Errorf("Expected the rest kept and the variation dropped, got %+v", req)
+	}
+
+	for field, bad := range map[string]string{"seed": "lucky", "size": "big", "steps": "0", "prompt": " "} {
+		if _, msg := remixRequest(stored, remixInputs(map[string]string{field: bad})); msg == "" {
+			t.Errorf("Expected %s %q to be rejected", field, bad)
+		}
+	}
+}
+
+func TestRemixCost(t *testing.T) {
+	i := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type: discordgo.InteractionModalSubmit,
+		Data: discordgo.ModalSubmitInteractionData{
+			CustomID: imagineAction{action: actionRemix, generation: 5}.customID(),
+			Components: []discordgo.MessageComponent{
+				&discordgo.ActionsRow{Components: []discordgo.MessageComponent{&discordgo.TextInput{CustomID: "size", Value: "2048x2048"}}},
+			},
+		},
+	}}
+	if got := (&ImagineCommand{}).Cost(i); got != 4 {
+		t.Errorf("Expected a 2048x2048 remix to cost 4, got %d", got)
+	}
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/safety"
+)
+
+// spoilerPrefix makes Discord blur an attachment until it's clicked.
+const spoilerPrefix = "SPOILER_"
+
+const imageWithheldMessage = "🚫 The result was withheld by this server's content filter."
+
+// screenImagePrompt runs prompt through the image safety gate and returns
+// it with the server's rewrites. If it's refused, the deferred response
+// says why and ok is false.
+func (c *ImagineCommand) screenImagePrompt(s *discordgo.Session, i *discordgo.InteractionCreate, prompt string) (string, bool, error) {
+	prompt, refused := c.safety.CheckPrompt(s, interactionSubject(i, c.Name()), prompt)
+	if len(refused) == 0 {
+		return prompt, true, nil
+	}
+	_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:     strPtr(refusedPromptMessage(refused)),
+		Attachments: &[]*discordgo.MessageAttachment{},
+	})
+	return prompt, false, err
+}
+
+func refusedPromptMessage(terms []string) string {
+	quoted := make([]string, len(terms))
+	for n, term := range terms {
+		quoted[n] = "`" + term + "`"
+	}
+	return fmt.Sprintf("🚫 This server doesn't allow %s in image prompts.", strings.Join(quoted, ", "))
+}
+
+// checkImages asks the safety gate how each image may be posted in the
+// interaction's channel.
+func (c *ImagineCommand) checkImages(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, images [][]byte) []safety.Decision {
+	nsfw := false
+	if c.safety.ClassifiesImages(i.GuildID) {
+		nsfw = channelNSFW(s, i.ChannelID)
+	}
+	return c.safety.CheckImages(ctx, s, interactionSubject(i, c.Name()), nsfw, images)
+}
+
+// previews returns how live previews may be shown in the interaction's
+// channel.
+func (c *ImagineCommand) previews(s *discordgo.Session, i *discordgo.InteractionCreate) previewMode {
+	classifies := c.safety.ClassifiesImages(i.GuildID)
+	return previewsFor(classifies, classifies && channelNSFW(s, i.ChannelID))
+}
+
+// channelNSFW reports whether a channel is age-restricted. Threads follow
+// their parent, and a channel that can't be looked up counts as not.
+func channelNSFW(s *discordgo.Session, channelID string) bool {
+	var ch *discordgo.Channel
+	var err error
+	if s.State != nil {
+		ch, err = s.State.Channel(channelID)
+	}
+	if ch == nil {
+		if ch, err = s.Channel(channelID); err != nil {
+			slog.Warn("Failed to look up channel, treating it as not age-restricted", "channel_id", channelID, "error", err)
+			return false
+		}
+	}
+	if ch.IsThread() && ch.ParentID != "" {
+		return channelNSFW(s, ch.ParentID)
+	}
+	return ch.NSFW
+}
+
+// withheldImage stands in for an image the safety gate blocked from a
+// batch, so the others keep their places and buttons: a plain grey image
+// of the same size.
+func withheldImage(data []byte) []byte {
+	width, height := gridCellSize, gridCellSize
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = cfg.Width, cfg.Height
+	}
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 0x40}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		slog.Warn("Failed to encode withheld image", "error", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestWithheldImageKeepsSize(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 640, 384))); err != nil {
+		t.Fatalf("Failed to encode source: %v", err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(withheldImage(src.Bytes())))
+	if err != nil || cfg.Width != 640 || cfg.Height != 384 {
+		t.Errorf("Expected a 640x384 stand-in, got %dx%d, %v", cfg.Width, cfg.Height, err)
+	}
+
+	cfg, _, err = image.DecodeConfig(bytes.NewReader(withheldImage([]byte("not an image"))))
+	if err != nil || cfg.Width != gridCellSize {
+		t.Errorf("Expected a default-sized stand-in, got %dx%d, %v", cfg.Width, cfg.Height, err)
+	}
+}
+
+func TestRefusedPromptMessage(t *testing.T) {
+	if got := refusedPromptMessage([]string{"gore", "two words"}); !strings.Contains(got, "`gore`, `two words`") {
+		t.Errorf("Unexpected message %q", got)
+	}
+}
+
+func TestPreviewsFor(t *testing.T) {
+	if previewsFor(false, false) != previewsShown {
+		t.Error("Expected previews shown when images aren't checked")
+	}
+	if previewsFor(true, true) != previewsSpoilered {
+		t.Error("Expected previews as spoilers in an age-restricted channel")
+	}
+	if previewsFor(true, false) != previewsHidden {
+		t.Error("Expected no previews where the result might be withheld")
+	}
+}
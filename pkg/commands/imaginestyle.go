@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/imagegen"
+)
+
+// maxChoices is the most autocomplete suggestions Discord shows.
+const maxChoices = 25
+
+// autocompleteTimeout leaves time to answer within Discord's three
+// seconds when the web UI is slow to list its models.
+const autocompleteTimeout = 2 * time.Second
+
+// imagineStyle is what the user picked with the preset, negative, model
+// and sampler options.
+type imagineStyle struct {
+	preset   string
+	negative string
+	model    string
+	sampler  string
+}
+
+func (st *imagineStyle) set(opt *discordgo.ApplicationCommandInteractionDataOption) bool {
+	switch opt.Name {
+	case "preset":
+		st.preset = strings.TrimSpace(opt.StringValue())
+	case "negative":
+		st.negative = strings.TrimSpace(opt.StringValue())
+	case "model":
+		st.model = strings.TrimSpace(opt.StringValue())
+	case "sampler":
+		st.sampler = strings.TrimSpace(opt.StringValue())
+	default:
+		return false
+	}
+	return true
+}
+
+func styleOptions() []*discordgo.ApplicationCommandOption {
+	return []*discordgo.ApplicationCommandOption{
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "preset",
+			Description:  "Style preset, e.g. photo, anime or pixel-art",
+			Required:     false,
+			Autocomplete: true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "negative",
+			Description: "What to keep out of the image (default: the preset's or the server's)",
+			Required:    false,
+		},
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "model",
+			Description:  "Checkpoint to use (default: the preset's, or the one loaded)",
+			Required:     false,
+			Autocomplete: true,
+		},
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "sampler",
+			Description:  "Sampler to use (default: the preset's or the server's)",
+			Required:     false,
+			Autocomplete: true,
+		},
+	}
+}
+
+// applyStyle fills in req from the chosen preset and the server's
+// defaults. The user's options win over the preset, and the preset over
+// the defaults; steps the user didn't set come from the preset, kept
+// within the limit.
+// It returns a user-facing message if the preset doesn't exist, or "".
+func applyStyle(req *imagegen.GenerationRequest, st imagineStyle, cfg config.ImagineConfig) string {
+	if st.preset != "" {
+		preset, ok := cfg.Presets[strings.ToLower(st.preset)]
+		if !ok {
+			return fmt.Sprintf("❌ There's no preset called %q. Start typing to see the list.", st.preset)
+		}
+		if preset.Prompt != "" {
+			req.Prompt = strings.ReplaceAll(preset.Prompt, "{prompt}", req.Prompt)
+		}
+		req.NegativePrompt = preset.NegativePrompt
+		req.SamplerName = preset.Sampler
+		req.CfgScale = preset.CfgScale
+		req.Checkpoint = preset.Checkpoint
+		if req.Steps == 0 && preset.Steps > 0 {
+			req.Steps = min(preset.Steps, cfg.MaxSteps)
+		}
+	}
+
+	if st.negative != "" {
+		req.NegativePrompt = st.negative
+	}
+	if st.sampler != "" {
+		req.SamplerName = st.sampler
+	}
+	if st.model != "" {
+		req.Checkpoint = st.model
+	}
+
+	if req.NegativePrompt == "" {
+		req.NegativePrompt = cfg.NegativePrompt
+	}
+	if req.SamplerName == "" {
+		req.SamplerName = cfg.Sampler
+	}
+	return ""
+}
+
+// resolveStyle checks the model and the sampler the user typed against
+// the web UI, and swaps in the names it knows them by.
+func (c *ImagineCommand) resolveStyle(ctx context.Context, req *imagegen.GenerationRequest, st imagineStyle) error {
+	if req.Checkpoint != "" {
+		title, err := c.client.ResolveModel(ctx, req.Checkpoint)
+		if err != nil {
+			return err
+		}
+		req.Checkpoint = title
+	}
+	if st.sampler != "" {
+		name, err := c.client.ResolveSampler(ctx, st.sampler)
+		if err != nil {
+			return err
+		}
+		req.SamplerName = name
+	}
+	return nil
+}
+
+// styleLine describes the model for a result's caption, if one was picked.
+func styleLine(req *imagegen.GenerationRequest) string {
+	if req.Checkpoint == "" {
+		return ""
+	}
+	model, _, _ := strings.Cut(req.Checkpoint, " [")
+	return fmt.Sprintf("\n**Model:** %s | **Sampler:** %s", model, req.SamplerName)
+}
+
+// Autocomplete suggests presets from the config, and models and samplers
+// from the web UI.
+func (c *ImagineCommand) Autocomplete(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	if focused := focusedOption(i.ApplicationCommandData().Options); focused != nil {
+		typed := strings.ToLower(strings.TrimSpace(focused.StringValue()))
+
+		ctx, cancel := context.WithTimeout(ctx, autocompleteTimeout)
+		defer cancel()
+
+		switch focused.Name {
+		case "preset":
+			choices = presetChoices(c.cfg.ForGuild(i.GuildID).Imagine.Presets, typed)
+		case "model":
+			models, err := c.client.Models(ctx)
+			if err != nil {
+				slog.Warn("Failed to list models for autocomplete", "error", err)
+			}
+			choices = modelChoices(models, typed)
+		case "sampler":
+			samplers, err := c.client.Samplers(ctx)
+			if err != nil {
+				slog.Warn("Failed to list samplers for autocomplete", "error", err)
+			}
+			choices = samplerChoices(samplers, typed)
+		}
+	}
+
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+}
+
+func focusedOption(options []*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, opt := range options {
+		if opt.Focused {
+			return opt
+		}
+		if found := focusedOption(opt.Options); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func presetChoices(presets map[string]config.ImaginePreset, typed string) []*discordgo.ApplicationCommandOptionChoice {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, name := range names {
+		description := presets[name].Description
+		if !strings.Contains(name, typed) && !strings.Contains(strings.ToLower(description), typed) {
+			continue
+		}
+		label := name
+		if description != "" {
+			label += " — " + description
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: truncate(label, 100), Value: name})
+		if len(choices) == maxChoices {
+			break
+		}
+	}
+	return choices
+}
+
+func modelChoices(models []imagegen.Model, typed string) []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, m := range models {
+		if !strings.Contains(strings.ToLower(m.Title), typed) {
+			continue
+		}
+		// Values are limited to 100 characters; the model name is
+		// shorter and resolves to the same model.
+		value := m.Title
+		if len(value) > 100 {
+			value = m.ModelName
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: truncate(m.Title, 100), Value: value})
+		if len(choices) == maxChoices {
+			break
+		}
+	}
+	return choices
+}
+
+func samplerChoices(samplers []imagegen.Sampler, typed string) []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, sampler := range samplers {
+		if !strings.Contains(strings.ToLower(sampler.Name), typed) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: sampler.Name, Value: sampler.Name})
+		if len(choices) == maxChoices {
+			break
+		}
+	}
+	return choices
+}
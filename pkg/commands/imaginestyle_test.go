@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/imagegen"
+)
+
+func styleConfig() config.ImagineConfig {
+	return config.ImagineConfig{
+		MaxSteps:       40,
+		Sampler:        "DPM++ 2M",
+		NegativePrompt: "blurry",
+		Presets: map[string]config.ImaginePreset{
+			"pixel-art": {
+				Description:    "Retro 16-bit sprites",
+				Prompt:         "pixel art of {prompt}, 16-bit",
+				NegativePrompt: "smooth, photo",
+				Sampler:        "Euler a",
+				CfgScale:       9,
+				Steps:          60,
+				Checkpoint:     "pixel-xl",
+			},
+			"photo": {Description: "Realistic photograph", Prompt: "photo of {prompt}"},
+		},
+	}
+}
+
+func TestApplyStyle(t *testing.T) {
+	cfg := styleConfig()
+
+	req := &imagegen.GenerationRequest{Prompt: "a fox"}
+	if msg := applyStyle(req, imagineStyle{}, cfg); msg != "" {
+		t.Fatalf("Unexpected message %q", msg)
+	}
+	if req.Prompt != "a fox" || req.SamplerName != "DPM++ 2M" || req.NegativePrompt != "blurry" || req.Checkpoint != "" {
+		t.Errorf("Expected the server defaults, got %+v", req)
+	}
+
+	req = &imagegen.GenerationRequest{Prompt: "a fox"}
+	applyStyle(req, imagineStyle{preset: "Pixel-Art"}, cfg)
+	if req.Prompt != "pixel art of a fox, 16-bit" || req.NegativePrompt != "smooth, photo" || req.SamplerName != "Euler a" ||
+		req.CfgScale != 9 || req.Checkpoint != "pixel-xl" {
+		t.Errorf("Expected the preset, got %+v", req)
+	}
+	if req.Steps != 40 {
+		t.Errorf("Expected the preset's steps clamped to 40, got %d", req.Steps)
+	}
+
+	// The user's options win over the preset.
+	req = &imagegen.GenerationRequest{Prompt: "a fox", Steps: 12}
+	applyStyle(req, imagineStyle{preset: "pixel-art", negative: "text", sampler: "DDIM", model: "base"}, cfg)
+	if req.Steps != 12 || req.NegativePrompt != "text" || req.SamplerName != "DDIM" || req.Checkpoint != "base" {
+		t.Errorf("Expected the user's choices, got %+v", req)
+	}
+
+	// A preset that leaves fields empty falls back to the defaults.
+	req = &imagegen.GenerationRequest{Prompt: "a fox"}
+	applyStyle(req, imagineStyle{preset: "photo"}, cfg)
+	if req.Prompt != "photo of a fox" || req.NegativePrompt != "blurry" || req.SamplerName != "DPM++ 2M" {
+		t.Errorf("Expected the defaults under the preset, got %+v", req)
+	}
+
+	if msg := applyStyle(&imagegen.GenerationRequest{}, imagineStyle{preset: "oil"}, cfg); !strings.Contains(msg, "oil") {
+		t.Errorf("Expected an unknown preset message, got %q", msg)
+	}
+}
+
+func choiceValues(choices []*discordgo.ApplicationCommandOptionChoice) []string {
+	var values []string
+	for _, c := range choices {
+		values = append(values, c.Value.(string))
+	}
+	return values
+}
+
+func TestPresetChoices(t *testing.T) {
+	presets := styleConfig().Presets
+	if got := choiceValues(presetChoices(presets, "")); strings.Join(got, ",") != "photo,pixel-art" {
+		t.Errorf("Expected every preset in order, got %v", got)
+	}
+	// Matches the description too.
+	if got := choiceValues(presetChoices(presets, "retro")); strings.Join(got, ",") != "pixel-art" {
+		t.Errorf("Expected pixel-art, got %v", got)
+	}
+	if got := presetChoices(presets, "oil"); len(got) != 0 {
+		t.Errorf("Expected no matches, got %v", choiceValues(got))
+	}
+}
+
+func TestModelChoices(t *testing.T) {
+	long := strings.Repeat("x", 120) + ".safetensors [abc]"
+	models := []imagegen.Model{
+		{Title: "base.safetensors [aaa]", ModelName: "base"},
+		{Title: long, ModelName: "long-one"},
+	}
+	got := modelChoices(models, "")
+	if len(got) != 2 || got[0].Value != "base.safetensors [aaa]" {
+		t.Fatalf("Unexpected choices %v", choiceValues(got))
+	}
+	if got[1].Value != "long-one" || utf8.RuneCountInString(got[1].Name) > 100 {
+		t.Errorf("Expected a long title to use the model name, got %q = %v", got[1].Name, got[1].Value)
+	}
+	if got := modelChoices(models, "base"); len(got) != 1 {
+		t.Errorf("Expected one match, got %v", choiceValues(got))
+	}
+}
+
+func TestFocusedOption(t *testing.T) {
+	options := []*discordgo.ApplicationCommandInteractionDataOption{{
+		Name: "create",
+		Type: discordgo.ApplicationCommandOptionSubCommand,
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: "prompt", Type: discordgo.ApplicationCommandOptionString, Value: "a fox"},
+			{Name: "sampler", Type: discordgo.ApplicationCommandOptionString, Value: "eul", Focused: true},
+		},
+	}}
+	if opt := focusedOption(options); opt == nil || opt.Name != "sampler" {
+		t.Errorf("Expected the sampler option, got %+v", opt)
+	}
+	if opt := focusedOption(options[0].Options[:1]); opt != nil {
+		t.Errorf("Expected nothing focused, got %+v", opt)
+	}
+}
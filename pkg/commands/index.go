@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/db"
+	"github.com/josh/discord-bot/internal/jobs"
+	"github.com/josh/discord-bot/internal/rag"
+)
+
+type IndexCommand struct {
+	index *rag.Indexer
+	cfg   *config.Manager
+	jobs  *jobs.Manager
+}
+
+func NewIndexCommand(index *rag.Indexer, cfg *config.Manager, jobManager *jobs.Manager) *IndexCommand {
+	return &IndexCommand{
+		index: index,
+		cfg:   cfg,
+		jobs:  jobManager,
+	}
+}
+
+func (c *IndexCommand) Name() string {
+	return "index"
+}
+
+func (c *IndexCommand) Description() string {
+	return "Choose which channels /ask can answer from"
+}
+
+func (c *IndexCommand) Data() *discordgo.ApplicationCommand {
+	channelOption := []*discordgo.ApplicationCommandOption{
+		{
+			Type:         discordgo.ApplicationCommandOptionChannel,
+			Name:         "channel",
+			Description:  "Channel (default: this one)",
+			Required:     false,
+			ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+		},
+	}
+	return &discordgo.ApplicationCommand{
+		Name:        c.Name(),
+		Description: c.Description(),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "enable",
+				Description: "Index a channel's messages and files, including recent history (admins)",
+				Options:     channelOption,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "disable",
+				Description: "Stop indexing a channel and forget what was indexed (admins)",
+				Options:     channelOption,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "Show which channels are indexed",
+			},
+		},
+	}
+}
+
+func (c *IndexCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || i.GuildID == "" {
+		return respondEphemeral(s, i, "This command can only be used in a server")
+	}
+
+	sub := data.Options[0]
+	channelID := i.ChannelID
+	if len(sub.Options) > 0 {
+		channelID = sub.Options[0].ChannelValue(nil).ID
+	}
+
+	switch sub.Name {
+	case "enable":
+		if !canManageGuild(i) {
+			return respondEphemeral(s, i, "❌ You need the Manage Server permission to change indexing.")
+		}
+		return c.enable(ctx, s, i, channelID)
+	case "disable":
+		if !canManageGuild(i) {
+			return respondEphemeral(s, i, "❌ You need the Manage Server permission to change indexing.")
+		}
+		if err := db.DisableRAGChannel(channelID); err != nil {
+			return respondEphemeral(s, i, "Error disabling indexing: "+err.Error())
+		}
+		slog.Info("RAG indexing disabled", "guild_id", i.GuildID, "channel_id", channelID, "user_id", interactionUserID(i))
+		return respondEphemeral(s, i, fmt.Sprintf("✅ <#%s> is no longer indexed and its index was deleted.", channelID))
+	case "status":
+		return c.status(s, i)
+	default:
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+}
+
+func (c *IndexCommand) enable(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, channelID string) error {
+	if err := db.EnableRAGChannel(i.GuildID, channelID, interactionUserID(i)); err != nil {
+		return respondEphemeral(s, i, "Error enabling indexing: "+err.Error())
+	}
+	slog.Info("RAG indexing enabled", "guild_id", i.GuildID, "channel_id", channelID, "user_id", interactionUserID(i))
+
+	limit := c.cfg.Current().RAG.BackfillMessages
+	if limit == 0 {
+		return respondEphemeral(s, i, fmt.Sprintf("✅ New messages in <#%s> will be indexed for `/ask`.", channelID))
+	}
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}); err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("index #%s", channelID)
+	return submitJob(ctx, s, i, c.jobs, c.Name(), description, func(ctx context.Context) error {
+		fetched := 0
+		fetch := func(before string) ([]*discordgo.Message, error) {
+			jobs.SetStage(ctx, fmt.Sprintf("Indexing history (%d messages so far)", fetched))
+			page, err := s.ChannelMessages(channelID, 100, before, "", "", discordgo.WithContext(ctx))
+			fetched += len(page)
+			return page, err
+		}
+
+		messages, chunks, err := c.index.Backfill(ctx, i.GuildID, limit, fetch)
+		content := fmt.Sprintf("✅ <#%s> is now indexed for `/ask`: %d recent messages gave %d passages, and new messages will be added as they arrive.", channelID, messages, chunks)
+		if err != nil {
+			slog.Error("RAG backfill failed", "guild_id", i.GuildID, "channel_id", channelID, "error", err)
+			content = fmt.Sprintf("⚠️ <#%s> will be indexed from now on, but indexing its history stopped after %d messages: %v", channelID, messages, err)
+		}
+		if _, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); editErr != nil {
+			return editErr
+		}
+		return err
+	})
+}
+
+func (c *IndexCommand) status(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	channels, err := db.ListRAGChannels(i.GuildID)
+	if err != nil {
+		return respondEphemeral(s, i, "Error loading index: "+err.Error())
+	}
+	if len(channels) == 0 {
+		return respondEphemeral(s, i, "No channels are indexed. An admin can add one with `/index enable`.")
+	}
+
+	ids := make([]string, 0, len(channels))
+	for id := range channels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	b.WriteString("**Indexed channels:**\n")
+	for _, id := range ids {
+		fmt.Fprintf(&b, "- <#%s>: %d passages\n", id, channels[id])
+	}
+	return respondEphemeral(s, i, b.String())
+}
@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/jobs"
+)
+
+type JobsCommand struct {
+	jobs *jobs.Manager
+}
+
+func NewJobsCommand(manager *jobs.Manager) *JobsCommand {
+	return &JobsCommand{
+		jobs: manager,
+	}
+}
+
+func (c *JobsCommand) Name() string {
+	return "jobs"
+}
+
+func (c *JobsCommand) Description() string {
+	return "List or cancel your running generations"
+}
+
+func (c *JobsCommand) Data() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        c.Name(),
+		Description: c.Description(),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show your queued and running jobs",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "cancel",
+				Description: "Cancel one of your jobs",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "id",
+						Description: "Job ID from /jobs list",
+						Required:    true,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (c *JobsCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+
+	userID := interactionUserID(i)
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "list":
+		statuses := c.jobs.List(userID)
+		if len(statuses) == 0 {
+			return respondEphemeral(s, i, "You have no running jobs.")
+		}
+		var b strings.Builder
+		b.WriteString("**Your jobs:**\n")
+		for _, status := range statuses {
+			b.WriteString(fmt.Sprintf("- `#%s` /%s — %s — %s\n", status.ID, status.Command, status.Description, jobStageText(status)))
+		}
+		return respondEphemeral(s, i, b.String())
+	case "cancel":
+		id := strings.TrimPrefix(strings.TrimSpace(sub.Options[0].StringValue()), "#")
+		if err := c.jobs.Cancel(userID, id); err != nil {
+			return respondEphemeral(s, i, fmt.Sprintf("❌ No running job `#%s` found for you", id))
+		}
+		return respondEphemeral(s, i, fmt.Sprintf("🛑 Cancelling job `#%s`", id))
+	default:
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+}
+
+// submitJob runs fn as a background job after the interaction has been
+// deferred. Progress is reported by editing the deferred reply; fn posts
+// its own result. Submission failures are reported to the user rather
+// than returned, since the reply has already been deferred.
+func submitJob(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, manager *jobs.Manager, command, description string, fn func(ctx context.Context) error) error {
+	spec := jobs.Spec{
+		UserID:      interactionUserID(i),
+		GuildID:     i.GuildID,
+		Command:     command,
+		Description: description,
+		Progress:    jobProgressReporter(s, i),
+	}
+
+	_, err := manager.Submit(ctx, spec, fn)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, jobs.ErrTooManyJobs):
+		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: strPtr(fmt.Sprintf("❌ You have too many jobs running (%v). Use `/jobs list` to see or cancel them.", err)),
+		})
+		return editErr
+	case errors.Is(err, jobs.ErrShuttingDown):
+		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: strPtr("🔄 The bot is restarting, please try again in a minute."),
+		})
+		return editErr
+	default:
+		return err
+	}
+}
+
+func jobProgressReporter(s *discordgo.Session, i *discordgo.InteractionCreate) func(jobs.Status) {
+	return func(status jobs.Status) {
+		var content string
+		switch status.State {
+		case jobs.StateQueued, jobs.StateRunning:
+			content = fmt.Sprintf("⏳ `/%s` job `#%s`: %s", status.Command, status.ID, jobStageText(status))
+		case jobs.StateCancelled:
+			content = fmt.Sprintf("🛑 Your `/%s` job `#%s` was cancelled.", status.Command, status.ID)
+		case jobs.StateInterrupted:
+			content = fmt.Sprintf("⚠️ The bot is restarting and your `/%s` request was interrupted. Please try again in a minute.", status.Command)
+		default:
+			// Failures are reported by the command with more detail.
+			return
+		}
+
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: &content,
+		}); err != nil {
+			slog.Warn("Failed to update job progress", "id", status.ID, "error", err)
+		}
+	}
+}
+
+func jobStageText(status jobs.Status) string {
+	elapsed := status.Elapsed.Round(time.Second)
+	if status.State == jobs.StateQueued {
+		return fmt.Sprintf("waiting for %s, position %d in line (%s)", status.WaitingFor, status.QueuePosition, elapsed)
+	}
+	stage := status.Stage
+	if stage == "" {
+		stage = "Starting"
+	}
+	return fmt.Sprintf("%s... (%s)", stage, elapsed)
+}
+
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
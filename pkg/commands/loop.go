@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"context"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/josh/discord-bot/internal/voice"
 )
@@ -22,7 +24,7 @@ func (c *LoopCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *LoopCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *LoopCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	current := voice.IsLooping(i.GuildID)
 	newState := !current
 	voice.SetLoop(i.GuildID, newState)
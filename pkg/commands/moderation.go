@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/moderation"
+)
+
+const blockedPromptMessage = "🚫 That was blocked by this server's content filter. Please rephrase it."
+
+// promptOptions are the options whose text is sent to a model.
+var promptOptions = map[string]bool{
+	"prompt":   true,
+	"question": true,
+	"title":    true,
+}
+
+// ScreenPrompt runs the text options of a command, or the fields of a
+// form, through the moderation filter before it executes, and reports
+// whether it may run. If it may not, the user has already been told why.
+func ScreenPrompt(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, filter *moderation.Filter) bool {
+	var name string
+	var texts []string
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		name = data.Name
+		collectPromptText(data.Options, &texts)
+	case discordgo.InteractionModalSubmit:
+		data := i.ModalSubmitData()
+		name, _, _ = strings.Cut(data.CustomID, ":")
+		for _, input := range textInputs(data.Components) {
+			if promptOptions[input.CustomID] {
+				texts = append(texts, input.Value)
+			}
+		}
+	}
+	if len(texts) == 0 {
+		return true
+	}
+
+	if !filter.CheckPrompt(ctx, s, interactionSubject(i, name), strings.Join(texts, "\n")).Blocked {
+		return true
+	}
+	if err := respondEphemeral(s, i, blockedPromptMessage); err != nil {
+		slog.Error("Failed to report blocked prompt", "name", name, "error", err)
+	}
+	return false
+}
+
+func collectPromptText(options []*discordgo.ApplicationCommandInteractionDataOption, texts *[]string) {
+	for _, opt := range options {
+		switch {
+		case opt.Type == discordgo.ApplicationCommandOptionSubCommand || opt.Type == discordgo.ApplicationCommandOptionSubCommandGroup:
+			collectPromptText(opt.Options, texts)
+		case opt.Type == discordgo.ApplicationCommandOptionString && promptOptions[opt.Name]:
+			*texts = append(*texts, opt.StringValue())
+		}
+	}
+}
+
+// textInputs returns the text fields of a submitted form, in order.
+func textInputs(components []discordgo.MessageComponent) []*discordgo.TextInput {
+	var inputs []*discordgo.TextInput
+	for _, c := range components {
+		switch c := c.(type) {
+		case *discordgo.ActionsRow:
+			inputs = append(inputs, textInputs(c.Components)...)
+		case *discordgo.TextInput:
+			inputs = append(inputs, c)
+		}
+	}
+	return inputs
+}
+
+// moderatedLive is newLiveMessage for LLM output, masked by the server's
+// moderation rules as it streams.
+func moderatedLive(sink messageSink, filter *moderation.Filter, guildID string) *liveMessage {
+	live := newLiveMessage(sink, streamEditInterval)
+	live.redact = func(text string) string {
+		return filter.Redact(guildID, text)
+	}
+	return live
+}
+
+// withholdFlagged checks a finished reply and takes it down if the filter
+// blocks it. It reports whether the reply was withheld.
+func withholdFlagged(ctx context.Context, s *discordgo.Session, filter *moderation.Filter, sub moderation.Subject, live *liveMessage, reply string) bool {
+	if !filter.CheckReply(ctx, s, sub, reply).Blocked {
+		return false
+	}
+	if err := live.Withdraw(moderation.Withheld); err != nil {
+		slog.Error("Failed to withdraw flagged reply", "guild_id", sub.GuildID, "command", sub.Command, "error", err)
+	}
+	return true
+}
+
+func interactionSubject(i *discordgo.InteractionCreate, command string) moderation.Subject {
+	return moderation.Subject{
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		UserID:    interactionUserID(i),
+		Command:   command,
+	}
+}
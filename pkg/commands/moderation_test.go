@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCollectPromptText(t *testing.T) {
+	options := []*discordgo.ApplicationCommandInteractionDataOption{
+		{
+			Name: "ask",
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "prompt", Type: discordgo.ApplicationCommandOptionString, Value: "draw a cat"},
+				{Name: "model", Type: discordgo.ApplicationCommandOptionString, Value: "fast"},
+			},
+		},
+		{Name: "title", Type: discordgo.ApplicationCommandOptionString, Value: "Cats"},
+		{Name: "pages", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(3)},
+	}
+
+	var texts []string
+	collectPromptText(options, &texts)
+	if want := []string{"draw a cat", "Cats"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("Expected %q, got %q", want, texts)
+	}
+}
@@ -2,29 +2,39 @@ package commands
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/josh/discord-bot/internal/imagegen"
+	"github.com/josh/discord-bot/internal/jobs"
 	"github.com/josh/discord-bot/internal/officegen"
 )
 
+// nativeFormats is the Office format for each type of document.
+var nativeFormats = map[string]officegen.Format{
+	"document":     officegen.FormatDOCX,
+	"spreadsheet":  officegen.FormatXLSX,
+	"presentation": officegen.FormatPPTX,
+}
+
 type PDFCommand struct {
 	docGen   *officegen.DocumentGenerator
 	sheetGen *officegen.SpreadsheetGenerator
 	presGen  *officegen.PresentationGenerator
+	jobs     *jobs.Manager
 }
 
-func NewPDFCommand(llmURL string, imageGenURL string) *PDFCommand {
-	llmClient := officegen.NewClient(llmURL)
-	sdClient := imagegen.NewClient(imageGenURL)
+func NewPDFCommand(llmClient *officegen.Client, sdClient imagegen.ImageBackend, jobManager *jobs.Manager) *PDFCommand {
 	imageGen := officegen.NewImageGenerator(sdClient, llmClient)
 
 	return &PDFCommand{
 		docGen:   officegen.NewDocumentGenerator(llmClient, imageGen),
 		sheetGen: officegen.NewSpreadsheetGenerator(llmClient, imageGen),
 		presGen:  officegen.NewPresentationGenerator(llmClient, imageGen),
+		jobs:     jobManager,
 	}
 }
 
@@ -33,7 +43,7 @@ func (c *PDFCommand) Name() string {
 }
 
 func (c *PDFCommand) Description() string {
-	return "Generate PDF documents (reports, presentations, tables) with AI"
+	return "Generate documents, presentations and tables with AI as PDF or Office files"
 }
 
 func (c *PDFCommand) Data() *discordgo.ApplicationCommand {
@@ -70,11 +80,31 @@ func (c *PDFCommand) Data() *discordgo.ApplicationCommand {
 				Description: "Target number of pages/slides/sheets (0=auto, default: 0)",
 				Required:    false,
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "format",
+				Description: "File to send (default: PDF)",
+				Required:    false,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "PDF", Value: "pdf"},
+					{Name: "Office file (Word, Excel or PowerPoint)", Value: "office"},
+				},
+			},
 		},
 	}
 }
 
-func (c *PDFCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+// Cost counts every five requested pages or slides as one use.
+func (c *PDFCommand) Cost(i *discordgo.InteractionCreate) int {
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "pages" {
+			return max(1, (int(opt.IntValue())+4)/5)
+		}
+	}
+	return 1
+}
+
+func (c *PDFCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	options := i.ApplicationCommandData().Options
 
 	username := "Unknown"
@@ -89,6 +119,7 @@ func (c *PDFCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreat
 
 	var title string
 	var pages int
+	format := officegen.FormatPDF
 
 	for _, opt := range options[2:] {
 		switch opt.Name {
@@ -96,6 +127,10 @@ func (c *PDFCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreat
 			title = opt.StringValue()
 		case "pages":
 			pages = int(opt.IntValue())
+		case "format":
+			if opt.StringValue() == "office" {
+				format = nativeFormats[docType]
+			}
 		}
 	}
 
@@ -107,38 +142,49 @@ func (c *PDFCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreat
 		"prompt", prompt,
 		"title", title,
 		"pages", pages,
+		"format", format,
 	)
 
 	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: "📄 Generating your PDF with AI images... This may take 1-3 minutes.",
+			Content: fmt.Sprintf("📄 Generating your %s with AI images... This may take 1-3 minutes.", strings.ToUpper(string(format))),
 		},
 	}); err != nil {
 		return err
 	}
 
+	description := fmt.Sprintf("%s: %s", docType, truncate(prompt, 60))
+	return submitJob(ctx, s, i, c.jobs, c.Name(), description, func(ctx context.Context) error {
+		return c.generate(ctx, s, i, docType, prompt, title, pages, format)
+	})
+}
+
+func (c *PDFCommand) generate(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, docType, prompt, title string, pages int, format officegen.Format) error {
 	var result *officegen.GeneratedDocument
 	var err error
 
 	switch docType {
 	case "document":
-		result, err = c.docGen.Generate(&officegen.DocumentRequest{
+		result, err = c.docGen.Generate(ctx, &officegen.DocumentRequest{
 			Prompt:      prompt,
 			Title:       title,
 			TargetPages: pages,
+			Format:      format,
 		})
 	case "spreadsheet":
-		result, err = c.sheetGen.Generate(&officegen.SpreadsheetRequest{
+		result, err = c.sheetGen.Generate(ctx, &officegen.SpreadsheetRequest{
 			Prompt:      prompt,
 			Title:       title,
 			TargetPages: pages,
+			Format:      format,
 		})
 	case "presentation":
-		result, err = c.presGen.Generate(&officegen.PresentationRequest{
+		result, err = c.presGen.Generate(ctx, &officegen.PresentationRequest{
 			Prompt:       prompt,
 			Title:        title,
 			TargetSlides: pages,
+			Format:       format,
 		})
 	default:
 		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
@@ -148,9 +194,12 @@ func (c *PDFCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreat
 	}
 
 	if err != nil {
-		slog.Error("Failed to generate PDF", "error", err, "type", docType)
+		slog.Error("Failed to generate document", "error", err, "type", docType, "format", format)
+		if ctx.Err() != nil {
+			return err
+		}
 		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: strPtr(fmt.Sprintf("❌ Failed to generate PDF: %v", err)),
+			Content: strPtr(fmt.Sprintf("❌ Failed to generate %s: %v", strings.ToUpper(string(format)), err)),
 		})
 		if editErr != nil {
 			return editErr
@@ -161,13 +210,13 @@ func (c *PDFCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreat
 	files := []*discordgo.File{
 		{
 			Name:        result.Filename,
-			ContentType: "application/pdf",
+			ContentType: result.ContentType,
 			Reader:      bytes.NewReader(result.Data),
 		},
 	}
 
-	content := fmt.Sprintf("✨ **PDF Generated**\n**Type:** %s\n**Filename:** %s\n**Size:** %.2f KB",
-		docType, result.Filename, float64(len(result.Data))/1024)
+	content := fmt.Sprintf("✨ **%s Generated**\n**Type:** %s\n**Filename:** %s\n**Size:** %.2f KB",
+		strings.ToUpper(string(format)), docType, result.Filename, float64(len(result.Data))/1024)
 
 	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
 		Content: &content,
@@ -175,12 +224,13 @@ func (c *PDFCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreat
 	})
 
 	if err != nil {
-		slog.Error("Failed to send PDF", "error", err)
+		slog.Error("Failed to send document", "error", err)
 		return err
 	}
 
-	slog.Info("PDF sent successfully",
+	slog.Info("Document sent successfully",
 		"type", docType,
+		"format", format,
 		"filename", result.Filename,
 		"size", len(result.Data),
 	)
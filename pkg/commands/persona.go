@@ -0,0 +1,224 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/db"
+)
+
+// personaOff clears an assignment in /persona set.
+const personaOff = "none"
+
+var personaNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+type PersonaCommand struct{}
+
+func NewPersonaCommand() *PersonaCommand {
+	return &PersonaCommand{}
+}
+
+func (c *PersonaCommand) Name() string {
+	return "persona"
+}
+
+func (c *PersonaCommand) Description() string {
+	return "Manage the AI's persona for this server or channel"
+}
+
+func (c *PersonaCommand) Data() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        c.Name(),
+		Description: c.Description(),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List this server's personas and which are in use",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "create",
+				Description: "Create a persona, or replace the prompt of an existing one (admins)",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Lowercase name, e.g. pirate",
+						Required:    true,
+						MaxLength:   32,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "prompt",
+						Description: "System prompt: tone, rules and anything the AI should know",
+						Required:    true,
+						MaxLength:   2000,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Use a persona in this channel or the whole server (admins)",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Persona to use, or none to remove the assignment",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "scope",
+						Description: "Where to use it (default: this channel)",
+						Required:    false,
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "This channel", Value: "channel"},
+							{Name: "Whole server", Value: "guild"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (c *PersonaCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || i.GuildID == "" {
+		return respondEphemeral(s, i, "This command can only be used in a server")
+	}
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "list":
+		return c.list(s, i)
+	case "create":
+		if !canManageGuild(i) {
+			return respondEphemeral(s, i, "❌ You need the Manage Server permission to create personas.")
+		}
+		return c.create(s, i, sub.Options)
+	case "set":
+		if !canManageGuild(i) {
+			return respondEphemeral(s, i, "❌ You need the Manage Server permission to change personas.")
+		}
+		return c.set(s, i, sub.Options)
+	default:
+		return respondEphemeral(s, i, "Unknown subcommand")
+	}
+}
+
+func (c *PersonaCommand) list(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	personas, err := db.ListPersonas(i.GuildID)
+	if err != nil {
+		return respondEphemeral(s, i, "Error loading personas: "+err.Error())
+	}
+	if len(personas) == 0 {
+		return respondEphemeral(s, i, "No personas yet. An admin can add one with `/persona create`.")
+	}
+
+	guildPersona, err := db.AssignedPersona("guild", i.GuildID)
+	if err != nil {
+		return respondEphemeral(s, i, "Error loading personas: "+err.Error())
+	}
+	channelPersona, err := db.AssignedPersona("channel", i.ChannelID)
+	if err != nil {
+		return respondEphemeral(s, i, "Error loading personas: "+err.Error())
+	}
+
+	var b strings.Builder
+	b.WriteString("**Personas:**\n")
+	for _, p := range personas {
+		var tags []string
+		if p.Name == guildPersona {
+			tags = append(tags, "server default")
+		}
+		if p.Name == channelPersona {
+			tags = append(tags, "this channel")
+		}
+		line := fmt.Sprintf("- `%s`", p.Name)
+		if len(tags) > 0 {
+			line += " (" + strings.Join(tags, ", ") + ")"
+		}
+		b.WriteString(line + ": " + truncate(strings.ReplaceAll(p.Prompt, "\n", " "), 120) + "\n")
+	}
+	return respondEphemeral(s, i, b.String())
+}
+
+func (c *PersonaCommand) create(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) error {
+	name := strings.ToLower(strings.TrimSpace(options[0].StringValue()))
+	prompt := strings.TrimSpace(options[1].StringValue())
+
+	if err := validatePersonaName(name); err != nil {
+		return respondEphemeral(s, i, "❌ "+err.Error())
+	}
+	if prompt == "" {
+		return respondEphemeral(s, i, "❌ The prompt can't be empty.")
+	}
+
+	existed, err := db.SavePersona(i.GuildID, db.Persona{Name: name, Prompt: prompt, CreatedBy: interactionUserID(i)})
+	if err != nil {
+		return respondEphemeral(s, i, "Error saving persona: "+err.Error())
+	}
+
+	slog.Info("Persona saved", "guild_id", i.GuildID, "name", name, "user_id", interactionUserID(i), "replaced", existed)
+	if existed {
+		return respondEphemeral(s, i, fmt.Sprintf("✅ Persona `%s` updated.", name))
+	}
+	return respondEphemeral(s, i, fmt.Sprintf("✅ Persona `%s` created. Use `/persona set name:%s` to use it.", name, name))
+}
+
+func (c *PersonaCommand) set(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) error {
+	name := strings.ToLower(strings.TrimSpace(options[0].StringValue()))
+	scope := "channel"
+	if len(options) > 1 {
+		scope = options[1].StringValue()
+	}
+	scopeID, where := i.ChannelID, "this channel"
+	if scope == "guild" {
+		scopeID, where = i.GuildID, "this server"
+	}
+
+	if name == personaOff {
+		if err := db.UnassignPersona(scope, scopeID); err != nil {
+			return respondEphemeral(s, i, "Error removing persona: "+err.Error())
+		}
+		slog.Info("Persona unassigned", "guild_id", i.GuildID, "scope", scope, "scope_id", scopeID)
+		return respondEphemeral(s, i, fmt.Sprintf("✅ Removed the persona for %s.", where))
+	}
+
+	persona, err := db.GetPersona(i.GuildID, name)
+	if err != nil {
+		return respondEphemeral(s, i, "Error loading persona: "+err.Error())
+	}
+	if persona == nil {
+		return respondEphemeral(s, i, fmt.Sprintf("❌ No persona named `%s`. See `/persona list`.", name))
+	}
+
+	if err := db.AssignPersona(i.GuildID, scope, scopeID, name); err != nil {
+		return respondEphemeral(s, i, "Error saving persona: "+err.Error())
+	}
+	slog.Info("Persona assigned", "guild_id", i.GuildID, "scope", scope, "scope_id", scopeID, "name", name)
+	return respondEphemeral(s, i, fmt.Sprintf("✅ The AI now uses persona `%s` in %s.", name, where))
+}
+
+func validatePersonaName(name string) error {
+	if name == personaOff {
+		return fmt.Errorf("`%s` is reserved for removing a persona", personaOff)
+	}
+	if !personaNamePattern.MatchString(name) {
+		return fmt.Errorf("persona names are up to 32 lowercase letters, digits, - or _")
+	}
+	return nil
+}
+
+// canManageGuild reports whether the member running i has the Manage
+// Server permission in the channel.
+func canManageGuild(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&discordgo.PermissionManageGuild != 0
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestValidatePersonaName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"pirate", true},
+		{"support-bot_2", true},
+		{"none", false},
+		{"", false},
+		{"-pirate", false},
+		{"two words", false},
+		{"abcdefghijklmnopqrstuvwxyz0123456", false},
+	}
+	for _, tt := range tests {
+		err := validatePersonaName(tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("validatePersonaName(%q) = %v, want valid=%v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestCanManageGuild(t *testing.T) {
+	admin := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Member: &discordgo.Member{Permissions: discordgo.PermissionManageGuild | discordgo.PermissionSendMessages},
+	}}
+	member := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Member: &discordgo.Member{Permissions: discordgo.PermissionSendMessages},
+	}}
+	dm := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{}}
+
+	if !canManageGuild(admin) {
+		t.Error("Expected Manage Server to be allowed")
+	}
+	if canManageGuild(member) || canManageGuild(dm) {
+		t.Error("Expected members without Manage Server to be refused")
+	}
+}
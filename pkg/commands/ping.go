@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"context"
+
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -21,7 +23,7 @@ func (c *PingCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *PingCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *PingCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
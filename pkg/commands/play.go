@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
@@ -32,7 +33,7 @@ func (c *PlayCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *PlayCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *PlayCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	url := i.ApplicationCommandData().Options[0].StringValue()
 
 	// Check if user is in voice channel
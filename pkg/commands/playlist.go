@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
@@ -77,7 +78,7 @@ func (c *PlaylistCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *PlaylistCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *PlaylistCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	data := i.ApplicationCommandData()
 	if len(data.Options) == 0 {
 		return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 
@@ -63,7 +64,7 @@ func (c *QueueCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *QueueCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *QueueCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	data := i.ApplicationCommandData()
 	if len(data.Options) == 0 {
 		return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
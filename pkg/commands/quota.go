@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/ratelimit"
+)
+
+type QuotaCommand struct {
+	limiter *ratelimit.Limiter
+	cfg     *config.Manager
+}
+
+func NewQuotaCommand(limiter *ratelimit.Limiter, cfg *config.Manager) *QuotaCommand {
+	return &QuotaCommand{
+		limiter: limiter,
+		cfg:     cfg,
+	}
+}
+
+func (c *QuotaCommand) Name() string {
+	return "quota"
+}
+
+func (c *QuotaCommand) Description() string {
+	return "Show how many uses of limited commands you have left today"
+}
+
+func (c *QuotaCommand) Data() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        c.Name(),
+		Description: c.Description(),
+	}
+}
+
+func (c *QuotaCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	var commandNames []string
+	for name := range c.cfg.Current().RateLimits {
+		commandNames = append(commandNames, name)
+	}
+	sort.Strings(commandNames)
+
+	if len(commandNames) == 0 {
+		return respondEphemeral(s, i, "No commands are rate limited.")
+	}
+
+	var b strings.Builder
+	b.WriteString("**Your daily quota (resets at midnight UTC):**\n")
+	for _, name := range commandNames {
+		decision, err := c.limiter.Peek(RateLimitRequest(name, i, 1))
+		if err != nil {
+			slog.Error("Failed to check quota", "command", name, "error", err)
+			b.WriteString(fmt.Sprintf("- `/%s`: unavailable\n", name))
+			continue
+		}
+		b.WriteString(fmt.Sprintf("- `/%s`: %s\n", name, QuotaText(decision)))
+	}
+	return respondEphemeral(s, i, b.String())
+}
+
+// RateLimitRequest builds a limiter request for the user running i.
+func RateLimitRequest(command string, i *discordgo.InteractionCreate, cost int) ratelimit.Request {
+	req := ratelimit.Request{
+		Command: command,
+		GuildID: i.GuildID,
+		UserID:  interactionUserID(i),
+		Cost:    cost,
+	}
+	if i.Member != nil {
+		req.RoleIDs = i.Member.Roles
+	}
+	return req
+}
+
+type refundKey struct{}
+
+// WithRefund returns a context carrying refund, which gives back the rate
+// limit use an interaction was charged.
+func WithRefund(ctx context.Context, refund func()) context.Context {
+	return context.WithValue(ctx, refundKey{}, refund)
+}
+
+// rejectRequest refuses an interaction before it has done any work, such
+// as one over the server's image size limits, and gives back the use it
+// was charged so mistakes don't eat into the quota.
+func rejectRequest(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+	if refund, ok := ctx.Value(refundKey{}).(func()); ok {
+		refund()
+	}
+	return respondEphemeral(s, i, content)
+}
+
+// QuotaText describes the user's remaining daily quota.
+func QuotaText(d ratelimit.Decision) string {
+	switch {
+	case d.Exempt:
+		return "unlimited (exempt)"
+	case d.Remaining() < 0:
+		return "no daily limit"
+	default:
+		return fmt.Sprintf("%d of %d left today", d.Remaining(), d.UserLimit)
+	}
+}
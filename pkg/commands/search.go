@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"net/url"
 
 	"github.com/bwmarrin/discordgo"
@@ -31,7 +32,7 @@ func (c *SearchCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *SearchCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *SearchCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	query := i.ApplicationCommandData().Options[0].StringValue()
 	searchURL := "https://www.youtube.com/results?search_query=" + url.QueryEscape(query)
 	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
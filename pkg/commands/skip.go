@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"context"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/josh/discord-bot/internal/voice"
 )
@@ -22,7 +24,7 @@ func (c *SkipCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *SkipCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *SkipCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	voice.Skip(i.GuildID)
 	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
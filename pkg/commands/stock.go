@@ -1,35 +1,41 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/josh/discord-bot/internal/llm"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/jobs"
 	"github.com/josh/discord-bot/internal/sentiment"
 	"github.com/josh/discord-bot/internal/stocknews"
 )
 
 type LLMClient interface {
-	Chat(prompt string) (string, error)
+	Chat(ctx context.Context, prompt string) (string, error)
 }
 
 type SentimentClient interface {
-	GetSentiment(ticker string) (sentiment.SentimentData, error)
+	GetSentiment(ctx context.Context, ticker string) (sentiment.SentimentData, error)
 }
 
 type StockCommand struct {
 	newsClient      stocknews.Client
 	llmClient       LLMClient
 	sentimentClient SentimentClient
+	cfg             *config.Manager
+	jobs            *jobs.Manager
 }
 
-func NewStockCommand(newsClient stocknews.Client, llmClient *llm.Client, sentimentClient *sentiment.Aggregator) *StockCommand {
+func NewStockCommand(newsClient stocknews.Client, llmClient LLMClient, sentimentClient *sentiment.Aggregator, cfg *config.Manager, jobManager *jobs.Manager) *StockCommand {
 	return &StockCommand{
 		newsClient:      newsClient,
 		llmClient:       llmClient,
 		sentimentClient: sentimentClient,
+		cfg:             cfg,
+		jobs:            jobManager,
 	}
 }
 
@@ -58,7 +64,7 @@ func (c *StockCommand) Data() *discordgo.ApplicationCommand {
 			{
 				Type:        discordgo.ApplicationCommandOptionInteger,
 				Name:        "days",
-				Description: "Number of days of news to retrieve (default: 7)",
+				Description: "Number of days of news to retrieve (default: 7, configurable per server)",
 				Required:    false,
 			},
 		},
@@ -66,11 +72,11 @@ func (c *StockCommand) Data() *discordgo.ApplicationCommand {
 }
 
 // Execute executes the stock command
-func (c *StockCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *StockCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	options := i.ApplicationCommandData().Options
 
 	var tickers string
-	days := int64(7)
+	days := int64(c.cfg.ForGuild(i.GuildID).StockNews.DefaultDays)
 
 	for _, opt := range options {
 		switch opt.Name {
@@ -112,61 +118,87 @@ func (c *StockCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCre
 		return err
 	}
 
-	go func() {
-		var report string
-		var err error
+	description := "trending"
+	if len(tickerList) > 0 {
+		description = strings.Join(tickerList, ", ")
+	}
+	return submitJob(ctx, s, i, c.jobs, c.Name(), description, func(ctx context.Context) error {
+		return c.run(ctx, s, i, tickerList, int(days))
+	})
+}
 
-		if len(tickerList) == 0 {
-			report, err = c.processTrendingAnalysis(int(days))
-		} else {
-			report, err = c.processStockAnalysis(tickerList, int(days))
-		}
+// run builds the report and posts it, splitting it across follow-up
+// messages if it is over Discord's message limit.
+func (c *StockCommand) run(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, tickerList []string, days int) error {
+	var report string
+	var err error
+	if len(tickerList) == 0 {
+		report, err = c.processTrendingAnalysis(ctx, days)
+	} else {
+		report, err = c.processStockAnalysis(ctx, tickerList, days)
+	}
 
-		if err != nil {
-			slog.Error("Error processing stock analysis", "error", err)
-			s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-				Content: fmt.Sprintf("Error processing stock analysis: %v", err),
-			})
-			return
+	if err != nil {
+		slog.Error("Error processing stock analysis", "error", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		_, followupErr := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("Error processing stock analysis: %v", err),
+		})
+		if followupErr != nil {
+			return followupErr
 		}
+		return err
+	}
 
-		if len(report) > 2000 {
-			chunks := make([]string, 0)
-			for len(report) > 0 {
-				end := 2000
-				if end > len(report) {
-					end = len(report)
-				}
-				chunks = append(chunks, report[:end])
-				report = report[end:]
+	if len(report) > 2000 {
+		chunks := make([]string, 0)
+		for len(report) > 0 {
+			end := 2000
+			if end > len(report) {
+				end = len(report)
 			}
+			chunks = append(chunks, report[:end])
+			report = report[end:]
+		}
 
-			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-				Content: &chunks[0],
-			})
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:         &chunks[0],
+			AllowedMentions: noMentions,
+		}); err != nil {
+			return err
+		}
 
-			for _, chunk := range chunks[1:] {
-				s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-					Content: chunk,
-				})
+		for _, chunk := range chunks[1:] {
+			if _, err := s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+				Content:         chunk,
+				AllowedMentions: noMentions,
+			}); err != nil {
+				return err
 			}
-		} else {
-			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-				Content: &report,
-			})
 		}
-	}()
+		return nil
+	}
 
-	return nil
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:         &report,
+		AllowedMentions: noMentions,
+	})
+	return err
 }
 
 // processStockAnalysis processes the stock analysis for given tickers
-func (c *StockCommand) processStockAnalysis(tickers []string, days int) (string, error) {
+func (c *StockCommand) processStockAnalysis(ctx context.Context, tickers []string, days int) (string, error) {
 	var report strings.Builder
 
 	report.WriteString("## 📊 Stock Analysis Report\n\n")
 
 	for i, ticker := range tickers {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
 		if i > 0 {
 			report.WriteString("\n---\n\n")
 		}
@@ -174,7 +206,7 @@ func (c *StockCommand) processStockAnalysis(tickers []string, days int) (string,
 		report.WriteString(fmt.Sprintf("### %s\n\n", ticker))
 
 		// Get news for this ticker
-		news, err := c.getStockNews(ticker, days)
+		news, err := c.getStockNews(ctx, ticker, days)
 		if err != nil {
 			slog.Error("Error getting news", "ticker", ticker, "error", err)
 			report.WriteString(fmt.Sprintf("Error retrieving news for %s: %v\n\n", ticker, err))
@@ -182,7 +214,7 @@ func (c *StockCommand) processStockAnalysis(tickers []string, days int) (string,
 		}
 
 		// Get sentiment analysis
-		sentiment, err := c.getSentimentAnalysis(ticker)
+		sentiment, err := c.getSentimentAnalysis(ctx, ticker)
 		if err != nil {
 			slog.Error("Error getting sentiment", "ticker", ticker, "error", err)
 			report.WriteString(fmt.Sprintf("Error retrieving sentiment for %s: %v\n\n", ticker, err))
@@ -191,7 +223,7 @@ func (c *StockCommand) processStockAnalysis(tickers []string, days int) (string,
 
 		// Combine data and generate AI report
 		aiPrompt := c.generateAIPrompt(ticker, news, sentiment)
-		aiReport, err := c.generateAIReport(aiPrompt)
+		aiReport, err := c.generateAIReport(ctx, aiPrompt)
 		if err != nil {
 			slog.Error("Error generating AI report", "ticker", ticker, "error", err)
 			report.WriteString(fmt.Sprintf("Error generating AI report for %s: %v\n\n", ticker, err))
@@ -205,13 +237,15 @@ func (c *StockCommand) processStockAnalysis(tickers []string, days int) (string,
 }
 
 // getStockNews retrieves news for a given stock ticker
-func (c *StockCommand) getStockNews(ticker string, days int) ([]stocknews.NewsItem, error) {
-	return c.newsClient.GetNews(ticker, days)
+func (c *StockCommand) getStockNews(ctx context.Context, ticker string, days int) ([]stocknews.NewsItem, error) {
+	jobs.SetStage(ctx, "Fetching news for "+ticker)
+	return c.newsClient.GetNews(ctx, ticker, days)
 }
 
 // getSentimentAnalysis retrieves sentiment analysis for a given stock ticker
-func (c *StockCommand) getSentimentAnalysis(ticker string) (sentiment.SentimentData, error) {
-	return c.sentimentClient.GetSentiment(ticker)
+func (c *StockCommand) getSentimentAnalysis(ctx context.Context, ticker string) (sentiment.SentimentData, error) {
+	jobs.SetStage(ctx, "Checking sentiment for "+ticker)
+	return c.sentimentClient.GetSentiment(ctx, ticker)
 }
 
 // generateAIPrompt generates a prompt for the AI to process the stock data
@@ -242,16 +276,21 @@ func (c *StockCommand) generateAIPrompt(ticker string, news []stocknews.NewsItem
 }
 
 // generateAIReport generates a report using the llama.cpp service
-func (c *StockCommand) generateAIReport(prompt string) (string, error) {
-	return c.llmClient.Chat(prompt)
+func (c *StockCommand) generateAIReport(ctx context.Context, prompt string) (string, error) {
+	release, err := jobs.Acquire(ctx, jobs.BackendLLM, "Writing analysis")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return c.llmClient.Chat(ctx, prompt)
 }
 
-func (c *StockCommand) processTrendingAnalysis(days int) (string, error) {
+func (c *StockCommand) processTrendingAnalysis(ctx context.Context, days int) (string, error) {
 	var report strings.Builder
 
 	report.WriteString("## 📈 Trending Stocks Analysis\n\n")
 
-	news, err := c.newsClient.GetTrendingNews(days)
+	news, err := c.newsClient.GetTrendingNews(ctx, days)
 	if err != nil {
 		return "", fmt.Errorf("failed to get trending news: %w", err)
 	}
@@ -271,20 +310,24 @@ func (c *StockCommand) processTrendingAnalysis(days int) (string, error) {
 	report.WriteString("\n---\n\n")
 
 	for i, ticker := range topTickers {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
 		if i > 0 {
 			report.WriteString("\n---\n\n")
 		}
 
 		report.WriteString(fmt.Sprintf("### %s\n\n", ticker))
 
-		tickerNews, err := c.getStockNews(ticker, days)
+		tickerNews, err := c.getStockNews(ctx, ticker, days)
 		if err != nil {
 			slog.Error("Error getting news", "ticker", ticker, "error", err)
 			report.WriteString(fmt.Sprintf("Error retrieving news for %s: %v\n\n", ticker, err))
 			continue
 		}
 
-		sentimentData, err := c.getSentimentAnalysis(ticker)
+		sentimentData, err := c.getSentimentAnalysis(ctx, ticker)
 		if err != nil {
 			slog.Error("Error getting sentiment", "ticker", ticker, "error", err)
 			report.WriteString(fmt.Sprintf("Error retrieving sentiment for %s: %v\n\n", ticker, err))
@@ -292,7 +335,7 @@ func (c *StockCommand) processTrendingAnalysis(days int) (string, error) {
 		}
 
 		aiPrompt := c.generateAIPrompt(ticker, tickerNews, sentimentData)
-		aiReport, err := c.generateAIReport(aiPrompt)
+		aiReport, err := c.generateAIReport(ctx, aiPrompt)
 		if err != nil {
 			slog.Error("Error generating AI report", "ticker", ticker, "error", err)
 			report.WriteString(fmt.Sprintf("Error generating AI report for %s: %v\n\n", ticker, err))
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -12,7 +13,7 @@ import (
 
 type mockNewsClient struct{}
 
-func (m *mockNewsClient) GetNews(ticker string, days int) ([]stocknews.NewsItem, error) {
+func (m *mockNewsClient) GetNews(ctx context.Context, ticker string, days int) ([]stocknews.NewsItem, error) {
 	return []stocknews.NewsItem{
 		{
 			Title:       fmt.Sprintf("%s Beats Earnings Expectations", ticker),
@@ -41,7 +42,7 @@ func (m *mockNewsClient) GetNews(ticker string, days int) ([]stocknews.NewsItem,
 	}, nil
 }
 
-func (m *mockNewsClient) GetTrendingNews(days int) ([]stocknews.NewsItem, error) {
+func (m *mockNewsClient) GetTrendingNews(ctx context.Context, days int) ([]stocknews.NewsItem, error) {
 	return []stocknews.NewsItem{
 		{
 			Title:       "AAPL Launches New Product Line",
@@ -78,13 +79,13 @@ func (m *mockNewsClient) GetTrendingNews(days int) ([]stocknews.NewsItem, error)
 	}, nil
 }
 
-func (m *mockNewsClient) HealthCheck() error {
+func (m *mockNewsClient) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
 type mockLLMClient struct{}
 
-func (m *mockLLMClient) Chat(prompt string) (string, error) {
+func (m *mockLLMClient) Chat(ctx context.Context, prompt string) (string, error) {
 	return `### Investment Analysis
 
 **Key Findings:**
@@ -111,7 +112,7 @@ Based on the analysis, this appears to be a solid investment opportunity with go
 
 type mockSentimentAggregator struct{}
 
-func (m *mockSentimentAggregator) GetSentiment(ticker string) (sentiment.SentimentData, error) {
+func (m *mockSentimentAggregator) GetSentiment(ctx context.Context, ticker string) (sentiment.SentimentData, error) {
 	return sentiment.SentimentData{
 		Ticker: ticker,
 		XSentiment: sentiment.PlatformSentiment{
@@ -144,7 +145,7 @@ func TestStockCommand_ProcessAnalysis(t *testing.T) {
 
 	t.Logf("\n=== Testing Stock Analysis for: %v (last %d days) ===\n", tickers, days)
 
-	report, err := cmd.processStockAnalysis(tickers, days)
+	report, err := cmd.processStockAnalysis(context.Background(), tickers, days)
 	if err != nil {
 		t.Fatalf("processStockAnalysis failed: %v", err)
 	}
@@ -179,7 +180,7 @@ func TestStockCommand_SingleTicker(t *testing.T) {
 
 	t.Logf("\n=== Testing Single Ticker Analysis: %s ===\n", ticker)
 
-	report, err := cmd.processStockAnalysis([]string{ticker}, days)
+	report, err := cmd.processStockAnalysis(context.Background(), []string{ticker}, days)
 	if err != nil {
 		t.Fatalf("processStockAnalysis failed: %v", err)
 	}
@@ -202,7 +203,7 @@ func TestStockCommand_TrendingAnalysis(t *testing.T) {
 
 	t.Logf("\n=== Testing Trending Analysis (no tickers provided) ===\n")
 
-	report, err := cmd.processTrendingAnalysis(days)
+	report, err := cmd.processTrendingAnalysis(context.Background(), days)
 	if err != nil {
 		t.Fatalf("processTrendingAnalysis failed: %v", err)
 	}
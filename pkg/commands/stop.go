@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"context"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/josh/discord-bot/internal/voice"
 )
@@ -22,7 +24,7 @@ func (c *StopCommand) Data() *discordgo.ApplicationCommand {
 	}
 }
 
-func (c *StopCommand) Execute(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+func (c *StopCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
 	voice.StopPlaying(i.GuildID)
 	voice.LeaveVoiceChannel(i.GuildID)
 
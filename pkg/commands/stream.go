@@ -0,0 +1,232 @@
+package commands
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// streamEditInterval keeps live edits well under Discord's limit of five
+// message edits per five seconds.
+const streamEditInterval = time.Second
+
+// noMentions stops LLM output from pinging anyone, whatever it writes.
+var noMentions = &discordgo.MessageAllowedMentions{}
+
+// messageSink posts and edits the messages a streamed reply is written to.
+type messageSink interface {
+	Send(content string) (id string, err error)
+	Edit(id, content string) error
+}
+
+// liveMessage writes a streamed reply to Discord as it arrives. The current
+// message is edited at most once per interval, and once it reaches Discord's
+// length limit it is finished and the text continues in a new message.
+type liveMessage struct {
+	sink     messageSink
+	interval time.Duration
+	maxLen   int
+	now      func() time.Time
+
+	// redact, if set, rewrites text before it is shown.
+	redact func(string) string
+
+	ids       []string
+	current   string
+	currentID string
+	posted    string
+	lastEdit  time.Time
+}
+
+func newLiveMessage(sink messageSink, interval time.Duration) *liveMessage {
+	return &liveMessage{
+		sink:     sink,
+		interval: interval,
+		maxLen:   2000,
+		now:      time.Now,
+	}
+}
+
+func (l *liveMessage) Write(delta string) error {
+	l.current += delta
+
+	// Split the redacted text, as masking can make it longer. The rest is
+	// kept redacted, which redacting again leaves as it is.
+	shown := l.shown(l.current)
+	for len(shown) > l.maxLen {
+		cut := splitPoint(shown, l.maxLen)
+		if err := l.put(shown[:cut]); err != nil {
+			return err
+		}
+		l.current = shown[cut:]
+		l.currentID = ""
+		l.posted = ""
+		shown = l.shown(l.current)
+	}
+
+	if l.now().Sub(l.lastEdit) < l.interval {
+		return nil
+	}
+	return l.put(shown)
+}
+
+// Close writes whatever hasn't been shown yet.
+func (l *liveMessage) Close() error {
+	return l.put(l.shown(l.current))
+}
+
+// Posted reports whether anything has been sent yet.
+func (l *liveMessage) Posted() bool {
+	return len(l.ids) > 0
+}
+
+// IDs returns the IDs of every message the reply was written to.
+func (l *liveMessage) IDs() []string {
+	return l.ids
+}
+
+// Withdraw replaces everything written so far with notice, for a reply
+// that turned out not to be fit to show.
+func (l *liveMessage) Withdraw(notice string) error {
+	for n, id := range l.ids {
+		content := notice
+		if n > 0 {
+			content = "…"
+		}
+		if err := l.sink.Edit(id, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shown is text as it will appear, after redaction.
+func (l *liveMessage) shown(text string) string {
+	if l.redact == nil {
+		return text
+	}
+	return l.redact(text)
+}
+
+func (l *liveMessage) put(content string) error {
+	if strings.TrimSpace(content) == "" || content == l.posted {
+		return nil
+	}
+
+	if l.currentID == "" {
+		id, err := l.sink.Send(content)
+		if err != nil {
+			return err
+		}
+		l.currentID = id
+		l.ids = append(l.ids, id)
+	} else if err := l.sink.Edit(l.currentID, content); err != nil {
+		return err
+	}
+
+	l.posted = content
+	l.lastEdit = l.now()
+	return nil
+}
+
+// splitPoint picks where to break s so the first part fits in maxLen bytes,
+// preferring a line break, then a space, and never splitting a rune.
+func splitPoint(s string, maxLen int) int {
+	head := s[:maxLen]
+	if i := strings.LastIndex(head, "\n"); i > maxLen/2 {
+		return i + 1
+	}
+	if i := strings.LastIndex(head, " "); i > maxLen/2 {
+		return i + 1
+	}
+	cut := maxLen
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// interactionSink writes to a deferred interaction response: the first
+// message replaces the "thinking..." placeholder and later ones are
+// followups.
+type interactionSink struct {
+	s           *discordgo.Session
+	interaction *discordgo.Interaction
+	originalID  string
+}
+
+func (w *interactionSink) Send(content string) (string, error) {
+	if w.originalID == "" {
+		msg, err := w.s.InteractionResponseEdit(w.interaction, &discordgo.WebhookEdit{
+			Content:         &content,
+			AllowedMentions: noMentions,
+		})
+		if err != nil {
+			return "", err
+		}
+		w.originalID = msg.ID
+		return msg.ID, nil
+	}
+	msg, err := w.s.FollowupMessageCreate(w.interaction, true, &discordgo.WebhookParams{
+		Content:         content,
+		AllowedMentions: noMentions,
+	})
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+func (w *interactionSink) Edit(id, content string) error {
+	var err error
+	if id == w.originalID {
+		_, err = w.s.InteractionResponseEdit(w.interaction, &discordgo.WebhookEdit{
+			Content:         &content,
+			AllowedMentions: noMentions,
+		})
+	} else {
+		_, err = w.s.FollowupMessageEdit(w.interaction, id, &discordgo.WebhookEdit{
+			Content:         &content,
+			AllowedMentions: noMentions,
+		})
+	}
+	return err
+}
+
+// channelSink writes a reply to a channel message: the first message
+// replies to it and later ones follow in the channel.
+type channelSink struct {
+	s         *discordgo.Session
+	channelID string
+	reference *discordgo.MessageReference
+	sent      bool
+}
+
+func (w *channelSink) Send(content string) (string, error) {
+	send := &discordgo.MessageSend{
+		Content: content,
+		// Still ping the person being answered, as a normal reply does.
+		AllowedMentions: &discordgo.MessageAllowedMentions{RepliedUser: true},
+	}
+	if !w.sent {
+		send.Reference = w.reference
+	}
+	msg, err := w.s.ChannelMessageSendComplex(w.channelID, send)
+	if err != nil {
+		return "", err
+	}
+	w.sent = true
+	return msg.ID, nil
+}
+
+func (w *channelSink) Edit(id, content string) error {
+	_, err := w.s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:              id,
+		Channel:         w.channelID,
+		Content:         &content,
+		AllowedMentions: noMentions,
+	})
+	return err
+}
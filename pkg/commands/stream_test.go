@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+// recordingSink keeps the latest content of every message and counts the
+// Discord calls that would have been made.
+type recordingSink struct {
+	messages []string
+	sends    int
+	edits    int
+}
+
+func (r *recordingSink) Send(content string) (string, error) {
+	r.sends++
+	r.messages = append(r.messages, content)
+	return fmt.Sprint(len(r.messages) - 1), nil
+}
+
+func (r *recordingSink) Edit(id, content string) error {
+	r.edits++
+	var n int
+	fmt.Sscan(id, &n)
+	r.messages[n] = content
+	return nil
+}
+
+func TestLiveMessage_ThrottlesEdits(t *testing.T) {
+	sink := &recordingSink{}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	live := newLiveMessage(sink, time.Second)
+	live.now = func() time.Time { return now }
+
+	for _, delta := range []string{"a", "b", "c"} {
+		live.Write(delta)
+	}
+	if sink.sends != 1 || sink.edits != 0 {
+		t.Errorf("Expected only the first delta to be posted within the interval, got %d sends and %d edits", sink.sends, sink.edits)
+	}
+
+	now = now.Add(time.Second)
+	live.Write("d")
+	if sink.edits != 1 || sink.messages[0] != "abcd" {
+		t.Errorf("Expected one edit with all text after the interval, got %d edits and %q", sink.edits, sink.messages[0])
+	}
+
+	live.Write("e")
+	live.Close()
+	if sink.messages[0] != "abcde" {
+		t.Errorf("Expected Close to flush remaining text, got %q", sink.messages[0])
+	}
+
+	edits := sink.edits
+	live.Close()
+	if sink.edits != edits {
+		t.Error("Expected Close not to edit when nothing changed")
+	}
+}
+
+func TestLiveMessage_RedactAndWithdraw(t *testing.T) {
+	sink := &recordingSink{}
+	live := newLiveMessage(sink, 0)
+	live.maxLen = 20
+	live.redact = func(s string) string { return strings.ReplaceAll(s, "secret", "██████") }
+
+	live.Write("the secret is out and it spans two messages")
+	live.Close()
+	if strings.Contains(strings.Join(sink.messages, ""), "secret") {
+		t.Errorf("Expected redacted output, got %q", sink.messages)
+	}
+	if len(sink.messages) < 2 {
+		t.Fatalf("Expected the reply to span messages, got %q", sink.messages)
+	}
+
+	if err := live.Withdraw("withheld"); err != nil {
+		t.Fatal(err)
+	}
+	if sink.messages[0] != "withheld" || sink.messages[1] != "…" {
+		t.Errorf("Expected every message replaced, got %q", sink.messages)
+	}
+}
+
+func TestLiveMessage_SplitsAfterRedacting(t *testing.T) {
+	sink := &recordingSink{}
+	live := newLiveMessage(sink, 0)
+	live.maxLen = 20
+	live.redact = func(s string) string { return strings.ReplaceAll(s, "gg/x", "[invite removed]") }
+
+	for range 6 {
+		live.Write("gg/x ")
+	}
+	live.Close()
+	for _, m := range sink.messages {
+		if len(m) > live.maxLen || strings.Contains(m, "gg/x") {
+			t.Errorf("Expected redacted messages within %d bytes, got %q", live.maxLen, sink.messages)
+		}
+	}
+	if got := strings.Count(strings.Join(sink.messages, ""), "[invite removed]"); got != 6 {
+		t.Errorf("Expected every invite shown once as removed, got %d in %q", got, sink.messages)
+	}
+}
+
+func TestLiveMessage_SplitPointKeepsRunesWhole(t *testing.T) {
+	s := strings.Repeat("é", 1500)
+	cut := splitPoint(s, 2000)
+	if cut > 2000 || !strings.HasPrefix(s, s[:cut]) || cut%2 != 0 {
+		t.Errorf("Expected cut on a rune boundary within 2000 bytes, got %d", cut)
+	}
+}
+
+// TestAIStreaming_RollsOverToFollowups streams a long reply from an SSE stub
+// through the real LLM client into a live message.
+func TestAIStreaming_RollsOverToFollowups(t *testing.T) {
+	var words []string
+	for i := 0; i < 1000; i++ {
+		words = append(words, fmt.Sprintf("word%d ", i))
+	}
+	want := strings.Join(words, "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for _, word := range words {
+			data, _ := json.Marshal(llm.ChatStreamChunk{
+				Choices: []llm.ChatStreamChoice{{Delta: llm.ChatMessage{Content: word}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := llm.NewClient(server.URL, "test-model", 5*time.Second)
+	sink := &recordingSink{}
+	live := newLiveMessage(sink, 0)
+
+	content, err := client.ChatStream(context.Background(), []llm.ChatMessage{{Role: "user", Content: "count"}}, live.Write)
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+	if err := live.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	t.Logf("Streamed %d characters into %d messages with %d edits", len(content), len(sink.messages), sink.edits)
+
+	if content != want {
+		t.Errorf("Expected streamed content to match, got %d characters", len(content))
+	}
+	if len(sink.messages) < 4 {
+		t.Errorf("Expected at least 4 messages for %d characters, got %d", len(want), len(sink.messages))
+	}
+	for n, msg := range sink.messages {
+		if len(msg) > 2000 {
+			t.Errorf("Message %d is %d characters, over Discord's limit", n, len(msg))
+		}
+	}
+	if strings.Join(sink.messages, "") != want {
+		t.Error("Expected messages to join back into the full reply")
+	}
+	if len(live.IDs()) != len(sink.messages) {
+		t.Errorf("Expected %d message IDs, got %d", len(sink.messages), len(live.IDs()))
+	}
+}
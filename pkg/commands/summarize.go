@@ -0,0 +1,323 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/config"
+	"github.com/josh/discord-bot/internal/jobs"
+	"github.com/josh/discord-bot/internal/llm"
+	"github.com/josh/discord-bot/internal/moderation"
+	"github.com/josh/discord-bot/internal/rag"
+	"github.com/josh/discord-bot/internal/summarize"
+)
+
+// defaultSummaryWindow is how far back /summarize reads without a since
+// option.
+const defaultSummaryWindow = 24 * time.Hour
+
+// discordEpoch is the start of Discord's snowflake clock, in Unix
+// milliseconds.
+const discordEpoch = 1420070400000
+
+type SummarizeCommand struct {
+	llm       llm.Completer
+	cfg       *config.Manager
+	jobs      *jobs.Manager
+	moderator *moderation.Filter
+}
+
+func NewSummarizeCommand(llmClient llm.Completer, cfg *config.Manager, jobManager *jobs.Manager, moderator *moderation.Filter) *SummarizeCommand {
+	return &SummarizeCommand{
+		llm:       llmClient,
+		cfg:       cfg,
+		jobs:      jobManager,
+		moderator: moderator,
+	}
+}
+
+func (c *SummarizeCommand) Name() string {
+	return "summarize"
+}
+
+func (c *SummarizeCommand) Description() string {
+	return "Summarize a channel or thread: topics, decisions and action items"
+}
+
+func (c *SummarizeCommand) Data() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        c.Name(),
+		Description: c.Description(),
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionChannel,
+				Name:        "channel",
+				Description: "Channel or thread to summarize (default: this one)",
+				Required:    false,
+				ChannelTypes: []discordgo.ChannelType{
+					discordgo.ChannelTypeGuildText,
+					discordgo.ChannelTypeGuildNews,
+					discordgo.ChannelTypeGuildPublicThread,
+					discordgo.ChannelTypeGuildPrivateThread,
+					discordgo.ChannelTypeGuildNewsThread,
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "since",
+				Description: "How far back: e.g. 2h, 3d, today, or a message link (default: 24h)",
+				Required:    false,
+			},
+		},
+	}
+}
+
+func (c *SummarizeCommand) Execute(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	if i.GuildID == "" {
+		return respondEphemeral(s, i, "This command can only be used in a server")
+	}
+
+	channelID := i.ChannelID
+	since := ""
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "channel":
+			channelID = opt.ChannelValue(nil).ID
+		case "since":
+			since = opt.StringValue()
+		}
+	}
+
+	start, err := parseSince(since, i.GuildID, time.Now())
+	if err != nil {
+		return respondEphemeral(s, i, "❌ "+err.Error())
+	}
+	if start.channelID != "" {
+		channelID = start.channelID
+	}
+
+	userID := interactionUserID(i)
+	if !canReadChannel(s, userID)(channelID) {
+		return respondEphemeral(s, i, fmt.Sprintf("❌ You can't read the history of <#%s>.", channelID))
+	}
+
+	slog.Info("Summarize command received", "user_id", userID, "guild_id", i.GuildID, "channel_id", channelID, "since", since)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: summaryFlags(s.State, i.ChannelID, channelID)},
+	}); err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("summary of #%s", channelID)
+	return submitJob(ctx, s, i, c.jobs, c.Name(), description, func(ctx context.Context) error {
+		return c.run(ctx, s, i, channelID, start)
+	})
+}
+
+func (c *SummarizeCommand) run(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, channelID string, start summaryStart) error {
+	settings := c.cfg.Current().AI
+
+	jobs.SetStage(ctx, "Reading messages")
+	fetch := func(before string) ([]*discordgo.Message, error) {
+		return s.ChannelMessages(channelID, 100, before, "", "", discordgo.WithContext(ctx))
+	}
+	history, truncated, err := collectMessages(fetch, start.afterID, settings.SummaryMaxMessages)
+	if err != nil {
+		slog.Error("Failed to read messages to summarize", "channel_id", channelID, "error", err)
+		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: strPtr(fmt.Sprintf("❌ Failed to read <#%s>: %v", channelID, err)),
+		})
+		return editErr
+	}
+
+	messages := summaryMessages(history)
+	if len(messages) == 0 {
+		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: strPtr(fmt.Sprintf("🤷 No messages in <#%s> since %s.", channelID, start.describe())),
+		})
+		return err
+	}
+
+	release, err := jobs.Acquire(ctx, jobs.BackendLLM, "Summarizing")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	digest, err := summarize.NewSummarizer(c.llm, settings.SummaryChunkTokens).Summarize(ctx, messages)
+	if err != nil {
+		slog.Error("Failed to summarize channel", "channel_id", channelID, "error", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: strPtr(fmt.Sprintf("❌ Failed to summarize: %v", err)),
+		})
+		return editErr
+	}
+
+	digest = summarize.LinkCitations(digest, messages, func(messageID string) string {
+		return rag.MessageURL(i.GuildID, channelID, messageID)
+	})
+
+	header := fmt.Sprintf("📋 **Summary of <#%s>**: %d messages since %s", channelID, len(messages), start.describe())
+	if truncated {
+		header += fmt.Sprintf(" (only the latest %d were read)", settings.SummaryMaxMessages)
+	}
+
+	live := moderatedLive(&interactionSink{s: s, interaction: i.Interaction}, c.moderator, i.GuildID)
+	live.Write(header + "\n\n" + digest)
+	if err := live.Close(); err != nil {
+		return err
+	}
+	withholdFlagged(ctx, s, c.moderator, interactionSubject(i, c.Name()), live, digest)
+	return nil
+}
+
+// summaryFlags makes the summary visible only to the user when it's of a
+// channel that not everyone in the current one can read.
+func summaryFlags(state *discordgo.State, here, channelID string) discordgo.MessageFlags {
+	if audienceCanRead(state, here, channelID) {
+		return 0
+	}
+	return discordgo.MessageFlagsEphemeral
+}
+
+// summaryStart is where a summary begins: after afterID, a snowflake for
+// either a time or the message before a linked one.
+type summaryStart struct {
+	afterID uint64
+	from    time.Time
+
+	// channelID is set when the range was given as a message link.
+	channelID string
+	messageID string
+}
+
+func (st summaryStart) describe() string {
+	if st.messageID != "" {
+		return "the linked message"
+	}
+	return fmt.Sprintf("<t:%d:f>", st.from.Unix())
+}
+
+var (
+	// messageLinkPattern captures a message link's guild (or @me for a
+	// DM), channel and message.
+	messageLinkPattern = regexp.MustCompile(`^https://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/channels/(\d+|@me)/(\d+)/(\d+)/?$`)
+	daysPattern        = regexp.MustCompile(`^(\d+)d$`)
+)
+
+// parseSince reads the since option: a duration (2h, 90m, 3d), "today"
+// (since local midnight) or a link to a message in guildID, from which the
+// summary starts.
+func parseSince(since, guildID string, now time.Time) (summaryStart, error) {
+	since = strings.ToLower(strings.TrimSpace(since))
+
+	if m := messageLinkPattern.FindStringSubmatch(since); m != nil {
+		if m[1] != guildID {
+			return summaryStart{}, fmt.Errorf("that message isn't in this server")
+		}
+		id, err := strconv.ParseUint(m[3], 10, 64)
+		if err != nil || id == 0 {
+			return summaryStart{}, fmt.Errorf("that message link doesn't look right")
+		}
+		return summaryStart{afterID: id - 1, channelID: m[2], messageID: m[3]}, nil
+	}
+
+	var from time.Time
+	switch {
+	case since == "":
+		from = now.Add(-defaultSummaryWindow)
+	case since == "today":
+		y, mo, d := now.Date()
+		from = time.Date(y, mo, d, 0, 0, 0, 0, now.Location())
+	case daysPattern.MatchString(since):
+		days, _ := strconv.Atoi(daysPattern.FindStringSubmatch(since)[1])
+		from = now.AddDate(0, 0, -days)
+	default:
+		d, err := time.ParseDuration(since)
+		if err != nil || d <= 0 {
+			return summaryStart{}, fmt.Errorf("`since` should be like 2h, 3d, today or a message link")
+		}
+		from = now.Add(-d)
+	}
+	return summaryStart{afterID: snowflakeAt(from), from: from}, nil
+}
+
+// snowflakeAt returns the smallest snowflake ID created at t, for
+// comparing message IDs with a time.
+func snowflakeAt(t time.Time) uint64 {
+	ms := t.UnixMilli() - discordEpoch
+	if ms < 0 {
+		return 0
+	}
+	return uint64(ms) << 22
+}
+
+// collectMessages pages backwards from the newest message until it passes
+// afterID, keeping at most limit messages, and returns them oldest first.
+// truncated reports whether older messages in the range were left out.
+func collectMessages(fetch func(beforeID string) ([]*discordgo.Message, error), afterID uint64, limit int) (messages []*discordgo.Message, truncated bool, err error) {
+	before := ""
+	for {
+		page, err := fetch(before)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, m := range page {
+			id, err := strconv.ParseUint(m.ID, 10, 64)
+			if err != nil || id <= afterID {
+				return reverseMessages(messages), false, nil
+			}
+			if len(messages) == limit {
+				return reverseMessages(messages), true, nil
+			}
+			messages = append(messages, m)
+		}
+		if len(page) < 100 {
+			return reverseMessages(messages), false, nil
+		}
+		before = page[len(page)-1].ID
+	}
+}
+
+func reverseMessages(messages []*discordgo.Message) []*discordgo.Message {
+	for a, b := 0, len(messages)-1; a < b; a, b = a+1, b-1 {
+		messages[a], messages[b] = messages[b], messages[a]
+	}
+	return messages
+}
+
+// summaryMessages keeps what people wrote, with mentions readable and
+// attachments named, and drops joins, pins and other system messages.
+func summaryMessages(history []*discordgo.Message) []summarize.Message {
+	var out []summarize.Message
+	for _, m := range history {
+		if m.Author == nil || (m.Type != discordgo.MessageTypeDefault && m.Type != discordgo.MessageTypeReply) {
+			continue
+		}
+		content := strings.TrimSpace(m.ContentWithMentionsReplaced())
+		for _, a := range m.Attachments {
+			content = strings.TrimSpace(content + " [attachment: " + a.Filename + "]")
+		}
+		if content == "" {
+			continue
+		}
+		out = append(out, summarize.Message{
+			ID:      m.ID,
+			Author:  m.Author.Username,
+			Content: content,
+			Time:    m.Timestamp,
+		})
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 5, 4, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		since string
+		from  time.Time
+	}{
+		{"", now.Add(-24 * time.Hour)},
+		{"2h", now.Add(-2 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"3d", now.AddDate(0, 0, -3)},
+		{"Today", time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.since, "111", now)
+		if err != nil {
+			t.Errorf("parseSince(%q): %v", tt.since, err)
+			continue
+		}
+		if !got.from.Equal(tt.from) || got.afterID != snowflakeAt(tt.from) {
+			t.Errorf("parseSince(%q) = %v, want %v", tt.since, got.from, tt.from)
+		}
+	}
+
+	got, err := parseSince("https://discord.com/channels/111/222/333", "111", now)
+	if err != nil {
+		t.Fatalf("parseSince(link): %v", err)
+	}
+	if got.channelID != "222" || got.messageID != "333" || got.afterID != 332 {
+		t.Errorf("parseSince(link) = %+v", got)
+	}
+
+	for _, bad := range []string{
+		"yesterday", "-2h", "https://example.com/channels/111/2/3",
+		"https://discord.com/channels/999/222/333", "https://discord.com/channels/@me/222/333",
+	} {
+		if _, err := parseSince(bad, "111", now); err == nil {
+			t.Errorf("parseSince(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestSnowflakeAt(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts, err := discordgo.SnowflakeTimestamp(strconv.FormatUint(snowflakeAt(at), 10))
+	if err != nil {
+		t.Fatalf("SnowflakeTimestamp: %v", err)
+	}
+	if !ts.Equal(at) {
+		t.Errorf("snowflake decodes to %v, want %v", ts, at)
+	}
+}
+
+// pagedHistory serves messages 1..n newest first, 100 per page, the way
+// the channel messages endpoint does.
+func pagedHistory(n int, calls *int) func(before string) ([]*discordgo.Message, error) {
+	return func(before string) ([]*discordgo.Message, error) {
+		*calls++
+		top := n
+		if before != "" {
+			id, _ := strconv.Atoi(before)
+			top = id - 1
+		}
+		var page []*discordgo.Message
+		for id := top; id > 0 && len(page) < 100; id-- {
+			page = append(page, &discordgo.Message{ID: fmt.Sprint(id)})
+		}
+		return page, nil
+	}
+}
+
+func TestCollectMessages(t *testing.T) {
+	calls := 0
+	messages, truncated, err := collectMessages(pagedHistory(350, &calls), 120, 1000)
+	if err != nil {
+		t.Fatalf("collectMessages: %v", err)
+	}
+	if truncated || len(messages) != 230 || messages[0].ID != "121" || messages[229].ID != "350" {
+		t.Errorf("expected messages 121..350 oldest first, got %d (truncated=%v)", len(messages), truncated)
+	}
+	if calls != 3 {
+		t.Errorf("expected to stop paging once past the start, made %d calls", calls)
+	}
+
+	calls = 0
+	messages, truncated, err = collectMessages(pagedHistory(350, &calls), 0, 150)
+	if err != nil {
+		t.Fatalf("collectMessages: %v", err)
+	}
+	if !truncated || len(messages) != 150 || messages[0].ID != "201" || messages[149].ID != "350" {
+		t.Errorf("expected the latest 150 messages, got %d (truncated=%v)", len(messages), truncated)
+	}
+
+	calls = 0
+	messages, truncated, err = collectMessages(pagedHistory(40, &calls), 0, 1000)
+	if err != nil || truncated || len(messages) != 40 || calls != 1 {
+		t.Errorf("expected the whole short channel in one call, got %d messages in %d calls (err=%v)", len(messages), calls, err)
+	}
+}
+
+func TestSummaryMessages(t *testing.T) {
+	alice := &discordgo.User{ID: "1", Username: "alice"}
+	bob := &discordgo.User{ID: "2", Username: "bob"}
+	history := []*discordgo.Message{
+		{ID: "10", Author: alice, Content: "ping <@2> about the release", Mentions: []*discordgo.User{bob}},
+		{ID: "11", Author: bob, Type: discordgo.MessageTypeGuildMemberJoin},
+		{ID: "12", Author: bob, Type: discordgo.MessageTypeReply, Attachments: []*discordgo.MessageAttachment{{Filename: "plan.pdf"}}},
+		{ID: "13", Author: alice, Content: "   "},
+	}
+
+	got := summaryMessages(history)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", got)
+	}
+	if got[0].Content != "ping @bob about the release" || got[0].Author != "alice" {
+		t.Errorf("unexpected first message %+v", got[0])
+	}
+	if got[1].Content != "[attachment: plan.pdf]" {
+		t.Errorf("unexpected attachment message %+v", got[1])
+	}
+}
+
+func TestSummaryFlags(t *testing.T) {
+	s := testGuildState(t)
+	if flags := summaryFlags(s.State, "general", "mods-chat"); flags != discordgo.MessageFlagsEphemeral {
+		t.Error("expected a summary of #mods run in #general to be ephemeral")
+	}
+	if flags := summaryFlags(s.State, "mods-chat", "general"); flags != 0 {
+		t.Error("expected a summary of #general run in #mods to be public")
+	}
+	if flags := summaryFlags(s.State, "general", "general"); flags != 0 {
+		t.Error("expected a summary of the current channel to be public")
+	}
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const readHistory = discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory
+
+// canShareChannel returns a filter for channels whose content may be
+// posted in channelID: the user must be able to read them, and so must
+// everyone who can see channelID, so a public answer doesn't quote a
+// channel its readers can't see. Lookups are cached for one request.
+func canShareChannel(s *discordgo.Session, userID, channelID string) func(sourceID string) bool {
+	canRead := canReadChannel(s, userID)
+	seen := make(map[string]bool)
+	return func(sourceID string) bool {
+		if ok, cached := seen[sourceID]; cached {
+			return ok
+		}
+		ok := canRead(sourceID) && audienceCanRead(s.State, channelID, sourceID)
+		seen[sourceID] = ok
+		return ok
+	}
+}
+
+// audienceCanRead reports whether everyone who can see channel from can
+// also read the history of channel to. The audience is worked out from the
+// cached guild: each role that can see from, and each member let in by an
+// overwrite. Anything missing from the cache counts as a no.
+func audienceCanRead(state *discordgo.State, from, to string) bool {
+	if from == to {
+		return true
+	}
+	// Private threads have members rather than overwrites, so their
+	// audience can't be checked.
+	if channel, err := state.Channel(to); err == nil && channel.Type == discordgo.ChannelTypeGuildPrivateThread {
+		return false
+	}
+	fromID, guild, err := permissionChannel(state, from)
+	if err != nil {
+		slog.Warn("Failed to look up channel audience", "channel_id", from, "error", err)
+		return false
+	}
+	toID, _, err := permissionChannel(state, to)
+	if err != nil {
+		slog.Warn("Failed to look up channel audience", "channel_id", to, "error", err)
+		return false
+	}
+
+	for _, role := range guild.Roles {
+		var roles []string
+		if role.ID != guild.ID {
+			roles = []string{role.ID}
+		}
+		if rolePermissions(state, fromID, roles)&discordgo.PermissionViewChannel == 0 {
+			continue
+		}
+		if rolePermissions(state, toID, roles)&readHistory != readHistory {
+			return false
+		}
+	}
+
+	channel, _ := state.Channel(fromID)
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type != discordgo.PermissionOverwriteTypeMember || overwrite.Allow&discordgo.PermissionViewChannel == 0 {
+			continue
+		}
+		perms, err := state.UserChannelPermissions(overwrite.ID, toID)
+		if err != nil || perms&readHistory != readHistory {
+			return false
+		}
+	}
+	return true
+}
+
+// permissionChannel returns the channel whose overwrites apply to
+// channelID, its parent for a thread, and the guild it's in.
+func permissionChannel(state *discordgo.State, channelID string) (string, *discordgo.Guild, error) {
+	channel, err := state.Channel(channelID)
+	if err != nil {
+		return "", nil, err
+	}
+	if channel.IsThread() {
+		if channel, err = state.Channel(channel.ParentID); err != nil {
+			return "", nil, err
+		}
+	}
+	guild, err := state.Guild(channel.GuildID)
+	if err != nil {
+		return "", nil, err
+	}
+	return channel.ID, guild, nil
+}
+
+// rolePermissions returns the permissions in a channel of a member with
+// only the given roles besides @everyone.
+func rolePermissions(state *discordgo.State, channelID string, roles []string) int64 {
+	perms, err := state.MessagePermissions(&discordgo.Message{
+		ChannelID: channelID,
+		Author:    &discordgo.User{},
+		Member:    &discordgo.Member{Roles: roles},
+	})
+	if err != nil {
+		return 0
+	}
+	return perms
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// testGuildState caches a guild with a public #general and #random, a
+// #mods only the mods role can see, a thread in #mods, a private thread
+// in #general, and a #vip that lets one member in directly.
+func testGuildState(t *testing.T) *discordgo.Session {
+	t.Helper()
+	hidden := func(allow ...string) []*discordgo.PermissionOverwrite {
+		overwrites := []*discordgo.PermissionOverwrite{{ID: "g", Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel}}
+		for _, id := range allow {
+			kind := discordgo.PermissionOverwriteTypeRole
+			if id == "vip-member" {
+				kind = discordgo.PermissionOverwriteTypeMember
+			}
+			overwrites = append(overwrites, &discordgo.PermissionOverwrite{ID: id, Type: kind, Allow: discordgo.PermissionViewChannel})
+		}
+		return overwrites
+	}
+
+	state := discordgo.NewState()
+	err := state.GuildAdd(&discordgo.Guild{
+		ID:      "g",
+		OwnerID: "owner",
+		Roles: []*discordgo.Role{
+			{ID: "g", Permissions: readHistory | discordgo.PermissionSendMessages},
+			{ID: "mods"},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: "general", GuildID: "g", Type: discordgo.ChannelTypeGuildText},
+			{ID: "random", GuildID: "g", Type: discordgo.ChannelTypeGuildText},
+			{ID: "mods-chat", GuildID: "g", Type: discordgo.ChannelTypeGuildText, PermissionOverwrites: hidden("mods")},
+			{ID: "vip", GuildID: "g", Type: discordgo.ChannelTypeGuildText, PermissionOverwrites: hidden("mods", "vip-member")},
+		},
+		Threads: []*discordgo.Channel{
+			{ID: "mods-thread", GuildID: "g", ParentID: "mods-chat", Type: discordgo.ChannelTypeGuildPublicThread},
+			{ID: "secret-thread", GuildID: "g", ParentID: "general", Type: discordgo.ChannelTypeGuildPrivateThread},
+		},
+		Members: []*discordgo.Member{
+			{GuildID: "g", User: &discordgo.User{ID: "mod"}, Roles: []string{"mods"}},
+			{GuildID: "g", User: &discordgo.User{ID: "vip-member"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to cache guild: %v", err)
+	}
+	return &discordgo.Session{State: state}
+}
+
+func TestAudienceCanRead(t *testing.T) {
+	s := testGuildState(t)
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"general", "general", true},
+		{"general", "random", true},
+		{"general", "mods-chat", false},
+		{"mods-chat", "general", true},
+		{"mods-thread", "mods-chat", true},
+		{"general", "mods-thread", false},
+		{"general", "secret-thread", false},
+		{"secret-thread", "general", true},
+		// The member let into #vip can't see #mods.
+		{"vip", "mods-chat", false},
+		{"mods-chat", "vip", true},
+		{"general", "unknown", false},
+	}
+	for _, tt := range tests {
+		if got := audienceCanRead(s.State, tt.from, tt.to); got != tt.want {
+			t.Errorf("audienceCanRead(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestCanShareChannel(t *testing.T) {
+	s := testGuildState(t)
+
+	// A mod asking in #general only gets what #general can read.
+	inGeneral := canShareChannel(s, "mod", "general")
+	if !inGeneral("random") || inGeneral("mods-chat") || inGeneral("mods-thread") {
+		t.Error("Expected only public channels shared in #general")
+	}
+	// In #mods they get everything they can read.
+	inMods := canShareChannel(s, "mod", "mods-chat")
+	if !inMods("mods-chat") || !inMods("general") || !inMods("vip") {
+		t.Error("Expected the mod's channels shared in #mods")
+	}
+	// What the asker can't read is never shared.
+	if canShareChannel(s, "vip-member", "mods-chat")("mods-chat") {
+		t.Error("Expected #mods hidden from a member who can't read it")
+	}
+}
@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/josh/discord-bot/internal/llm"
+)
+
+// maxImageBytes keeps attachments sent to the vision model to a size it
+// can encode quickly; Discord allows much larger uploads.
+const maxImageBytes = 8 << 20
+
+// maxImagesPerPrompt bounds how many images one message sends, since each
+// costs hundreds of tokens of context.
+const maxImagesPerPrompt = 4
+
+var visionHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// visionImageTypes are the formats llama.cpp's image loader reads.
+var visionImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+// isImageAttachment reports whether a is a picture, going by its content
+// type or, failing that, its file name.
+func isImageAttachment(a *discordgo.MessageAttachment) bool {
+	if a.ContentType != "" {
+		return visionImageTypes[strings.ToLower(strings.SplitN(a.ContentType, ";", 2)[0])]
+	}
+	switch strings.ToLower(path.Ext(a.Filename)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".bmp":
+		return true
+	}
+	return false
+}
+
+func hasImageAttachment(attachments []*discordgo.MessageAttachment) bool {
+	for _, a := range attachments {
+		if isImageAttachment(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadImage fetches an image attachment for the vision model. The
+// type is taken from the bytes, not the upload's claim.
+func downloadImage(ctx context.Context, a *discordgo.MessageAttachment) (llm.ContentPart, error) {
+	data, mimeType, err := fetchImage(ctx, a)
+	if err != nil {
+		return llm.ContentPart{}, err
+	}
+	return llm.ImagePart(mimeType, data), nil
+}
+
+// fetchImage downloads an image attachment and returns it with its
+// detected type.
+func fetchImage(ctx context.Context, a *discordgo.MessageAttachment) ([]byte, string, error) {
+	if !isImageAttachment(a) {
+		return nil, "", fmt.Errorf("%s isn't a PNG, JPEG, GIF, WebP or BMP image", a.Filename)
+	}
+	if a.Size > maxImageBytes {
+		return nil, "", fmt.Errorf("%s is over the %d MB image limit", a.Filename, maxImageBytes>>20)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := visionHTTPClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download %s: %w", a.Filename, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download %s: status %d", a.Filename, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download %s: %w", a.Filename, err)
+	}
+	if len(data) > maxImageBytes {
+		return nil, "", fmt.Errorf("%s is over the %d MB image limit", a.Filename, maxImageBytes>>20)
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !visionImageTypes[mimeType] {
+		return nil, "", fmt.Errorf("%s isn't a PNG, JPEG, GIF, WebP or BMP image", a.Filename)
+	}
+	return data, mimeType, nil
+}
+
+// promptImages downloads the image attachments for a prompt and returns
+// them with a note naming them, to append to the prompt so the saved
+// history records that images were shown.
+func promptImages(ctx context.Context, attachments []*discordgo.MessageAttachment) ([]llm.ContentPart, string, error) {
+	var images []llm.ContentPart
+	var names []string
+	for _, a := range attachments {
+		if !isImageAttachment(a) {
+			continue
+		}
+		if len(images) == maxImagesPerPrompt {
+			return nil, "", fmt.Errorf("at most %d images can be sent at once", maxImagesPerPrompt)
+		}
+		image, err := downloadImage(ctx, a)
+		if err != nil {
+			return nil, "", err
+		}
+		images = append(images, image)
+		names = append(names, a.Filename)
+	}
+	if len(images) == 0 {
+		return nil, "", nil
+	}
+	return images, fmt.Sprintf(" [attached image: %s]", strings.Join(names, ", ")), nil
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPromptImages(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		switch r.URL.Path {
+		case "/shot.png":
+			w.Write(pngData.Bytes())
+		default:
+			w.Write([]byte("not really an image"))
+		}
+	}))
+	defer server.Close()
+
+	attachments := []*discordgo.MessageAttachment{
+		{Filename: "notes.txt", URL: server.URL + "/notes.txt", ContentType: "text/plain", Size: 10},
+		{Filename: "shot.png", URL: server.URL + "/shot.png", ContentType: "image/png", Size: pngData.Len()},
+	}
+	images, note, err := promptImages(context.Background(), attachments)
+	if err != nil {
+		t.Fatalf("promptImages failed: %v", err)
+	}
+	if downloads != 1 {
+		t.Errorf("Expected only the image to be downloaded, got %d downloads", downloads)
+	}
+	want := "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngData.Bytes())
+	if len(images) != 1 || images[0].Type != "image_url" || images[0].ImageURL.URL != want {
+		t.Errorf("Unexpected image parts %+v", images)
+	}
+	if note != " [attached image: shot.png]" {
+		t.Errorf("Unexpected note %q", note)
+	}
+
+	// A file named like an image whose bytes aren't one is rejected.
+	fake := &discordgo.MessageAttachment{Filename: "fake.png", URL: server.URL + "/fake.png", Size: 19}
+	if _, _, err := promptImages(context.Background(), []*discordgo.MessageAttachment{fake}); err == nil || !strings.Contains(err.Error(), "fake.png") {
+		t.Errorf("Expected fake.png to be rejected, got %v", err)
+	}
+
+	// Oversized images are refused before downloading.
+	downloads = 0
+	huge := &discordgo.MessageAttachment{Filename: "huge.png", URL: server.URL + "/shot.png", ContentType: "image/png", Size: maxImageBytes + 1}
+	if _, err := downloadImage(context.Background(), huge); err == nil {
+		t.Error("Expected an oversized image to be rejected")
+	}
+	if downloads != 0 {
+		t.Error("Expected the oversized image not to be downloaded")
+	}
+
+	if images, note, err := promptImages(context.Background(), nil); images != nil || note != "" || err != nil {
+		t.Errorf("Expected nothing for no attachments, got %v %q %v", images, note, err)
+	}
+}